@@ -21,8 +21,11 @@ import (
 	"crypto/tls"
 	"fmt"
 	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/deliveries"
 	"github.com/armory/dinghy/pkg/dinghyfile"
 	"github.com/armory/dinghy/pkg/execution"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/lock"
 	"github.com/armory/dinghy/pkg/logevents"
 	"github.com/armory/dinghy/pkg/settings/global"
 	"github.com/armory/dinghy/pkg/settings/source"
@@ -32,6 +35,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/armory/dinghy/pkg/debug"
 
@@ -39,8 +43,11 @@ import (
 	"github.com/armory/dinghy/pkg/log/hooks"
 	"github.com/armory/plank/v4"
 
+	"github.com/armory/dinghy/pkg/approvals"
 	"github.com/armory/dinghy/pkg/cache"
 	"github.com/armory/dinghy/pkg/events"
+	"github.com/armory/dinghy/pkg/impact"
+	"github.com/armory/dinghy/pkg/revisions"
 	"github.com/armory/dinghy/pkg/util"
 	"github.com/armory/dinghy/pkg/web"
 	"github.com/go-redis/redis"
@@ -118,13 +125,22 @@ func Setup(sourceConfiguration source.SourceConfiguration, log *logr.Logger) (*l
 
 	var api *web.WebAPI
 	var logEventsClient logevents.LogEventsClient
+	var records recordClients
+	var locker lock.Locker
+	lockOptions := lock.Options{
+		WaitTimeout: time.Duration(config.Locking.WaitTimeoutSeconds) * time.Second,
+		Lease:       time.Duration(config.Locking.LeaseSeconds) * time.Second,
+	}
+	// Held locks renew their lease well before it expires
+	lockOptions.RenewInterval = lockOptions.Lease / 3
 	var persitenceManager dinghyfile.DependencyManager
 	var persitenceManagerReadOnly dinghyfile.DependencyManager
 
 	// Full SQL mode
 	if config.SQL.Enabled && !config.SQL.EventLogsOnly {
 
-		sqlClient, sqlerr := database.NewMySQLClient(&database.SQLConfig{
+		sqlClient, sqlerr := database.NewSQLClient(&database.SQLConfig{
+			Driver:   config.SQL.Driver,
 			DbUrl:    config.SQL.BaseUrl,
 			User:     config.SQL.User,
 			Password: config.SQL.Password,
@@ -141,26 +157,36 @@ func Setup(sourceConfiguration source.SourceConfiguration, log *logr.Logger) (*l
 		}
 
 		logEventsClient = &(logevents.LogEventSQLClient{SQLClient: sqlClient, MinutesTTL: config.LogEventTTLMinutes})
+		records = sqlRecordClients(sqlClient, config)
 		persitenceManager = sqlClient
 		persitenceManagerReadOnly = &sqlClientReadOnly
 
-		redisClient := cache.NewRedisCache(NewRedisOptions(config.SpinnakerSupplied.Redis), log, ctx, stop, false)
+		if config.SQL.Driver == database.SQLiteDriver {
+			// Embedded single node mode, there are no other replicas to share
+			// locks with and no Redis to migrate from
+			locker = lock.NewMemoryLocker(lockOptions)
+		} else {
+			locker = lock.SQLLocker{SQLClient: sqlClient, Options: lockOptions}
 
-		var migration execution.Execution
+			redisClient := cache.NewRedisCache(NewRedisOptions(config.SpinnakerSupplied.Redis), log, ctx, stop, false)
 
-		migration = &execution.RedisToSQLMigration{
-			Settings:   config,
-			Logger:     log,
-			RedisCache: redisClient,
-			SQLClient:  sqlClient,
-		}
+			var migration execution.Execution
+
+			migration = &execution.RedisToSQLMigration{
+				Settings:   config,
+				Logger:     log,
+				RedisCache: redisClient,
+				SQLClient:  sqlClient,
+			}
 
-		migration.Execute()
-		migration.Finalize()
+			migration.Execute()
+			migration.Finalize()
+		}
 
 	} else if config.SQL.Enabled && config.SQL.EventLogsOnly {
 		// Hybrid SQL mode just for eventlogs
-		sqlClient, sqlerr := database.NewMySQLClient(&database.SQLConfig{
+		sqlClient, sqlerr := database.NewSQLClient(&database.SQLConfig{
+			Driver:   config.SQL.Driver,
 			DbUrl:    config.SQL.BaseUrl,
 			User:     config.SQL.User,
 			Password: config.SQL.Password,
@@ -182,7 +208,9 @@ func Setup(sourceConfiguration source.SourceConfiguration, log *logr.Logger) (*l
 		}
 
 		logEventsClient = &(logevents.LogEventSQLClient{SQLClient: sqlClient, MinutesTTL: config.LogEventTTLMinutes})
+		records = sqlRecordClients(sqlClient, config)
 		persitenceManager = redisClient
+		locker = lock.RedisLocker{RedisClient: redisClient, Options: lockOptions}
 		persitenceManagerReadOnly = &redisClientReadOnly
 
 	} else {
@@ -198,6 +226,8 @@ func Setup(sourceConfiguration source.SourceConfiguration, log *logr.Logger) (*l
 		}
 
 		logEventsClient = logevents.LogEventRedisClient{RedisClient: redisClient, MinutesTTL: config.LogEventTTLMinutes}
+		records = redisRecordClients(redisClient, config)
+		locker = lock.RedisLocker{RedisClient: redisClient, Options: lockOptions}
 		persitenceManager = redisClient
 		persitenceManagerReadOnly = &redisClientReadOnly
 
@@ -205,14 +235,76 @@ func Setup(sourceConfiguration source.SourceConfiguration, log *logr.Logger) (*l
 
 	api = web.NewWebAPI(sourceConfiguration, persitenceManager, ec, log, persitenceManagerReadOnly, &clientReadOnly, logEventsClient, log)
 	api.MetricsHandler = new(web.NoOpMetricsHandler)
+	records.enable(api, config)
+	if config.Locking.Enabled {
+		api.Locker = locker
+	}
+	if config.ReferenceValidation.Enabled {
+		api.References = dinghyfile.NewReferenceCatalog(time.Duration(config.ReferenceValidation.CacheTTLSeconds)*time.Second, config.ReferenceValidation.StageTypes)
+	}
 	api.AddDinghyfileUnmarshaller(&dinghyfile.DinghyJsonUnmarshaller{})
+	api.AddDinghyfileParser("json", dinghyfile.NewDinghyfileParser(&dinghyfile.PipelineBuilder{}))
 	if config.ParserFormat == "json" {
 		api.SetDinghyfileParser(dinghyfile.NewDinghyfileParser(&dinghyfile.PipelineBuilder{}))
 	}
+	if err := api.StartPolling(ctx); err != nil {
+		log.Fatalf("Unable to start repository polling: %v", err)
+	}
+	api.StartReconciliation(ctx)
+	api.StartDriftDetection(ctx)
 	return log, api
 }
 
-func setupPlankClient(settings *global.Settings, log *logr.Logger) *plank.Client {
+// recordClients keep the records of the optional features in the backend
+// of the dependency graph.
+type recordClients struct {
+	deliveries deliveries.DeliveriesClient
+	impact     impact.ReportsClient
+	revisions  revisions.Client
+	approvals  approvals.Client
+}
+
+func sqlRecordClients(sqlClient *database.SQLClient, config *global.Settings) recordClients {
+	return recordClients{
+		deliveries: &deliveries.DeliverySQLClient{SQLClient: sqlClient, MinutesTTL: config.WebhookDeduplication.TTLMinutes},
+		impact:     impact.ReportSQLClient{SQLClient: sqlClient, MinutesTTL: config.ImpactReports.TTLMinutes},
+		revisions:  revisions.RevisionSQLClient{SQLClient: sqlClient, MaxPerApplication: config.Revisions.MaxPerApplication},
+		approvals:  approvals.ChangeRequestSQLClient{SQLClient: sqlClient},
+	}
+}
+
+func redisRecordClients(redisClient *cache.RedisCache, config *global.Settings) recordClients {
+	return recordClients{
+		deliveries: deliveries.DeliveryRedisClient{RedisClient: redisClient, MinutesTTL: config.WebhookDeduplication.TTLMinutes},
+		impact:     impact.ReportRedisClient{RedisClient: redisClient, MinutesTTL: config.ImpactReports.TTLMinutes},
+		revisions:  revisions.RevisionRedisClient{RedisClient: redisClient, MaxPerApplication: config.Revisions.MaxPerApplication},
+		approvals:  approvals.ChangeRequestRedisClient{RedisClient: redisClient},
+	}
+}
+
+// enable hands the clients of the features enabled in the settings to the api.
+func (r recordClients) enable(api *web.WebAPI, config *global.Settings) {
+	if config.WebhookDeduplication.Enabled {
+		api.DeliveriesClient = r.deliveries
+	}
+	if config.ImpactReports.Enabled {
+		api.ImpactReportsClient = r.impact
+	}
+	if config.Revisions.Enabled {
+		api.RevisionsClient = r.revisions
+	}
+	if config.Approvals.Enabled {
+		api.ApprovalsClient = r.approvals
+		if config.Approvals.CommentCommits {
+			api.AddNotifier(&github.ApprovalNotifier{
+				Config: github.Config{Endpoint: config.GithubEndpoint, Token: config.GitHubToken},
+				Logger: api.Logger,
+			})
+		}
+	}
+}
+
+func setupPlankClient(settings *global.Settings, log *logr.Logger) *util.SpinnakerClient {
 	var httpClient *http.Client
 	if log.Level == logr.DebugLevel {
 		httpClient = debug.NewInterceptorHttpClient(log, &settings.Http, true)
@@ -225,7 +317,8 @@ func setupPlankClient(settings *global.Settings, log *logr.Logger) *plank.Client
 	// Update the base URLs based on config
 	client.URLs["orca"] = settings.SpinnakerSupplied.Orca.BaseURL
 	client.URLs["front50"] = settings.SpinnakerSupplied.Front50.BaseURL
-	return client
+	client.URLs["clouddriver"] = settings.SpinnakerSupplied.Clouddriver.BaseURL
+	return util.NewSpinnakerClient(client)
 }
 
 func AddUnmarshaller(u dinghyfile.DinghyJsonUnmarshaller, api *web.WebAPI) {
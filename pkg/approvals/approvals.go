@@ -0,0 +1,121 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package approvals
+
+import (
+	"sort"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// Statuses of a change request
+const (
+	// StatusPending change requests wait for an approval
+	StatusPending = "pending"
+	// StatusApplied change requests were approved and applied
+	StatusApplied = "applied"
+	// StatusFailed change requests were approved but applying them failed
+	StatusFailed = "failed"
+	// StatusRejected change requests were discarded
+	StatusRejected = "rejected"
+	// StatusExpired change requests weren't approved in time
+	StatusExpired = "expired"
+	// StatusSuperseded change requests were replaced by a newer change of the same dinghyfile
+	StatusSuperseded = "superseded"
+)
+
+// Actions recorded in the audit trail of a change request
+const (
+	ActionCreated    = "created"
+	ActionApproved   = "approved"
+	ActionRejected   = "rejected"
+	ActionApplied    = "applied"
+	ActionFailed     = "failed"
+	ActionExpired    = "expired"
+	ActionSuperseded = "superseded"
+)
+
+// Client stores the change requests held for approval
+type Client interface {
+	// SaveChangeRequest creates a change request when its ID is 0 and updates
+	// it otherwise, it returns the change request with its ID and date.
+	SaveChangeRequest(request ChangeRequest) (ChangeRequest, error)
+	// GetChangeRequest returns a change request, or nil if there is none.
+	GetChangeRequest(id int64) (*ChangeRequest, error)
+	// GetChangeRequests returns the change requests with a status of an
+	// application, newest first. Empty values match everything.
+	GetChangeRequests(status, application string) ([]ChangeRequest, error)
+	// TransitionChangeRequest saves a change request only if its saved status
+	// is still from, it returns false when it changed meanwhile. Approvals and
+	// rejections use it so concurrent ones can't both succeed.
+	TransitionChangeRequest(request ChangeRequest, from string) (bool, error)
+}
+
+// ChangeRequest is a rendered dinghyfile of a protected application waiting
+// to be approved before it is applied.
+type ChangeRequest struct {
+	ID          int64  `json:"id" yaml:"id"`
+	Application string `json:"application" yaml:"application"`
+	Provider    string `json:"provider" yaml:"provider"`
+	Org         string `json:"org" yaml:"org"`
+	Repo        string `json:"repo" yaml:"repo"`
+	Path        string `json:"path" yaml:"path"`
+	Branch      string `json:"branch" yaml:"branch"`
+	// Commit is only known when the dinghyfile was processed for a push to its repository
+	Commit     string `json:"commit,omitempty" yaml:"commit,omitempty"`
+	Pusher     string `json:"pusher" yaml:"pusher"`
+	Dinghyfile string `json:"dinghyfile" yaml:"dinghyfile"`
+	// Globals are the global variables the dinghyfile was rendered with, some change how it is applied
+	Globals map[string]interface{} `json:"globals,omitempty" yaml:"globals,omitempty"`
+	Status  string                 `json:"status" yaml:"status"`
+	// ExpiresAt is when the change request can't be approved anymore, 0 if never
+	ExpiresAt int64        `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+	Audit     []AuditEntry `json:"audit" yaml:"audit"`
+	Date      int64        `json:"date" yaml:"date"`
+}
+
+// AuditEntry is something that happened to a change request, and who did it
+type AuditEntry struct {
+	Date    int64  `json:"date" yaml:"date"`
+	User    string `json:"user" yaml:"user"`
+	Action  string `json:"action" yaml:"action"`
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
+}
+
+// Record adds an entry to the audit trail.
+func (r *ChangeRequest) Record(user, action, comment string) {
+	r.Audit = append(r.Audit, AuditEntry{Date: store.Now(), User: user, Action: action, Comment: comment})
+}
+
+// Expired reports if a pending change request can't be approved anymore.
+func (r ChangeRequest) Expired() bool {
+	return r.Status == StatusPending && r.ExpiresAt > 0 && store.Now() >= r.ExpiresAt
+}
+
+// SameDinghyfile checks if both change requests are of the same dinghyfile.
+func (r ChangeRequest) SameDinghyfile(other ChangeRequest) bool {
+	return r.Application == other.Application && r.Provider == other.Provider &&
+		r.Org == other.Org && r.Repo == other.Repo && r.Path == other.Path && r.Branch == other.Branch
+}
+
+func (r ChangeRequest) matches(status, application string) bool {
+	return (status == "" || r.Status == status) && (application == "" || r.Application == application)
+}
+
+func newestFirst(requests []ChangeRequest) []ChangeRequest {
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID > requests[j].ID
+	})
+	return requests
+}
@@ -0,0 +1,76 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package approvals
+
+import (
+	"sync"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// ChangeRequestMemoryClient keeps the change requests of this replica, the
+// pending ones are lost when it restarts.
+type ChangeRequestMemoryClient struct {
+	mutex    sync.Mutex
+	nextID   int64
+	requests map[int64]ChangeRequest
+}
+
+func NewChangeRequestMemoryClient() *ChangeRequestMemoryClient {
+	return &ChangeRequestMemoryClient{requests: map[int64]ChangeRequest{}}
+}
+
+func (c *ChangeRequestMemoryClient) SaveChangeRequest(request ChangeRequest) (ChangeRequest, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if request.ID == 0 {
+		c.nextID++
+		request.ID = c.nextID
+		request.Date = store.Now()
+	}
+	c.requests[request.ID] = request
+	return request, nil
+}
+
+func (c *ChangeRequestMemoryClient) TransitionChangeRequest(request ChangeRequest, from string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if saved, found := c.requests[request.ID]; !found || saved.Status != from {
+		return false, nil
+	}
+	c.requests[request.ID] = request
+	return true, nil
+}
+
+func (c *ChangeRequestMemoryClient) GetChangeRequest(id int64) (*ChangeRequest, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	request, found := c.requests[id]
+	if !found {
+		return nil, nil
+	}
+	return &request, nil
+}
+
+func (c *ChangeRequestMemoryClient) GetChangeRequests(status, application string) ([]ChangeRequest, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	found := []ChangeRequest{}
+	for _, r := range c.requests {
+		if r.matches(status, application) {
+			found = append(found, r)
+		}
+	}
+	return newestFirst(found), nil
+}
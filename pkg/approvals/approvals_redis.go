@@ -0,0 +1,156 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package approvals
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/store"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChangeRequestRedisClient keeps every change request in its own key, with a
+// list of all the change requests and a list of those of each application.
+type ChangeRequestRedisClient struct {
+	RedisClient *cache.RedisCache
+}
+
+func changeRequestKey(id string) string {
+	return cache.CompileKey("changerequest", id)
+}
+
+func (c ChangeRequestRedisClient) SaveChangeRequest(request ChangeRequest) (ChangeRequest, error) {
+	loge := log.WithFields(log.Fields{"func": "SaveChangeRequest"})
+	created := request.ID == 0
+	if created {
+		id, err := store.NextID(c.RedisClient.Client, cache.CompileKey("changerequests", "nextid"))
+		if err != nil {
+			return request, err
+		}
+		request.ID = id
+		request.Date = store.Now()
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "marshall change request", "id": request.ID}).Error(err)
+		return request, err
+	}
+
+	pipe := c.RedisClient.Client.TxPipeline()
+	pipe.Set(changeRequestKey(strconv.FormatInt(request.ID, 10)), requestBytes, 0)
+	if created {
+		pipe.LPush(cache.CompileKey("changerequests", "all"), request.ID)
+		pipe.LPush(cache.CompileKey("changerequests", "application", request.Application), request.ID)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		loge.WithFields(log.Fields{"operation": "save change request", "id": request.ID}).Error(err)
+		return request, err
+	}
+	return request, nil
+}
+
+func (c ChangeRequestRedisClient) TransitionChangeRequest(request ChangeRequest, from string) (bool, error) {
+	loge := log.WithFields(log.Fields{"func": "TransitionChangeRequest"})
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "marshall change request", "id": request.ID}).Error(err)
+		return false, err
+	}
+	key := changeRequestKey(strconv.FormatInt(request.ID, 10))
+	transitioned := false
+	err = c.RedisClient.Client.Watch(func(tx *redis.Tx) error {
+		value, err := tx.Get(key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var saved ChangeRequest
+		if err := json.Unmarshal([]byte(value), &saved); err != nil {
+			return err
+		}
+		if saved.Status != from {
+			return nil
+		}
+		// the transaction fails if the change request was saved since it was read
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.Set(key, requestBytes, 0)
+			return nil
+		})
+		transitioned = err == nil
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return false, nil
+	}
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "transition change request", "id": request.ID}).Error(err)
+		return false, err
+	}
+	return transitioned, nil
+}
+
+func (c ChangeRequestRedisClient) GetChangeRequest(id int64) (*ChangeRequest, error) {
+	found, err := c.load([]string{strconv.FormatInt(id, 10)})
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+func (c ChangeRequestRedisClient) GetChangeRequests(status, application string) ([]ChangeRequest, error) {
+	key := cache.CompileKey("changerequests", "all")
+	if application != "" {
+		key = cache.CompileKey("changerequests", "application", application)
+	}
+	ids, err := c.RedisClient.Client.LRange(key, 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	requests, err := c.load(ids)
+	if err != nil {
+		return nil, err
+	}
+	found := []ChangeRequest{}
+	for _, r := range requests {
+		if r.matches(status, application) {
+			found = append(found, r)
+		}
+	}
+	return newestFirst(found), nil
+}
+
+func (c ChangeRequestRedisClient) load(ids []string) ([]ChangeRequest, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = changeRequestKey(id)
+	}
+	requests := []ChangeRequest{}
+	err := store.LoadJSON(c.RedisClient.Client, keys, func(value []byte) error {
+		var request ChangeRequest
+		if err := json.Unmarshal(value, &request); err != nil {
+			return err
+		}
+		requests = append(requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,178 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package approvals
+
+import (
+	"errors"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/store"
+	"gorm.io/gorm"
+)
+
+// ChangeRequestSQLClient numbers change requests with the ids of the
+// changerequests table, their audit trail is encoded in a column.
+type ChangeRequestSQLClient struct {
+	SQLClient *database.SQLClient
+}
+
+func (ChangeRequestSQL) TableName() string {
+	return "changerequests"
+}
+
+type ChangeRequestSQL struct {
+	Id          int64  `gorm:"primaryKey;column:id"`
+	Application string `gorm:"column:application"`
+	Provider    string `gorm:"column:provider"`
+	Org         string `gorm:"column:org"`
+	Repo        string `gorm:"column:repo"`
+	Path        string `gorm:"column:path"`
+	Branch      string `gorm:"column:branch"`
+	Commit      string `gorm:"column:commitsha"`
+	Pusher      string `gorm:"column:pusher"`
+	Dinghyfile  string `gorm:"column:dinghyfile"`
+	Globals     string `gorm:"column:globals"`
+	Status      string `gorm:"column:status"`
+	Audit       string `gorm:"column:audit"`
+	ExpiresAt   int64  `gorm:"column:expiredate"`
+	Date        int64  `gorm:"column:requestdate"`
+}
+
+func (c ChangeRequestSQLClient) SaveChangeRequest(request ChangeRequest) (ChangeRequest, error) {
+	if request.ID == 0 {
+		request.Date = store.Now()
+	}
+	row, err := changeRequestRow(request)
+	if err != nil {
+		return request, err
+	}
+	if request.ID == 0 {
+		err = c.SQLClient.Client.Create(&row).Error
+	} else {
+		err = c.SQLClient.Client.Save(&row).Error
+	}
+	if err != nil {
+		return request, err
+	}
+	request.ID = row.Id
+	return request, nil
+}
+
+func (c ChangeRequestSQLClient) TransitionChangeRequest(request ChangeRequest, from string) (bool, error) {
+	row, err := changeRequestRow(request)
+	if err != nil {
+		return false, err
+	}
+	result := c.SQLClient.Client.Model(&ChangeRequestSQL{}).
+		Where("id = ? AND status = ?", request.ID, from).
+		Updates(map[string]interface{}{"status": row.Status, "audit": row.Audit, "expiredate": row.ExpiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func changeRequestRow(request ChangeRequest) (ChangeRequestSQL, error) {
+	audit, err := store.JSONColumn(request.Audit)
+	if err != nil {
+		return ChangeRequestSQL{}, err
+	}
+	globals, err := store.JSONColumn(request.Globals)
+	if err != nil {
+		return ChangeRequestSQL{}, err
+	}
+	return ChangeRequestSQL{
+		Id:          request.ID,
+		Application: request.Application,
+		Provider:    request.Provider,
+		Org:         request.Org,
+		Repo:        request.Repo,
+		Path:        request.Path,
+		Branch:      request.Branch,
+		Commit:      request.Commit,
+		Pusher:      request.Pusher,
+		Dinghyfile:  request.Dinghyfile,
+		Globals:     globals,
+		Status:      request.Status,
+		Audit:       audit,
+		ExpiresAt:   request.ExpiresAt,
+		Date:        request.Date,
+	}, nil
+}
+
+func (c ChangeRequestSQLClient) GetChangeRequest(id int64) (*ChangeRequest, error) {
+	row := ChangeRequestSQL{}
+	if err := c.SQLClient.Client.First(&row, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	request, err := row.changeRequest()
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (c ChangeRequestSQLClient) GetChangeRequests(status, application string) ([]ChangeRequest, error) {
+	query := c.SQLClient.Client.Order("id desc")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if application != "" {
+		query = query.Where("application = ?", application)
+	}
+	found := []ChangeRequestSQL{}
+	if err := query.Find(&found).Error; err != nil {
+		return nil, err
+	}
+	requests := make([]ChangeRequest, 0, len(found))
+	for _, row := range found {
+		request, err := row.changeRequest()
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func (row ChangeRequestSQL) changeRequest() (ChangeRequest, error) {
+	audit := []AuditEntry{}
+	if err := store.FromJSONColumn(row.Audit, &audit); err != nil {
+		return ChangeRequest{}, err
+	}
+	var globals map[string]interface{}
+	if err := store.FromJSONColumn(row.Globals, &globals); err != nil {
+		return ChangeRequest{}, err
+	}
+	return ChangeRequest{
+		ID:          row.Id,
+		Application: row.Application,
+		Provider:    row.Provider,
+		Org:         row.Org,
+		Repo:        row.Repo,
+		Path:        row.Path,
+		Branch:      row.Branch,
+		Commit:      row.Commit,
+		Pusher:      row.Pusher,
+		Dinghyfile:  row.Dinghyfile,
+		Globals:     globals,
+		Status:      row.Status,
+		Audit:       audit,
+		ExpiresAt:   row.ExpiresAt,
+		Date:        row.Date,
+	}, nil
+}
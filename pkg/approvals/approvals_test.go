@@ -0,0 +1,222 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package approvals
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/store"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func pendingRequest(application string) ChangeRequest {
+	request := ChangeRequest{
+		Application: application,
+		Provider:    "github",
+		Org:         "org",
+		Repo:        "repo",
+		Path:        "dinghyfile",
+		Branch:      "master",
+		Commit:      "abc",
+		Pusher:      "someone",
+		Dinghyfile:  `{"application":"` + application + `"}`,
+		Status:      StatusPending,
+	}
+	request.Record("someone", ActionCreated, "")
+	return request
+}
+
+func requestIDs(requests []ChangeRequest) []int64 {
+	found := []int64{}
+	for _, r := range requests {
+		found = append(found, r.ID)
+	}
+	return found
+}
+
+func newSQLClient(t *testing.T) ChangeRequestSQLClient {
+	config := &database.SQLConfig{Driver: database.SQLiteDriver, DbName: filepath.Join(t.TempDir(), "dinghy.db")}
+	sqlClient, err := database.NewSQLClient(config, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	return ChangeRequestSQLClient{SQLClient: sqlClient}
+}
+
+func TestChangeRequestMemoryClientFilters(t *testing.T) {
+	c := NewChangeRequestMemoryClient()
+	first, _ := c.SaveChangeRequest(pendingRequest("app"))
+	second, _ := c.SaveChangeRequest(pendingRequest("app"))
+	other, _ := c.SaveChangeRequest(pendingRequest("other"))
+	first.Status = StatusRejected
+	_, err := c.SaveChangeRequest(first)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		status, application string
+		expected            []int64
+	}{
+		{"", "", []int64{other.ID, second.ID, first.ID}},
+		{StatusPending, "", []int64{other.ID, second.ID}},
+		{"", "app", []int64{second.ID, first.ID}},
+		{StatusRejected, "app", []int64{first.ID}},
+		{StatusRejected, "other", []int64{}},
+		{"", "missing", []int64{}},
+	}
+	for _, tc := range cases {
+		found, err := c.GetChangeRequests(tc.status, tc.application)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, requestIDs(found), "status %q application %q", tc.status, tc.application)
+	}
+}
+
+func TestChangeRequestMemoryClientCopies(t *testing.T) {
+	c := NewChangeRequestMemoryClient()
+	saved, err := c.SaveChangeRequest(pendingRequest("app"))
+	assert.Nil(t, err)
+
+	// changing a change request read from the client doesn't save it
+	found, _ := c.GetChangeRequest(saved.ID)
+	found.Status = StatusApplied
+	found, _ = c.GetChangeRequest(saved.ID)
+	assert.Equal(t, StatusPending, found.Status)
+
+	missing, err := c.GetChangeRequest(saved.ID + 1)
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestChangeRequestSQLClient(t *testing.T) {
+	c := newSQLClient(t)
+	first, err := c.SaveChangeRequest(pendingRequest("app"))
+	assert.Nil(t, err)
+	assert.NotZero(t, first.ID)
+	assert.NotZero(t, first.Date)
+	second, _ := c.SaveChangeRequest(pendingRequest("app"))
+	other, _ := c.SaveChangeRequest(pendingRequest("other"))
+
+	// updates keep the id and date, and the audit trail round trips
+	first.Status = StatusRejected
+	first.Record("approver", ActionRejected, "not now")
+	first.Globals = map[string]interface{}{"save_app_on_update": true}
+	updated, err := c.SaveChangeRequest(first)
+	assert.Nil(t, err)
+	assert.Equal(t, first.ID, updated.ID)
+	found, err := c.GetChangeRequest(first.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusRejected, found.Status)
+	assert.Equal(t, first.Date, found.Date)
+	assert.Equal(t, []string{ActionCreated, ActionRejected}, []string{found.Audit[0].Action, found.Audit[1].Action})
+	assert.Equal(t, "not now", found.Audit[1].Comment)
+	assert.Equal(t, map[string]interface{}{"save_app_on_update": true}, found.Globals)
+
+	missing, err := c.GetChangeRequest(1000)
+	assert.Nil(t, err)
+	assert.Nil(t, missing)
+
+	pending, err := c.GetChangeRequests(StatusPending, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{other.ID, second.ID}, requestIDs(pending))
+	pending, err = c.GetChangeRequests(StatusPending, "app")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{second.ID}, requestIDs(pending))
+	all, err := c.GetChangeRequests("", "app")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{second.ID, first.ID}, requestIDs(all))
+}
+
+func TestChangeRequestSQLClientTransitionKeepsTheChange(t *testing.T) {
+	c := newSQLClient(t)
+	request, err := c.SaveChangeRequest(pendingRequest("app"))
+	assert.Nil(t, err)
+
+	// only the status, audit trail and expiration move, the held change stays
+	expired := request
+	expired.Status = StatusExpired
+	expired.Dinghyfile = `{"application":"changed"}`
+	expired.Record("dinghy", ActionExpired, "")
+	transitioned, err := c.TransitionChangeRequest(expired, StatusPending)
+	assert.Nil(t, err)
+	assert.True(t, transitioned)
+
+	found, err := c.GetChangeRequest(request.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusExpired, found.Status)
+	assert.Equal(t, `{"application":"app"}`, found.Dinghyfile)
+	assert.Len(t, found.Audit, 2)
+
+	transitioned, err = c.TransitionChangeRequest(expired, StatusPending)
+	assert.Nil(t, err)
+	assert.False(t, transitioned)
+}
+
+// testConcurrentApprovals approves the same change request from many
+// goroutines, only one of them must succeed
+func testConcurrentApprovals(t *testing.T, c Client) {
+	request, err := c.SaveChangeRequest(pendingRequest("app"))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	approvers := []string{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(approver string) {
+			defer wg.Done()
+			approved := request
+			approved.Status = StatusApplied
+			approved.Record(approver, ActionApproved, "")
+			transitioned, err := c.TransitionChangeRequest(approved, StatusPending)
+			assert.Nil(t, err)
+			if transitioned {
+				mutex.Lock()
+				approvers = append(approvers, approver)
+				mutex.Unlock()
+			}
+		}(fmt.Sprintf("approver%d", i))
+	}
+	wg.Wait()
+	assert.Len(t, approvers, 1)
+
+	found, err := c.GetChangeRequest(request.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, StatusApplied, found.Status)
+	assert.Len(t, found.Audit, 2)
+	assert.Equal(t, approvers[0], found.Audit[1].User)
+
+	// neither missing change requests
+	request.ID = 1000
+	transitioned, err := c.TransitionChangeRequest(request, StatusPending)
+	assert.Nil(t, err)
+	assert.False(t, transitioned)
+}
+
+func TestConcurrentApprovals(t *testing.T) {
+	testConcurrentApprovals(t, NewChangeRequestMemoryClient())
+	testConcurrentApprovals(t, newSQLClient(t))
+}
+
+func TestExpired(t *testing.T) {
+	request := pendingRequest("app")
+	assert.False(t, request.Expired())
+	request.ExpiresAt = store.Now() - 1
+	assert.True(t, request.Expired())
+	request.Status = StatusApplied
+	assert.False(t, request.Expired())
+}
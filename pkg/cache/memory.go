@@ -69,7 +69,7 @@ func (c MemoryCache) GetRawData(url string) (string, error) {
 }
 
 // SetDeps sets the dependencies for a parent
-func (c MemoryCache) SetDeps(parent string, deps []string) {
+func (c MemoryCache) SetDeps(parent string, deps []string) error {
 	if _, exists := c[parent]; !exists {
 		c[parent] = NewNode(parent)
 	}
@@ -113,6 +113,7 @@ func (c MemoryCache) SetDeps(parent string, deps []string) {
 			foundNode.Parents = append(foundNode.Parents, node)
 		}
 	}
+	return nil
 }
 
 // UpstreamURLs returns two arrays:
@@ -171,6 +172,28 @@ func (c MemoryCache) GetRoots(url string) []string {
 	return roots
 }
 
+// GetChildren returns the direct dependencies of url
+func (c MemoryCache) GetChildren(url string) []string {
+	children := make([]string, 0)
+	if n, exists := c[url]; exists {
+		for _, child := range n.Children {
+			children = append(children, child.URL)
+		}
+	}
+	return children
+}
+
+// GetAllDinghyfiles returns every dinghyfile, the nodes with no parents
+func (c MemoryCache) GetAllDinghyfiles() []string {
+	dinghyfiles := make([]string, 0)
+	for url, n := range c {
+		if len(n.Parents) == 0 {
+			dinghyfiles = append(dinghyfiles, url)
+		}
+	}
+	return dinghyfiles
+}
+
 // Dump prints the cache, used for debugging
 func (c MemoryCache) Dump() {
 	for k, v := range c {
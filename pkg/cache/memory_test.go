@@ -114,3 +114,15 @@ func createCache() MemoryCache {
 
 	return c
 }
+
+func TestChildrenAndDinghyfiles(t *testing.T) {
+	c := NewMemoryCache()
+	c.SetDeps("df1", []string{"mod1", "mod2"})
+	c.SetDeps("df2", []string{})
+	c.SetDeps("mod1", []string{"mod3"})
+
+	assert.ElementsMatch(t, []string{"mod1", "mod2"}, c.GetChildren("df1"))
+	assert.Equal(t, []string{}, c.GetChildren("mod3"))
+	assert.Equal(t, []string{}, c.GetChildren("missing"))
+	assert.ElementsMatch(t, []string{"df1", "df2"}, c.GetAllDinghyfiles())
+}
@@ -77,67 +77,79 @@ func (c *RedisCache) monitorWorker() {
 	}
 }
 
+// setDepsScript replaces the children of a parent and mirrors the change in
+// the parents sets of the added and removed children. Scripts run atomically,
+// other clients never see a half updated graph.
+//
+// Every key is passed in KEYS: KEYS[1] is the children set of the parent,
+// followed by the parents sets of the current children and of the new
+// children. ARGV[1] is the parent, ARGV[2] the number n of current children,
+// ARGV[3..2+n] the current children and the rest the new children. The current
+// children are read before running the script, it returns -1 without changes
+// when they changed in between.
+var setDepsScript = redis.NewScript(`
+local n = tonumber(ARGV[2])
+if redis.call('SCARD', KEYS[1]) ~= n then
+	return -1
+end
+for i = 1, n do
+	if redis.call('SISMEMBER', KEYS[1], ARGV[2 + i]) == 0 then
+		return -1
+	end
+end
+local deps = {}
+for i = 3 + n, #ARGV do
+	deps[ARGV[i]] = true
+end
+for i = 1, n do
+	if not deps[ARGV[2 + i]] then
+		redis.call('SREM', KEYS[1], ARGV[2 + i])
+		redis.call('SREM', KEYS[1 + i], ARGV[1])
+	end
+end
+for i = 3 + n, #ARGV do
+	redis.call('SADD', KEYS[1], ARGV[i])
+	redis.call('SADD', KEYS[i - 1], ARGV[1])
+end
+return #ARGV - 2 - n
+`)
+
+// setDepsAttempts is the number of times SetDeps reads the current children
+// again when they change while setting the dependencies
+const setDepsAttempts = 5
+
 // SetDeps sets dependencies for a parent
-func (c *RedisCache) SetDeps(parent string, deps []string) {
+func (c *RedisCache) SetDeps(parent string, deps []string) error {
 	loge := log.WithFields(log.Fields{"func": "SetDeps"})
 	key := CompileKey("children", parent)
 
-	currentDeps, err := c.Client.SMembers(key).Result()
-	if err != nil {
-		loge.WithFields(log.Fields{"operation": "get members", "key": key}).Error(err)
-		return
-	}
-
-	// suppose current deps are (a, b, c) and new deps are (c, d, e)
-
-	// generate (a, b)
-	toDelete := make(map[string]bool, 0)
-	for _, currentDep := range currentDeps {
-		toDelete[currentDep] = true
-	}
-	for _, dep := range deps {
-		delete(toDelete, dep)
-	}
-	depsToDelete := make([]interface{}, 0)
-	for key := range toDelete {
-		depsToDelete = append(depsToDelete, key)
-	}
-
-	// generate (d, e)
-	toAdd := make(map[string]bool, 0)
-	for _, dep := range deps {
-		toAdd[dep] = true
-	}
-	for _, currentDep := range currentDeps {
-		delete(toAdd, currentDep)
-	}
-	depsToAdd := make([]interface{}, 0)
-	for _, key := range deps {
-		depsToAdd = append(depsToAdd, key)
-	}
-
-	//TODO:  if these redis operations fail, what happens?
-	key = CompileKey("children", parent)
-	if _, err := c.Client.SRem(key, depsToDelete...).Result(); err != nil {
-		loge.WithFields(log.Fields{"operation": "child delete deps"}).Debug(err)
-	}
-	if _, err := c.Client.SAdd(key, depsToAdd...).Result(); err != nil {
-		loge.WithFields(log.Fields{"operation": "child add deps"}).Debug(err)
-	}
-
-	for _, dep := range depsToDelete {
-		key = CompileKey("parents", dep.(string))
-		if _, err := c.Client.SRem(key, parent).Result(); err != nil {
-			loge.WithFields(log.Fields{"operation": "delete deps"}).Debug(err)
+	for attempt := 0; attempt < setDepsAttempts; attempt++ {
+		current, err := c.Client.SMembers(key).Result()
+		if err != nil {
+			loge.WithFields(log.Fields{"operation": "get children", "key": key}).Error(err)
+			return fmt.Errorf("unable to set dependencies of %s: %w", parent, err)
 		}
-	}
 
-	for _, dep := range depsToAdd {
-		key = CompileKey("parents", dep.(string))
-		if _, err := c.Client.SAdd(key, parent).Result(); err != nil {
-			loge.WithFields(log.Fields{"operation": "add deps"}).Debug(err)
+		keys := []string{key}
+		args := []interface{}{parent, len(current)}
+		for _, child := range current {
+			keys = append(keys, CompileKey("parents", child))
+			args = append(args, child)
+		}
+		for _, dep := range deps {
+			keys = append(keys, CompileKey("parents", dep))
+			args = append(args, dep)
+		}
+		added, err := setDepsScript.Run(c.Client, keys, args...).Int64()
+		if err != nil {
+			loge.WithFields(log.Fields{"operation": "set deps", "key": key}).Error(err)
+			return fmt.Errorf("unable to set dependencies of %s: %w", parent, err)
+		}
+		if added >= 0 {
+			return nil
 		}
 	}
+	return fmt.Errorf("unable to set dependencies of %s: its children kept changing", parent)
 }
 
 // GetRoots grabs roots
@@ -207,6 +219,20 @@ func returnRawData(c *redis.Client, url string) (string, error) {
 	return stringCmd.Result()
 }
 
+// GetPollState returns the last processed sha of a polled branch
+func (c *RedisCache) GetPollState(key string) (string, error) {
+	sha, err := c.Client.Get(CompileKey("pollstate", key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return sha, err
+}
+
+// SetPollState saves the last processed sha of a polled branch
+func (c *RedisCache) SetPollState(key string, sha string) error {
+	return c.Client.Set(CompileKey("pollstate", key), sha, 0).Err()
+}
+
 // Clear clears everything
 func (c *RedisCache) Clear() {
 	keys, _ := c.Client.Keys(CompileKey("children", "*")).Result()
@@ -217,18 +243,22 @@ func (c *RedisCache) Clear() {
 }
 
 
-// Get all Dinghyfiles
+// GetAllDinghyfiles returns every dinghyfile, the nodes with no parents
 func (c *RedisCache) GetAllDinghyfiles() []string {
+	return returnAllDinghyfiles(c.Client)
+}
+
+func returnAllDinghyfiles(c *redis.Client) []string {
 	loge := log.WithFields(log.Fields{"func": "GetAllDinghyfiles"})
 	key := CompileKey("parents", "*")
 	var cursor uint64
 	result := []string{}
 	childrens := map[string]bool{}
 	for {
-		keys, nextcursor, err := c.Client.Scan(cursor, key, 1000).Result()
+		keys, nextcursor, err := c.Scan(cursor, key, 1000).Result()
 		cursor = nextcursor
 		if err != nil {
-			loge.WithFields(log.Fields{"operation": "scan key", "key": CompileKey("parents","*")}).Error(err)
+			loge.WithFields(log.Fields{"operation": "scan key", "key": key}).Error(err)
 			return result
 		}
 		for _, key := range keys {
@@ -240,14 +270,16 @@ func (c *RedisCache) GetAllDinghyfiles() []string {
 		}
 	}
 
-	for currentChildren, _ := range childrens {
-		parents, errorNoKey := c.Client.SMembers(currentChildren).Result()
+	found := map[string]bool{}
+	for currentChildren := range childrens {
+		parents, errorNoKey := c.SMembers(currentChildren).Result()
 		if errorNoKey != nil {
 			continue
 		}
 		for _, currentParent := range parents {
 			compiledChildren := CompileKey("parents", currentParent)
-			if _, ok := childrens[compiledChildren]; !ok {
+			if _, ok := childrens[compiledChildren]; !ok && !found[currentParent] {
+				found[currentParent] = true
 				result = append(result, currentParent)
 			}
 		}
@@ -256,12 +288,16 @@ func (c *RedisCache) GetAllDinghyfiles() []string {
 	return result
 }
 
-// Get all childrens from url
+// GetChildren returns the direct dependencies of url
 func (c *RedisCache) GetChildren(url string) []string {
+	return returnChildren(c.Client, url)
+}
+
+func returnChildren(c *redis.Client, url string) []string {
 	loge := log.WithFields(log.Fields{"func": "GetChildren"})
 	key := CompileKey("children", url)
 
-	childrens, errorNoKey := c.Client.SMembers(key).Result()
+	childrens, errorNoKey := c.SMembers(key).Result()
 	if errorNoKey != nil {
 		loge.WithFields(log.Fields{"operation": "SMembers key", "key": key}).Error(errorNoKey)
 		return []string{}
@@ -270,3 +306,77 @@ func (c *RedisCache) GetChildren(url string) []string {
 	return childrens
 }
 
+// GraphInconsistency is an edge of the graph recorded only on one side, Missing
+// is the side lacking it: "parents" or "children".
+type GraphInconsistency struct {
+	Parent  string `json:"parent"`
+	Child   string `json:"child"`
+	Missing string `json:"missing"`
+}
+
+// CheckGraph verifies every children edge has its parents mirror and the other
+// way around.
+func (c *RedisCache) CheckGraph() ([]GraphInconsistency, error) {
+	return checkGraph(c.Client)
+}
+
+func checkGraph(c *redis.Client) ([]GraphInconsistency, error) {
+	children, err := readEdges(c, "children")
+	if err != nil {
+		return nil, err
+	}
+	parents, err := readEdges(c, "parents")
+	if err != nil {
+		return nil, err
+	}
+
+	inconsistencies := []GraphInconsistency{}
+	for parent, deps := range children {
+		for child := range deps {
+			if !parents[child][parent] {
+				inconsistencies = append(inconsistencies, GraphInconsistency{Parent: parent, Child: child, Missing: "parents"})
+			}
+		}
+	}
+	for child, deps := range parents {
+		for parent := range deps {
+			if !children[parent][child] {
+				inconsistencies = append(inconsistencies, GraphInconsistency{Parent: parent, Child: child, Missing: "children"})
+			}
+		}
+	}
+	return inconsistencies, nil
+}
+
+// readEdges loads every set of the given kind ("children" or "parents") keyed
+// by the url it belongs to.
+func readEdges(c *redis.Client, kind string) (map[string]map[string]bool, error) {
+	loge := log.WithFields(log.Fields{"func": "CheckGraph"})
+	prefix := CompileKey(kind, "")
+	edges := map[string]map[string]bool{}
+	var cursor uint64
+	for {
+		keys, nextcursor, err := c.Scan(cursor, prefix+"*", 1000).Result()
+		if err != nil {
+			loge.WithFields(log.Fields{"operation": "scan key", "key": prefix + "*"}).Error(err)
+			return nil, err
+		}
+		for _, key := range keys {
+			members, err := c.SMembers(key).Result()
+			if err != nil {
+				loge.WithFields(log.Fields{"operation": "SMembers key", "key": key}).Error(err)
+				return nil, err
+			}
+			set := map[string]bool{}
+			for _, member := range members {
+				set[member] = true
+			}
+			edges[strings.TrimPrefix(key, prefix)] = set
+		}
+		cursor = nextcursor
+		if cursor == 0 {
+			break
+		}
+	}
+	return edges, nil
+}
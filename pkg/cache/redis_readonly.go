@@ -32,8 +32,8 @@ type RedisCacheReadOnly struct {
 }
 
 // SetDeps sets dependencies for a parent
-func (c *RedisCacheReadOnly) SetDeps(parent string, deps []string) {
-
+func (c *RedisCacheReadOnly) SetDeps(parent string, deps []string) error {
+	return nil
 }
 
 // GetRoots grabs roots
@@ -41,6 +41,21 @@ func (c *RedisCacheReadOnly) GetRoots(url string) []string {
 	return returnRoots(c.Client, url)
 }
 
+// GetChildren returns the direct dependencies of url
+func (c *RedisCacheReadOnly) GetChildren(url string) []string {
+	return returnChildren(c.Client, url)
+}
+
+// GetAllDinghyfiles returns every dinghyfile
+func (c *RedisCacheReadOnly) GetAllDinghyfiles() []string {
+	return returnAllDinghyfiles(c.Client)
+}
+
+// CheckGraph verifies every children edge has its parents mirror
+func (c *RedisCacheReadOnly) CheckGraph() ([]GraphInconsistency, error) {
+	return checkGraph(c.Client)
+}
+
 // Set RawData
 func (c *RedisCacheReadOnly) SetRawData(url string, rawData string) error {
 	return nil
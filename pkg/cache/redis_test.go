@@ -57,4 +57,22 @@ func TestRedisCache(t *testing.T) {
 
 	c.SetDeps("mod1", []string{"mod3"})
 	assert.EqualValuesf(t, []string{}, c.GetRoots("mod4"), "mod4 should have no roots")
+	assert.ElementsMatch(t, []string{"mod3"}, c.GetChildren("mod1"))
+	assert.ElementsMatch(t, []string{"df1"}, c.GetAllDinghyfiles())
+
+	// the script doesn't change children that changed since they were read
+	changed, err := setDepsScript.Run(c.Client, []string{CompileKey("children", "mod1"), CompileKey("parents", "mod5")}, "mod1", 0, "mod5").Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), changed)
+	assert.ElementsMatch(t, []string{"mod3"}, c.GetChildren("mod1"))
+
+	inconsistencies, err := c.CheckGraph()
+	assert.Nil(t, err)
+	assert.Empty(t, inconsistencies)
+
+	// an edge without its mirror
+	c.Client.SAdd(CompileKey("children", "df2"), "mod1")
+	inconsistencies, err = c.CheckGraph()
+	assert.Nil(t, err)
+	assert.Equal(t, []GraphInconsistency{{Parent: "df2", Child: "mod1", Missing: "parents"}}, inconsistencies)
 }
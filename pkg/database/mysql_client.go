@@ -33,6 +33,10 @@ func NewMySQLClient(sqlOptions *SQLConfig, logger *log.Logger, ctx context.Conte
 		return nil, err
 	}
 
+	return newSQLClient(db, ctx, stop), nil
+}
+
+func newSQLClient(db *gorm.DB, ctx context.Context, stop chan os.Signal) *SQLClient {
 	//TODO: Add logger so queries can be seen, create a parameter later for this
 	sqlclient := &SQLClient{
 		Client: db,
@@ -42,7 +46,7 @@ func NewMySQLClient(sqlOptions *SQLConfig, logger *log.Logger, ctx context.Conte
 	}
 
 	go sqlclient.monitorWorker()
-	return sqlclient, nil
+	return sqlclient
 }
 
 func (c *SQLClient) monitorWorker() {
@@ -60,7 +64,7 @@ func (c *SQLClient) monitorWorker() {
 				count++
 				logger.Errorf("SQL monitor failed %d times (5 max)", count)
 				if count >= 5 {
-					logger.Error("Stopping dinghy because communication with MySQL database failed")
+					logger.Error("Stopping dinghy because communication with SQL database failed")
 					timer.Stop()
 					c.stop <- syscall.SIGINT
 				}
@@ -74,8 +78,31 @@ func (c *SQLClient) monitorWorker() {
 }
 
 type SQLConfig struct {
+	Driver   string
 	DbUrl    string
 	User     string
 	Password string
 	DbName   string
 }
+
+// Supported values of SQLConfig.Driver
+const (
+	MySQLDriver      = "mysql"
+	PostgreSQLDriver = "postgres"
+	SQLiteDriver     = "sqlite"
+)
+
+// NewSQLClient initializes the client of the database selected by the driver,
+// MySQL when it is not set
+func NewSQLClient(sqlOptions *SQLConfig, logger *log.Logger, ctx context.Context, stop chan os.Signal) (*SQLClient, error) {
+	switch sqlOptions.Driver {
+	case "", MySQLDriver:
+		return NewMySQLClient(sqlOptions, logger, ctx, stop)
+	case PostgreSQLDriver:
+		return NewPostgreSQLClient(sqlOptions, logger, ctx, stop)
+	case SQLiteDriver:
+		return NewSQLiteClient(sqlOptions, logger, ctx, stop)
+	default:
+		return nil, fmt.Errorf("unsupported sql driver %q, supported drivers are %s, %s and %s", sqlOptions.Driver, MySQLDriver, PostgreSQLDriver, SQLiteDriver)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package database
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const postgresDefaultPort = "5432"
+
+// NewPostgreSQLClient initializes a PostgreSQL Client, DbUrl is the host and
+// optionally the port of the server
+func NewPostgreSQLClient(sqlOptions *SQLConfig, logger *log.Logger, ctx context.Context, stop chan os.Signal) (*SQLClient, error) {
+	db, err := gorm.Open(postgres.Open(postgresDSN(sqlOptions)), &gorm.Config{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSQLClient(db, ctx, stop), nil
+}
+
+// postgresDSN returns the URL of the database, the credentials and database
+// name are escaped so they can't break it or add parameters.
+func postgresDSN(sqlOptions *SQLConfig) string {
+	host, port, err := net.SplitHostPort(sqlOptions.DbUrl)
+	if err != nil {
+		host, port = sqlOptions.DbUrl, postgresDefaultPort
+	}
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(sqlOptions.User, sqlOptions.Password),
+		Host:   net.JoinHostPort(host, port),
+		Path:   "/" + sqlOptions.DbName,
+	}
+	return dsn.String()
+}
@@ -17,7 +17,9 @@ import (
 	"context"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"os"
+	"time"
 )
 
 type SQLClient struct {
@@ -49,32 +51,102 @@ func (ExecutionSQL) TableName() string {
 	return "executions"
 }
 
-// SetDeps sets dependencies for a parent
-func (c *SQLClient) SetDeps(parent string, deps []string) {
+type PollStateSQL struct {
+	PollKey         string `gorm:"primaryKey;column:pollkey"`
+	Sha             string `gorm:"column:sha"`
+	LastUpdatedDate int64  `gorm:"column:lastupdateddate"`
+}
 
-	currParent := Fileurl{}
-	c.Client.Where(&Fileurl{Url: parent}).Find(&currParent)
-	if currParent.Url == "" {
-		currParent.Url = parent
-		c.Client.Create(&currParent)
-	}
-	children := []FileurlChilds{}
-	c.Client.Where(&FileurlChilds{FileurlID: currParent.Id}).Find(&children)
-	for _, currDep := range deps {
-		foundDep := Fileurl{}
-		c.Client.Where(&Fileurl{Url: currDep}).Find(&foundDep)
-		if foundDep.Url == "" {
-			foundDep.Url = currDep
-			c.Client.Create(&foundDep)
+func (PollStateSQL) TableName() string {
+	return "pollstate"
+}
+
+// SetDeps replaces the dependencies of a parent in a single transaction,
+// removing the edges to children it doesn't use anymore.
+func (c *SQLClient) SetDeps(parent string, deps []string) error {
+	deps = uniqueURLs(deps)
+	return c.Client.Transaction(func(tx *gorm.DB) error {
+		ids, err := fileurlIDs(tx, append([]string{parent}, deps...))
+		if err != nil {
+			return err
+		}
+		parentID := ids[parent]
+		childIDs := make([]int, 0, len(deps))
+		for _, dep := range deps {
+			childIDs = append(childIDs, ids[dep])
+		}
+
+		stale := tx.Where("fileurl_id = ?", parentID)
+		if len(childIDs) > 0 {
+			stale = stale.Where("childfileurl_id NOT IN ?", childIDs)
 		}
-		if !containsChildId(children, foundDep.Id) {
-			c.Client.Create(&FileurlChilds{
-				FileurlID:      currParent.Id,
-				ChildfileurlId: foundDep.Id,
-			})
+		if err := stale.Delete(&FileurlChilds{}).Error; err != nil {
+			return err
 		}
+
+		children := []FileurlChilds{}
+		if err := tx.Where("fileurl_id = ?", parentID).Find(&children).Error; err != nil {
+			return err
+		}
+		missing := []FileurlChilds{}
+		for _, childID := range childIDs {
+			if !containsChildId(children, childID) {
+				missing = append(missing, FileurlChilds{FileurlID: parentID, ChildfileurlId: childID})
+			}
+		}
+		if len(missing) > 0 {
+			return tx.Create(&missing).Error
+		}
+		return nil
+	})
+}
+
+// fileurlIDs returns the id of every url, creating the ones not stored yet.
+// Another replica can create the same urls meanwhile, their rows are left as
+// they are and the ids are selected again.
+func fileurlIDs(tx *gorm.DB, urls []string) (map[string]int, error) {
+	ids, err := selectFileurlIDs(tx, urls)
+	if err != nil {
+		return nil, err
 	}
 
+	missing := []Fileurl{}
+	for _, url := range uniqueURLs(urls) {
+		if _, exists := ids[url]; !exists {
+			missing = append(missing, Fileurl{Url: url})
+		}
+	}
+	if len(missing) == 0 {
+		return ids, nil
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error; err != nil {
+		return nil, err
+	}
+	return selectFileurlIDs(tx, urls)
+}
+
+func selectFileurlIDs(tx *gorm.DB, urls []string) (map[string]int, error) {
+	found := []Fileurl{}
+	if err := tx.Select("id", "url").Where("url IN ?", urls).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	ids := map[string]int{}
+	for _, f := range found {
+		ids[f.Url] = f.Id
+	}
+	return ids, nil
+}
+
+func uniqueURLs(urls []string) []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !seen[url] {
+			seen[url] = true
+			result = append(result, url)
+		}
+	}
+	return result
 }
 
 func containsChildId(slice []FileurlChilds, searchChildId int) bool {
@@ -94,34 +166,62 @@ func (c *SQLClient) GetRoots(url string) []string {
 	return returnRoots(c, url)
 }
 
+// rootsQuery walks up the graph from a url and keeps the ancestors nobody
+// depends on. UNION discards the rows already visited so cycles terminate.
+// Recursive CTEs need MySQL 8.
+const rootsQuery = `
+WITH RECURSIVE ancestors (id) AS (
+	SELECT id FROM fileurls WHERE url = ?
+	UNION
+	SELECT e.fileurl_id FROM fileurl_childs e JOIN ancestors a ON e.childfileurl_id = a.id
+)
+SELECT f.url FROM fileurls f JOIN ancestors a ON f.id = a.id
+WHERE f.url <> ? AND NOT EXISTS (SELECT 1 FROM fileurl_childs p WHERE p.childfileurl_id = f.id)`
+
 func returnRoots(c *SQLClient, url string) []string {
 	results := []string{}
-	currUrl := Fileurl{}
-	c.Client.Where(&Fileurl{Url: url}).Find(&currUrl)
-	if currUrl.Url != "" {
-		parents := []FileurlChilds{{FileurlID: currUrl.Id}}
-		tempParent := []FileurlChilds{}
-		for {
-			for _, currParent := range parents {
-				records := []FileurlChilds{}
-				c.Client.Where(&FileurlChilds{ChildfileurlId: currParent.FileurlID}).Find(&records)
-				if len(records) == 0 {
-					resultUrl := Fileurl{}
-					c.Client.Where(&Fileurl{Id: currParent.FileurlID}).Find(&resultUrl)
-					results = append(results, resultUrl.Url)
-				} else {
-					for _, currRecord := range records {
-						tempParent = append(tempParent, currRecord)
-					}
-				}
-			}
-			if len(tempParent) == 0 {
-				break
-			} else {
-				parents = tempParent
-				tempParent = []FileurlChilds{}
-			}
-		}
+	if err := c.Client.Raw(rootsQuery, url, url).Scan(&results).Error; err != nil {
+		log.WithFields(log.Fields{"func": "GetRoots", "url": url}).Error(err)
+		return []string{}
+	}
+	return results
+}
+
+// GetChildren returns the direct dependencies of url
+func (c *SQLClient) GetChildren(url string) []string {
+	return returnChildren(c, url)
+}
+
+const childrenQuery = `
+SELECT c.url FROM fileurls p
+JOIN fileurl_childs e ON e.fileurl_id = p.id
+JOIN fileurls c ON c.id = e.childfileurl_id
+WHERE p.url = ?`
+
+func returnChildren(c *SQLClient, url string) []string {
+	results := []string{}
+	if err := c.Client.Raw(childrenQuery, url).Scan(&results).Error; err != nil {
+		log.WithFields(log.Fields{"func": "GetChildren", "url": url}).Error(err)
+		return []string{}
+	}
+	return results
+}
+
+// GetAllDinghyfiles returns every dinghyfile, the urls that are not a
+// dependency of another one
+func (c *SQLClient) GetAllDinghyfiles() []string {
+	return returnAllDinghyfiles(c)
+}
+
+const allDinghyfilesQuery = `
+SELECT f.url FROM fileurls f
+WHERE NOT EXISTS (SELECT 1 FROM fileurl_childs p WHERE p.childfileurl_id = f.id)`
+
+func returnAllDinghyfiles(c *SQLClient) []string {
+	results := []string{}
+	if err := c.Client.Raw(allDinghyfilesQuery).Scan(&results).Error; err != nil {
+		log.WithFields(log.Fields{"func": "GetAllDinghyfiles"}).Error(err)
+		return []string{}
 	}
 	return results
 }
@@ -141,3 +241,20 @@ func returnRawData(c *SQLClient, url string) (string, error) {
 	result := c.Client.Where(&Fileurl{Url: url}).Find(&find)
 	return find.Rawdata, result.Error
 }
+
+// GetPollState returns the last processed sha of a polled branch
+func (c *SQLClient) GetPollState(key string) (string, error) {
+	states := []PollStateSQL{}
+	if err := c.Client.Where(&PollStateSQL{PollKey: key}).Find(&states).Error; err != nil {
+		return "", err
+	}
+	if len(states) == 0 {
+		return "", nil
+	}
+	return states[0].Sha, nil
+}
+
+// SetPollState saves the last processed sha of a polled branch
+func (c *SQLClient) SetPollState(key string, sha string) error {
+	return c.Client.Save(&PollStateSQL{PollKey: key, Sha: sha, LastUpdatedDate: time.Now().UnixNano() / 1000000}).Error
+}
@@ -0,0 +1,102 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSQLDependencies(t *testing.T) {
+	c, url := connectToSQL(t)
+
+	assert.Nil(t, c.SetDeps(url("df1"), []string{url("mod1"), url("mod2")}))
+	assert.Nil(t, c.SetDeps(url("df2"), []string{url("mod2")}))
+	assert.Nil(t, c.SetDeps(url("mod1"), []string{url("mod3"), url("mod4")}))
+
+	assert.ElementsMatch(t, []string{url("df1")}, c.GetRoots(url("mod4")))
+	assert.ElementsMatch(t, []string{url("df1"), url("df2")}, c.GetRoots(url("mod2")))
+	assert.ElementsMatch(t, []string{url("mod1"), url("mod2")}, c.GetChildren(url("df1")))
+	assert.Empty(t, c.GetRoots(url("df1")))
+
+	// stale edges are removed
+	assert.Nil(t, c.SetDeps(url("mod1"), []string{url("mod3")}))
+	assert.Empty(t, c.GetRoots(url("mod4")))
+	assert.ElementsMatch(t, []string{url("mod3")}, c.GetChildren(url("mod1")))
+
+	// setting the same dependencies twice keeps a single edge
+	assert.Nil(t, c.SetDeps(url("df2"), []string{url("mod2"), url("mod2")}))
+	assert.Equal(t, []string{url("mod2")}, c.GetChildren(url("df2")))
+
+	dinghyfiles := []string{}
+	for _, df := range c.GetAllDinghyfiles() {
+		if df == url("df1") || df == url("df2") || df == url("mod1") {
+			dinghyfiles = append(dinghyfiles, df)
+		}
+	}
+	assert.ElementsMatch(t, []string{url("df1"), url("df2")}, dinghyfiles)
+}
+
+func TestSQLDependenciesCreatedMeanwhile(t *testing.T) {
+	c, url := connectToSQL(t)
+
+	// another replica stores the child after it was looked up
+	created := false
+	assert.Nil(t, c.Client.Callback().Create().Before("gorm:create").Register("test:created_meanwhile", func(db *gorm.DB) {
+		if _, ok := db.Statement.Dest.(*[]Fileurl); ok && !created {
+			created = true
+			assert.Nil(t, db.Session(&gorm.Session{NewDB: true}).Exec("INSERT INTO fileurls (url) VALUES (?)", url("mod1")).Error)
+		}
+	}))
+	defer c.Client.Callback().Create().Remove("test:created_meanwhile")
+
+	assert.Nil(t, c.SetDeps(url("df1"), []string{url("mod1")}))
+	assert.True(t, created)
+	assert.Equal(t, []string{url("mod1")}, c.GetChildren(url("df1")))
+	assert.ElementsMatch(t, []string{url("df1")}, c.GetRoots(url("mod1")))
+}
+
+func TestSQLDependencyCycle(t *testing.T) {
+	c, url := connectToSQL(t)
+
+	assert.Nil(t, c.SetDeps(url("df1"), []string{url("mod1")}))
+	assert.Nil(t, c.SetDeps(url("mod1"), []string{url("mod2")}))
+	assert.Nil(t, c.SetDeps(url("mod2"), []string{url("mod1")}))
+
+	assert.ElementsMatch(t, []string{url("df1")}, c.GetRoots(url("mod2")))
+}
+
+func TestSQLChildrenAndDinghyfiles(t *testing.T) {
+	c, url := connectToSQL(t)
+
+	assert.Nil(t, c.SetDeps(url("df1"), []string{url("mod1"), url("mod2")}))
+	assert.Nil(t, c.SetDeps(url("df2"), []string{}))
+	assert.Nil(t, c.SetDeps(url("mod1"), []string{url("mod3")}))
+
+	assert.ElementsMatch(t, []string{url("mod1"), url("mod2")}, c.GetChildren(url("df1")))
+	assert.Empty(t, c.GetChildren(url("mod3")))
+	assert.Empty(t, c.GetChildren(url("missing")))
+
+	// dinghyfiles without modules are still dinghyfiles
+	dinghyfiles := []string{}
+	for _, df := range c.GetAllDinghyfiles() {
+		if strings.HasPrefix(df, url("")) {
+			dinghyfiles = append(dinghyfiles, df)
+		}
+	}
+	assert.ElementsMatch(t, []string{url("df1"), url("df2")}, dinghyfiles)
+}
@@ -31,8 +31,8 @@ type SQLReadOnly struct {
 }
 
 // SetDeps sets dependencies for a parent
-func (c *SQLReadOnly) SetDeps(parent string, deps []string) {
-
+func (c *SQLReadOnly) SetDeps(parent string, deps []string) error {
+	return nil
 }
 
 // GetRoots grabs roots
@@ -40,6 +40,16 @@ func (c *SQLReadOnly) GetRoots(url string) []string {
 	return returnRoots(c.Client, url)
 }
 
+// GetChildren returns the direct dependencies of url
+func (c *SQLReadOnly) GetChildren(url string) []string {
+	return returnChildren(c.Client, url)
+}
+
+// GetAllDinghyfiles returns every dinghyfile
+func (c *SQLReadOnly) GetAllDinghyfiles() []string {
+	return returnAllDinghyfiles(c.Client)
+}
+
 // Set RawData
 func (c *SQLReadOnly) SetRawData(url string, rawData string) error {
 	return nil
@@ -0,0 +1,167 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// sqliteSchema mirrors the liquibase changelog, there is no database server
+// to run liquibase against so the client creates the tables when it opens the file.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS fileurls (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL UNIQUE,
+		rawdata TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS fileurl_childs (
+		fileurl_id INTEGER NOT NULL REFERENCES fileurls (id),
+		childfileurl_id INTEGER NOT NULL REFERENCES fileurls (id),
+		UNIQUE (fileurl_id, childfileurl_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS executions (
+		execution TEXT PRIMARY KEY,
+		result TEXT,
+		success TEXT NOT NULL,
+		lastupdateddate INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS logevents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		org TEXT NOT NULL,
+		repo TEXT NOT NULL,
+		files TEXT NOT NULL,
+		message TEXT NOT NULL,
+		commitdate INTEGER NOT NULL,
+		commits TEXT NOT NULL,
+		status TEXT NOT NULL,
+		rawdata TEXT NOT NULL,
+		author TEXT,
+		rendereddinghyfile TEXT,
+		pullrequest TEXT,
+		pipelines TEXT
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_logevents_commitdate ON logevents (commitdate)`,
+	`CREATE TABLE IF NOT EXISTS deliveries (
+		id TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		statuscode INTEGER NOT NULL,
+		response TEXT,
+		deliverydate INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS pollstate (
+		pollkey TEXT PRIMARY KEY,
+		sha TEXT NOT NULL,
+		lastupdateddate INTEGER
+	)`,
+	`CREATE TABLE IF NOT EXISTS impactreports (
+		id TEXT PRIMARY KEY,
+		report TEXT,
+		reportdate INTEGER NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		application TEXT NOT NULL,
+		provider TEXT,
+		org TEXT,
+		repo TEXT,
+		path TEXT,
+		branch TEXT,
+		commitsha TEXT,
+		pusher TEXT,
+		dinghyfile TEXT,
+		pipelines TEXT,
+		rollbackof INTEGER,
+		revisiondate INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_revisions_application ON revisions (application)`,
+	`CREATE INDEX IF NOT EXISTS idx_revisions_commitsha ON revisions (commitsha)`,
+	`CREATE TABLE IF NOT EXISTS changerequests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		application TEXT NOT NULL,
+		provider TEXT,
+		org TEXT,
+		repo TEXT,
+		path TEXT,
+		branch TEXT,
+		commitsha TEXT,
+		pusher TEXT,
+		dinghyfile TEXT,
+		globals TEXT,
+		status TEXT NOT NULL,
+		audit TEXT,
+		expiredate INTEGER,
+		requestdate INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_changerequests_status ON changerequests (status)`,
+	`CREATE INDEX IF NOT EXISTS idx_changerequests_application ON changerequests (application)`,
+}
+
+// sqliteColumns are added to the tables of databases created before them,
+// by table
+var sqliteColumns = map[string][]string{
+	"logevents":      {"pipelines TEXT"},
+	"changerequests": {"globals TEXT"},
+}
+
+// NewSQLiteClient initializes a client storing everything in a SQLite file,
+// DbName is the path of the file, ":memory:" keeps the database in memory.
+func NewSQLiteClient(sqlOptions *SQLConfig, logger *log.Logger, ctx context.Context, stop chan os.Signal) (*SQLClient, error) {
+	if sqlOptions.DbName == "" {
+		return nil, fmt.Errorf("the path of the sqlite database is required, set it in databaseName")
+	}
+	dsn := fmt.Sprintf("%v?_foreign_keys=1&_busy_timeout=5000", sqlOptions.DbName)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single writer, sharing one connection serializes writes
+	// instead of failing them with "database is locked", and is required for
+	// an in memory database to be the same for every query
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	for _, statement := range sqliteSchema {
+		if err := db.Exec(statement).Error; err != nil {
+			return nil, fmt.Errorf("could not create the sqlite schema: %w", err)
+		}
+	}
+	for table, columns := range sqliteColumns {
+		for _, column := range columns {
+			name := strings.Fields(column)[0]
+			var found int64
+			if err := db.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, name).Scan(&found).Error; err != nil {
+				return nil, fmt.Errorf("could not read the columns of %s: %w", table, err)
+			}
+			if found > 0 {
+				continue
+			}
+			if err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)).Error; err != nil {
+				return nil, fmt.Errorf("could not add %s to %s: %w", name, table, err)
+			}
+		}
+	}
+
+	return newSQLClient(db, ctx, stop), nil
+}
@@ -0,0 +1,101 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package deliveries
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// ProcessingResponse is the response recorded for a delivery while it is
+// being processed, duplicates received meanwhile are answered with it.
+const ProcessingResponse = `{"status":"processing"}`
+
+// DeliveryHeaders are the request headers that git providers (or proxies in
+// front of dinghy) use to identify a single webhook delivery. Redeliveries of
+// the same event keep the same value.
+var DeliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Request-Id",
+}
+
+// DeliveriesClient stores the outcome of processed webhook deliveries so
+// duplicated deliveries can be answered without being processed again.
+type DeliveriesClient interface {
+	// GetDelivery returns the recorded delivery, or nil if it was never seen
+	// or its TTL expired.
+	GetDelivery(id string) (*Delivery, error)
+	SaveDelivery(delivery Delivery) error
+	// ClaimDelivery atomically records a delivery as in progress unless it
+	// was already received, in which case the recorded delivery is returned.
+	// Claims older than staleAfter belong to a replica that died processing
+	// them and are taken over.
+	ClaimDelivery(id, provider string, staleAfter time.Duration) (*Delivery, error)
+}
+
+// Delivery is the outcome of a processed webhook
+type Delivery struct {
+	ID         string `json:"id" yaml:"id"`
+	Provider   string `json:"provider" yaml:"provider"`
+	StatusCode int    `json:"statusCode" yaml:"statusCode"`
+	Response   string `json:"response" yaml:"response"`
+	Date       int64  `json:"date" yaml:"date"`
+}
+
+// claim is the record of a delivery being processed
+func claim(id, provider string) Delivery {
+	return Delivery{
+		ID:         id,
+		Provider:   provider,
+		StatusCode: http.StatusAccepted,
+		Response:   ProcessingResponse,
+		Date:       store.Now(),
+	}
+}
+
+// InProgress tells if the delivery is still being processed
+func (d Delivery) InProgress() bool {
+	return d.StatusCode == http.StatusAccepted && d.Response == ProcessingResponse
+}
+
+// staleClaim tells if the delivery is a claim older than staleAfter
+func (d Delivery) staleClaim(staleAfter time.Duration) bool {
+	return d.InProgress() && store.Now()-d.Date >= int64(staleAfter/time.Millisecond)
+}
+
+// IDFromHeaders returns the first delivery identity found in the request
+// headers, prefixed by the provider so identities never collide between
+// providers.
+func IDFromHeaders(provider string, h http.Header) string {
+	for _, header := range DeliveryHeaders {
+		if val := strings.TrimSpace(h.Get(header)); val != "" {
+			return provider + ":" + val
+		}
+	}
+	return ""
+}
+
+// IDFromPush builds a delivery identity for requests that didn't carry any
+// delivery header, hashing the provider, repository, ref and the sha the ref
+// was moved to.
+func IDFromPush(provider, org, repo, ref, after string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{provider, org, repo, ref, after}, "\n")))
+	return provider + ":" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package deliveries
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// DeliveryMemoryClient only deduplicates the deliveries received by this
+// replica, they are forgotten on restart.
+type DeliveryMemoryClient struct {
+	MinutesTTL time.Duration
+	mutex      sync.Mutex
+	deliveries map[string]Delivery
+}
+
+func NewDeliveryMemoryClient(minutesTTL time.Duration) *DeliveryMemoryClient {
+	return &DeliveryMemoryClient{
+		MinutesTTL: minutesTTL,
+		deliveries: map[string]Delivery{},
+	}
+}
+
+func (c *DeliveryMemoryClient) GetDelivery(id string) (*Delivery, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.getDelivery(id), nil
+}
+
+func (c *DeliveryMemoryClient) getDelivery(id string) *Delivery {
+	delivery, found := c.deliveries[id]
+	if !found {
+		return nil
+	}
+	if store.Expired(delivery.Date, c.MinutesTTL) {
+		delete(c.deliveries, id)
+		return nil
+	}
+	return &delivery
+}
+
+func (c *DeliveryMemoryClient) SaveDelivery(delivery Delivery) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delivery.Date = store.Now()
+	c.deliveries[delivery.ID] = delivery
+	return nil
+}
+
+func (c *DeliveryMemoryClient) ClaimDelivery(id, provider string, staleAfter time.Duration) (*Delivery, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if delivery := c.getDelivery(id); delivery != nil && !delivery.staleClaim(staleAfter) {
+		return delivery, nil
+	}
+	c.deliveries[id] = claim(id, provider)
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package deliveries
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/store"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// claimScript records a claim unless the delivery was already received, a
+// stale claim is replaced. It returns the recorded delivery, nil when the
+// claim was recorded.
+//
+// KEYS[1] is the delivery, ARGV[1] the claim, ARGV[2] its TTL in
+// milliseconds or 0 to keep it forever, ARGV[3] the response of claims and
+// ARGV[4] the date before which claims are stale.
+var claimScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current then
+	local delivery = cjson.decode(current)
+	if delivery.statusCode ~= 202 or delivery.response ~= ARGV[3] or delivery.date > tonumber(ARGV[4]) then
+		return current
+	end
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return false
+`)
+
+// DeliveryRedisClient keeps every delivery in its own key, expiring with it.
+type DeliveryRedisClient struct {
+	MinutesTTL  time.Duration
+	RedisClient *cache.RedisCache
+}
+
+func (c DeliveryRedisClient) GetDelivery(id string) (*Delivery, error) {
+	var delivery Delivery
+	if found, err := store.GetJSON(c.RedisClient.Client, cache.CompileKey("delivery", id), &delivery); !found {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (c DeliveryRedisClient) SaveDelivery(delivery Delivery) error {
+	delivery.Date = store.Now()
+	return store.SetJSON(c.RedisClient.Client, cache.CompileKey("delivery", delivery.ID), delivery, c.MinutesTTL)
+}
+
+func (c DeliveryRedisClient) ClaimDelivery(id, provider string, staleAfter time.Duration) (*Delivery, error) {
+	loge := log.WithFields(log.Fields{"func": "ClaimDelivery"})
+	delivery := claim(id, provider)
+	deliveryBytes, err := json.Marshal(delivery)
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "marshall delivery", "content": delivery}).Error(err)
+		return nil, err
+	}
+	key := cache.CompileKey("delivery", id)
+	staleBefore := delivery.Date - int64(staleAfter/time.Millisecond)
+	result, err := claimScript.Run(c.RedisClient.Client, []string{key}, deliveryBytes, int64(c.MinutesTTL*time.Minute/time.Millisecond), ProcessingResponse, staleBefore).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "claim key", "key": key}).Error(err)
+		return nil, err
+	}
+	value, _ := result.(string)
+	var current Delivery
+	if err := json.Unmarshal([]byte(value), &current); err != nil {
+		loge.WithFields(log.Fields{"operation": "unmarshall key " + key, "content": value}).Error(err)
+		return nil, err
+	}
+	return &current, nil
+}
@@ -0,0 +1,118 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package deliveries
+
+import (
+	"time"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/store"
+	"gorm.io/gorm/clause"
+)
+
+// DeliverySQLClient keeps deliveries in the deliveries table, the expired
+// ones are deleted as new ones are saved.
+type DeliverySQLClient struct {
+	MinutesTTL time.Duration
+	SQLClient  *database.SQLClient
+}
+
+func (DeliverySQL) TableName() string {
+	return "deliveries"
+}
+
+type DeliverySQL struct {
+	Id         string `gorm:"primaryKey;column:id"`
+	Provider   string `gorm:"column:provider"`
+	StatusCode int    `gorm:"column:statuscode"`
+	Response   string `gorm:"column:response"`
+	Date       int64  `gorm:"column:deliverydate"`
+}
+
+func (c DeliverySQLClient) GetDelivery(id string) (*Delivery, error) {
+	found := []DeliverySQL{}
+	result := c.SQLClient.Client.Where("id = ? AND deliverydate >= ?", id, store.Cutoff(c.MinutesTTL)).Limit(1).Find(&found)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	return &Delivery{
+		ID:         found[0].Id,
+		Provider:   found[0].Provider,
+		StatusCode: found[0].StatusCode,
+		Response:   found[0].Response,
+		Date:       found[0].Date,
+	}, nil
+}
+
+func (c DeliverySQLClient) SaveDelivery(delivery Delivery) error {
+	if err := store.DeleteExpired(c.SQLClient.Client, &DeliverySQL{}, "deliverydate", c.MinutesTTL); err != nil {
+		return err
+	}
+	return c.SQLClient.Client.Save(&DeliverySQL{
+		Id:         delivery.ID,
+		Provider:   delivery.Provider,
+		StatusCode: delivery.StatusCode,
+		Response:   delivery.Response,
+		Date:       store.Now(),
+	}).Error
+}
+
+func (c DeliverySQLClient) ClaimDelivery(id, provider string, staleAfter time.Duration) (*Delivery, error) {
+	delivery := claim(id, provider)
+	row := &DeliverySQL{
+		Id:         delivery.ID,
+		Provider:   delivery.Provider,
+		StatusCode: delivery.StatusCode,
+		Response:   delivery.Response,
+		Date:       delivery.Date,
+	}
+	// An expired delivery is as good as missing, the primary key is what
+	// makes only one replica win the insert.
+	if err := c.SQLClient.Client.Where("id = ? AND deliverydate < ?", id, store.Cutoff(c.MinutesTTL)).Delete(&DeliverySQL{}).Error; err != nil {
+		return nil, err
+	}
+	result := c.SQLClient.Client.Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	current, err := c.GetDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		// it expired meanwhile
+		return c.ClaimDelivery(id, provider, staleAfter)
+	}
+	if !current.staleClaim(staleAfter) {
+		return current, nil
+	}
+	// Only one replica takes over a stale claim, the others see its date changed
+	result = c.SQLClient.Client.Model(&DeliverySQL{}).
+		Where("id = ? AND statuscode = ? AND response = ? AND deliverydate = ?", id, current.StatusCode, current.Response, current.Date).
+		Update("deliverydate", delivery.Date)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+	return c.GetDelivery(id)
+}
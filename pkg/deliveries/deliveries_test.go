@@ -0,0 +1,251 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package deliveries
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDFromHeaders(t *testing.T) {
+	cases := map[string]struct {
+		headers  map[string]string
+		expected string
+	}{
+		"github delivery": {
+			headers:  map[string]string{"X-GitHub-Delivery": "72d3162e"},
+			expected: "github:72d3162e",
+		},
+		"gitlab event uuid": {
+			headers:  map[string]string{"X-Gitlab-Event-UUID": "b0ba8ff3"},
+			expected: "github:b0ba8ff3",
+		},
+		"request id": {
+			headers:  map[string]string{"X-Request-Id": "abc"},
+			expected: "github:abc",
+		},
+		"provider header wins over request id": {
+			headers:  map[string]string{"X-Request-Id": "abc", "X-GitHub-Delivery": "72d3162e"},
+			expected: "github:72d3162e",
+		},
+		"no headers": {
+			headers:  map[string]string{},
+			expected: "",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range c.headers {
+				h.Set(k, v)
+			}
+			assert.Equal(t, c.expected, IDFromHeaders("github", h))
+		})
+	}
+}
+
+func TestIDFromPush(t *testing.T) {
+	id := IDFromPush("github", "org", "repo", "master", "abc123")
+	assert.Equal(t, id, IDFromPush("github", "org", "repo", "master", "abc123"))
+	assert.NotEqual(t, id, IDFromPush("github", "org", "repo", "master", "def456"))
+	assert.NotEqual(t, id, IDFromPush("gitlab", "org", "repo", "master", "abc123"))
+}
+
+func TestDeliveryMemoryClient(t *testing.T) {
+	c := NewDeliveryMemoryClient(10)
+
+	found, err := c.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:1", Provider: "github", StatusCode: 200, Response: `{"status":"accepted"}`}))
+	found, err = c.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, found.StatusCode)
+	assert.Equal(t, `{"status":"accepted"}`, found.Response)
+
+	c.deliveries["github:1"] = Delivery{ID: "github:1", Date: 1}
+	found, err = c.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	// deliveries are kept forever without a TTL
+	forever := NewDeliveryMemoryClient(0)
+	forever.deliveries["github:1"] = Delivery{ID: "github:1", Date: 1}
+	found, err = forever.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.NotNil(t, found)
+}
+
+// testConcurrentClaims claims the same delivery from many goroutines, only
+// one of them must get it
+func testConcurrentClaims(t *testing.T, c DeliveriesClient) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	claimed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := c.ClaimDelivery("github:concurrent", "github", time.Hour)
+			assert.Nil(t, err)
+			if found == nil {
+				mutex.Lock()
+				claimed++
+				mutex.Unlock()
+			} else {
+				assert.True(t, found.InProgress())
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, claimed)
+}
+
+func TestDeliveryMemoryClientClaim(t *testing.T) {
+	c := NewDeliveryMemoryClient(10)
+	testConcurrentClaims(t, c)
+
+	found, err := c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	// a stale claim is taken over
+	claim := c.deliveries["github:1"]
+	claim.Date -= int64(2 * time.Hour / time.Millisecond)
+	c.deliveries["github:1"] = claim
+	found, err = c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	// a processed delivery never is, however old
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:1", Provider: "github", StatusCode: 200, Response: "done"}))
+	found, err = c.ClaimDelivery("github:1", "github", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "done", found.Response)
+}
+
+func TestDeliverySQLClientClaim(t *testing.T) {
+	config := &database.SQLConfig{Driver: database.SQLiteDriver, DbName: filepath.Join(t.TempDir(), "dinghy.db")}
+	sqlClient, err := database.NewSQLClient(config, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	c := DeliverySQLClient{MinutesTTL: 10, SQLClient: sqlClient}
+	testConcurrentClaims(t, c)
+
+	found, err := c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	found, err = c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, found.InProgress())
+
+	// a stale claim is taken over, once
+	stale := time.Now().Add(-2*time.Hour).UnixNano() / 1000000
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Where("id = ?", "github:1").Update("deliverydate", stale).Error)
+	c.MinutesTTL = 180
+	found, err = c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	found, err = c.ClaimDelivery("github:1", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.NotNil(t, found)
+
+	// an expired delivery is claimed again
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:2", Provider: "github", StatusCode: 200, Response: "done"}))
+	found, err = c.ClaimDelivery("github:2", "github", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "done", found.Response)
+	expired := time.Now().Add(-4*time.Hour).UnixNano() / 1000000
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Where("id = ?", "github:2").Update("deliverydate", expired).Error)
+	found, err = c.ClaimDelivery("github:2", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func TestDeliverySQLClientExpiry(t *testing.T) {
+	config := &database.SQLConfig{Driver: database.SQLiteDriver, DbName: filepath.Join(t.TempDir(), "dinghy.db")}
+	sqlClient, err := database.NewSQLClient(config, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	c := DeliverySQLClient{MinutesTTL: 60, SQLClient: sqlClient}
+
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:1", Provider: "github", StatusCode: 200, Response: "done"}))
+	found, err := c.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.Equal(t, "done", found.Response)
+
+	// expired deliveries aren't found, and are deleted when another one is saved
+	expired := time.Now().Add(-2*time.Hour).UnixNano() / 1000000
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Where("id = ?", "github:1").Update("deliverydate", expired).Error)
+	found, err = c.GetDelivery("github:1")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:2", Provider: "github", StatusCode: 200, Response: "done"}))
+	var ids []string
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Pluck("id", &ids).Error)
+	assert.Equal(t, []string{"github:2"}, ids)
+
+	// deliveries are kept forever without a TTL
+	c.MinutesTTL = 0
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Where("id = ?", "github:2").Update("deliverydate", expired).Error)
+	found, err = c.GetDelivery("github:2")
+	assert.Nil(t, err)
+	assert.NotNil(t, found)
+	found, err = c.ClaimDelivery("github:2", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, "done", found.Response)
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:3", Provider: "github", StatusCode: 200, Response: "done"}))
+	ids = nil
+	assert.Nil(t, sqlClient.Client.Model(&DeliverySQL{}).Order("id").Pluck("id", &ids).Error)
+	assert.Equal(t, []string{"github:2", "github:3"}, ids)
+}
+
+func TestDeliveryRedisClientWithoutTTL(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", util.GetenvOrDefault("REDIS_HOST", "redis"), util.GetenvOrDefault("REDIS_PORT", "6379")),
+		Password: util.GetenvOrDefault("REDIS_PASSWORD", ""),
+	})
+	if _, err := client.Ping().Result(); err != nil {
+		t.Skip("Could not connect to Redis; skipping test")
+	}
+	c := DeliveryRedisClient{RedisClient: &cache.RedisCache{Client: client}}
+	key := cache.CompileKey("delivery", "github:forever")
+	client.Del(key)
+
+	found, err := c.ClaimDelivery("github:forever", "github", time.Hour)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	assert.Equal(t, time.Duration(-1), client.PTTL(key).Val())
+
+	assert.Nil(t, c.SaveDelivery(Delivery{ID: "github:forever", Provider: "github", StatusCode: 200, Response: "done"}))
+	assert.Equal(t, time.Duration(-1), client.PTTL(key).Val())
+	found, err = c.GetDelivery("github:forever")
+	assert.Nil(t, err)
+	assert.Equal(t, "done", found.Response)
+	client.Del(key)
+}
@@ -0,0 +1,199 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/plank/v4"
+)
+
+// ProtectedAttribute is the attribute of an application spec that protects it
+const ProtectedAttribute = "protected"
+
+// Approvals holds the changes to protected applications as change requests
+// until they are approved.
+type Approvals struct {
+	Client approvals.Client
+	// Applications are globs of the names of the protected applications
+	Applications []string
+	// Expiration is how long a change request can be approved, 0 is forever
+	Expiration time.Duration
+	// Approvers are told about the change requests by the notifiers
+	Approvers []string
+	// Held are the change requests of the processed dinghyfiles, in the order they were held
+	Held []approvals.ChangeRequest
+}
+
+// Protects checks if the changes to an application need an approval, either
+// because of its name or the protected attribute of its spec.
+func (a *Approvals) Protects(app plank.Application) bool {
+	if a == nil || a.Client == nil {
+		return false
+	}
+	for _, pattern := range a.Applications {
+		if matched, _ := path.Match(pattern, app.Name); matched {
+			return true
+		}
+	}
+	switch protected := app.AppMetadata[ProtectedAttribute].(type) {
+	case bool:
+		return protected
+	case string:
+		return strings.EqualFold(protected, "true")
+	}
+	return false
+}
+
+// PendingDescription describes the change request holding the changes of a
+// dinghyfile, empty when there is none. Every dinghyfile of a push gets its
+// own commit status, the change requests of the others don't show in it.
+func (a *Approvals) PendingDescription(org, repo, path, branch string) string {
+	if a == nil {
+		return ""
+	}
+	// a dinghyfile processed again is held by its latest change request
+	for i := len(a.Held) - 1; i >= 0; i-- {
+		r := a.Held[i]
+		if r.Org == org && r.Repo == repo && r.Path == path && r.Branch == branch {
+			return fmt.Sprintf("Awaiting approval of change request %d", r.ID)
+		}
+	}
+	return ""
+}
+
+// needsChanges checks if applying the dinghyfile would change anything in
+// Spinnaker, when it's unknown the dinghyfile needs changes.
+func (b *PipelineBuilder) needsChanges(d Dinghyfile) bool {
+	drift, err := b.ApplicationDrift(d.ApplicationSpec.Name, []Dinghyfile{d})
+	if err != nil {
+		return true
+	}
+	if drift.Missing || len(drift.Settings) > 0 {
+		return true
+	}
+	for _, p := range drift.Pipelines {
+		if p.Drift != DriftUnmanaged || d.DeleteStalePipelines {
+			return true
+		}
+	}
+	return false
+}
+
+// holdForApproval stores the rendered dinghyfile as a pending change request,
+// replacing the pending change requests of the same dinghyfile, and tells the
+// approvers about it.
+func (b *PipelineBuilder) holdForApproval(org, repo, path, branch, pusher string, d Dinghyfile, rendered string) error {
+	request := approvals.ChangeRequest{
+		Application: d.ApplicationSpec.Name,
+		Provider:    b.Provider,
+		Org:         org,
+		Repo:        repo,
+		Path:        path,
+		Branch:      branch,
+		Commit:      b.commitOf(org, repo, branch),
+		Pusher:      pusher,
+		Dinghyfile:  rendered,
+		Globals:     b.GlobalVariablesMap,
+		Status:      approvals.StatusPending,
+	}
+	pending, err := b.Approvals.Client.GetChangeRequests(approvals.StatusPending, request.Application)
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		if p.SameDinghyfile(request) && p.Dinghyfile == rendered && !p.Expired() {
+			b.Logger.Infof("Change request %d already holds these changes to %s", p.ID, request.Application)
+			b.Approvals.Held = append(b.Approvals.Held, p)
+			return nil
+		}
+	}
+
+	if b.Approvals.Expiration > 0 {
+		request.ExpiresAt = time.Now().Add(b.Approvals.Expiration).UnixNano() / 1000000
+	}
+	request.Record(pusher, approvals.ActionCreated, "")
+	request, err = b.Approvals.Client.SaveChangeRequest(request)
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		if !p.SameDinghyfile(request) {
+			continue
+		}
+		p.Status = approvals.StatusSuperseded
+		p.Record(pusher, approvals.ActionSuperseded, fmt.Sprintf("superseded by change request %d", request.ID))
+		// A change request approved, rejected or expired meanwhile is left as is
+		if transitioned, err := b.Approvals.Client.TransitionChangeRequest(p, approvals.StatusPending); err != nil {
+			b.Logger.Errorf("Failed to supersede change request %d: %s", p.ID, err.Error())
+		} else if !transitioned {
+			b.Logger.Infof("Change request %d is no longer pending, it isn't superseded", p.ID)
+		}
+	}
+	b.Logger.Infof("Changes to protected application %s are held for approval as change request %d", request.Application, request.ID)
+	b.Approvals.Held = append(b.Approvals.Held, request)
+	b.notifyApprovers(org, repo, path, d.ApplicationSpec.Notifications, request)
+	return nil
+}
+
+func (b *PipelineBuilder) notifyApprovers(org, repo, path string, notifications plank.NotificationsType, request approvals.ChangeRequest) {
+	for _, n := range b.Notifiers {
+		if approvalNotifier, ok := n.(notifiers.ApprovalNotifier); ok {
+			content := b.getNotificationContent()
+			content["changeRequest"] = request
+			content["approvers"] = b.Approvals.Approvers
+			approvalNotifier.SendApprovalRequest(org, repo, path, notifications, content)
+		}
+	}
+}
+
+// ApplyChangeRequest applies the dinghyfile of an approved change request,
+// the way it would have been applied when it was processed.
+func (b *PipelineBuilder) ApplyChangeRequest(request approvals.ChangeRequest) (logevents.PipelineChanges, error) {
+	d, err := b.UpdateDinghyfile([]byte(request.Dinghyfile))
+	if err != nil {
+		return logevents.PipelineChanges{}, err
+	}
+	b.Provider = request.Provider
+	b.PushCommit = PushCommit{Org: request.Org, Repo: request.Repo, Branch: request.Branch, SHA: request.Commit}
+	b.GlobalVariablesMap = request.Globals
+	b.Logger.Infof("Applying change request %d to %s", request.ID, request.Application)
+	applied, err := b.updatePipelines(d, request.Pusher, b.pipelineOwner(request.Org, request.Repo, request.Path, request.Branch))
+	if err != nil {
+		b.Logger.Errorf("Failed to apply change request %d: %s", request.ID, err.Error())
+		b.NotifyFailure(request.Org, request.Repo, request.Path, err, request.Dinghyfile)
+		return applied.changes, err
+	}
+	b.saveRevision(revisions.Revision{
+		Application: request.Application,
+		Provider:    request.Provider,
+		Org:         request.Org,
+		Repo:        request.Repo,
+		Path:        request.Path,
+		Branch:      request.Branch,
+		Commit:      request.Commit,
+		Pusher:      request.Pusher,
+		Dinghyfile:  request.Dinghyfile,
+		Pipelines:   applied.pipelines,
+	})
+	b.notifySuccess(request.Org, request.Repo, request.Path, d.ApplicationSpec.Notifications, &applied.changes)
+	return applied.changes, nil
+}
@@ -0,0 +1,239 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type approvalsTestNotifier struct {
+	requests []approvals.ChangeRequest
+}
+
+func (n *approvalsTestNotifier) SendSuccess(org, repo, path string, notifications plank.NotificationsType, content map[string]interface{}) {
+}
+
+func (n *approvalsTestNotifier) SendFailure(org, repo, path string, err error, notifications plank.NotificationsType, content map[string]interface{}) {
+}
+
+func (n *approvalsTestNotifier) SendOnValidation() bool {
+	return false
+}
+
+func (n *approvalsTestNotifier) SendApprovalRequest(org, repo, path string, notifications plank.NotificationsType, content map[string]interface{}) {
+	n.requests = append(n.requests, content["changeRequest"].(approvals.ChangeRequest))
+}
+
+func TestProtects(t *testing.T) {
+	a := &Approvals{Client: approvals.NewChangeRequestMemoryClient(), Applications: []string{"prod*", "billing"}}
+	assert.True(t, a.Protects(plank.Application{Name: "prodapp"}))
+	assert.True(t, a.Protects(plank.Application{Name: "billing"}))
+	assert.False(t, a.Protects(plank.Application{Name: "billingtest"}))
+	assert.True(t, a.Protects(plank.Application{Name: "other", AppMetadata: map[string]interface{}{"protected": true}}))
+	assert.True(t, a.Protects(plank.Application{Name: "other", AppMetadata: map[string]interface{}{"protected": "true"}}))
+	assert.False(t, a.Protects(plank.Application{Name: "other", AppMetadata: map[string]interface{}{"protected": false}}))
+
+	var disabled *Approvals
+	assert.False(t, disabled.Protects(plank.Application{Name: "prodapp"}))
+	assert.Equal(t, "", disabled.PendingDescription("org", "repo", "dinghyfile", "master"))
+}
+
+func TestProcessDinghyfileHoldsProtectedApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	first := `{"application":"biff","spec":{"appmetadata":{"protected":true}},"pipelines":[{"name":"deploy","application":"biff"}]}`
+	second := `{"application":"biff","spec":{"appmetadata":{"protected":true}},"pipelines":[{"name":"deploy","application":"biff","description":"changed"}]}`
+
+	renderer := NewMockParser(ctrl)
+	gomock.InOrder(
+		renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(first), nil),
+		renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(first), nil),
+		renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(second), nil),
+	)
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil).Times(3)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil).Times(3)
+	client.EXPECT().UpsertPipeline(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	store := approvals.NewChangeRequestMemoryClient()
+	notifier := &approvalsTestNotifier{}
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = client
+	b.Notifiers = []notifiers.Notifier{notifier}
+	b.Approvals = &Approvals{Client: store}
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	// the same changes are held by the same change request
+	_, err = b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	assert.Len(t, b.Approvals.Held, 2)
+	assert.Equal(t, b.Approvals.Held[0].ID, b.Approvals.Held[1].ID)
+	assert.Len(t, notifier.requests, 1)
+
+	_, err = b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	assert.Len(t, notifier.requests, 2)
+
+	pending, _ := store.GetChangeRequests(approvals.StatusPending, "biff")
+	assert.Len(t, pending, 1)
+	assert.Equal(t, second, pending[0].Dinghyfile)
+	assert.Equal(t, "pusher", pending[0].Pusher)
+	assert.Equal(t, approvals.ActionCreated, pending[0].Audit[0].Action)
+
+	superseded, _ := store.GetChangeRequests(approvals.StatusSuperseded, "biff")
+	assert.Len(t, superseded, 1)
+	assert.Equal(t, first, superseded[0].Dinghyfile)
+	assert.Equal(t, "Awaiting approval of change request 2", b.Approvals.PendingDescription("myorg", "myrepo", "dinghyfile", "master"))
+	assert.Equal(t, "", b.Approvals.PendingDescription("myorg", "myrepo", "other/dinghyfile", "master"))
+}
+
+// rejectingClient rejects the pending change requests once they are listed,
+// as an approver could while a push is processed.
+type rejectingClient struct {
+	*approvals.ChangeRequestMemoryClient
+}
+
+func (c rejectingClient) GetChangeRequests(status, application string) ([]approvals.ChangeRequest, error) {
+	requests, err := c.ChangeRequestMemoryClient.GetChangeRequests(status, application)
+	for _, r := range requests {
+		rejected := r
+		rejected.Status = approvals.StatusRejected
+		c.ChangeRequestMemoryClient.TransitionChangeRequest(rejected, approvals.StatusPending)
+	}
+	return requests, err
+}
+
+func TestProcessDinghyfileDoesNotSupersedeRejectedChangeRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rendered := `{"application":"biff","spec":{"appmetadata":{"protected":true}},"pipelines":[{"name":"deploy","application":"biff"}]}`
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(rendered), nil)
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil)
+
+	store := approvals.NewChangeRequestMemoryClient()
+	first, _ := store.SaveChangeRequest(approvals.ChangeRequest{Application: "biff", Org: "myorg", Repo: "myrepo", Path: "dinghyfile", Branch: "master", Dinghyfile: "{}", Status: approvals.StatusPending})
+
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = client
+	b.Approvals = &Approvals{Client: rejectingClient{store}}
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	saved, _ := store.GetChangeRequest(first.ID)
+	assert.Equal(t, approvals.StatusRejected, saved.Status)
+	pending, _ := store.GetChangeRequests(approvals.StatusPending, "biff")
+	assert.Len(t, pending, 1)
+	assert.Equal(t, rendered, pending[0].Dinghyfile)
+}
+
+func TestProcessDinghyfileAppliesUnchangedProtectedApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	rendered := `{"application":"biff","pipelines":[{"name":"deploy","application":"biff"}]}`
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(rendered), nil)
+
+	existing := plank.Pipeline{ID: "deployID", Name: "deploy", Application: "biff"}
+	live := NewDinghyfile().ApplicationSpec
+	live.Name = "biff"
+	live.Email = DefaultEmail
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&live, nil).Times(2)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{existing}, nil).Times(2)
+
+	store := approvals.NewChangeRequestMemoryClient()
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = client
+	b.Approvals = &Approvals{Client: store, Applications: []string{"biff"}}
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	assert.Empty(t, b.Approvals.Held)
+	found, _ := store.GetChangeRequests("", "")
+	assert.Empty(t, found)
+}
+
+func TestApplyChangeRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil).Times(1)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil).Times(1)
+	client.EXPECT().UpsertPipeline(gomock.Any(), "", "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	changes, err := b.ApplyChangeRequest(approvals.ChangeRequest{
+		ID:          1,
+		Application: "biff",
+		Org:         "myorg",
+		Repo:        "myrepo",
+		Path:        "dinghyfile",
+		Branch:      "master",
+		Pusher:      "pusher",
+		Dinghyfile:  `{"application":"biff","pipelines":[{"name":"deploy","application":"biff"}]}`,
+		Status:      approvals.StatusApplied,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, logevents.PipelineChanges{Created: 1}, changes)
+}
+
+func TestApplyChangeRequestWithGlobals(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil).Times(1)
+	// save_app_on_update of the rendered dinghyfile still applies
+	client.EXPECT().UpdateApplication(gomock.Any(), "").Return(nil).Times(1)
+	client.EXPECT().UpdateApplicationNotifications(gomock.Any(), "biff", "").Return(nil).Times(1)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	_, err := b.ApplyChangeRequest(approvals.ChangeRequest{
+		ID:          1,
+		Application: "biff",
+		Org:         "myorg",
+		Repo:        "myrepo",
+		Path:        "dinghyfile",
+		Branch:      "master",
+		Pusher:      "pusher",
+		Dinghyfile:  `{"application":"biff","spec":{"email":"biff@example.com"}}`,
+		Globals:     map[string]interface{}{"save_app_on_update": true},
+		Status:      approvals.StatusApplied,
+	})
+	assert.Nil(t, err)
+}
@@ -23,12 +23,17 @@ import (
 	"fmt"
 	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
 	"github.com/armory/dinghy/pkg/log"
-	"path/filepath"
 	"regexp"
 	"time"
 
 	"github.com/armory/dinghy/pkg/events"
+	"github.com/armory/dinghy/pkg/lint"
+	"github.com/armory/dinghy/pkg/lock"
+	"github.com/armory/dinghy/pkg/logevents"
 	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/dinghy/pkg/policy"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/dinghy/pkg/schema"
 	"github.com/armory/dinghy/pkg/util"
 	"github.com/armory/plank/v4"
 )
@@ -63,14 +68,60 @@ type PipelineBuilder struct {
 	JsonValidationDisabled             bool
 	UserWriteAccessValidation          UserWriteAccessValidation
 	UpsertPipelineUsingOrcaTaskEnabled bool
+	// Locker serializes the updates of an application between replicas, nil disables locking
+	Locker lock.Locker
+	// DinghyfilePatterns are globs of file names processed as dinghyfiles besides DinghyfileName
+	DinghyfilePatterns []string
+	// DirectoryConfigs looks up the .dinghy.yml defaults of every dinghyfile, nil disables them
+	DirectoryConfigs *DirectoryConfigs
+	// Parsers by format, used when a .dinghy.yml sets the parserFormat
+	Parsers map[string]Parser
+	// ModuleBranch overrides the branch modules are rendered from, used to
+	// render dinghyfiles against a template repo branch
+	ModuleBranch string
+	// PipelineOwnership stamps the pipelines with the dinghyfile that manages
+	// them, the pipelines of other dinghyfiles are neither updated nor deleted
+	PipelineOwnership bool
+	// Provider is the git provider of the dinghyfiles, part of the pipeline owner
+	Provider string
+	// PushCommit is recorded on the pipelines of the dinghyfiles in the pushed repository
+	PushCommit PushCommit
+	// PipelineChanges adds up the pipelines changed by the processed dinghyfiles, nil doesn't count them
+	PipelineChanges *logevents.PipelineChanges
+	// Revisions records the dinghyfiles and pipelines applied to every application, nil disables them
+	Revisions revisions.Client
+	// TransactionalUpdates restores the application, its notifications and
+	// pipelines the way they were when an update fails halfway
+	TransactionalUpdates bool
+	// PolicySource is where the policies the dinghyfiles are evaluated against are, nil disables them
+	PolicySource *policy.Source
+	// LintRules returns the severity of the lint rules by ID for the dinghyfiles
+	// of a repository, nil disables linting
+	LintRules func(repo, branch string) map[string]string
+	// LintReport adds up the lint warnings of the processed dinghyfiles, nil doesn't keep them
+	LintReport *lint.Report
+	// References looks up the accounts, stage types and pipelines the
+	// pipelines reference in Spinnaker, nil disables the validation
+	References *ReferenceCatalog
+	// Schema validates the rendered dinghyfiles against the Dinghyfile JSON
+	// Schema and the extensions of the template repo, nil disables it
+	Schema *schema.Extensions
+	// Approvals holds the changes to protected applications until they are
+	// approved, nil applies every change right away
+	Approvals *Approvals
+	// Freezes only validates the dinghyfiles of applications in a change
+	// freeze, nil applies them whatever the date
+	Freezes *Freezes
 }
 
 // DependencyManager is an interface for assigning dependencies and looking up root nodes
 type DependencyManager interface {
 	GetRawData(url string) (string, error)
 	SetRawData(url string, rawData string) error
-	SetDeps(parent string, deps []string)
+	SetDeps(parent string, deps []string) error
 	GetRoots(child string) []string
+	GetChildren(parent string) []string
+	GetAllDinghyfiles() []string
 }
 
 // Downloader is an interface that fetches files from a source
@@ -89,6 +140,12 @@ type Dinghyfile struct {
 	DeleteStalePipelines bool                   `json:"deleteStalePipelines" yaml:"deleteStalePipelines" hcl:"deleteStalePipelines"`
 	Globals              map[string]interface{} `json:"globals" yaml:"globals" hcl:"globals"`
 	Pipelines            []plank.Pipeline       `json:"pipelines" yaml:"pipelines" hcl:"pipelines"`
+	// TakeoverPipelines manages the pipelines owned by other dinghyfiles from this one
+	TakeoverPipelines bool `json:"takeoverPipelines" yaml:"takeoverPipelines" hcl:"takeoverPipelines"`
+	// PipelineTemplates are the v2 pipeline templates (MPTv2) saved in Front50 before the pipelines
+	PipelineTemplates []util.PipelineTemplate `json:"pipelineTemplates" yaml:"pipelineTemplates" hcl:"pipelineTemplates"`
+	// TemplatedPipelines are the pipelines of the application built from v2 pipeline templates
+	TemplatedPipelines []util.TemplatedPipeline `json:"templatedPipelines" yaml:"templatedPipelines" hcl:"templatedPipelines"`
 }
 
 type UserWriteAccessValidation struct {
@@ -96,6 +153,9 @@ type UserWriteAccessValidation struct {
 	Client  util.PlankClient
 	Enabled bool
 	Ignore  []string
+	// Resources checks the pusher can use the application, service accounts,
+	// accounts and roles of every dinghyfile, also when only validating
+	Resources bool
 }
 
 func (v *UserWriteAccessValidation) Validate(application plank.Application, pusher string) error {
@@ -108,6 +168,56 @@ func (v *UserWriteAccessValidation) Validate(application plank.Application, push
 	return validator.Validate(pusher)
 }
 
+// ValidateResources checks the pusher can create or write to the application
+// of a dinghyfile and use the service accounts, accounts and roles of its pipelines.
+func (v *UserWriteAccessValidation) ValidateResources(d Dinghyfile, pusher string) error {
+	if !v.Resources {
+		return nil
+	}
+	filter := WritePermissionUserFilter{v.Ignore}
+	if filter.ShouldIgnore(pusher) {
+		v.Logger.Infof("Skipping permissions check for user %s, because it is on a ignore list", pusher)
+		return nil
+	}
+	resources := Resources{
+		Application:     d.ApplicationSpec.Name,
+		ServiceAccounts: map[string][]string{},
+		Accounts:        map[string][]string{},
+		Roles:           map[string][]string{},
+	}
+	if _, err := v.Client.GetApplication(d.ApplicationSpec.Name, ""); err != nil {
+		if failedResponse, ok := err.(*plank.FailedResponse); !ok || failedResponse.StatusCode != 404 {
+			return err
+		}
+		resources.NewApplication = true
+	}
+	for _, p := range d.Pipelines {
+		for _, trigger := range p.Triggers {
+			if runAsUser, ok := trigger["runAsUser"].(string); ok && runAsUser != "" && !isExpression(runAsUser) {
+				resources.ServiceAccounts[p.Name] = appendMissing(resources.ServiceAccounts[p.Name], runAsUser)
+			}
+		}
+		for _, stage := range p.Stages {
+			for _, account := range stageAccounts(stage) {
+				resources.Accounts[p.Name] = appendMissing(resources.Accounts[p.Name], account)
+			}
+		}
+		if len(p.Roles) > 0 {
+			resources.Roles[p.Name] = p.Roles
+		}
+	}
+	return GetWritePermissionsValidator(true, v.Client, d.ApplicationSpec).ValidateResources(pusher, resources)
+}
+
+func appendMissing(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
 func NewDinghyfile() Dinghyfile {
 	return Dinghyfile{
 		// initialize the application spec so that the default
@@ -133,6 +243,8 @@ var (
 // email on the ApplicationSpec if it didn't get unmarshalled on its own.
 func (b *PipelineBuilder) UpdateDinghyfile(dinghyfile []byte) (Dinghyfile, error) {
 	d := NewDinghyfile()
+	// the builder setting is the default when the dinghyfile doesn't set it
+	d.DeleteStalePipelines = b.DeleteStalePipelines
 	// try every parser, maybe we'll get lucky
 	parseErrs := 0
 	suceeded := false
@@ -175,6 +287,7 @@ func (b *PipelineBuilder) UpdateDinghyfile(dinghyfile []byte) (Dinghyfile, error
 	if d.ApplicationSpec.Email == "" {
 		d.ApplicationSpec.Email = DefaultEmail
 	}
+	setTemplateDefaults(&d)
 
 	return d, nil
 }
@@ -240,8 +353,62 @@ func (b *PipelineBuilder) ValidateAppNotifications(d Dinghyfile, dinghyfile []by
 	return nil
 }
 
+// EvaluatePolicies checks the rendered dinghyfile against the policies, deny
+// violations fail it and warnings are only logged.
+func (b *PipelineBuilder) EvaluatePolicies(d Dinghyfile) error {
+	if b.PolicySource == nil {
+		return nil
+	}
+	engine, err := policy.Load(*b.PolicySource, b.Downloader.Download)
+	if err != nil {
+		return fmt.Errorf("could not load the policies: %w", err)
+	}
+	violations, err := engine.Evaluate(jsonObject(d))
+	if err != nil {
+		return err
+	}
+	for _, warning := range policy.Warnings(violations) {
+		b.Logger.Warnf("Policy warning: %s", warning.String())
+	}
+	if denied := policy.Denied(violations); len(denied) > 0 {
+		return &policy.ViolationError{Violations: denied}
+	}
+	return nil
+}
+
+// LintPipelines checks the pipelines of the rendered dinghyfile with the lint
+// rules, error findings fail it and warnings are logged and reported.
+func (b *PipelineBuilder) LintPipelines(d Dinghyfile, repo, branch string) error {
+	if b.LintRules == nil {
+		return nil
+	}
+	c := &lint.Context{
+		Application: d.ApplicationSpec.Name,
+		Pipelines:   d.Pipelines,
+		Lookup: func(application string) ([]plank.Pipeline, error) {
+			return b.Client.GetPipelines(application, "")
+		},
+	}
+	findings, err := lint.Run(c, b.LintRules(repo, branch))
+	if err != nil {
+		return err
+	}
+	warnings := lint.WithSeverity(findings, lint.SeverityWarn)
+	for _, warning := range warnings {
+		b.Logger.Warnf("Lint warning: %s", warning.String())
+	}
+	if b.LintReport != nil {
+		b.LintReport.Add(warnings...)
+	}
+	if errors := lint.WithSeverity(findings, lint.SeverityError); len(errors) > 0 {
+		return &lint.Error{Findings: errors}
+	}
+	return nil
+}
+
 // DetermineParser currently only returns a DinghyfileParser; it could
-// return other types of parsers in the future (for example, MPTv2)
+// return other types of parsers in the future. MPTv2 templates and
+// templated pipelines are declared in the dinghyfiles themselves.
 // If we can't discern the types based on the path passed here, we may need
 // to revisit this.  For now, this is just a stub that always returns the
 // DinghyfileParser type.
@@ -251,6 +418,24 @@ func (b *PipelineBuilder) DetermineParser(path string) Parser {
 
 // ProcessDinghyfile downloads a dinghyfile and uses it to update Spinnaker's pipelines.
 func (b *PipelineBuilder) ProcessDinghyfile(org, repo, path, branch, pusher string) (string, error) {
+	if b.DirectoryConfigs != nil {
+		config, err := b.DirectoryConfigs.ForFile(org, repo, path, branch)
+		if err != nil {
+			b.Logger.Errorf("Failed to load directory configuration for %s: %s", path, err.Error())
+			b.NotifyFailure(org, repo, path, err, "")
+			return "", err
+		}
+		if !config.IsEmpty() {
+			b.Logger.Infof("Using directory configuration for %s", path)
+			return b.withDirectoryConfig(config, func(db *PipelineBuilder) (string, error) {
+				return db.processDinghyfile(org, repo, path, branch, pusher)
+			})
+		}
+	}
+	return b.processDinghyfile(org, repo, path, branch, pusher)
+}
+
+func (b *PipelineBuilder) processDinghyfile(org, repo, path, branch, pusher string) (string, error) {
 	if b.Parser == nil {
 		// Set the renderer based on evaluation of the path, if not already set
 		b.Logger.Info("Calling DetermineParser")
@@ -278,6 +463,19 @@ func (b *PipelineBuilder) ProcessDinghyfile(org, repo, path, branch, pusher stri
 	b.Logger.Infof("Updated: %s", buf.String())
 	b.Logger.Infof("Dinghyfile struct: %v", dinghyfile)
 
+	if err := b.trackTemplates(org, repo, path, branch, dinghyfile); err != nil {
+		b.Logger.Errorf("Failed to record the pipeline templates used by %s: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
+	err = b.ValidateSchema(buf.Bytes())
+	if err != nil {
+		b.Logger.Errorf("Dinghyfile %s failed the schema validation: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
 	err = b.ValidatePipelines(dinghyfile, buf.Bytes())
 	if err != nil {
 		b.Logger.Errorf("Failed to validate pipelines %s", path)
@@ -286,6 +484,27 @@ func (b *PipelineBuilder) ProcessDinghyfile(org, repo, path, branch, pusher stri
 	}
 	b.Logger.Info("Validations for stage refs were successful")
 
+	err = b.ValidateTemplates(dinghyfile)
+	if err != nil {
+		b.Logger.Errorf("Failed to validate the pipeline templates of %s: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
+	err = b.ValidateReferences(dinghyfile)
+	if err != nil {
+		b.Logger.Errorf("Failed to validate the Spinnaker references of %s: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
+	err = b.LintPipelines(dinghyfile, repo, branch)
+	if err != nil {
+		b.Logger.Errorf("Dinghyfile %s failed lint: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
 	err = b.ValidateAppNotifications(dinghyfile, buf.Bytes())
 	if err != nil {
 		b.Logger.Errorf("Failed to validate application notifications %s", dinghyfile.ApplicationSpec.Notifications)
@@ -294,14 +513,63 @@ func (b *PipelineBuilder) ProcessDinghyfile(org, repo, path, branch, pusher stri
 	}
 	b.Logger.Info("Validations for app notifications were successful")
 
+	err = b.EvaluatePolicies(dinghyfile)
+	if err != nil {
+		b.Logger.Errorf("Dinghyfile %s failed the policies: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
+	err = b.UserWriteAccessValidation.ValidateResources(dinghyfile, pusher)
+	if err != nil {
+		b.Logger.Errorf("Dinghyfile %s failed the permissions check: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
+	frozen, err := b.frozen(org, repo, path, dinghyfile)
+	if err != nil {
+		b.Logger.Errorf("Failed to check the change freezes of %s: %s", path, err.Error())
+		b.NotifyFailure(org, repo, path, err, buf.String())
+		return buf.String(), err
+	}
+
 	if b.Action == pipebuilder.Validate {
 		b.Logger.Info("Validation finished successfully")
+	} else if frozen {
+		return buf.String(), nil
+	} else if b.Approvals.Protects(dinghyfile.ApplicationSpec) && b.needsChanges(dinghyfile) {
+		if err := b.holdForApproval(org, repo, path, branch, pusher, dinghyfile, buf.String()); err != nil {
+			b.Logger.Errorf("Failed to hold the changes of %s for approval: %s", path, err.Error())
+			b.NotifyFailure(org, repo, path, err, buf.String())
+			return buf.String(), err
+		}
+		return buf.String(), nil
 	} else {
-		if err := b.updatePipelines(dinghyfile, pusher); err != nil {
+		applied, err := b.updatePipelines(dinghyfile, pusher, b.pipelineOwner(org, repo, path, branch))
+		if b.PipelineChanges != nil {
+			b.PipelineChanges.Add(applied.changes)
+		}
+		if err != nil {
 			b.Logger.Errorf("Failed to update Pipelines for %s: %s", path, err.Error())
 			b.NotifyFailure(org, repo, path, err, buf.String())
 			return buf.String(), err
 		}
+		b.saveRevision(revisions.Revision{
+			Application: dinghyfile.ApplicationSpec.Name,
+			Provider:    b.Provider,
+			Org:         org,
+			Repo:        repo,
+			Path:        path,
+			Branch:      branch,
+			Commit:      b.commitOf(org, repo, branch),
+			Pusher:      pusher,
+			Dinghyfile:  buf.String(),
+			Pipelines:   applied.pipelines,
+		})
+		b.notifySuccess(org, repo, path, dinghyfile.ApplicationSpec.Notifications, &applied.changes)
+		b.rebuildTemplatedPipelines(b.Downloader.EncodeURL(org, repo, path, branch), applied.templates, pusher)
+		return buf.String(), nil
 	}
 
 	b.NotifySuccess(org, repo, path, dinghyfile.ApplicationSpec.Notifications)
@@ -352,7 +620,7 @@ func (b *PipelineBuilder) RebuildModuleRoots(org, repo, path, branch, pusher str
 	// Process all dinghyfiles that depend on this module
 	for _, url := range b.Depman.GetRoots(url) {
 		org, repo, path, branch := b.Downloader.DecodeURL(url)
-		if filepath.Base(path) == b.DinghyfileName {
+		if b.IsDinghyfile(path) {
 			if b.RepositoryRawdataProcessing {
 				rawData, errRaw := b.Depman.GetRawData(url)
 				if errRaw == nil && rawData != "" {
@@ -392,8 +660,36 @@ func (b *PipelineBuilder) RebuildModuleRoots(org, repo, path, branch, pusher str
 	return nil
 }
 
-// This is the bit that actually updates the pipeline(s) and application in Spinnaker
-func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string) error {
+// appliedPipelines is the outcome of updatePipelines
+type appliedPipelines struct {
+	changes logevents.PipelineChanges
+	// pipelines are the pipelines of the dinghyfile the way they were saved
+	pipelines []plank.Pipeline
+	// templates are the IDs of the pipeline templates saved
+	templates []string
+}
+
+// This is the bit that actually updates the pipeline(s) and application in Spinnaker.
+// When owner is set, the pipelines are stamped with it and the pipelines of
+// other owners are left alone. Pipelines that wouldn't change aren't saved.
+func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string, owner *PipelineOwner) (appliedPipelines, error) {
+	appLock, errLock := b.lockApplication(dinghyfile.ApplicationSpec.Name)
+	if errLock != nil {
+		return appliedPipelines{pipelines: []plank.Pipeline{}}, errLock
+	}
+	if appLock != nil {
+		defer appLock.Release()
+	}
+	if b.TransactionalUpdates {
+		return b.applyTransaction(dinghyfile, pusher, owner, appLock)
+	}
+	return b.applyPipelines(dinghyfile, pusher, owner, appLock)
+}
+
+// applyPipelines updates the application and its pipelines while holding appLock, if any.
+func (b *PipelineBuilder) applyPipelines(dinghyfile Dinghyfile, pusher string, owner *PipelineOwner, appLock lock.Lock) (appliedPipelines, error) {
+	applied := appliedPipelines{pipelines: []plank.Pipeline{}}
+	changes := &applied.changes
 	app := dinghyfile.ApplicationSpec
 	pipelines := dinghyfile.Pipelines
 	deleteStale := dinghyfile.DeleteStalePipelines
@@ -405,18 +701,18 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 		failedResponse, ok := err.(*plank.FailedResponse)
 		if !ok {
 			b.Logger.Errorf("Failed to create application (%s)", err.Error())
-			return err
+			return applied, err
 		}
 		if failedResponse.StatusCode == 404 {
 			// Likely just not there...
 			b.Logger.Infof("Creating application '%s'...", app.Name)
 			if err = b.Client.CreateApplication(&app, ""); err != nil {
 				b.Logger.Errorf("Failed to create application (%s)", failedResponse.Error())
-				return err
+				return applied, err
 			}
 		} else {
 			b.Logger.Errorf("Failed to create application (%s)", failedResponse.Error())
-			return err
+			return applied, err
 		}
 	} else {
 		if b.saveAppOnUpdate() {
@@ -424,12 +720,12 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 			//doesn't have write access to the application, thus we need to prevent from updating the app.
 			err := b.UserWriteAccessValidation.Validate(app, pusher)
 			if err != nil {
-				return err
+				return applied, err
 			}
 			errUpdating := b.Client.UpdateApplication(app, "")
 			if errUpdating != nil {
 				b.Logger.Errorf("Failed to update application (%s)", errUpdating.Error())
-				return errUpdating
+				return applied, errUpdating
 			}
 		}
 	}
@@ -442,7 +738,31 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 		}
 	}
 
-	ids, _ := b.PipelineIDs(app.Name)
+	if applied.templates, err = b.saveTemplates(dinghyfile.PipelineTemplates); err != nil {
+		return applied, err
+	}
+
+	// Without the existing pipelines they would be duplicated, and their
+	// owners couldn't be checked
+	existing, err := b.existingPipelines(app.Name)
+	if err != nil {
+		return applied, err
+	}
+	ids := map[string]string{}
+	for name, p := range existing {
+		ids[name] = p.ID
+	}
+	if owner != nil && !dinghyfile.TakeoverPipelines {
+		managed := pipelines
+		for _, p := range dinghyfile.TemplatedPipelines {
+			managed = append(managed, plank.Pipeline{Name: p.Name})
+		}
+		if conflicts := ownershipConflicts(*owner, managed, existing); len(conflicts) > 0 {
+			err := &OwnershipConflictError{Application: app.Name, Pipelines: conflicts}
+			b.Logger.Errorf("Refusing to update %s: %s", app.Name, err.Error())
+			return applied, err
+		}
+	}
 	ignoreList := make(map[string]bool)
 	idToName := make(map[string]string)
 	for name, id := range ids {
@@ -453,7 +773,9 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 	for _, p := range pipelines {
 		// Add ids to existing pipelines
 		b.Logger.Info("Processing pipeline ", p)
-		if id, exists := ids[p.Name]; exists {
+		current, exists := existing[p.Name]
+		if exists {
+			id := current.ID
 			b.Logger.Debug("Added id ", id, " to pipeline ", p.Name)
 			ignoreList[p.Name] = true
 			p.ID = id //note: we're working with a copy.  once this loop exits all changes go out of scope!
@@ -467,20 +789,30 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 			b.Logger.Debug("Locking pipeline ", p.Name)
 			p.Lock()
 		}
+		if exists && pipelineUnchanged(p, current, owner) {
+			b.Logger.Infof("Pipeline %s is unchanged, skipping", p.Name)
+			changes.Unchanged++
+			applied.pipelines = append(applied.pipelines, current)
+			continue
+		}
+		if owner != nil && !setOwner(&p, *owner) {
+			b.Logger.Warnf("Pipeline %s isn't stamped with its owner, its config isn't an object", p.Name)
+		}
 
-		if b.UpsertPipelineUsingOrcaTaskEnabled {
-			if err := b.Client.UpsertPipelineUsingOrca(p, p.ID, ""); err != nil {
-				b.Logger.Errorf("Upsert failed: %s", err.Error())
-				return err
-			}
+		if err := b.upsertPipeline(app.Name, p, appLock); err != nil {
+			return applied, err
+		}
+		applied.pipelines = append(applied.pipelines, p)
+		if exists {
+			changes.Updated++
 		} else {
-			if err := b.Client.UpsertPipeline(p, p.ID, ""); err != nil {
-				err = unwrapFront50Error(err)
-				b.Logger.Errorf("Upsert failed: %s", err.Error())
-				return err
-			}
+			changes.Created++
+		}
+	}
+	if len(dinghyfile.TemplatedPipelines) > 0 {
+		if err := b.applyTemplatedPipelines(dinghyfile, owner, existing, ignoreList, &applied, appLock); err != nil {
+			return applied, err
 		}
-		b.Logger.Info("Upsert succeeded.")
 	}
 	if deleteStale {
 		// clear existing pipelines that weren't updated
@@ -490,33 +822,109 @@ func (b *PipelineBuilder) updatePipelines(dinghyfile Dinghyfile, pusher string)
 			b.Logger.Errorf("Could not retrieve pipelines for %s: %s", app.Name, err.Error())
 		} else {
 			for _, p := range allPipelines {
+				if !ownedBy(p, owner) {
+					continue
+				}
 				if !ignoreList[p.Name] {
 					b.Logger.Infof("Deleting stale pipeline %s", p.Name)
 					if err := b.Client.DeletePipeline(p, ""); err != nil {
 						// Not worrying about handling errors here because it just means it
 						// didn't get deleted *this time*.
 						b.Logger.Warnf("Could not delete Pipeline %s (Application %s)", p.Name, p.Application)
+					} else {
+						changes.Deleted++
 					}
 				}
 			}
 		}
 	}
-	return err
+	return applied, err
+}
+
+// upsertPipeline saves a pipeline of app while holding appLock, if any.
+func (b *PipelineBuilder) upsertPipeline(app string, p plank.Pipeline, appLock lock.Lock) error {
+	if err := b.checkLock(app, appLock); err != nil {
+		return err
+	}
+
+	if b.UpsertPipelineUsingOrcaTaskEnabled {
+		if err := b.Client.UpsertPipelineUsingOrca(p, p.ID, ""); err != nil {
+			b.Logger.Errorf("Upsert failed: %s", err.Error())
+			return err
+		}
+	} else {
+		if err := b.Client.UpsertPipeline(p, p.ID, ""); err != nil {
+			err = unwrapFront50Error(err)
+			b.Logger.Errorf("Upsert failed: %s", err.Error())
+			return err
+		}
+	}
+	b.Logger.Info("Upsert succeeded.")
+	return nil
+}
+
+// checkLock checks appLock is still held, if any. Our lease may have expired
+// while processing previous pipelines.
+func (b *PipelineBuilder) checkLock(app string, appLock lock.Lock) error {
+	if appLock == nil {
+		return nil
+	}
+	if err := appLock.Check(); err != nil {
+		b.Logger.Errorf("Lost lock for application %s (token %d): %s", app, appLock.Token(), err.Error())
+		return err
+	}
+	return nil
+}
+
+// lockApplication acquires the lock for an application, it returns a nil lock
+// when locking is disabled or the builder is only validating.
+func (b *PipelineBuilder) lockApplication(app string) (lock.Lock, error) {
+	if b.Locker == nil || b.Action == pipebuilder.Validate {
+		return nil, nil
+	}
+	appLock, err := b.Locker.Acquire(lock.ApplicationKey(app))
+	if err != nil {
+		b.Logger.Errorf("Failed to lock application %s: %s", app, err.Error())
+		return nil, err
+	}
+	b.Logger.Debugf("Locked application %s (token %d)", app, appLock.Token())
+	return appLock, nil
 }
 
 // PipelineIDs returns a map of pipeline names -> their UUID.
 func (b *PipelineBuilder) PipelineIDs(app string) (map[string]string, error) {
 	ids := map[string]string{}
+	pipelines, err := b.existingPipelines(app)
+	for name, p := range pipelines {
+		ids[name] = p.ID
+	}
+	return ids, err
+}
+
+// existingPipelines returns the pipelines of an application by name.
+func (b *PipelineBuilder) existingPipelines(app string) (map[string]plank.Pipeline, error) {
+	existing := map[string]plank.Pipeline{}
 	b.Logger.Info("Looking up existing pipelines")
 	pipelines, err := b.Client.GetPipelines(app, "")
 	if err != nil {
 		b.Logger.Errorf("Failed to GetPipelines for %s: %s", app, err.Error())
-		return ids, err
+		return existing, err
 	}
 	for _, p := range pipelines {
-		ids[p.Name] = p.ID
+		existing[p.Name] = p
+	}
+	return existing, nil
+}
+
+// pipelineUnchanged checks if saving a pipeline would leave the existing one
+// as it is, ignoring the fields Spinnaker sets on save and the commit of its owner.
+func pipelineUnchanged(p, existing plank.Pipeline, owner *PipelineOwner) bool {
+	if owner != nil {
+		if current, owned := OwnerOf(existing); !owned || !current.Same(*owner) {
+			return false
+		}
 	}
-	return ids, nil
+	return len(pipelineDiffs(withoutOwner(p), existing)) == 0
 }
 
 // GetPipelineByID returns a pipeline's UUID by its name; if the pipeline
@@ -573,13 +981,26 @@ func (b *PipelineBuilder) AddUnmarshaller(u Unmarshaller) {
 }
 
 func (b *PipelineBuilder) NotifySuccess(org, repo, path string, notifications plank.NotificationsType) {
+	b.notifySuccess(org, repo, path, notifications, nil)
+}
+
+// notifySuccess adds the pipelines changed by the dinghyfile to the content
+// of the notifications, if any.
+func (b *PipelineBuilder) notifySuccess(org, repo, path string, notifications plank.NotificationsType, changes *logevents.PipelineChanges) {
+	content := func() map[string]interface{} {
+		c := b.getNotificationContent()
+		if changes != nil {
+			c["pipelines"] = *changes
+		}
+		return c
+	}
 	for _, n := range b.Notifiers {
 		if b.Action == pipebuilder.Validate {
 			if n.SendOnValidation() {
-				n.SendSuccess(org, repo, path, notifications, b.getNotificationContent())
+				n.SendSuccess(org, repo, path, notifications, content())
 			}
 		} else {
-			n.SendSuccess(org, repo, path, notifications, b.getNotificationContent())
+			n.SendSuccess(org, repo, path, notifications, content())
 		}
 	}
 }
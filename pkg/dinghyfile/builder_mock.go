@@ -113,9 +113,11 @@ func (mr *MockDependencyManagerMockRecorder) SetRawData(url, rawData interface{}
 }
 
 // SetDeps mocks base method.
-func (m *MockDependencyManager) SetDeps(parent string, deps []string) {
+func (m *MockDependencyManager) SetDeps(parent string, deps []string) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "SetDeps", parent, deps)
+	ret := m.ctrl.Call(m, "SetDeps", parent, deps)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // SetDeps indicates an expected call of SetDeps.
@@ -138,6 +140,34 @@ func (mr *MockDependencyManagerMockRecorder) GetRoots(child interface{}) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRoots", reflect.TypeOf((*MockDependencyManager)(nil).GetRoots), child)
 }
 
+// GetChildren mocks base method.
+func (m *MockDependencyManager) GetChildren(parent string) []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetChildren", parent)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// GetChildren indicates an expected call of GetChildren.
+func (mr *MockDependencyManagerMockRecorder) GetChildren(parent interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChildren", reflect.TypeOf((*MockDependencyManager)(nil).GetChildren), parent)
+}
+
+// GetAllDinghyfiles mocks base method.
+func (m *MockDependencyManager) GetAllDinghyfiles() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllDinghyfiles")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// GetAllDinghyfiles indicates an expected call of GetAllDinghyfiles.
+func (mr *MockDependencyManagerMockRecorder) GetAllDinghyfiles() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllDinghyfiles", reflect.TypeOf((*MockDependencyManager)(nil).GetAllDinghyfiles))
+}
+
 // MockDownloader is a mock of Downloader interface.
 type MockDownloader struct {
 	ctrl     *gomock.Controller
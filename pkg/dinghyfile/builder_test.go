@@ -21,10 +21,15 @@ import (
 	"errors"
 	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
 	"github.com/armory/dinghy/pkg/events"
+	"github.com/armory/dinghy/pkg/git/dummy"
+	"github.com/armory/dinghy/pkg/lint"
+	"github.com/armory/dinghy/pkg/lock"
 	"github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
 	"github.com/armory/dinghy/pkg/util"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/jinzhu/copier"
@@ -34,6 +39,7 @@ import (
 
 	"github.com/armory/dinghy/pkg/mock"
 	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/dinghy/pkg/policy"
 )
 
 // Test the high-level runthrough of ProcessDinghyfile
@@ -118,7 +124,10 @@ func TestUpdateApplication(t *testing.T) {
 	deletedPipeline := plank.Pipeline{Name: "DeletedPipeline", ID: "DeletedID", Application: "testapp"}
 	newPipeline := plank.Pipeline{Name: "NewPipeline", ID: "NewID", Locked: &plank.PipelineLockType{true, true}}
 
-	existing := []plank.Pipeline{existingPipeline, deletedPipeline}
+	// Spinnaker has an older version of the existing pipeline, unchanged pipelines aren't saved
+	outdatedPipeline := existingPipeline
+	outdatedPipeline.Description = "outdated"
+	existing := []plank.Pipeline{outdatedPipeline, deletedPipeline}
 	newPipelines := []plank.Pipeline{existingPipeline, newPipeline}
 
 	testapp := &plank.Application{Name: "testapp"}
@@ -146,7 +155,7 @@ func TestUpdateApplication(t *testing.T) {
 		DeleteStalePipelines: true,
 	}
 
-	err := b.updatePipelines(dinghyfile, "pusher")
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
 	assert.NotNil(t, err)
 	assert.Equal(t, "upsert fail test", err.Error())
 }
@@ -932,7 +941,10 @@ func TestUpdatePipelinesDeleteStaleWithExisting(t *testing.T) {
 	deletedPipeline := plank.Pipeline{Name: "DeletedPipeline", ID: "DeletedID", Application: "testapp"}
 	newPipeline := plank.Pipeline{Name: "NewPipeline", ID: "NewID", Locked: &plank.PipelineLockType{true, true}}
 
-	existing := []plank.Pipeline{existingPipeline, deletedPipeline}
+	// Spinnaker has an older version of the existing pipeline, unchanged pipelines aren't saved
+	outdatedPipeline := existingPipeline
+	outdatedPipeline.Description = "outdated"
+	existing := []plank.Pipeline{outdatedPipeline, deletedPipeline}
 	newPipelines := []plank.Pipeline{existingPipeline, newPipeline}
 	combined := []plank.Pipeline{existingPipeline, deletedPipeline, newPipeline}
 
@@ -956,7 +968,7 @@ func TestUpdatePipelinesDeleteStaleWithExisting(t *testing.T) {
 		DeleteStalePipelines: true,
 	}
 
-	err := b.updatePipelines(dinghyfile, "pusher")
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
 	assert.Nil(t, err)
 }
 
@@ -968,7 +980,10 @@ func TestUpdatePipelinesNoDeleteStaleWithExisting(t *testing.T) {
 	deletedPipeline := plank.Pipeline{Name: "DeletedPipeline", ID: "DeletedID", Application: "testapp"}
 	newPipeline := plank.Pipeline{Name: "NewPipeline", ID: "NewID", Locked: &plank.PipelineLockType{true, true}}
 
-	existing := []plank.Pipeline{existingPipeline, deletedPipeline}
+	// Spinnaker has an older version of the existing pipeline, unchanged pipelines aren't saved
+	outdatedPipeline := existingPipeline
+	outdatedPipeline.Description = "outdated"
+	existing := []plank.Pipeline{outdatedPipeline, deletedPipeline}
 	newPipelines := []plank.Pipeline{existingPipeline, newPipeline}
 
 	testapp := &plank.Application{Name: "testapp"}
@@ -990,10 +1005,105 @@ func TestUpdatePipelinesNoDeleteStaleWithExisting(t *testing.T) {
 		DeleteStalePipelines: false,
 	}
 
-	err := b.updatePipelines(dinghyfile, "pusher")
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
 	assert.Nil(t, err)
 }
 
+func TestUpdatePipelinesSkipsUnchanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stages := []map[string]interface{}{{"name": "wait", "refId": "1", "type": "wait", "waitTime": 30}}
+	unchanged := plank.Pipeline{Name: "Unchanged", Application: "testapp", Stages: stages}
+	updated := plank.Pipeline{Name: "Updated", Application: "testapp", Description: "new"}
+	created := plank.Pipeline{Name: "Created", Application: "testapp"}
+
+	// Spinnaker sets the ids and save fields, stage numbers come back as float64
+	storedUnchanged := plank.Pipeline{Name: "Unchanged", ID: "UnchangedID", Application: "testapp", UpdateTs: "1600000000000", LastModifiedBy: "dinghy",
+		Stages: []map[string]interface{}{{"name": "wait", "refId": "1", "type": "wait", "waitTime": float64(30)}}}
+	storedUpdated := plank.Pipeline{Name: "Updated", ID: "UpdatedID", Application: "testapp", Description: "old"}
+	stale := plank.Pipeline{Name: "Stale", ID: "StaleID", Application: "testapp"}
+	existing := []plank.Pipeline{storedUnchanged, storedUpdated, stale}
+
+	expectedUpdate := updated
+	expectedUpdate.ID = "UpdatedID"
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, nil).Times(1)
+	client.EXPECT().GetPipelines("testapp", "").Return(existing, nil).Times(2)
+	client.EXPECT().UpsertPipeline(expectedUpdate, "UpdatedID", "").Return(nil).Times(1)
+	client.EXPECT().UpsertPipeline(created, "", "").Return(nil).Times(1)
+	client.EXPECT().DeletePipeline(stale, "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec:      plank.Application{Name: "testapp"},
+		Pipelines:            []plank.Pipeline{unchanged, updated, created},
+		DeleteStalePipelines: true,
+	}
+	applied, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, logevents.PipelineChanges{Created: 1, Updated: 1, Unchanged: 1, Deleted: 1}, applied.changes)
+	assert.Len(t, applied.pipelines, 3)
+}
+
+func TestUpdatePipelinesLockedApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	locker := lock.NewMemoryLocker(lock.Options{WaitTimeout: 10 * time.Millisecond, Lease: time.Minute})
+	held, err := locker.Acquire(lock.ApplicationKey("testapp"))
+	assert.Nil(t, err)
+	defer held.Release()
+
+	// Nothing must be read or written while another push holds the application
+	client := NewMockPlankClient(ctrl)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.Locker = locker
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{{Name: "NewPipeline"}},
+	}
+
+	_, err = b.updatePipelines(dinghyfile, "pusher", nil)
+	assert.True(t, errors.Is(err, lock.ErrTimeout))
+}
+
+func TestUpdatePipelinesReleasesLock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	newPipeline := plank.Pipeline{Name: "NewPipeline", Application: "testapp"}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, nil).Times(1)
+	client.EXPECT().GetPipelines(gomock.Eq("testapp"), "").Return([]plank.Pipeline{}, nil).Times(1)
+	client.EXPECT().UpsertPipeline(gomock.Eq(newPipeline), "", "").Return(nil).Times(1)
+
+	locker := lock.NewMemoryLocker(lock.Options{WaitTimeout: 10 * time.Millisecond, Lease: time.Minute})
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.Locker = locker
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{newPipeline},
+	}
+
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	assert.Nil(t, err)
+
+	held, err := locker.Acquire(lock.ApplicationKey("testapp"))
+	assert.Nil(t, err)
+	held.Release()
+}
+
 func TestUpdatePipelinesDeleteStaleWithFailure(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -1002,7 +1112,10 @@ func TestUpdatePipelinesDeleteStaleWithFailure(t *testing.T) {
 	deletedPipeline := plank.Pipeline{Name: "DeletedPipeline", ID: "DeletedID", Application: "testapp"}
 	newPipeline := plank.Pipeline{Name: "NewPipeline", ID: "NewID", Locked: &plank.PipelineLockType{true, true}}
 
-	existing := []plank.Pipeline{existingPipeline, deletedPipeline}
+	// Spinnaker has an older version of the existing pipeline, unchanged pipelines aren't saved
+	outdatedPipeline := existingPipeline
+	outdatedPipeline.Description = "outdated"
+	existing := []plank.Pipeline{outdatedPipeline, deletedPipeline}
 	newPipelines := []plank.Pipeline{existingPipeline, newPipeline}
 
 	testapp := &plank.Application{Name: "testapp"}
@@ -1026,7 +1139,7 @@ func TestUpdatePipelinesDeleteStaleWithFailure(t *testing.T) {
 		DeleteStalePipelines: true,
 	}
 
-	err := b.updatePipelines(dinghyfile, "true")
+	_, err := b.updatePipelines(dinghyfile, "true", nil)
 	assert.Nil(t, err)
 }
 
@@ -1038,7 +1151,10 @@ func TestUpdatePipelinesUpsertFail(t *testing.T) {
 	deletedPipeline := plank.Pipeline{Name: "DeletedPipeline", ID: "DeletedID", Application: "testapp"}
 	newPipeline := plank.Pipeline{Name: "NewPipeline", ID: "NewID", Locked: &plank.PipelineLockType{true, true}}
 
-	existing := []plank.Pipeline{existingPipeline, deletedPipeline}
+	// Spinnaker has an older version of the existing pipeline, unchanged pipelines aren't saved
+	outdatedPipeline := existingPipeline
+	outdatedPipeline.Description = "outdated"
+	existing := []plank.Pipeline{outdatedPipeline, deletedPipeline}
 	newPipelines := []plank.Pipeline{existingPipeline, newPipeline}
 
 	testapp := &plank.Application{Name: "testapp"}
@@ -1060,11 +1176,35 @@ func TestUpdatePipelinesUpsertFail(t *testing.T) {
 		Pipelines:            newPipelines,
 		DeleteStalePipelines: true,
 	}
-	err := b.updatePipelines(dinghyfile, "true")
+	_, err := b.updatePipelines(dinghyfile, "true", nil)
 	assert.NotNil(t, err)
 	assert.Equal(t, "upsert fail test", err.Error())
 }
 
+func TestUpdatePipelinesGetPipelinesFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testapp := &plank.Application{Name: "testapp"}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(testapp, nil).Times(1)
+	client.EXPECT().GetPipelines(gomock.Eq("testapp"), "").Return(nil, errors.New("get pipelines fail test")).Times(1)
+	// Should not get called at all, the existing pipelines would be duplicated
+	client.EXPECT().UpsertPipeline(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	b := testPipelineBuilder()
+	b.Client = client
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: *testapp,
+		Pipelines:       []plank.Pipeline{{Name: "NewPipeline", Application: "testapp"}},
+	}
+	_, err := b.updatePipelines(dinghyfile, "true", nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, "get pipelines fail test", err.Error())
+}
+
 func TestUpdatePipelinesRespectsAutoLockOn(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -1092,7 +1232,7 @@ func TestUpdatePipelinesRespectsAutoLockOn(t *testing.T) {
 		Pipelines:            []plank.Pipeline{newPipeline},
 		DeleteStalePipelines: false,
 	}
-	err := b.updatePipelines(dinghyfile, "true")
+	_, err := b.updatePipelines(dinghyfile, "true", nil)
 	assert.Nil(t, err)
 }
 
@@ -1122,7 +1262,7 @@ func TestUpdatePipelinesRespectsAutoLockOff(t *testing.T) {
 		Pipelines:            []plank.Pipeline{newPipeline},
 		DeleteStalePipelines: false,
 	}
-	err := b.updatePipelines(dinghyfile, "")
+	_, err := b.updatePipelines(dinghyfile, "", nil)
 	assert.Nil(t, err)
 }
 
@@ -1441,3 +1581,72 @@ func TestPipelineBuilder_getContent(t *testing.T) {
 		})
 	}
 }
+
+func TestEvaluatePolicies(t *testing.T) {
+	b := testPipelineBuilder()
+	d := Dinghyfile{
+		Application: "testapp",
+		Pipelines:   []plank.Pipeline{{Name: "deploy", Application: "testapp"}},
+	}
+	assert.Nil(t, b.EvaluatePolicies(d))
+
+	b.Downloader = dummy.FileService{"master": {"policies.yml": `
+rules:
+  - id: description
+    severity: warn
+    scope: pipeline
+    condition: '!has(pipeline.description) || pipeline.description == ""'
+    message: pipelines should have a description
+  - id: app-prefix
+    condition: '!dinghyfile.application.startsWith("team-")'
+    message: applications must start with team-
+`}}
+	b.PolicySource = &policy.Source{Org: "org", Repo: "policies", Path: "policies.yml", Branch: "master"}
+	err := b.EvaluatePolicies(d)
+	violations, ok := err.(*policy.ViolationError)
+	assert.True(t, ok)
+	assert.Equal(t, []policy.Violation{{RuleID: "app-prefix", Severity: policy.SeverityDeny, Message: "applications must start with team-"}}, violations.Violations)
+
+	d.Application = "team-app"
+	assert.Nil(t, b.EvaluatePolicies(d))
+}
+
+func TestLintPipelines(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b := testPipelineBuilder()
+	d := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines: []plank.Pipeline{{
+			Name: "deploy",
+			Triggers: []map[string]interface{}{
+				{"type": "cron", "cronExpression": "0 0 12 * * *"},
+				{"type": "pipeline", "pipeline": "missing"},
+			},
+		}},
+	}
+	assert.Nil(t, b.LintPipelines(d, "repo", "master"))
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{}, nil).Times(1)
+	b.Client = client
+	b.LintReport = &lint.Report{}
+	b.LintRules = func(repo, branch string) map[string]string {
+		return map[string]string{lint.InvalidCron: "warn"}
+	}
+	assert.Nil(t, b.LintPipelines(d, "repo", "master"))
+	assert.Equal(t, 2, b.LintReport.Count())
+
+	client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{}, nil).Times(1)
+	b.LintRules = func(repo, branch string) map[string]string {
+		return map[string]string{lint.TriggerMissingPipeline: "error"}
+	}
+	err := b.LintPipelines(d, "repo", "master")
+	lintErr, ok := err.(*lint.Error)
+	assert.True(t, ok)
+	assert.Equal(t, []lint.Finding{
+		{Rule: lint.InvalidCron, Severity: lint.SeverityError, Pipeline: "deploy", Message: `cron expression "0 0 12 * * *": one, and only one, of day of month and day of week must be ?`},
+		{Rule: lint.TriggerMissingPipeline, Severity: lint.SeverityError, Pipeline: "deploy", Message: "enabled trigger of pipeline missing of application testapp, it doesn't exist"},
+	}, lintErr.Findings)
+}
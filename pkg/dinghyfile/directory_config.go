@@ -0,0 +1,209 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+// DirectoryConfigFilename is the name of the optional file that sets the
+// defaults of the dinghyfiles beneath its directory.
+const DirectoryConfigFilename = ".dinghy.yml"
+
+// DirectoryConfig is the content of a .dinghy.yml file. Unset fields are
+// inherited from the parent directories and then from the settings.
+type DirectoryConfig struct {
+	TemplateOrg          *string `json:"templateOrg,omitempty" yaml:"templateOrg"`
+	TemplateRepo         *string `json:"templateRepo,omitempty" yaml:"templateRepo"`
+	ParserFormat         *string `json:"parserFormat,omitempty" yaml:"parserFormat"`
+	AutolockPipelines    *bool   `json:"autoLockPipelines,omitempty" yaml:"autoLockPipelines"`
+	DeleteStalePipelines *bool   `json:"deleteStalePipelines,omitempty" yaml:"deleteStalePipelines"`
+	// Settings overrides for the pushes to the repository, only allowed at the repository root
+	Settings *global.RepositorySettings `json:"settings,omitempty" yaml:"settings"`
+}
+
+// ParseDirectoryConfig parses a .dinghy.yml file, unknown keys are rejected
+// so typos don't go unnoticed.
+func ParseDirectoryConfig(contents string) (DirectoryConfig, error) {
+	c := DirectoryConfig{}
+	raw := struct {
+		Settings map[interface{}]interface{} `yaml:"settings"`
+	}{}
+	if err := yaml.Unmarshal([]byte(contents), &raw); err != nil {
+		return c, err
+	}
+	if err := global.ValidateRepositorySettings(raw.Settings); err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict([]byte(contents), &c); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// IsEmpty is true when the config doesn't override anything
+func (c DirectoryConfig) IsEmpty() bool {
+	return c == DirectoryConfig{}
+}
+
+// Inherit returns the config with the unset fields taken from parent
+func (c DirectoryConfig) Inherit(parent DirectoryConfig) DirectoryConfig {
+	if c.TemplateOrg == nil {
+		c.TemplateOrg = parent.TemplateOrg
+	}
+	if c.TemplateRepo == nil {
+		c.TemplateRepo = parent.TemplateRepo
+	}
+	if c.ParserFormat == nil {
+		c.ParserFormat = parent.ParserFormat
+	}
+	if c.AutolockPipelines == nil {
+		c.AutolockPipelines = parent.AutolockPipelines
+	}
+	if c.DeleteStalePipelines == nil {
+		c.DeleteStalePipelines = parent.DeleteStalePipelines
+	}
+	return c
+}
+
+func (c DirectoryConfig) apply(b *PipelineBuilder) {
+	if c.TemplateOrg != nil {
+		b.TemplateOrg = *c.TemplateOrg
+	}
+	if c.TemplateRepo != nil {
+		b.TemplateRepo = *c.TemplateRepo
+	}
+	if c.AutolockPipelines != nil {
+		b.AutolockPipelines = strconv.FormatBool(*c.AutolockPipelines)
+	}
+	if c.DeleteStalePipelines != nil {
+		b.DeleteStalePipelines = *c.DeleteStalePipelines
+	}
+}
+
+// DirectoryConfigs finds the .dinghy.yml files that apply to a dinghyfile,
+// every file is downloaded once.
+type DirectoryConfigs struct {
+	Downloader Downloader
+	configs    map[string]DirectoryConfig
+}
+
+func NewDirectoryConfigs(d Downloader) *DirectoryConfigs {
+	return &DirectoryConfigs{
+		Downloader: d,
+		configs:    map[string]DirectoryConfig{},
+	}
+}
+
+// ForRepository returns the config at the root of the repository, the only
+// one that can override settings.
+func (dc *DirectoryConfigs) ForRepository(org, repo, branch string) (DirectoryConfig, error) {
+	return dc.forDirectory(org, repo, ".", branch)
+}
+
+// ForFile returns the config of the directory of a file merged with the
+// configs of all its parent directories, the nearest file wins.
+func (dc *DirectoryConfigs) ForFile(org, repo, file, branch string) (DirectoryConfig, error) {
+	config := DirectoryConfig{}
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		c, err := dc.forDirectory(org, repo, dir, branch)
+		if err != nil {
+			return config, err
+		}
+		if dir == "." || dir == "/" {
+			// settings were already applied for the whole push
+			c.Settings = nil
+			return config.Inherit(c), nil
+		}
+		if c.Settings != nil {
+			return config, fmt.Errorf("invalid %s: settings can only be set in the %s at the repository root", path.Join(dir, DirectoryConfigFilename), DirectoryConfigFilename)
+		}
+		config = config.Inherit(c)
+	}
+}
+
+func (dc *DirectoryConfigs) forDirectory(org, repo, dir, branch string) (DirectoryConfig, error) {
+	file := DirectoryConfigFilename
+	if dir != "." && dir != "/" {
+		file = path.Join(dir, DirectoryConfigFilename)
+	}
+	url := dc.Downloader.EncodeURL(org, repo, file, branch)
+	if c, found := dc.configs[url]; found {
+		return c, nil
+	}
+
+	c := DirectoryConfig{}
+	contents, err := dc.Downloader.Download(org, repo, file, branch)
+	switch {
+	case util.IsFileNotFoundErr(err):
+		// most directories don't have a config
+	case err != nil:
+		return c, fmt.Errorf("failed to download %s: %w", file, err)
+	default:
+		if c, err = ParseDirectoryConfig(contents); err != nil {
+			return c, fmt.Errorf("invalid %s: %w", file, err)
+		}
+	}
+	dc.configs[url] = c
+	return c, nil
+}
+
+// withDirectoryConfig runs f with a copy of the builder using the config
+// defaults, the parser is bound to the copy for the duration of f.
+func (b *PipelineBuilder) withDirectoryConfig(c DirectoryConfig, f func(*PipelineBuilder) (string, error)) (string, error) {
+	db := *b
+	db.DirectoryConfigs = nil
+	c.apply(&db)
+
+	parser := b.Parser
+	if c.ParserFormat != nil {
+		found, ok := b.Parsers[*c.ParserFormat]
+		if !ok {
+			return "", fmt.Errorf("parser format %q is not available", *c.ParserFormat)
+		}
+		parser = found
+	}
+	if parser != nil {
+		db.Parser = parser
+		parser.SetBuilder(&db)
+		if b.Parser != nil {
+			defer b.Parser.SetBuilder(b)
+		}
+	}
+	return f(&db)
+}
+
+// IsDinghyfile checks if the name of a file is the dinghyfile name or matches
+// one of the dinghyfile patterns.
+func (b *PipelineBuilder) IsDinghyfile(file string) bool {
+	name := filepath.Base(file)
+	if name == b.DinghyfileName {
+		return true
+	}
+	for _, pattern := range b.DinghyfilePatterns {
+		// dot files such as .dinghy.yml are never dinghyfiles
+		if matched, _ := filepath.Match(pattern, name); matched && !strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
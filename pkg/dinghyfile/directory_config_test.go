@@ -0,0 +1,142 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/git/dummy"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDirectoryConfig(t *testing.T) {
+	c, err := ParseDirectoryConfig("templateRepo: other-templates\nautoLockPipelines: false\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "other-templates", *c.TemplateRepo)
+	assert.False(t, *c.AutolockPipelines)
+	assert.Nil(t, c.TemplateOrg)
+
+	_, err = ParseDirectoryConfig("templateRepos: typo\n")
+	assert.NotNil(t, err)
+
+	c, err = ParseDirectoryConfig("")
+	assert.Nil(t, err)
+	assert.True(t, c.IsEmpty())
+
+	c, err = ParseDirectoryConfig("settings:\n  jsonValidationDisabled: true\n")
+	assert.Nil(t, err)
+	assert.True(t, *c.Settings.JsonValidationDisabled)
+
+	_, err = ParseDirectoryConfig("settings:\n  githubToken: token\n")
+	assert.NotNil(t, err)
+}
+
+func TestDirectoryConfigsForFile(t *testing.T) {
+	fs := dummy.FileService{
+		"master": {
+			".dinghy.yml":                 "templateOrg: root-org\ndeleteStalePipelines: true\n",
+			"services/.dinghy.yml":        "templateOrg: services-org\ntemplateRepo: services-templates\n",
+			"services/broken/.dinghy.yml": "templateOrg: [",
+			"services/app/dinghyfile":     "{}",
+			"services/broken/dinghyfile":  "{}",
+			"tools/dinghyfile":            "{}",
+			"nested/.dinghy.yml":          "settings:\n  autoLockPipelines: false\n",
+			"nested/dinghyfile":           "{}",
+		},
+	}
+	dc := NewDirectoryConfigs(fs)
+
+	c, err := dc.ForFile("org", "repo", "services/app/dinghyfile", "master")
+	assert.Nil(t, err)
+	assert.Equal(t, "services-org", *c.TemplateOrg)
+	assert.Equal(t, "services-templates", *c.TemplateRepo)
+	assert.True(t, *c.DeleteStalePipelines)
+
+	c, err = dc.ForFile("org", "repo", "tools/dinghyfile", "master")
+	assert.Nil(t, err)
+	assert.Equal(t, "root-org", *c.TemplateOrg)
+	assert.Nil(t, c.TemplateRepo)
+
+	c, err = dc.ForFile("org", "repo", "dinghyfile", "master")
+	assert.Nil(t, err)
+	assert.Equal(t, "root-org", *c.TemplateOrg)
+
+	_, err = dc.ForFile("org", "repo", "services/broken/dinghyfile", "master")
+	assert.NotNil(t, err)
+
+	// Settings can only be overridden at the repository root
+	_, err = dc.ForFile("org", "repo", "nested/dinghyfile", "master")
+	assert.NotNil(t, err)
+}
+
+func TestWithDirectoryConfig(t *testing.T) {
+	b := testPipelineBuilder()
+	b.TemplateRepo = "templates"
+	b.AutolockPipelines = "true"
+	parser := NewDinghyfileParser(b)
+	b.Parser = parser
+
+	repo, autolock := "other-templates", false
+	c := DirectoryConfig{TemplateRepo: &repo, AutolockPipelines: &autolock}
+	_, err := b.withDirectoryConfig(c, func(db *PipelineBuilder) (string, error) {
+		assert.Equal(t, "other-templates", db.TemplateRepo)
+		assert.Equal(t, "false", db.AutolockPipelines)
+		assert.Equal(t, "armory", db.TemplateOrg)
+		assert.Equal(t, db, parser.Builder)
+		return "", nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "templates", b.TemplateRepo)
+	assert.Equal(t, b, parser.Builder)
+
+	format := "hcl"
+	_, err = b.withDirectoryConfig(DirectoryConfig{ParserFormat: &format}, func(db *PipelineBuilder) (string, error) {
+		return "", nil
+	})
+	assert.NotNil(t, err)
+}
+
+func TestIsDinghyfile(t *testing.T) {
+	b := testPipelineBuilder()
+	b.DinghyfileName = "dinghyfile"
+	b.DinghyfilePatterns = []string{"*.dinghyfile", "dinghyfile.*"}
+
+	assert.True(t, b.IsDinghyfile("dinghyfile"))
+	assert.True(t, b.IsDinghyfile("services/app/dinghyfile"))
+	assert.True(t, b.IsDinghyfile("services/app/app.dinghyfile"))
+	assert.True(t, b.IsDinghyfile("services/app/dinghyfile.json"))
+	assert.False(t, b.IsDinghyfile("services/app/.dinghyfile"))
+	assert.False(t, b.IsDinghyfile("services/app/module.json"))
+}
+
+func TestDirectoryConfigsDownloadFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	downloader := NewMockDownloader(ctrl)
+	downloader.EXPECT().EncodeURL("org", "repo", gomock.Any(), "master").DoAndReturn(
+		func(org, repo, file, branch string) string { return file }).AnyTimes()
+	downloader.EXPECT().Download("org", "repo", "services/.dinghy.yml", "master").Return("", &util.GitHubFileNotFoundErr{}).Times(1)
+	downloader.EXPECT().Download("org", "repo", ".dinghy.yml", "master").Return("", errors.New("Error downloading file from http://stash: Status: 401")).Times(2)
+	dc := NewDirectoryConfigs(downloader)
+
+	// Failures aren't cached as a missing config
+	for i := 0; i < 2; i++ {
+		_, err := dc.ForFile("org", "repo", "services/dinghyfile", "master")
+		assert.EqualError(t, err, "failed to download .dinghy.yml: Error downloading file from http://stash: Status: 401")
+	}
+}
@@ -0,0 +1,236 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/armory/plank/v4"
+)
+
+// Ways a pipeline drifts from its dinghyfile
+const (
+	// DriftModified pipelines differ from the rendered ones
+	DriftModified = "modified"
+	// DriftUnmanaged pipelines are not rendered by any dinghyfile of the application
+	DriftUnmanaged = "unmanaged"
+	// DriftMissing pipelines are rendered but not in Spinnaker
+	DriftMissing = "missing"
+)
+
+// volatileFields are set by Spinnaker every time a pipeline is saved
+var volatileFields = []string{"updateTs", "lastModifiedBy"}
+
+// ApplicationDrift is the difference between the pipelines and settings
+// rendered from the dinghyfiles of an application and the ones in Spinnaker
+type ApplicationDrift struct {
+	Application string `json:"application"`
+	// Missing is set when the application doesn't exist in Spinnaker
+	Missing   bool            `json:"missing,omitempty"`
+	Settings  []FieldDiff     `json:"settings"`
+	Pipelines []PipelineDrift `json:"pipelines"`
+}
+
+// PipelineDrift is a pipeline that drifted, Fields are only set for modified pipelines
+type PipelineDrift struct {
+	Name   string      `json:"name"`
+	Drift  string      `json:"drift"`
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+// FieldDiff is a field with a different value in Spinnaker, Path is its
+// location in the JSON document, eg: stages[0].name
+type FieldDiff struct {
+	Path     string      `json:"path"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+// Drifted reports if anything differs from the dinghyfiles
+func (a ApplicationDrift) Drifted() bool {
+	return a.Missing || len(a.Settings) > 0 || len(a.Pipelines) > 0
+}
+
+// Count returns the number of pipelines with the given drift
+func (a ApplicationDrift) Count(drift string) int {
+	count := 0
+	for _, p := range a.Pipelines {
+		if p.Drift == drift {
+			count++
+		}
+	}
+	return count
+}
+
+// RenderDinghyfile renders a dinghyfile without updating anything.
+func (b *PipelineBuilder) RenderDinghyfile(org, repo, path, branch string) (Dinghyfile, error) {
+	buf, err := b.Parser.Parse(org, repo, path, branch, nil)
+	if err != nil {
+		return Dinghyfile{}, err
+	}
+	return b.UpdateDinghyfile(buf.Bytes())
+}
+
+// ApplicationDrift compares the application settings and pipelines rendered
+// from the dinghyfiles of app with the ones in Spinnaker. Only the settings
+// set by the dinghyfiles are compared.
+func (b *PipelineBuilder) ApplicationDrift(app string, dinghyfiles []Dinghyfile) (ApplicationDrift, error) {
+	drift := ApplicationDrift{Application: app, Settings: []FieldDiff{}, Pipelines: []PipelineDrift{}}
+
+	live, err := b.Client.GetApplication(app, "")
+	if err != nil {
+		if failed, ok := err.(*plank.FailedResponse); !ok || failed.StatusCode != 404 {
+			return drift, fmt.Errorf("unable to get application %s: %w", app, err)
+		}
+		drift.Missing = true
+	}
+	var existing []plank.Pipeline
+	if !drift.Missing {
+		if existing, err = b.Client.GetPipelines(app, ""); err != nil {
+			return drift, fmt.Errorf("unable to get pipelines of %s: %w", app, err)
+		}
+	}
+	current := map[string]plank.Pipeline{}
+	for _, p := range existing {
+		current[p.Name] = p
+	}
+
+	rendered := map[string]bool{}
+	for _, d := range dinghyfiles {
+		if live != nil {
+			drift.Settings = append(drift.Settings, applicationDiffs(d.ApplicationSpec, *live)...)
+		}
+		for _, p := range d.Pipelines {
+			rendered[p.Name] = true
+			if b.AutolockPipelines == "true" {
+				p.Lock()
+			}
+			old, exists := current[p.Name]
+			if !exists {
+				drift.Pipelines = append(drift.Pipelines, PipelineDrift{Name: p.Name, Drift: DriftMissing})
+				continue
+			}
+			p.ID = old.ID
+			if fields := pipelineDiffs(p, old); len(fields) > 0 {
+				drift.Pipelines = append(drift.Pipelines, PipelineDrift{Name: p.Name, Drift: DriftModified, Fields: fields})
+			}
+		}
+	}
+	for _, p := range existing {
+		if !rendered[p.Name] {
+			drift.Pipelines = append(drift.Pipelines, PipelineDrift{Name: p.Name, Drift: DriftUnmanaged})
+		}
+	}
+	return drift, nil
+}
+
+func pipelineDiffs(expected, actual plank.Pipeline) []FieldDiff {
+	e, a := jsonObject(expected), jsonObject(withoutOwner(actual))
+	for _, field := range volatileFields {
+		delete(e, field)
+		delete(a, field)
+	}
+	return diffFields("", e, a)
+}
+
+// applicationDiffs compares the settings of the dinghyfile application spec,
+// Spinnaker keeps other settings that dinghy doesn't manage.
+func applicationDiffs(expected, actual plank.Application) []FieldDiff {
+	e, a := jsonObject(expected), jsonObject(actual)
+	keys := make([]string, 0, len(e))
+	for k, v := range e {
+		if !emptyValue(v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	diffs := []FieldDiff{}
+	for _, k := range keys {
+		diffs = append(diffs, diffFields(k, e[k], a[k])...)
+	}
+	return diffs
+}
+
+// jsonObject converts v to the map it is serialized as
+func jsonObject(v interface{}) map[string]interface{} {
+	object := map[string]interface{}{}
+	if out, err := json.Marshal(v); err == nil {
+		json.Unmarshal(out, &object)
+	}
+	return object
+}
+
+func emptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case map[string]interface{}:
+		return len(value) == 0
+	case []interface{}:
+		return len(value) == 0
+	}
+	return false
+}
+
+// diffFields compares two JSON values field by field
+func diffFields(path string, expected, actual interface{}) []FieldDiff {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		if a, ok := actual.(map[string]interface{}); ok {
+			keys := []string{}
+			for k := range e {
+				keys = append(keys, k)
+			}
+			for k := range a {
+				if _, exists := e[k]; !exists {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			diffs := []FieldDiff{}
+			for _, k := range keys {
+				field := k
+				if path != "" {
+					field = path + "." + k
+				}
+				diffs = append(diffs, diffFields(field, e[k], a[k])...)
+			}
+			return diffs
+		}
+	case []interface{}:
+		if a, ok := actual.([]interface{}); ok {
+			diffs := []FieldDiff{}
+			for i := 0; i < len(e) || i < len(a); i++ {
+				var ev, av interface{}
+				if i < len(e) {
+					ev = e[i]
+				}
+				if i < len(a) {
+					av = a[i]
+				}
+				diffs = append(diffs, diffFields(fmt.Sprintf("%s[%d]", path, i), ev, av)...)
+			}
+			return diffs
+		}
+	}
+	if reflect.DeepEqual(expected, actual) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Expected: expected, Actual: actual}}
+}
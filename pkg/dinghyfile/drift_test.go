@@ -0,0 +1,103 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"testing"
+
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplicationDrift(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b := testPipelineBuilder()
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("app", "").Return(&plank.Application{
+		Name:        "app",
+		Email:       "old@example.com",
+		Description: "set in deck",
+	}, nil).Times(1)
+	client.EXPECT().GetPipelines("app", "").Return([]plank.Pipeline{
+		{
+			ID:             "1",
+			Name:           "same",
+			Application:    "app",
+			LastModifiedBy: "someone",
+			UpdateTs:       "1600000000000",
+		},
+		{
+			ID:          "2",
+			Name:        "edited",
+			Application: "app",
+			Stages:      []map[string]interface{}{{"name": "wait", "waitTime": 60}},
+		},
+		{ID: "3", Name: "manual", Application: "app"},
+	}, nil).Times(1)
+	b.Client = client
+
+	d := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "app", Email: "team@example.com"},
+		Pipelines: []plank.Pipeline{
+			{Name: "same", Application: "app"},
+			{Name: "edited", Application: "app", Stages: []map[string]interface{}{{"name": "wait", "waitTime": 30}}},
+			{Name: "new", Application: "app"},
+		},
+	}
+	drift, err := b.ApplicationDrift("app", []Dinghyfile{d})
+	assert.Nil(t, err)
+	assert.True(t, drift.Drifted())
+	assert.False(t, drift.Missing)
+	assert.Equal(t, []FieldDiff{{Path: "email", Expected: "team@example.com", Actual: "old@example.com"}}, drift.Settings)
+	assert.Equal(t, []PipelineDrift{
+		{
+			Name:   "edited",
+			Drift:  DriftModified,
+			Fields: []FieldDiff{{Path: "stages[0].waitTime", Expected: float64(30), Actual: float64(60)}},
+		},
+		{Name: "new", Drift: DriftMissing},
+		{Name: "manual", Drift: DriftUnmanaged},
+	}, drift.Pipelines)
+	assert.Equal(t, 1, drift.Count(DriftUnmanaged))
+}
+
+func TestApplicationDriftMissingApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b := testPipelineBuilder()
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("app", "").Return(nil, &plank.FailedResponse{StatusCode: 404}).Times(1)
+	b.Client = client
+
+	drift, err := b.ApplicationDrift("app", []Dinghyfile{{Pipelines: []plank.Pipeline{{Name: "p", Application: "app"}}}})
+	assert.Nil(t, err)
+	assert.True(t, drift.Missing)
+	assert.Equal(t, []PipelineDrift{{Name: "p", Drift: DriftMissing}}, drift.Pipelines)
+}
+
+func TestDiffFields(t *testing.T) {
+	expected := map[string]interface{}{"a": "x", "list": []interface{}{"1", "2"}, "nested": map[string]interface{}{"b": true}}
+	actual := map[string]interface{}{"a": "x", "list": []interface{}{"1"}, "nested": map[string]interface{}{"b": false, "c": "new"}}
+
+	assert.Equal(t, []FieldDiff{
+		{Path: "list[1]", Expected: "2", Actual: nil},
+		{Path: "nested.b", Expected: true, Actual: false},
+		{Path: "nested.c", Expected: nil, Actual: "new"},
+	}, diffFields("", expected, actual))
+	assert.Empty(t, diffFields("", expected, expected))
+}
@@ -0,0 +1,98 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
+	"github.com/armory/dinghy/pkg/freeze"
+)
+
+// Freezes only validates the dinghyfiles of the applications in a change
+// freeze, unless the freezes are overridden.
+type Freezes struct {
+	Calendar *freeze.Calendar
+	// Error is why the freeze windows are invalid, nothing is applied until they are fixed
+	Error error
+	// Override lifts the freezes, it describes who or what lifted them
+	Override string
+	// Frozen are the dinghyfiles only validated because of a freeze
+	Frozen []FrozenDinghyfile
+	// Overridden are the dinghyfiles applied despite a freeze
+	Overridden []FrozenDinghyfile
+	// Now is the time the windows are checked at, time.Now when nil
+	Now func() time.Time
+}
+
+// FrozenDinghyfile is a dinghyfile of an application in a change freeze
+type FrozenDinghyfile struct {
+	Org         string
+	Repo        string
+	Path        string
+	Application string
+	Freeze      freeze.Freeze
+}
+
+// Active returns the freeze of an application, if any.
+func (f *Freezes) Active(org, repo, application string) (*freeze.Freeze, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if f.Error != nil {
+		return nil, f.Error
+	}
+	now := time.Now
+	if f.Now != nil {
+		now = f.Now
+	}
+	return f.Calendar.Active(now(), freeze.Scope{Org: org, Repo: repo, Application: application}), nil
+}
+
+// Description describes why a dinghyfile was only validated, empty when it
+// wasn't. Every dinghyfile of a push gets its own commit status, the freezes
+// of the others don't show in it.
+func (f *Freezes) Description(org, repo, path string) string {
+	if f == nil {
+		return ""
+	}
+	for i := len(f.Frozen) - 1; i >= 0; i-- {
+		if frozen := f.Frozen[i]; frozen.Org == org && frozen.Repo == repo && frozen.Path == path {
+			return fmt.Sprintf("Validated only, %s", frozen.Freeze.Description())
+		}
+	}
+	return ""
+}
+
+// frozen checks if the changes of a dinghyfile must not be applied because
+// of a freeze, overridden freezes are recorded and don't stop them.
+func (b *PipelineBuilder) frozen(org, repo, path string, d Dinghyfile) (bool, error) {
+	if b.Action != pipebuilder.Process || b.Freezes == nil {
+		return false, nil
+	}
+	active, err := b.Freezes.Active(org, repo, d.ApplicationSpec.Name)
+	if err != nil || active == nil {
+		return false, err
+	}
+	frozen := FrozenDinghyfile{Org: org, Repo: repo, Path: path, Application: d.ApplicationSpec.Name, Freeze: *active}
+	if b.Freezes.Override != "" {
+		b.Logger.Warnf("Applying %s despite the %s, overridden by %s", path, active.Description(), b.Freezes.Override)
+		b.Freezes.Overridden = append(b.Freezes.Overridden, frozen)
+		return false, nil
+	}
+	b.Logger.Infof("Only validating %s, %s is in a %s", path, d.ApplicationSpec.Name, active.Description())
+	b.Freezes.Frozen = append(b.Freezes.Frozen, frozen)
+	return true, nil
+}
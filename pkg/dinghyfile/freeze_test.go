@@ -0,0 +1,107 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/freeze"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFreezes(t *testing.T) *Freezes {
+	calendar, err := freeze.New([]freeze.Window{{Name: "holidays", Start: "2022-12-23", End: "2022-12-26", Applications: []string{"biff"}}})
+	assert.Nil(t, err)
+	return &Freezes{Calendar: calendar, Now: func() time.Time { return time.Date(2022, 12, 24, 12, 0, 0, 0, time.UTC) }}
+}
+
+func TestProcessDinghyfileFrozen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(`{"application":"biff"}`), nil)
+
+	// nothing is applied
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = NewMockPlankClient(ctrl)
+	b.Freezes = testFreezes(t)
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	assert.Len(t, b.Freezes.Frozen, 1)
+	assert.Equal(t, FrozenDinghyfile{Org: "myorg", Repo: "myrepo", Path: "dinghyfile", Application: "biff", Freeze: b.Freezes.Frozen[0].Freeze}, b.Freezes.Frozen[0])
+	assert.Equal(t, "Validated only, change freeze holidays until 2022-12-27 00:00 UTC", b.Freezes.Description("myorg", "myrepo", "dinghyfile"))
+	assert.Equal(t, "", b.Freezes.Description("myorg", "myrepo", "other/dinghyfile"))
+	assert.Empty(t, b.Freezes.Overridden)
+}
+
+func TestProcessDinghyfileFreezeOverridden(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(`{"application":"biff"}`), nil)
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil).Times(1)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = client
+	b.Freezes = testFreezes(t)
+	b.Freezes.Override = "pusher release-manager"
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "release-manager")
+	assert.Nil(t, err)
+	assert.Empty(t, b.Freezes.Frozen)
+	assert.Len(t, b.Freezes.Overridden, 1)
+	assert.Equal(t, "", b.Freezes.Description("myorg", "myrepo", "dinghyfile"))
+}
+
+func TestProcessDinghyfileInvalidFreezes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(`{"application":"biff"}`), nil)
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplicationNotifications("biff", "").Return(nil, errors.New("not found")).AnyTimes()
+
+	b := testPipelineBuilder()
+	b.Parser = renderer
+	b.Client = client
+	b.Freezes = &Freezes{Error: errors.New("invalid freeze window w: a name is required")}
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.EqualError(t, err, "invalid freeze window w: a name is required")
+}
+
+func TestFreezesDescriptionByDinghyfile(t *testing.T) {
+	f := &Freezes{Frozen: []FrozenDinghyfile{
+		{Org: "org", Repo: "repo", Path: "prod/dinghyfile", Freeze: freeze.Freeze{Window: freeze.Window{Name: "prod"}}},
+		{Org: "org", Repo: "repo", Path: "billing/dinghyfile", Freeze: freeze.Freeze{Window: freeze.Window{Name: "billing"}}},
+	}}
+	assert.Contains(t, f.Description("org", "repo", "prod/dinghyfile"), "change freeze prod")
+	assert.Contains(t, f.Description("org", "repo", "billing/dinghyfile"), "change freeze billing")
+	assert.Equal(t, "", f.Description("org", "repo", "dev/dinghyfile"))
+	assert.Equal(t, "", f.Description("org", "other", "prod/dinghyfile"))
+}
@@ -0,0 +1,92 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DependencyGraph is a snapshot of the dependencies between dinghyfiles and modules
+type DependencyGraph struct {
+	Nodes []DependencyNode `json:"nodes"`
+	Edges []DependencyEdge `json:"edges"`
+}
+
+// DependencyNode is a dinghyfile or a module of the graph
+type DependencyNode struct {
+	URL        string `json:"url"`
+	Dinghyfile bool   `json:"dinghyfile"`
+}
+
+// DependencyEdge links a parent with one of the modules it renders
+type DependencyEdge struct {
+	Parent string `json:"parent"`
+	Child  string `json:"child"`
+}
+
+// BuildDependencyGraph walks the dependencies of every dinghyfile known by dm
+func BuildDependencyGraph(dm DependencyManager) DependencyGraph {
+	graph := DependencyGraph{Nodes: []DependencyNode{}, Edges: []DependencyEdge{}}
+
+	dinghyfiles := dm.GetAllDinghyfiles()
+	sort.Strings(dinghyfiles)
+
+	visited := map[string]bool{}
+	for _, url := range dinghyfiles {
+		visited[url] = true
+		graph.Nodes = append(graph.Nodes, DependencyNode{URL: url, Dinghyfile: true})
+	}
+
+	for q := dinghyfiles; len(q) > 0; {
+		curr := q[0]
+		q = q[1:]
+
+		children := dm.GetChildren(curr)
+		sort.Strings(children)
+		for _, child := range children {
+			graph.Edges = append(graph.Edges, DependencyEdge{Parent: curr, Child: child})
+			if !visited[child] {
+				visited[child] = true
+				graph.Nodes = append(graph.Nodes, DependencyNode{URL: child})
+				q = append(q, child)
+			}
+		}
+	}
+
+	return graph
+}
+
+// DOT renders the graph in the Graphviz format, dinghyfiles are drawn as boxes
+func (g DependencyGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph dinghy {\n")
+	for _, n := range g.Nodes {
+		shape := "ellipse"
+		if n.Dinghyfile {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "  %s [shape=%s];\n", dotID(n.URL), shape)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", dotID(e.Parent), dotID(e.Child))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
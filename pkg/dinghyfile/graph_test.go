@@ -0,0 +1,56 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"testing"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDependencyGraph(t *testing.T) {
+	c := cache.NewMemoryCache()
+	c.SetDeps("df1", []string{"mod1", "mod2"})
+	c.SetDeps("df2", []string{"mod2"})
+	c.SetDeps("mod2", []string{"mod3"})
+
+	graph := BuildDependencyGraph(c)
+	assert.Equal(t, []DependencyNode{
+		{URL: "df1", Dinghyfile: true},
+		{URL: "df2", Dinghyfile: true},
+		{URL: "mod1"},
+		{URL: "mod2"},
+		{URL: "mod3"},
+	}, graph.Nodes)
+	assert.Equal(t, []DependencyEdge{
+		{Parent: "df1", Child: "mod1"},
+		{Parent: "df1", Child: "mod2"},
+		{Parent: "df2", Child: "mod2"},
+		{Parent: "mod2", Child: "mod3"},
+	}, graph.Edges)
+}
+
+func TestDependencyGraphDOT(t *testing.T) {
+	graph := DependencyGraph{
+		Nodes: []DependencyNode{{URL: "df1", Dinghyfile: true}, {URL: `mod"1`}},
+		Edges: []DependencyEdge{{Parent: "df1", Child: `mod"1`}},
+	}
+	expected := "digraph dinghy {\n" +
+		"  \"df1\" [shape=box];\n" +
+		"  \"mod\\\"1\" [shape=ellipse];\n" +
+		"  \"df1\" -> \"mod\\\"1\";\n" +
+		"}\n"
+	assert.Equal(t, expected, graph.DOT())
+}
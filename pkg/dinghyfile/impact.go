@@ -0,0 +1,284 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
+	"github.com/armory/plank/v4"
+)
+
+// Changes a module makes to a pipeline
+const (
+	PipelineCreated   = "created"
+	PipelineUpdated   = "updated"
+	PipelineUnchanged = "unchanged"
+	PipelineDeleted   = "deleted"
+)
+
+// diffContext is the number of unchanged lines shown around every change
+const diffContext = 3
+
+// ModuleImpact is the effect of a module change on the dinghyfiles rendering it
+type ModuleImpact struct {
+	Module      string             `json:"module"`
+	Path        string             `json:"path"`
+	Dinghyfiles []DinghyfileImpact `json:"dinghyfiles"`
+}
+
+// DinghyfileImpact is the outcome of rendering a dinghyfile with the changed module
+type DinghyfileImpact struct {
+	Org         string           `json:"org"`
+	Repo        string           `json:"repo"`
+	Path        string           `json:"path"`
+	Branch      string           `json:"branch"`
+	Application string           `json:"application,omitempty"`
+	Rendered    bool             `json:"rendered"`
+	Validated   bool             `json:"validated"`
+	Error       string           `json:"error,omitempty"`
+	Pipelines   []PipelineImpact `json:"pipelines"`
+}
+
+// PipelineImpact compares a rendered pipeline with the one in Spinnaker
+type PipelineImpact struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+// Failed reports if any dinghyfile didn't render or validate
+func (m ModuleImpact) Failed() bool {
+	for _, d := range m.Dinghyfiles {
+		if !d.Rendered || !d.Validated {
+			return true
+		}
+	}
+	return false
+}
+
+// ModuleImpact renders every dinghyfile depending on the module at path, taking
+// the modules from branch, and compares the resulting pipelines with the ones
+// in Spinnaker. Nothing is updated.
+func (b *PipelineBuilder) ModuleImpact(org, repo, path, branch string) ModuleImpact {
+	ib := *b
+	ib.Action = pipebuilder.Validate
+	ib.RebuildingModules = true
+	ib.ModuleBranch = branch
+	if ib.Parser == nil {
+		ib.Parser = ib.DetermineParser(path)
+	} else {
+		defer b.Parser.SetBuilder(b)
+	}
+	ib.Parser.SetBuilder(&ib)
+
+	impact := ModuleImpact{
+		Module:      b.Downloader.EncodeURL(org, repo, path, branch),
+		Path:        path,
+		Dinghyfiles: []DinghyfileImpact{},
+	}
+	// The dependency graph records the modules of the template repo in master
+	roots := b.Depman.GetRoots(b.Downloader.EncodeURL(org, repo, path, "master"))
+	sort.Strings(roots)
+	for _, url := range roots {
+		org, repo, path, branch := b.Downloader.DecodeURL(url)
+		if ib.IsDinghyfile(path) {
+			impact.Dinghyfiles = append(impact.Dinghyfiles, ib.dinghyfileImpact(org, repo, path, branch))
+		}
+	}
+	return impact
+}
+
+func (b *PipelineBuilder) dinghyfileImpact(org, repo, path, branch string) DinghyfileImpact {
+	impact := DinghyfileImpact{Org: org, Repo: repo, Path: path, Branch: branch, Pipelines: []PipelineImpact{}}
+
+	buf, err := b.Parser.Parse(org, repo, path, branch, nil)
+	if err != nil {
+		impact.Error = err.Error()
+		return impact
+	}
+	impact.Rendered = true
+
+	d, err := b.UpdateDinghyfile(buf.Bytes())
+	if err != nil {
+		impact.Error = err.Error()
+		return impact
+	}
+	impact.Application = d.ApplicationSpec.Name
+	if err := b.ValidatePipelines(d, buf.Bytes()); err != nil {
+		impact.Error = err.Error()
+		return impact
+	}
+	if err := b.ValidateAppNotifications(d, buf.Bytes()); err != nil {
+		impact.Error = err.Error()
+		return impact
+	}
+	impact.Validated = true
+
+	existing, err := b.Client.GetPipelines(d.ApplicationSpec.Name, "")
+	if err != nil {
+		// A missing application has no pipelines yet
+		if failed, ok := err.(*plank.FailedResponse); !ok || failed.StatusCode != 404 {
+			impact.Error = fmt.Sprintf("unable to get pipelines of %s: %s", d.ApplicationSpec.Name, err.Error())
+			return impact
+		}
+		existing = nil
+	}
+	owner := b.pipelineOwner(org, repo, path, branch)
+	if owner != nil && !d.TakeoverPipelines {
+		current := map[string]plank.Pipeline{}
+		for _, p := range existing {
+			current[p.Name] = p
+		}
+		if conflicts := ownershipConflicts(*owner, d.Pipelines, current); len(conflicts) > 0 {
+			impact.Error = (&OwnershipConflictError{Application: d.ApplicationSpec.Name, Pipelines: conflicts}).Error()
+			return impact
+		}
+	}
+	impact.Pipelines = b.pipelineImpacts(d, existing, owner)
+	return impact
+}
+
+// pipelineImpacts compares the pipelines of a dinghyfile with the existing ones
+// the way updatePipelines would apply them.
+func (b *PipelineBuilder) pipelineImpacts(d Dinghyfile, existing []plank.Pipeline, owner *PipelineOwner) []PipelineImpact {
+	current := map[string]plank.Pipeline{}
+	for _, p := range existing {
+		current[p.Name] = p
+	}
+
+	impacts := []PipelineImpact{}
+	rendered := map[string]bool{}
+	for _, p := range d.Pipelines {
+		rendered[p.Name] = true
+		if b.AutolockPipelines == "true" {
+			p.Lock()
+		}
+		old, exists := current[p.Name]
+		if !exists {
+			impacts = append(impacts, PipelineImpact{Name: p.Name, Change: PipelineCreated, Diff: diffLines(nil, pipelineLines(p))})
+			continue
+		}
+		p.ID = old.ID
+		diff := diffLines(pipelineLines(withoutOwner(old)), pipelineLines(p))
+		if diff == "" {
+			impacts = append(impacts, PipelineImpact{Name: p.Name, Change: PipelineUnchanged})
+		} else {
+			impacts = append(impacts, PipelineImpact{Name: p.Name, Change: PipelineUpdated, Diff: diff})
+		}
+	}
+
+	if d.DeleteStalePipelines {
+		for _, p := range existing {
+			if !ownedBy(p, owner) {
+				continue
+			}
+			if !rendered[p.Name] {
+				impacts = append(impacts, PipelineImpact{Name: p.Name, Change: PipelineDeleted})
+			}
+		}
+	}
+	return impacts
+}
+
+// pipelineLines renders a pipeline as indented JSON without the fields
+// Spinnaker sets on save.
+func pipelineLines(p plank.Pipeline) []string {
+	p.LastModifiedBy = ""
+	p.UpdateTs = ""
+	out, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(string(out), "\n")
+}
+
+// diffLines returns a line diff of a and b, an empty string if they are equal.
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	lines := []line{}
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, line{'+', b[j]})
+			changed = true
+			j++
+		default:
+			lines = append(lines, line{'-', a[i]})
+			changed = true
+			i++
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	// only keep the unchanged lines close to a change
+	keep := make([]bool, len(lines))
+	for n, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		for k := n - diffContext; k <= n+diffContext; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	var sb strings.Builder
+	skipped := false
+	for n, l := range lines {
+		if !keep[n] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			sb.WriteString("@@\n")
+			skipped = false
+		}
+		sb.WriteByte(l.op)
+		sb.WriteString(l.text)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
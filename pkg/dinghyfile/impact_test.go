@@ -0,0 +1,102 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleImpact(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b := testPipelineBuilder()
+	b.DinghyfileName = "dinghyfile"
+	roots := []string{
+		b.Downloader.EncodeURL("org", "repo", "dinghyfile", "master"),
+		b.Downloader.EncodeURL("org", "broken", "dinghyfile", "master"),
+	}
+
+	depman := NewMockDependencyManager(ctrl)
+	depman.EXPECT().GetRoots(gomock.Eq(b.Downloader.EncodeURL("org", "templates", "mod1", "master"))).Return(roots).Times(1)
+	b.Depman = depman
+
+	dinghyfile := `{
+		"application": "testone",
+		"deleteStalePipelines": true,
+		"pipelines": [
+			{"application": "testone", "name": "unchanged"},
+			{"application": "testone", "name": "new"}
+		]
+	}`
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().SetBuilder(gomock.Any()).Do(func(builder *PipelineBuilder) {
+		if builder != b {
+			assert.Equal(t, "feature", builder.ModuleBranch)
+		}
+	}).Times(2)
+	renderer.EXPECT().Parse(gomock.Eq("org"), gomock.Eq("repo"), gomock.Eq("dinghyfile"), gomock.Eq("master"), gomock.Nil()).Return(bytes.NewBufferString(dinghyfile), nil).Times(1)
+	renderer.EXPECT().Parse(gomock.Eq("org"), gomock.Eq("broken"), gomock.Eq("dinghyfile"), gomock.Eq("master"), gomock.Nil()).Return(nil, errors.New("rendering failed")).Times(1)
+	b.Parser = renderer
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetPipelines(gomock.Eq("testone"), "").Return([]plank.Pipeline{
+		{ID: "1", Name: "unchanged", Application: "testone", UpdateTs: "1600000000000"},
+		{ID: "2", Name: "stale", Application: "testone"},
+	}, nil).Times(1)
+	b.Client = client
+
+	impact := b.ModuleImpact("org", "templates", "mod1", "feature")
+	assert.Equal(t, "mod1", impact.Path)
+	assert.True(t, impact.Failed())
+	assert.Len(t, impact.Dinghyfiles, 2)
+
+	// roots are sorted by url
+	assert.False(t, impact.Dinghyfiles[0].Rendered)
+	assert.Equal(t, "rendering failed", impact.Dinghyfiles[0].Error)
+
+	d := impact.Dinghyfiles[1]
+	assert.Equal(t, "testone", d.Application)
+	assert.True(t, d.Rendered)
+	assert.True(t, d.Validated)
+	assert.Equal(t, []string{"unchanged", "new", "stale"}, []string{d.Pipelines[0].Name, d.Pipelines[1].Name, d.Pipelines[2].Name})
+	assert.Equal(t, []string{PipelineUnchanged, PipelineCreated, PipelineDeleted}, []string{d.Pipelines[0].Change, d.Pipelines[1].Change, d.Pipelines[2].Change})
+	assert.Empty(t, d.Pipelines[0].Diff)
+	assert.Contains(t, d.Pipelines[1].Diff, `+  "name": "new",`)
+
+	// The builder is left as it was
+	assert.Equal(t, "", b.ModuleBranch)
+	assert.Equal(t, renderer, b.Parser)
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"{", `"a": 1,`, `"b": 2,`, `"c": 3,`, `"d": 4,`, `"e": 5,`, `"f": 6,`, `"g": 7`, "}"}
+	b := []string{"{", `"a": 1,`, `"b": 2,`, `"c": 3,`, `"d": 4,`, `"e": 5,`, `"f": 6,`, `"g": 8`, "}"}
+
+	assert.Equal(t, "", diffLines(a, a))
+	assert.Equal(t, "@@\n"+
+		` "d": 4,`+"\n"+
+		` "e": 5,`+"\n"+
+		` "f": 6,`+"\n"+
+		`-"g": 7`+"\n"+
+		`+"g": 8`+"\n"+
+		" }\n", diffLines(a, b))
+	assert.Equal(t, "+{\n+}\n", diffLines(nil, []string{"{", "}"}))
+}
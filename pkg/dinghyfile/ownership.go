@@ -0,0 +1,165 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/armory/plank/v4"
+)
+
+// OwnerConfigKey is the key of the owner of a pipeline in its config, plank
+// only keeps the fields of plank.Pipeline and config is the only one with a
+// free form.
+const OwnerConfigKey = "dinghy"
+
+// PipelineOwner is the dinghyfile that manages a pipeline.
+type PipelineOwner struct {
+	Provider string `json:"provider"`
+	Org      string `json:"org"`
+	Repo     string `json:"repo"`
+	Path     string `json:"path"`
+	Branch   string `json:"branch"`
+	// Commit is only known when the pipeline was updated by a push to its repository
+	Commit string `json:"commit,omitempty"`
+}
+
+// PushCommit is the last commit of the push being processed.
+type PushCommit struct {
+	Org    string
+	Repo   string
+	Branch string
+	SHA    string
+}
+
+// Same checks if both owners are the same dinghyfile, whatever the commit.
+func (o PipelineOwner) Same(other PipelineOwner) bool {
+	o.Commit, other.Commit = "", ""
+	return o == other
+}
+
+func (o PipelineOwner) String() string {
+	return fmt.Sprintf("%s:%s/%s/%s@%s", o.Provider, o.Org, o.Repo, o.Path, o.Branch)
+}
+
+// pipelineOwner returns the owner of the pipelines of a dinghyfile, nil when
+// pipeline ownership is disabled.
+func (b *PipelineBuilder) pipelineOwner(org, repo, path, branch string) *PipelineOwner {
+	if !b.PipelineOwnership {
+		return nil
+	}
+	return &PipelineOwner{Provider: b.Provider, Org: org, Repo: repo, Path: path, Branch: branch, Commit: b.commitOf(org, repo, branch)}
+}
+
+// commitOf returns the pushed commit of a branch, empty when the branch wasn't pushed.
+func (b *PipelineBuilder) commitOf(org, repo, branch string) string {
+	if c := b.PushCommit; c.Org == org && c.Repo == repo && c.Branch == branch {
+		return c.SHA
+	}
+	return ""
+}
+
+// OwnerOf returns the dinghyfile that manages a pipeline, if any.
+func OwnerOf(p plank.Pipeline) (PipelineOwner, bool) {
+	var owner PipelineOwner
+	config, ok := p.Config.(map[string]interface{})
+	if !ok || config[OwnerConfigKey] == nil {
+		return owner, false
+	}
+	raw, err := json.Marshal(config[OwnerConfigKey])
+	if err != nil || json.Unmarshal(raw, &owner) != nil {
+		return owner, false
+	}
+	return owner, true
+}
+
+// ownedBy checks if owner manages a pipeline, every pipeline is owned when
+// pipeline ownership is disabled.
+func ownedBy(p plank.Pipeline, owner *PipelineOwner) bool {
+	if owner == nil {
+		return true
+	}
+	current, owned := OwnerOf(p)
+	return owned && current.Same(*owner)
+}
+
+// setOwner stamps a pipeline with its owner, keeping the rest of its config.
+// It returns false when the config isn't an object and can't be stamped.
+func setOwner(p *plank.Pipeline, owner PipelineOwner) bool {
+	config := map[string]interface{}{}
+	switch c := p.Config.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range c {
+			config[k] = v
+		}
+	default:
+		return false
+	}
+	config[OwnerConfigKey] = owner
+	p.Config = config
+	return true
+}
+
+// withoutOwner returns a copy of a pipeline without its owner.
+func withoutOwner(p plank.Pipeline) plank.Pipeline {
+	config, ok := p.Config.(map[string]interface{})
+	if !ok || config[OwnerConfigKey] == nil {
+		return p
+	}
+	rest := map[string]interface{}{}
+	for k, v := range config {
+		if k != OwnerConfigKey {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		p.Config = nil
+	} else {
+		p.Config = rest
+	}
+	return p
+}
+
+// ownershipConflicts returns the pipelines of a dinghyfile that another
+// dinghyfile owns, by name.
+func ownershipConflicts(owner PipelineOwner, pipelines []plank.Pipeline, existing map[string]plank.Pipeline) []string {
+	conflicts := []string{}
+	for _, p := range pipelines {
+		current, exists := existing[p.Name]
+		if !exists {
+			continue
+		}
+		if other, owned := OwnerOf(current); owned && !other.Same(owner) {
+			conflicts = append(conflicts, fmt.Sprintf("%s (owned by %s)", p.Name, other))
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// OwnershipConflictError is returned when a dinghyfile updates the pipelines
+// of another dinghyfile without taking them over.
+type OwnershipConflictError struct {
+	Application string
+	Pipelines   []string
+}
+
+func (e *OwnershipConflictError) Error() string {
+	return fmt.Sprintf("pipelines of %s managed by other dinghyfiles, set takeoverPipelines to manage them from this one: %s",
+		e.Application, strings.Join(e.Pipelines, ", "))
+}
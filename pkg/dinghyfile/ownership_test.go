@@ -0,0 +1,153 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testOwner = PipelineOwner{Provider: "github", Org: "org", Repo: "repo", Path: "dinghyfile", Branch: "master", Commit: "abc"}
+
+// fromFront50 returns a pipeline the way plank decodes it from Front50.
+func fromFront50(t *testing.T, p plank.Pipeline) plank.Pipeline {
+	raw, err := json.Marshal(p)
+	assert.Nil(t, err)
+	var decoded plank.Pipeline
+	assert.Nil(t, json.Unmarshal(raw, &decoded))
+	return decoded
+}
+
+func ownedPipeline(t *testing.T, p plank.Pipeline, owner PipelineOwner) plank.Pipeline {
+	setOwner(&p, owner)
+	return fromFront50(t, p)
+}
+
+func TestPipelineOwner(t *testing.T) {
+	p := plank.Pipeline{Name: "deploy", Config: map[string]interface{}{"schema": "1"}}
+	_, owned := OwnerOf(p)
+	assert.False(t, owned)
+
+	p = ownedPipeline(t, p, testOwner)
+	owner, owned := OwnerOf(p)
+	assert.True(t, owned)
+	assert.Equal(t, testOwner, owner)
+	assert.Equal(t, map[string]interface{}{"schema": "1"}, withoutOwner(p).Config)
+	assert.Nil(t, withoutOwner(ownedPipeline(t, plank.Pipeline{Name: "deploy"}, testOwner)).Config)
+
+	assert.False(t, setOwner(&plank.Pipeline{Config: "template"}, testOwner))
+
+	other := testOwner
+	other.Commit = "def"
+	assert.True(t, testOwner.Same(other))
+	other.Branch = "develop"
+	assert.False(t, testOwner.Same(other))
+}
+
+func TestPipelineOwnerCommit(t *testing.T) {
+	b := testPipelineBuilder()
+	assert.Nil(t, b.pipelineOwner("org", "repo", "dinghyfile", "master"))
+
+	b.PipelineOwnership = true
+	b.Provider = "github"
+	b.PushCommit = PushCommit{Org: "org", Repo: "repo", Branch: "master", SHA: "abc"}
+	assert.Equal(t, &testOwner, b.pipelineOwner("org", "repo", "dinghyfile", "master"))
+	assert.Equal(t, "", b.pipelineOwner("org", "other", "dinghyfile", "master").Commit)
+}
+
+func TestUpdatePipelinesOwnershipConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	other := testOwner
+	other.Repo = "other"
+	existing := []plank.Pipeline{ownedPipeline(t, plank.Pipeline{Name: "deploy", ID: "deployID", Application: "testapp"}, other)}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, nil).Times(1)
+	client.EXPECT().GetPipelines("testapp", "").Return(existing, nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+
+	d := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{{Name: "deploy", Application: "testapp"}},
+	}
+	_, err := b.updatePipelines(d, "pusher", &testOwner)
+	var conflict *OwnershipConflictError
+	assert.True(t, errors.As(err, &conflict))
+	assert.Equal(t, []string{"deploy (owned by github:org/other/dinghyfile@master)"}, conflict.Pipelines)
+}
+
+func TestUpdatePipelinesTakeover(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	other := testOwner
+	other.Repo = "other"
+	existing := []plank.Pipeline{ownedPipeline(t, plank.Pipeline{Name: "deploy", ID: "deployID", Application: "testapp"}, other)}
+	expected := plank.Pipeline{Name: "deploy", ID: "deployID", Application: "testapp"}
+	setOwner(&expected, testOwner)
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, nil).Times(1)
+	client.EXPECT().GetPipelines("testapp", "").Return(existing, nil).Times(1)
+	client.EXPECT().UpsertPipeline(expected, "deployID", "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+
+	d := Dinghyfile{
+		ApplicationSpec:   plank.Application{Name: "testapp"},
+		Pipelines:         []plank.Pipeline{{Name: "deploy", Application: "testapp"}},
+		TakeoverPipelines: true,
+	}
+	_, err := b.updatePipelines(d, "pusher", &testOwner)
+	assert.Nil(t, err)
+}
+
+func TestUpdatePipelinesDeleteStaleOwned(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	other := testOwner
+	other.Path = "other/dinghyfile"
+	owned := ownedPipeline(t, plank.Pipeline{Name: "owned", ID: "ownedID", Application: "testapp"}, testOwner)
+	others := ownedPipeline(t, plank.Pipeline{Name: "others", ID: "othersID", Application: "testapp"}, other)
+	manual := plank.Pipeline{Name: "manual", ID: "manualID", Application: "testapp"}
+	existing := []plank.Pipeline{owned, others, manual}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, nil).Times(1)
+	client.EXPECT().GetPipelines("testapp", "").Return(existing, nil).Times(2)
+	client.EXPECT().UpsertPipeline(gomock.Any(), "", "").Return(nil).Times(1)
+	client.EXPECT().DeletePipeline(owned, "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+
+	d := Dinghyfile{
+		ApplicationSpec:      plank.Application{Name: "testapp"},
+		Pipelines:            []plank.Pipeline{{Name: "deploy", Application: "testapp"}},
+		DeleteStalePipelines: true,
+	}
+	_, err := b.updatePipelines(d, "pusher", &testOwner)
+	assert.Nil(t, err)
+}
@@ -27,7 +27,6 @@ import (
 	"fmt"
 	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
 	"github.com/armory/dinghy/pkg/git"
-	"path/filepath"
 	"time"
 
 	"text/template"
@@ -119,6 +118,10 @@ func moduleFunction(org string, mod string, r *DinghyfileParser, repo string, br
 		r.Builder.Logger.Errorf("error rendering imported module '%s': %s", mod, err.Error())
 		return "", fmt.Errorf("error rendering imported module '%s': %s", mod, err.Error())
 	}
+	if err := r.Builder.ValidateModuleSchema(mod, result.Bytes()); err != nil {
+		r.Builder.Logger.Errorf("module '%s' failed the schema validation: %s", mod, err.Error())
+		return "", err
+	}
 	return result.String(), nil
 }
 
@@ -253,7 +256,7 @@ func (r *DinghyfileParser) Parse(org, repo, path, branch string, vars []VarMap)
 	}
 
 	// Extract global vars if we're processing a dinghyfile (and not a module)
-	isDinghyfile := filepath.Base(path) == r.Builder.DinghyfileName
+	isDinghyfile := r.Builder.IsDinghyfile(path)
 	if isDinghyfile {
 		module = false
 		gvs, err := preprocessor.ParseGlobalVars(contents, gitInfo)
@@ -284,6 +287,9 @@ func (r *DinghyfileParser) Parse(org, repo, path, branch string, vars []VarMap)
 	if r.Builder.Action == pipebuilder.Validate && r.Builder.TemplateRepo != repo {
 		moduleBranch = "master"
 	}
+	if r.Builder.ModuleBranch != "" {
+		moduleBranch = r.Builder.ModuleBranch
+	}
 	// NOTE:  I don't think moduleFunc needs to take branch argument;
 	// moduleFunc should be able to figure out the branch needed from the
 	// configuration (since it has to have access to TemplateOrg and TemplateRepo
@@ -323,8 +329,11 @@ func (r *DinghyfileParser) Parse(org, repo, path, branch string, vars []VarMap)
 	for dep := range deps {
 		depUrls = append(depUrls, dep)
 	}
-	r.Builder.Depman.SetDeps(r.Builder.Downloader.EncodeURL(org, repo, path, branch), depUrls)
-	if filepath.Base(path) == r.Builder.DinghyfileName && !r.Builder.RebuildingModules {
+	if err := r.Builder.Depman.SetDeps(r.Builder.Downloader.EncodeURL(org, repo, path, branch), depUrls); err != nil {
+		r.Builder.Logger.Errorf("Failed to record the dependencies of %s: %s", path, err.Error())
+		return nil, err
+	}
+	if isDinghyfile && !r.Builder.RebuildingModules {
 		result, errRaw := json.Marshal(r.Builder.PushRaw)
 		if errRaw != nil {
 			r.Builder.Logger.Errorf("Failed to parse rawdata:\n %s", r.Builder.PushRaw)
@@ -7,6 +7,7 @@ package dinghyfile
 import (
 	reflect "reflect"
 
+	util "github.com/armory/dinghy/pkg/util"
 	plank "github.com/armory/plank/v4"
 	gomock "github.com/golang/mock/gomock"
 )
@@ -62,6 +63,34 @@ func (mr *MockPlankClientMockRecorder) CreateApplication(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateApplication", reflect.TypeOf((*MockPlankClient)(nil).CreateApplication), arg0, arg1)
 }
 
+// DeleteApplication mocks base method.
+func (m *MockPlankClient) DeleteApplication(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteApplication", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteApplication indicates an expected call of DeleteApplication.
+func (mr *MockPlankClientMockRecorder) DeleteApplication(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteApplication", reflect.TypeOf((*MockPlankClient)(nil).DeleteApplication), arg0, arg1)
+}
+
+// DeletePipelineTemplate mocks base method.
+func (m *MockPlankClient) DeletePipelineTemplate(arg0, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePipelineTemplate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePipelineTemplate indicates an expected call of DeletePipelineTemplate.
+func (mr *MockPlankClientMockRecorder) DeletePipelineTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePipelineTemplate", reflect.TypeOf((*MockPlankClient)(nil).DeletePipelineTemplate), arg0, arg1, arg2)
+}
+
 // DeletePipeline mocks base method.
 func (m *MockPlankClient) DeletePipeline(arg0 plank.Pipeline, arg1 string) error {
 	m.ctrl.T.Helper()
@@ -88,6 +117,21 @@ func (mr *MockPlankClientMockRecorder) EnableArmoryEndpoints() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableArmoryEndpoints", reflect.TypeOf((*MockPlankClient)(nil).EnableArmoryEndpoints))
 }
 
+// GetAccounts mocks base method.
+func (m *MockPlankClient) GetAccounts(arg0 string) ([]util.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccounts", arg0)
+	ret0, _ := ret[0].([]util.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccounts indicates an expected call of GetAccounts.
+func (mr *MockPlankClientMockRecorder) GetAccounts(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccounts", reflect.TypeOf((*MockPlankClient)(nil).GetAccounts), arg0)
+}
+
 // GetApplication mocks base method.
 func (m *MockPlankClient) GetApplication(arg0, arg1 string) (*plank.Application, error) {
 	m.ctrl.T.Helper()
@@ -118,6 +162,36 @@ func (mr *MockPlankClientMockRecorder) GetApplicationNotifications(arg0, arg1 in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetApplicationNotifications", reflect.TypeOf((*MockPlankClient)(nil).GetApplicationNotifications), arg0, arg1)
 }
 
+// GetApplications mocks base method.
+func (m *MockPlankClient) GetApplications(arg0 string) (*[]plank.Application, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetApplications", arg0)
+	ret0, _ := ret[0].(*[]plank.Application)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetApplications indicates an expected call of GetApplications.
+func (mr *MockPlankClientMockRecorder) GetApplications(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetApplications", reflect.TypeOf((*MockPlankClient)(nil).GetApplications), arg0)
+}
+
+// GetPipelineTemplate mocks base method.
+func (m *MockPlankClient) GetPipelineTemplate(arg0 string, arg1 string, arg2 string) (*util.PipelineTemplate, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPipelineTemplate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*util.PipelineTemplate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPipelineTemplate indicates an expected call of GetPipelineTemplate.
+func (mr *MockPlankClientMockRecorder) GetPipelineTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPipelineTemplate", reflect.TypeOf((*MockPlankClient)(nil).GetPipelineTemplate), arg0, arg1, arg2)
+}
+
 // GetPipelines mocks base method.
 func (m *MockPlankClient) GetPipelines(arg0, arg1 string) ([]plank.Pipeline, error) {
 	m.ctrl.T.Helper()
@@ -133,6 +207,51 @@ func (mr *MockPlankClientMockRecorder) GetPipelines(arg0, arg1 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPipelines", reflect.TypeOf((*MockPlankClient)(nil).GetPipelines), arg0, arg1)
 }
 
+// GetStageTypes mocks base method.
+func (m *MockPlankClient) GetStageTypes(arg0 string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetStageTypes", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetStageTypes indicates an expected call of GetStageTypes.
+func (mr *MockPlankClientMockRecorder) GetStageTypes(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStageTypes", reflect.TypeOf((*MockPlankClient)(nil).GetStageTypes), arg0)
+}
+
+// GetTemplatedPipelines mocks base method.
+func (m *MockPlankClient) GetTemplatedPipelines(arg0 string, arg1 string) ([]util.TemplatedPipeline, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTemplatedPipelines", arg0, arg1)
+	ret0, _ := ret[0].([]util.TemplatedPipeline)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTemplatedPipelines indicates an expected call of GetTemplatedPipelines.
+func (mr *MockPlankClientMockRecorder) GetTemplatedPipelines(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTemplatedPipelines", reflect.TypeOf((*MockPlankClient)(nil).GetTemplatedPipelines), arg0, arg1)
+}
+
+// GetUserPermissions mocks base method.
+func (m *MockPlankClient) GetUserPermissions(arg0, arg1 string) (*util.UserPermissions, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserPermissions", arg0, arg1)
+	ret0, _ := ret[0].(*util.UserPermissions)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserPermissions indicates an expected call of GetUserPermissions.
+func (mr *MockPlankClientMockRecorder) GetUserPermissions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserPermissions", reflect.TypeOf((*MockPlankClient)(nil).GetUserPermissions), arg0, arg1)
+}
+
 // ResyncFiat mocks base method.
 func (m *MockPlankClient) ResyncFiat(arg0 string) error {
 	m.ctrl.T.Helper()
@@ -203,6 +322,34 @@ func (mr *MockPlankClientMockRecorder) UpsertPipelineUsingOrca(arg0, arg1, arg2
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertPipelineUsingOrca", reflect.TypeOf((*MockPlankClient)(nil).UpsertPipeline), arg0, arg1, arg2)
 }
 
+// UpsertPipelineTemplate mocks base method.
+func (m *MockPlankClient) UpsertPipelineTemplate(arg0 util.PipelineTemplate, arg1 string, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertPipelineTemplate", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertPipelineTemplate indicates an expected call of UpsertPipelineTemplate.
+func (mr *MockPlankClientMockRecorder) UpsertPipelineTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertPipelineTemplate", reflect.TypeOf((*MockPlankClient)(nil).UpsertPipelineTemplate), arg0, arg1, arg2)
+}
+
+// UpsertTemplatedPipeline mocks base method.
+func (m *MockPlankClient) UpsertTemplatedPipeline(arg0 util.TemplatedPipeline, arg1 string, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpsertTemplatedPipeline", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpsertTemplatedPipeline indicates an expected call of UpsertTemplatedPipeline.
+func (mr *MockPlankClientMockRecorder) UpsertTemplatedPipeline(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertTemplatedPipeline", reflect.TypeOf((*MockPlankClient)(nil).UpsertTemplatedPipeline), arg0, arg1, arg2)
+}
+
 // UseGateEndpoints mocks base method.
 func (m *MockPlankClient) UseGateEndpoints() {
 	m.ctrl.T.Helper()
@@ -0,0 +1,288 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+)
+
+// ReferenceCatalog caches what the pipelines reference in Spinnaker: the
+// accounts, stage types, applications and pipelines of every application.
+type ReferenceCatalog struct {
+	// TTL of the cached lists
+	TTL time.Duration
+	// ExtraStageTypes are accepted besides the ones of Orca, e.g. the stages of plugins
+	ExtraStageTypes []string
+
+	mutex   sync.Mutex
+	entries map[string]catalogEntry
+	now     func() time.Time
+}
+
+type catalogEntry struct {
+	values  map[string]bool
+	fetched time.Time
+}
+
+// NewReferenceCatalog returns an empty catalog keeping the lists for ttl.
+func NewReferenceCatalog(ttl time.Duration, extraStageTypes []string) *ReferenceCatalog {
+	return &ReferenceCatalog{TTL: ttl, ExtraStageTypes: extraStageTypes, entries: map[string]catalogEntry{}, now: time.Now}
+}
+
+// lookup returns a cached list, fetched again when it expired or when refresh is set.
+func (c *ReferenceCatalog) lookup(key string, refresh bool, fetch func() ([]string, error)) (map[string]bool, error) {
+	c.mutex.Lock()
+	entry, found := c.entries[key]
+	c.mutex.Unlock()
+	if found && !refresh && c.now().Sub(entry.fetched) < c.TTL {
+		return entry.values, nil
+	}
+	list, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	entry = catalogEntry{values: map[string]bool{}, fetched: c.now()}
+	for _, v := range list {
+		entry.values[v] = true
+	}
+	c.mutex.Lock()
+	c.entries[key] = entry
+	c.mutex.Unlock()
+	return entry.values, nil
+}
+
+// contains looks a value up, on a miss the list is fetched again since it
+// may have been created after it was cached.
+func (c *ReferenceCatalog) contains(key, value string, fetch func() ([]string, error)) (bool, error) {
+	values, err := c.lookup(key, false, fetch)
+	if err != nil || values[value] {
+		return values[value], err
+	}
+	values, err = c.lookup(key, true, fetch)
+	return values[value], err
+}
+
+// Accounts returns the accounts of Clouddriver.
+func (c *ReferenceCatalog) Accounts(client util.PlankClient) (map[string]bool, error) {
+	return c.lookup("accounts", false, func() ([]string, error) {
+		accounts, err := client.GetAccounts("")
+		if err != nil {
+			return nil, fmt.Errorf("could not list the accounts: %w", err)
+		}
+		names := make([]string, len(accounts))
+		for i, a := range accounts {
+			names[i] = a.Name
+		}
+		return names, nil
+	})
+}
+
+// StageTypes returns the stage types of Orca and the extra ones.
+func (c *ReferenceCatalog) StageTypes(client util.PlankClient) (map[string]bool, error) {
+	return c.lookup("stageTypes", false, func() ([]string, error) {
+		stageTypes, err := client.GetStageTypes("")
+		if err != nil {
+			return nil, fmt.Errorf("could not list the stage types: %w", err)
+		}
+		return append(stageTypes, c.ExtraStageTypes...), nil
+	})
+}
+
+// HasApplication reports whether an application exists.
+func (c *ReferenceCatalog) HasApplication(client util.PlankClient, application string) (bool, error) {
+	return c.contains("applications", strings.ToLower(application), func() ([]string, error) {
+		apps, err := client.GetApplications("")
+		if err != nil {
+			return nil, fmt.Errorf("could not list the applications: %w", err)
+		}
+		names := []string{}
+		if apps != nil {
+			for _, a := range *apps {
+				names = append(names, strings.ToLower(a.Name))
+			}
+		}
+		return names, nil
+	})
+}
+
+// HasPipeline reports whether an application has a pipeline with an ID.
+func (c *ReferenceCatalog) HasPipeline(client util.PlankClient, application, id string) (bool, error) {
+	return c.contains("pipelines:"+application, id, func() ([]string, error) {
+		pipelines, err := client.GetPipelines(application, "")
+		if err != nil {
+			return nil, fmt.Errorf("could not list the pipelines of %s: %w", application, err)
+		}
+		ids := make([]string, len(pipelines))
+		for i, p := range pipelines {
+			ids[i] = p.ID
+		}
+		return ids, nil
+	})
+}
+
+// ReferenceError fails a dinghyfile referencing accounts, stage types,
+// applications or pipelines Spinnaker doesn't have.
+type ReferenceError struct {
+	Problems []string
+}
+
+func (e *ReferenceError) Error() string {
+	return fmt.Sprintf("dinghyfile references what doesn't exist in Spinnaker: %s", strings.Join(e.Problems, "; "))
+}
+
+// ValidateReferences checks the accounts and stage types of the stages, and
+// the pipelines of the pipeline triggers and stages, exist in Spinnaker.
+func (b *PipelineBuilder) ValidateReferences(d Dinghyfile) error {
+	if b.References == nil {
+		return nil
+	}
+	accounts, err := b.References.Accounts(b.Client)
+	if err != nil {
+		return err
+	}
+	stageTypes, err := b.References.StageTypes(b.Client)
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	for _, p := range d.Pipelines {
+		for _, stage := range p.Stages {
+			name := stageName(stage)
+			if stageType, ok := stage["type"].(string); ok && !isExpression(stageType) && !stageTypes[stageType] {
+				problems = append(problems, fmt.Sprintf("pipeline %s: stage %s has unknown type %s", p.Name, name, stageType))
+			}
+			for _, account := range stageAccounts(stage) {
+				if !accounts[account] {
+					problems = append(problems, fmt.Sprintf("pipeline %s: stage %s uses unknown account %s", p.Name, name, account))
+				}
+			}
+			if stage["type"] == "pipeline" {
+				problem, err := b.pipelineReference(d, stage)
+				if err != nil {
+					return err
+				}
+				if problem != "" {
+					problems = append(problems, fmt.Sprintf("pipeline %s: stage %s runs %s", p.Name, name, problem))
+				}
+			}
+		}
+		for _, trigger := range p.Triggers {
+			if trigger["type"] != "pipeline" {
+				continue
+			}
+			problem, err := b.pipelineReference(d, trigger)
+			if err != nil {
+				return err
+			}
+			if problem != "" {
+				problems = append(problems, fmt.Sprintf("pipeline %s: triggered by %s", p.Name, problem))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ReferenceError{Problems: problems}
+	}
+	return nil
+}
+
+// pipelineReference checks the application and pipeline of a pipeline
+// trigger or stage, it returns what's missing.
+func (b *PipelineBuilder) pipelineReference(d Dinghyfile, reference map[string]interface{}) (string, error) {
+	application, _ := reference["application"].(string)
+	id, _ := reference["pipeline"].(string)
+	if application == "" {
+		application = d.ApplicationSpec.Name
+	}
+	if isExpression(application) || isExpression(id) {
+		return "", nil
+	}
+	if !strings.EqualFold(application, d.ApplicationSpec.Name) {
+		exists, err := b.References.HasApplication(b.Client, application)
+		if err != nil || !exists {
+			return fmt.Sprintf("unknown application %s", application), err
+		}
+	}
+	if id == "" {
+		return fmt.Sprintf("no pipeline of application %s", application), nil
+	}
+	if strings.EqualFold(application, d.ApplicationSpec.Name) && pipelineWithID(d.Pipelines, id) {
+		return "", nil
+	}
+	exists, err := b.References.HasPipeline(b.Client, application, id)
+	if err != nil || !exists {
+		return fmt.Sprintf("unknown pipeline %s of application %s", id, application), err
+	}
+	return "", nil
+}
+
+func pipelineWithID(pipelines []plank.Pipeline, id string) bool {
+	for _, p := range pipelines {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// stageAccounts returns the accounts and credentials a stage, or its
+// clusters and manifests, deploy to.
+func stageAccounts(stage map[string]interface{}) []string {
+	found := map[string]bool{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			for key, item := range value {
+				if account, ok := item.(string); ok && (key == "account" || key == "credentials") {
+					if account != "" && !isExpression(account) {
+						found[account] = true
+					}
+					continue
+				}
+				walk(item)
+			}
+		case []interface{}:
+			for _, item := range value {
+				walk(item)
+			}
+		}
+	}
+	walk(stage)
+	accounts := make([]string, 0, len(found))
+	for account := range found {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+func stageName(stage map[string]interface{}) interface{} {
+	if name, ok := stage["name"]; ok {
+		return name
+	}
+	return stage["refId"]
+}
+
+// isExpression reports whether a value is resolved when the pipeline runs.
+func isExpression(s string) bool {
+	return strings.Contains(s, "${")
+}
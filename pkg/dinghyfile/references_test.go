@@ -0,0 +1,121 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func referencesDinghyfile() Dinghyfile {
+	return Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "myapp"},
+		Pipelines: []plank.Pipeline{
+			{
+				ID:   "deploy-id",
+				Name: "deploy",
+				Stages: []map[string]interface{}{
+					{"refId": "1", "name": "manifest", "type": "deployManifest", "account": "k8s"},
+					{"refId": "2", "name": "servergroup", "type": "deploy", "clusters": []interface{}{
+						map[string]interface{}{"account": "aws-prod"},
+						map[string]interface{}{"account": "${ parameters.account }"},
+					}},
+					{"refId": "3", "name": "smoke", "type": "pipeline", "application": "tests", "pipeline": "smoke-id"},
+					{"refId": "4", "name": "custom", "type": "myPluginStage"},
+				},
+				Triggers: []map[string]interface{}{
+					{"type": "pipeline", "pipeline": "build-id"},
+				},
+			},
+			{
+				ID:   "build-id",
+				Name: "build",
+				Stages: []map[string]interface{}{
+					{"refId": "1", "name": "bake", "type": "bakery"},
+				},
+				Triggers: []map[string]interface{}{
+					{"type": "pipeline", "application": "other", "pipeline": "release-id"},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateReferences(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetAccounts("").Return([]util.Account{{Name: "k8s", Type: "kubernetes"}, {Name: "aws-prod", Type: "aws"}}, nil).Times(1)
+	client.EXPECT().GetStageTypes("").Return([]string{"deployManifest", "deploy", "pipeline"}, nil).Times(1)
+	client.EXPECT().GetApplications("").Return(&[]plank.Application{{Name: "tests"}}, nil).Times(3)
+	client.EXPECT().GetPipelines("tests", "").Return([]plank.Pipeline{{ID: "smoke-id"}}, nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	assert.Nil(t, b.ValidateReferences(referencesDinghyfile()))
+
+	b.References = NewReferenceCatalog(time.Minute, []string{"myPluginStage"})
+	err := b.ValidateReferences(referencesDinghyfile())
+	referenceErr, ok := err.(*ReferenceError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"pipeline build: stage bake has unknown type bakery",
+		"pipeline build: triggered by unknown application other",
+	}, referenceErr.Problems)
+
+	// the lists are cached, the applications are looked up again only for the missing one
+	d := referencesDinghyfile()
+	d.Pipelines[0].Stages[0]["account"] = "k8s-typo"
+	d.Pipelines[1].Stages[0]["type"] = "myPluginStage"
+	err = b.ValidateReferences(d)
+	assert.EqualError(t, err, "dinghyfile references what doesn't exist in Spinnaker: "+
+		"pipeline deploy: stage manifest uses unknown account k8s-typo; pipeline build: triggered by unknown application other")
+}
+
+func TestReferenceCatalogRefreshesOnMiss(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := NewMockPlankClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().GetPipelines("tests", "").Return([]plank.Pipeline{{ID: "smoke-id"}}, nil),
+		client.EXPECT().GetPipelines("tests", "").Return([]plank.Pipeline{{ID: "smoke-id"}, {ID: "new-id"}}, nil),
+		client.EXPECT().GetPipelines("tests", "").Return(nil, errors.New("front50 is down")),
+	)
+
+	catalog := NewReferenceCatalog(time.Minute, nil)
+	now := time.Now()
+	catalog.now = func() time.Time { return now }
+
+	exists, err := catalog.HasPipeline(client, "tests", "smoke-id")
+	assert.True(t, exists)
+	assert.Nil(t, err)
+	exists, err = catalog.HasPipeline(client, "tests", "new-id")
+	assert.True(t, exists)
+	assert.Nil(t, err)
+	exists, err = catalog.HasPipeline(client, "tests", "smoke-id")
+	assert.True(t, exists)
+	assert.Nil(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = catalog.HasPipeline(client, "tests", "smoke-id")
+	assert.EqualError(t, err, "could not list the pipelines of tests: front50 is down")
+}
@@ -0,0 +1,107 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/plank/v4"
+)
+
+// saveRevision records what was applied to an application, failing to record
+// it doesn't fail the update that already happened.
+func (b *PipelineBuilder) saveRevision(revision revisions.Revision) (revisions.Revision, bool) {
+	if b.Revisions == nil {
+		return revision, false
+	}
+	saved, err := b.Revisions.SaveRevision(revision)
+	if err != nil {
+		b.Logger.Errorf("Failed to save the revision of %s: %s", revision.Application, err.Error())
+		return revision, false
+	}
+	return saved, true
+}
+
+// RestoreRevision saves the pipelines of target again, and deletes the
+// pipelines latest added since. Both revisions are of the same dinghyfile,
+// latest is the revision in place. The rollback is recorded as a new revision.
+func (b *PipelineBuilder) RestoreRevision(target, latest revisions.Revision, pusher string) (revisions.Revision, logevents.PipelineChanges, error) {
+	changes := logevents.PipelineChanges{}
+	rollback := revisions.Revision{
+		Application: target.Application,
+		Provider:    target.Provider,
+		Org:         target.Org,
+		Repo:        target.Repo,
+		Path:        target.Path,
+		Branch:      target.Branch,
+		Pusher:      pusher,
+		Dinghyfile:  target.Dinghyfile,
+		Pipelines:   []plank.Pipeline{},
+		RollbackOf:  target.ID,
+	}
+
+	appLock, err := b.lockApplication(target.Application)
+	if err != nil {
+		return rollback, changes, err
+	}
+	if appLock != nil {
+		defer appLock.Release()
+	}
+
+	existing, err := b.existingPipelines(target.Application)
+	if err != nil {
+		return rollback, changes, err
+	}
+	restored := map[string]bool{}
+	for _, p := range target.Pipelines {
+		restored[p.Name] = true
+		// the pipeline may have been deleted and created again since
+		current, exists := existing[p.Name]
+		p.ID = current.ID
+		var owner *PipelineOwner
+		if o, owned := OwnerOf(p); owned {
+			owner = &o
+		}
+		if exists && pipelineUnchanged(p, current, owner) {
+			changes.Unchanged++
+			rollback.Pipelines = append(rollback.Pipelines, current)
+			continue
+		}
+		b.Logger.Infof("Restoring pipeline %s of %s from revision %d", p.Name, target.Application, target.ID)
+		if err := b.upsertPipeline(target.Application, p, appLock); err != nil {
+			return rollback, changes, err
+		}
+		rollback.Pipelines = append(rollback.Pipelines, p)
+		if exists {
+			changes.Updated++
+		} else {
+			changes.Created++
+		}
+	}
+	for _, p := range latest.Pipelines {
+		current, exists := existing[p.Name]
+		if restored[p.Name] || !exists {
+			continue
+		}
+		b.Logger.Infof("Deleting pipeline %s of %s, it isn't part of revision %d", p.Name, target.Application, target.ID)
+		if err := b.Client.DeletePipeline(current, ""); err != nil {
+			b.Logger.Errorf("Could not delete pipeline %s (application %s): %s", p.Name, target.Application, err.Error())
+			return rollback, changes, err
+		}
+		changes.Deleted++
+	}
+
+	rollback, _ = b.saveRevision(rollback)
+	return rollback, changes, nil
+}
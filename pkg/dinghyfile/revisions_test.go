@@ -0,0 +1,65 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"testing"
+
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreRevision(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	kept := plank.Pipeline{Name: "Kept", Application: "testapp", Description: "same"}
+	restored := plank.Pipeline{Name: "Restored", Application: "testapp", Description: "old"}
+	recreated := plank.Pipeline{Name: "Recreated", ID: "GoneID", Application: "testapp"}
+	added := plank.Pipeline{Name: "Added", ID: "AddedID", Application: "testapp"}
+
+	store := revisions.NewRevisionMemoryClient(0)
+	target, _ := store.SaveRevision(revisions.Revision{Application: "testapp", Path: "dinghyfile", Pipelines: []plank.Pipeline{kept, restored, recreated}})
+	latest, _ := store.SaveRevision(revisions.Revision{Application: "testapp", Path: "dinghyfile", Pipelines: []plank.Pipeline{kept, {Name: "Restored", Description: "new"}, added}})
+
+	storedKept := plank.Pipeline{Name: "Kept", ID: "KeptID", Application: "testapp", Description: "same"}
+	storedRestored := plank.Pipeline{Name: "Restored", ID: "RestoredID", Application: "testapp", Description: "new"}
+	expectedRestored := restored
+	expectedRestored.ID = "RestoredID"
+	expectedRecreated := recreated
+	expectedRecreated.ID = ""
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{storedKept, storedRestored, added}, nil).Times(1)
+	client.EXPECT().UpsertPipeline(expectedRestored, "RestoredID", "").Return(nil).Times(1)
+	client.EXPECT().UpsertPipeline(expectedRecreated, "", "").Return(nil).Times(1)
+	client.EXPECT().DeletePipeline(added, "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.Revisions = store
+
+	rollback, changes, err := b.RestoreRevision(target, latest, "someone")
+	assert.Nil(t, err)
+	assert.Equal(t, logevents.PipelineChanges{Created: 1, Updated: 1, Unchanged: 1, Deleted: 1}, changes)
+	assert.Equal(t, target.ID, rollback.RollbackOf)
+	assert.Equal(t, "someone", rollback.Pusher)
+	assert.Len(t, rollback.Pipelines, 3)
+
+	found, _ := store.GetRevisions("testapp")
+	assert.Equal(t, rollback.ID, found[0].ID)
+}
@@ -0,0 +1,87 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/armory/dinghy/pkg/schema"
+)
+
+// ValidateSchema checks a rendered dinghyfile against the Dinghyfile schema
+// and the dinghyfile extensions, dinghyfiles that aren't JSON are skipped.
+func (b *PipelineBuilder) ValidateSchema(rendered []byte) error {
+	if b.Schema == nil || !json.Valid(rendered) {
+		return nil
+	}
+	schemas := []string{schema.Dinghyfile}
+	for _, path := range b.Schema.Dinghyfile {
+		extension, err := b.downloadSchema(path)
+		if err != nil {
+			return err
+		}
+		schemas = append(schemas, extension)
+	}
+	return validateAgainst("dinghyfile", rendered, schemas)
+}
+
+// ValidateModuleSchema checks a rendered module against the schema of its
+// path in the extensions, modules without one aren't checked.
+func (b *PipelineBuilder) ValidateModuleSchema(module string, rendered []byte) error {
+	if b.Schema == nil {
+		return nil
+	}
+	path := b.Schema.SchemaFor(module)
+	if path == "" {
+		return nil
+	}
+	subject := fmt.Sprintf("module %s", module)
+	if !json.Valid(rendered) {
+		return &schema.Error{Subject: subject, Problems: []string{"it isn't a JSON document"}}
+	}
+	moduleSchema, err := b.downloadSchema(path)
+	if err != nil {
+		return err
+	}
+	return validateAgainst(subject, rendered, []string{moduleSchema})
+}
+
+func validateAgainst(subject string, document []byte, schemas []string) error {
+	validator, err := schema.Compile(schemas...)
+	if err != nil {
+		return fmt.Errorf("could not compile the schemas of the %s: %w", subject, err)
+	}
+	problems, err := validator.Validate(document)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return &schema.Error{Subject: subject, Problems: problems}
+	}
+	return nil
+}
+
+// downloadSchema reads a schema of the template repo, from the branch the modules are rendered from.
+func (b *PipelineBuilder) downloadSchema(path string) (string, error) {
+	branch := b.ModuleBranch
+	if branch == "" {
+		branch = "master"
+	}
+	content, err := b.Downloader.Download(b.TemplateOrg, b.TemplateRepo, path, branch)
+	if err != nil {
+		return "", fmt.Errorf("could not download the schema %s: %w", path, err)
+	}
+	return content, nil
+}
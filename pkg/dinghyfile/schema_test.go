@@ -0,0 +1,58 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"testing"
+
+	"github.com/armory/dinghy/pkg/git/dummy"
+	"github.com/armory/dinghy/pkg/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSchema(t *testing.T) {
+	b := testPipelineBuilder()
+	rendered := []byte(`{"application": "myapp", "pipelines": [{"name": "deploy", "stages": [{"refId": "1"}]}]}`)
+	assert.Nil(t, b.ValidateSchema(rendered))
+
+	b.Schema = &schema.Extensions{}
+	assert.EqualError(t, b.ValidateSchema(rendered), "dinghyfile doesn't match the schema: pipelines[0].stages[0].type is required")
+	// dinghyfiles of other formats are left to their parsers
+	assert.Nil(t, b.ValidateSchema([]byte("application: myapp")))
+
+	b.Downloader = dummy.FileService{"master": {
+		"schemas/dinghyfile.json": `{"properties": {"application": {"pattern": "^team-"}}}`,
+	}}
+	b.Schema = &schema.Extensions{Dinghyfile: []string{"schemas/dinghyfile.json"}}
+	rendered = []byte(`{"application": "myapp", "pipelines": []}`)
+	assert.EqualError(t, b.ValidateSchema(rendered), "dinghyfile doesn't match the schema: application: Does not match pattern '^team-'")
+
+	b.Schema = &schema.Extensions{Dinghyfile: []string{"schemas/missing.json"}}
+	assert.NotNil(t, b.ValidateSchema(rendered))
+}
+
+func TestValidateModuleSchema(t *testing.T) {
+	b := testPipelineBuilder()
+	b.Downloader = dummy.FileService{"master": {
+		"schemas/stage.json": `{"type": "object", "required": ["type", "name"]}`,
+	}}
+	b.Schema = &schema.Extensions{Modules: []schema.ModuleSchema{{Pattern: "stages/*", Schema: "schemas/stage.json"}}}
+
+	assert.Nil(t, b.ValidateModuleSchema("stages/wait.module", []byte(`{"type": "wait", "name": "Wait"}`)))
+	assert.Nil(t, b.ValidateModuleSchema("notifications/slack.module", []byte(`{"type": "slack"},`)))
+	assert.EqualError(t, b.ValidateModuleSchema("stages/wait.module", []byte(`{"type": "wait"}`)),
+		"module stages/wait.module doesn't match the schema: name is required")
+	assert.EqualError(t, b.ValidateModuleSchema("stages/wait.module", []byte(`{"type": "wait"},`)),
+		"module stages/wait.module doesn't match the schema: it isn't a JSON document")
+}
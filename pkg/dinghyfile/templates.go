@@ -0,0 +1,265 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/lock"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+)
+
+// TemplateURL is the node of a pipeline template in the dependency graph,
+// the dinghyfiles with pipelines built from it depend on it.
+func TemplateURL(id string) string {
+	return util.Front50TemplateScheme + id
+}
+
+// TemplateError fails a dinghyfile with invalid pipeline templates or templated pipelines.
+type TemplateError struct {
+	Problems []string
+}
+
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("invalid pipeline templates: %s", strings.Join(e.Problems, "; "))
+}
+
+// setTemplateDefaults fills in what Spinnaker expects of v2 templates and
+// templated pipelines and dinghyfiles can leave out.
+func setTemplateDefaults(d *Dinghyfile) {
+	for i := range d.PipelineTemplates {
+		t := &d.PipelineTemplates[i]
+		if t.Schema == "" {
+			t.Schema = util.TemplateSchema
+		}
+	}
+	for i := range d.TemplatedPipelines {
+		p := &d.TemplatedPipelines[i]
+		if p.Schema == "" {
+			p.Schema = util.TemplateSchema
+		}
+		if p.Type == "" {
+			p.Type = util.TemplatedPipelineType
+		}
+		if p.Application == "" {
+			p.Application = d.ApplicationSpec.Name
+		}
+		if _, _, ok := p.Template.Front50Template(); ok {
+			if p.Template.Type == "" {
+				p.Template.Type = util.Front50TemplateType
+			}
+			if p.Template.ArtifactAccount == "" {
+				p.Template.ArtifactAccount = util.Front50TemplateAccount
+			}
+		}
+	}
+}
+
+// ValidateTemplates checks the pipeline templates and templated pipelines of
+// a dinghyfile, and that the Front50 templates they use exist.
+func (b *PipelineBuilder) ValidateTemplates(d Dinghyfile) error {
+	problems := []string{}
+	declared := map[string]bool{}
+	for i, t := range d.PipelineTemplates {
+		switch {
+		case t.ID == "":
+			problems = append(problems, fmt.Sprintf("pipeline template #%d has no id", i+1))
+		case declared[t.ID]:
+			problems = append(problems, fmt.Sprintf("pipeline template %s is declared twice", t.ID))
+		case t.Schema != util.TemplateSchema:
+			problems = append(problems, fmt.Sprintf("pipeline template %s has schema %s, only %s is supported", t.ID, t.Schema, util.TemplateSchema))
+		}
+		declared[t.ID] = true
+	}
+
+	names := map[string]bool{}
+	for _, p := range d.Pipelines {
+		names[p.Name] = true
+	}
+	for i, p := range d.TemplatedPipelines {
+		if p.Name == "" {
+			problems = append(problems, fmt.Sprintf("templated pipeline #%d has no name", i+1))
+			continue
+		}
+		if names[p.Name] {
+			problems = append(problems, fmt.Sprintf("templated pipeline %s: there is another pipeline with that name", p.Name))
+		}
+		names[p.Name] = true
+		if p.Schema != util.TemplateSchema {
+			problems = append(problems, fmt.Sprintf("templated pipeline %s has schema %s, only %s is supported", p.Name, p.Schema, util.TemplateSchema))
+		}
+		if !strings.EqualFold(p.Application, d.ApplicationSpec.Name) {
+			problems = append(problems, fmt.Sprintf("templated pipeline %s belongs to %s, not %s", p.Name, p.Application, d.ApplicationSpec.Name))
+		}
+		if p.Template.Reference == "" {
+			problems = append(problems, fmt.Sprintf("templated pipeline %s has no template reference", p.Name))
+			continue
+		}
+		id, tag, ok := p.Template.Front50Template()
+		if !ok || declared[id] || isExpression(id) || b.Client == nil {
+			continue
+		}
+		template, err := b.Client.GetPipelineTemplate(id, tag, "")
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			problems = append(problems, fmt.Sprintf("templated pipeline %s uses unknown pipeline template %s", p.Name, p.Template.Reference))
+		}
+	}
+	if len(problems) > 0 {
+		return &TemplateError{Problems: problems}
+	}
+	return nil
+}
+
+// trackTemplates records that a dinghyfile depends on the Front50 templates
+// of its templated pipelines, besides the modules it was rendered from.
+func (b *PipelineBuilder) trackTemplates(org, repo, path, branch string, d Dinghyfile) error {
+	templates := []string{}
+	for _, p := range d.TemplatedPipelines {
+		if id, _, ok := p.Template.Front50Template(); ok {
+			templates = append(templates, TemplateURL(id))
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+	url := b.Downloader.EncodeURL(org, repo, path, branch)
+	deps := b.Depman.GetChildren(url)
+	for _, t := range templates {
+		deps = appendMissing(deps, t)
+	}
+	return b.Depman.SetDeps(url, deps)
+}
+
+// saveTemplates saves the pipeline templates of a dinghyfile that changed,
+// it returns the IDs of the saved ones.
+func (b *PipelineBuilder) saveTemplates(templates []util.PipelineTemplate) ([]string, error) {
+	saved := []string{}
+	for _, t := range templates {
+		existing, err := b.Client.GetPipelineTemplate(t.ID, t.Tag, "")
+		if err != nil {
+			b.Logger.Errorf("Failed to get pipeline template %s: %s", t.ID, err.Error())
+			return saved, err
+		}
+		id := ""
+		if existing != nil {
+			id = t.ID
+			existing.Tag = t.Tag
+			if len(diffFields("", jsonObject(t), jsonObject(*existing))) == 0 {
+				b.Logger.Infof("Pipeline template %s is unchanged, skipping", t.ID)
+				continue
+			}
+		}
+		b.Logger.Infof("Saving pipeline template %s", t.ID)
+		if err := b.Client.UpsertPipelineTemplate(t, id, ""); err != nil {
+			b.Logger.Errorf("Failed to save pipeline template %s: %s", t.ID, err.Error())
+			return saved, err
+		}
+		saved = append(saved, t.ID)
+	}
+	return saved, nil
+}
+
+// applyTemplatedPipelines saves the templated pipelines of a dinghyfile the
+// way applyPipelines saves the others, existing are all the pipelines of the
+// application by name and ignoreList keeps the saved ones from being deleted.
+func (b *PipelineBuilder) applyTemplatedPipelines(d Dinghyfile, owner *PipelineOwner, existing map[string]plank.Pipeline, ignoreList map[string]bool, applied *appliedPipelines, appLock lock.Lock) error {
+	app := d.ApplicationSpec.Name
+	templated, err := b.Client.GetTemplatedPipelines(app, "")
+	if err != nil {
+		b.Logger.Errorf("Failed to get the templated pipelines of %s: %s", app, err.Error())
+		return err
+	}
+	current := map[string]util.TemplatedPipeline{}
+	for _, p := range templated {
+		current[p.Name] = p
+	}
+	for _, p := range d.TemplatedPipelines {
+		ignoreList[p.Name] = true
+		e, exists := existing[p.Name]
+		if exists {
+			p.ID = e.ID
+		}
+		if b.AutolockPipelines == "true" {
+			p.Locked = (&plank.Pipeline{}).Lock().Locked
+		}
+		if c, ok := current[p.Name]; ok && exists && templatedPipelineUnchanged(p, c, owner) {
+			b.Logger.Infof("Templated pipeline %s is unchanged, skipping", p.Name)
+			applied.changes.Unchanged++
+			applied.pipelines = append(applied.pipelines, e)
+			continue
+		}
+		stamped := plank.Pipeline{Config: p.Config}
+		if owner != nil && !setOwner(&stamped, *owner) {
+			b.Logger.Warnf("Templated pipeline %s isn't stamped with its owner, its config isn't an object", p.Name)
+		}
+		p.Config = stamped.Config
+
+		if err := b.checkLock(app, appLock); err != nil {
+			return err
+		}
+		b.Logger.Infof("Saving templated pipeline %s", p.Name)
+		if err := b.Client.UpsertTemplatedPipeline(p, p.ID, ""); err != nil {
+			err = unwrapFront50Error(err)
+			b.Logger.Errorf("Upsert failed: %s", err.Error())
+			return err
+		}
+		applied.pipelines = append(applied.pipelines, plank.Pipeline{ID: p.ID, Type: p.Type, Name: p.Name, Application: p.Application, Description: p.Description, Config: p.Config})
+		if exists {
+			applied.changes.Updated++
+		} else {
+			applied.changes.Created++
+		}
+	}
+	return nil
+}
+
+// templatedPipelineUnchanged checks if saving a templated pipeline would
+// leave the existing one as it is, like pipelineUnchanged.
+func templatedPipelineUnchanged(p, existing util.TemplatedPipeline, owner *PipelineOwner) bool {
+	if owner != nil {
+		if current, owned := OwnerOf(plank.Pipeline{Config: existing.Config}); !owned || !current.Same(*owner) {
+			return false
+		}
+	}
+	p.Config = withoutOwner(plank.Pipeline{Config: p.Config}).Config
+	existing.Config = withoutOwner(plank.Pipeline{Config: existing.Config}).Config
+	return len(diffFields("", jsonObject(p), jsonObject(existing))) == 0
+}
+
+// rebuildTemplatedPipelines processes again the dinghyfiles with pipelines
+// built from the templates saved by the dinghyfile at url.
+func (b *PipelineBuilder) rebuildTemplatedPipelines(url string, templates []string, pusher string) {
+	rebuilt := map[string]bool{url: true}
+	rebuilding := b.RebuildingModules
+	b.RebuildingModules = true
+	defer func() { b.RebuildingModules = rebuilding }()
+	for _, id := range templates {
+		for _, root := range b.Depman.GetRoots(TemplateURL(id)) {
+			if rebuilt[root] {
+				continue
+			}
+			rebuilt[root] = true
+			org, repo, path, branch := b.Downloader.DecodeURL(root)
+			b.Logger.Infof("Rebuilding %s, its pipelines use the pipeline template %s", root, id)
+			if _, err := b.ProcessDinghyfile(org, repo, path, branch, pusher); err != nil {
+				b.Logger.Errorf("Failed to rebuild %s after the pipeline template %s changed: %s", root, id, err.Error())
+			}
+		}
+	}
+}
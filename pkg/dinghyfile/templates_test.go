@@ -0,0 +1,140 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const templatesDinghyfile = `{
+	"application": "biff",
+	"pipelineTemplates": [{
+		"id": "deploy",
+		"tag": "v1",
+		"metadata": {"name": "Deploy"},
+		"variables": [{"name": "replicas", "type": "int", "defaultValue": 1}],
+		"pipeline": {"stages": [{"refId": "1", "type": "wait", "waitTime": "${ templateVariables.replicas }"}]}
+	}],
+	"templatedPipelines": [{
+		"name": "deploy-prod",
+		"template": {"reference": "spinnaker://deploy:v1"},
+		"variables": {"replicas": 3}
+	}]
+}`
+
+const templatedDinghyfile = `{
+	"application": "other",
+	"templatedPipelines": [{
+		"name": "deploy-other",
+		"template": {"reference": "spinnaker://deploy:v1"}
+	}]
+}`
+
+func TestUpdateDinghyfileTemplateDefaults(t *testing.T) {
+	b := testPipelineBuilder()
+	d, err := b.UpdateDinghyfile([]byte(templatesDinghyfile))
+	assert.Nil(t, err)
+	assert.Equal(t, "v2", d.PipelineTemplates[0].Schema)
+	assert.Equal(t, util.TemplatedPipeline{
+		Schema:      "v2",
+		Type:        "templatedPipeline",
+		Name:        "deploy-prod",
+		Application: "biff",
+		Template:    util.TemplateReference{ArtifactAccount: "front50ArtifactCredentials", Reference: "spinnaker://deploy:v1", Type: "front50/pipelineTemplate"},
+		Variables:   map[string]interface{}{"replicas": float64(3)},
+	}, d.TemplatedPipelines[0])
+}
+
+func TestValidateTemplates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetPipelineTemplate("missing", "", "").Return(nil, nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	d := NewDinghyfile()
+	d.ApplicationSpec.Name = "biff"
+	d.Pipelines = []plank.Pipeline{{Name: "plain"}}
+	d.PipelineTemplates = []util.PipelineTemplate{{ID: "deploy", Schema: "v2"}, {ID: "deploy", Schema: "v2"}, {Schema: "v1"}}
+	d.TemplatedPipelines = []util.TemplatedPipeline{
+		{Name: "plain", Schema: "v2", Application: "biff", Template: util.TemplateReference{Reference: "spinnaker://deploy"}},
+		{Name: "elsewhere", Schema: "v2", Application: "other", Template: util.TemplateReference{Reference: "spinnaker://deploy"}},
+		{Name: "unknown", Schema: "v2", Application: "biff", Template: util.TemplateReference{Reference: "spinnaker://missing"}},
+		{Name: "artifact", Schema: "v2", Application: "biff", Template: util.TemplateReference{Reference: "https://example.org/template.json"}},
+		{Name: "unreferenced", Schema: "v1", Application: "biff"},
+	}
+
+	err := b.ValidateTemplates(d)
+	assert.EqualError(t, err, "invalid pipeline templates: pipeline template deploy is declared twice; pipeline template #3 has no id; "+
+		"templated pipeline plain: there is another pipeline with that name; templated pipeline elsewhere belongs to other, not biff; "+
+		"templated pipeline unknown uses unknown pipeline template spinnaker://missing; "+
+		"templated pipeline unreferenced has schema v1, only v2 is supported; templated pipeline unreferenced has no template reference")
+}
+
+func TestProcessDinghyfileTemplates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	b := testPipelineBuilder()
+	// another dinghyfile uses the template
+	other := b.Downloader.EncodeURL("myorg", "myrepo", "other", "master")
+	b.Depman.SetDeps(other, []string{TemplateURL("deploy")})
+
+	renderer := NewMockParser(ctrl)
+	renderer.EXPECT().Parse("myorg", "myrepo", "dinghyfile", "master", gomock.Any()).Return(bytes.NewBufferString(templatesDinghyfile), nil).Times(1)
+	renderer.EXPECT().Parse("myorg", "myrepo", "other", "master", gomock.Any()).Return(bytes.NewBufferString(templatedDinghyfile), nil).Times(1)
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("biff", "").Return(&plank.Application{Name: "biff"}, nil).Times(1)
+	client.EXPECT().GetPipelineTemplate("deploy", "v1", "").Return(&util.PipelineTemplate{ID: "deploy", Schema: "v2"}, nil).Times(2)
+	client.EXPECT().UpsertPipelineTemplate(gomock.Any(), "deploy", "").DoAndReturn(func(template util.PipelineTemplate, id, traceparent string) error {
+		assert.Equal(t, "v1", template.Tag)
+		assert.Equal(t, "Deploy", template.Metadata.Name)
+		return nil
+	}).Times(1)
+	client.EXPECT().GetPipelines("biff", "").Return([]plank.Pipeline{}, nil).Times(1)
+	client.EXPECT().GetTemplatedPipelines("biff", "").Return([]util.TemplatedPipeline{}, nil).Times(1)
+	client.EXPECT().UpsertTemplatedPipeline(gomock.Any(), "", "").DoAndReturn(func(p util.TemplatedPipeline, id, traceparent string) error {
+		assert.Equal(t, "deploy-prod", p.Name)
+		assert.Equal(t, "spinnaker://deploy:v1", p.Template.Reference)
+		assert.Equal(t, map[string]interface{}{"replicas": float64(3)}, p.Variables)
+		return nil
+	}).Times(1)
+
+	// the other dinghyfile is rebuilt, its pipeline is unchanged
+	existing := util.TemplatedPipeline{ID: "2", Schema: "v2", Type: "templatedPipeline", Name: "deploy-other", Application: "other",
+		Template: util.TemplateReference{ArtifactAccount: "front50ArtifactCredentials", Reference: "spinnaker://deploy:v1", Type: "front50/pipelineTemplate"}}
+	client.EXPECT().GetApplication("other", "").Return(&plank.Application{Name: "other"}, nil).Times(1)
+	client.EXPECT().GetPipelines("other", "").Return([]plank.Pipeline{{ID: "2", Name: "deploy-other", Type: "templatedPipeline"}}, nil).Times(1)
+	client.EXPECT().GetTemplatedPipelines("other", "").Return([]util.TemplatedPipeline{existing}, nil).Times(1)
+
+	b.Parser = renderer
+	b.Client = client
+	changes := &logevents.PipelineChanges{}
+	b.PipelineChanges = changes
+
+	_, err := b.ProcessDinghyfile("myorg", "myrepo", "dinghyfile", "master", "pusher")
+	assert.Nil(t, err)
+	assert.Equal(t, logevents.PipelineChanges{Created: 1, Unchanged: 1}, *changes)
+	assert.Equal(t, []string{TemplateURL("deploy")}, b.Depman.GetChildren(other))
+}
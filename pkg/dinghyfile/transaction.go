@@ -0,0 +1,267 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/lock"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+)
+
+// CompensatedUpdateError is returned when an update failed and the
+// application was restored the way it was before, or restoring it failed too.
+type CompensatedUpdateError struct {
+	Application string
+	Err         error
+	// CompensationErr is nil when the application was restored
+	CompensationErr error
+}
+
+func (e *CompensatedUpdateError) Error() string {
+	if e.CompensationErr == nil {
+		return fmt.Sprintf("%s (the changes to application %s were rolled back)", e.Err.Error(), e.Application)
+	}
+	return fmt.Sprintf("%s (rolling back the changes to application %s failed: %s)", e.Err.Error(), e.Application, e.CompensationErr.Error())
+}
+
+func (e *CompensatedUpdateError) Unwrap() error {
+	return e.Err
+}
+
+// applicationSnapshot is an application the way it was before an update.
+type applicationSnapshot struct {
+	// exists is false when the update creates the application
+	exists        bool
+	application   *plank.Application
+	notifications *plank.NotificationsType
+	pipelines     map[string]plank.Pipeline
+	// templatedPipelines keep the template and variables plank drops, by name
+	templatedPipelines map[string]util.TemplatedPipeline
+	// templates are the pipeline templates the update saves, nil when the
+	// update creates them
+	templates []templateSnapshot
+}
+
+// templateSnapshot is a version of a pipeline template before an update.
+type templateSnapshot struct {
+	id       string
+	tag      string
+	template *util.PipelineTemplate
+}
+
+func (b *PipelineBuilder) snapshotApplication(name string, templates []util.PipelineTemplate) (applicationSnapshot, error) {
+	snapshot := applicationSnapshot{pipelines: map[string]plank.Pipeline{}}
+	for _, t := range templates {
+		existing, err := b.Client.GetPipelineTemplate(t.ID, t.Tag, "")
+		if err != nil {
+			return snapshot, err
+		}
+		if existing != nil {
+			existing.Tag = t.Tag
+		}
+		snapshot.templates = append(snapshot.templates, templateSnapshot{id: t.ID, tag: t.Tag, template: existing})
+	}
+	app, err := b.Client.GetApplication(name, "")
+	if err != nil {
+		if notFound(err) {
+			return snapshot, nil
+		}
+		return snapshot, err
+	}
+	snapshot.exists = true
+	snapshot.application = app
+	notifications, err := b.Client.GetApplicationNotifications(name, "")
+	if err != nil && !notFound(err) {
+		return snapshot, err
+	}
+	if notifications == nil {
+		notifications = &plank.NotificationsType{}
+	}
+	snapshot.notifications = notifications
+	snapshot.pipelines, err = b.existingPipelines(name)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.templatedPipelines, err = b.snapshotTemplatedPipelines(name, snapshot.pipelines)
+	return snapshot, err
+}
+
+// snapshotTemplatedPipelines gets the templated pipelines of an application,
+// only when some of its pipelines are templated.
+func (b *PipelineBuilder) snapshotTemplatedPipelines(name string, pipelines map[string]plank.Pipeline) (map[string]util.TemplatedPipeline, error) {
+	templated := map[string]util.TemplatedPipeline{}
+	for _, p := range pipelines {
+		if p.Type != util.TemplatedPipelineType {
+			continue
+		}
+		stored, err := b.Client.GetTemplatedPipelines(name, "")
+		if err != nil {
+			return templated, err
+		}
+		for _, t := range stored {
+			templated[t.Name] = t
+		}
+		break
+	}
+	return templated, nil
+}
+
+func notFound(err error) bool {
+	failed, ok := err.(*plank.FailedResponse)
+	return ok && failed.StatusCode == 404
+}
+
+// applyTransaction applies a dinghyfile all or nothing, on failure the
+// application is restored from a snapshot taken before changing anything.
+func (b *PipelineBuilder) applyTransaction(dinghyfile Dinghyfile, pusher string, owner *PipelineOwner, appLock lock.Lock) (appliedPipelines, error) {
+	name := dinghyfile.ApplicationSpec.Name
+	snapshot, err := b.snapshotApplication(name, dinghyfile.PipelineTemplates)
+	if err != nil {
+		b.Logger.Errorf("Failed to snapshot application %s, nothing was updated: %s", name, err.Error())
+		return appliedPipelines{pipelines: []plank.Pipeline{}}, err
+	}
+	applied, err := b.applyPipelines(dinghyfile, pusher, owner, appLock)
+	if err == nil {
+		return applied, nil
+	}
+
+	b.Logger.Warnf("Rolling back the changes to application %s: %s", name, err.Error())
+	compensationErr := b.restoreSnapshot(name, snapshot, appLock)
+	if compensationErr != nil {
+		b.Logger.Errorf("Failed to roll back the changes to application %s: %s", name, compensationErr.Error())
+	} else {
+		// nothing was applied in the end
+		applied = appliedPipelines{pipelines: []plank.Pipeline{}}
+	}
+	return applied, &CompensatedUpdateError{Application: name, Err: err, CompensationErr: compensationErr}
+}
+
+// restoreSnapshot puts back the pipelines, pipeline templates, notifications
+// and application of a snapshot, it carries on after failures and returns all
+// of them.
+func (b *PipelineBuilder) restoreSnapshot(name string, snapshot applicationSnapshot, appLock lock.Lock) error {
+	failures := []string{}
+	current, err := b.existingPipelines(name)
+	if err != nil {
+		return err
+	}
+	for pipelineName, p := range current {
+		if _, existed := snapshot.pipelines[pipelineName]; existed {
+			continue
+		}
+		b.Logger.Infof("Deleting pipeline %s created by the failed update", pipelineName)
+		if err := b.Client.DeletePipeline(p, ""); err != nil {
+			failures = append(failures, fmt.Sprintf("deleting pipeline %s: %s", pipelineName, err.Error()))
+		}
+	}
+	for pipelineName, p := range snapshot.pipelines {
+		if c, exists := current[pipelineName]; exists && samePipeline(p, c) {
+			continue
+		}
+		if p.Type == util.TemplatedPipelineType {
+			if err := b.restoreTemplatedPipeline(name, snapshot, pipelineName, appLock); err != nil {
+				failures = append(failures, fmt.Sprintf("restoring templated pipeline %s: %s", pipelineName, err.Error()))
+			}
+			continue
+		}
+		b.Logger.Infof("Restoring pipeline %s", pipelineName)
+		if err := b.upsertPipeline(name, p, appLock); err != nil {
+			failures = append(failures, fmt.Sprintf("restoring pipeline %s: %s", pipelineName, err.Error()))
+		}
+	}
+
+	for _, t := range snapshot.templates {
+		if err := b.restoreTemplate(t); err != nil {
+			failures = append(failures, fmt.Sprintf("restoring pipeline template %s: %s", t.id, err.Error()))
+		}
+	}
+
+	if !snapshot.exists {
+		b.Logger.Infof("Deleting application %s created by the failed update", name)
+		if err := b.Client.DeleteApplication(name, ""); err != nil {
+			failures = append(failures, fmt.Sprintf("deleting application: %s", err.Error()))
+		}
+	} else if b.saveAppOnUpdate() {
+		if snapshot.application != nil {
+			if err := b.Client.UpdateApplication(*snapshot.application, ""); err != nil {
+				failures = append(failures, fmt.Sprintf("restoring application: %s", err.Error()))
+			}
+		}
+		if err := b.Client.UpdateApplicationNotifications(*snapshot.notifications, name, ""); err != nil {
+			failures = append(failures, fmt.Sprintf("restoring notifications: %s", err.Error()))
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// restoreTemplatedPipeline saves back a templated pipeline of a snapshot from
+// its templated pipelines, plank drops the template and variables.
+func (b *PipelineBuilder) restoreTemplatedPipeline(app string, snapshot applicationSnapshot, name string, appLock lock.Lock) error {
+	p, found := snapshot.templatedPipelines[name]
+	if !found {
+		return errors.New("it is missing from the snapshot")
+	}
+	if err := b.checkLock(app, appLock); err != nil {
+		return err
+	}
+	b.Logger.Infof("Restoring templated pipeline %s", name)
+	if err := b.Client.UpsertTemplatedPipeline(p, p.ID, ""); err != nil {
+		return unwrapFront50Error(err)
+	}
+	return nil
+}
+
+// restoreTemplate saves back a pipeline template of a snapshot, or deletes it
+// when the failed update created it.
+func (b *PipelineBuilder) restoreTemplate(t templateSnapshot) error {
+	current, err := b.Client.GetPipelineTemplate(t.id, t.tag, "")
+	if err != nil {
+		return err
+	}
+	if t.template == nil {
+		if current == nil {
+			return nil
+		}
+		b.Logger.Infof("Deleting pipeline template %s created by the failed update", t.id)
+		return b.Client.DeletePipelineTemplate(t.id, t.tag, "")
+	}
+	id := ""
+	if current != nil {
+		id = t.id
+		current.Tag = t.tag
+		if len(diffFields("", jsonObject(*t.template), jsonObject(*current))) == 0 {
+			return nil
+		}
+	}
+	b.Logger.Infof("Restoring pipeline template %s", t.id)
+	return b.Client.UpsertPipelineTemplate(*t.template, id, "")
+}
+
+// samePipeline checks if two pipelines only differ in the fields Spinnaker sets on save.
+func samePipeline(p, other plank.Pipeline) bool {
+	owner, owned := OwnerOf(p)
+	otherOwner, otherOwned := OwnerOf(other)
+	if owned != otherOwned || owner != otherOwner {
+		return false
+	}
+	return len(pipelineDiffs(withoutOwner(p), other)) == 0
+}
@@ -0,0 +1,185 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package dinghyfile
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdatePipelinesTransactionalRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stored := plank.Pipeline{Name: "Updated", ID: "UpdatedID", Application: "testapp", Description: "old"}
+	updated := plank.Pipeline{Name: "Updated", Application: "testapp", Description: "new"}
+	created := plank.Pipeline{Name: "Created", Application: "testapp"}
+	failing := plank.Pipeline{Name: "Failing", Application: "testapp"}
+
+	expectedUpdate := updated
+	expectedUpdate.ID = "UpdatedID"
+	storedCreated := created
+	storedCreated.ID = "CreatedID"
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(&plank.Application{Name: "testapp"}, nil).Times(2)
+	client.EXPECT().GetApplicationNotifications("testapp", "").Return(&plank.NotificationsType{}, nil).Times(1)
+	gomock.InOrder(
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{stored}, nil).Times(2),
+		client.EXPECT().UpsertPipeline(expectedUpdate, "UpdatedID", "").Return(nil).Times(1),
+		client.EXPECT().UpsertPipeline(created, "", "").Return(nil).Times(1),
+		client.EXPECT().UpsertPipeline(failing, "", "").Return(errors.New("boom")).Times(1),
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{expectedUpdate, storedCreated}, nil).Times(1),
+	)
+	client.EXPECT().DeletePipeline(storedCreated, "").Return(nil).Times(1)
+	client.EXPECT().UpsertPipeline(stored, "UpdatedID", "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.TransactionalUpdates = true
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{updated, created, failing},
+	}
+	applied, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	compensated, ok := err.(*CompensatedUpdateError)
+	assert.True(t, ok)
+	assert.EqualError(t, compensated.Err, "boom")
+	assert.Nil(t, compensated.CompensationErr)
+	assert.Equal(t, "boom (the changes to application testapp were rolled back)", err.Error())
+	assert.Zero(t, applied.changes.Updated+applied.changes.Created)
+}
+
+func TestUpdatePipelinesTransactionalRestoresTemplatedPipelines(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stored := plank.Pipeline{Name: "Templated", ID: "TemplatedID", Type: util.TemplatedPipelineType, Application: "testapp", Description: "old"}
+	changed := stored
+	changed.Description = "changed"
+	snapshot := util.TemplatedPipeline{
+		ID:          "TemplatedID",
+		Schema:      "v2",
+		Type:        util.TemplatedPipelineType,
+		Name:        "Templated",
+		Application: "testapp",
+		Description: "old",
+		Template:    util.TemplateReference{ArtifactAccount: "front50ArtifactCredentials", Reference: "spinnaker://deploy", Type: "front50/pipelineTemplate"},
+		Variables:   map[string]interface{}{"region": "us-east-1"},
+	}
+	failing := plank.Pipeline{Name: "Failing", Application: "testapp"}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(&plank.Application{Name: "testapp"}, nil).Times(2)
+	client.EXPECT().GetApplicationNotifications("testapp", "").Return(&plank.NotificationsType{}, nil).Times(1)
+	client.EXPECT().GetTemplatedPipelines("testapp", "").Return([]util.TemplatedPipeline{snapshot}, nil).Times(1)
+	gomock.InOrder(
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{stored}, nil).Times(2),
+		client.EXPECT().UpsertPipeline(failing, "", "").Return(errors.New("boom")).Times(1),
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{changed}, nil).Times(1),
+	)
+	client.EXPECT().UpsertTemplatedPipeline(snapshot, "TemplatedID", "").Return(nil).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.TransactionalUpdates = true
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{failing},
+	}
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	compensated, ok := err.(*CompensatedUpdateError)
+	assert.True(t, ok)
+	assert.Nil(t, compensated.CompensationErr)
+}
+
+func TestUpdatePipelinesTransactionalRestoresPipelineTemplates(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	stored := util.PipelineTemplate{ID: "deploy", Schema: "v2", Metadata: util.PipelineTemplateMetadata{Name: "Deploy", Description: "old"}}
+	updated := util.PipelineTemplate{ID: "deploy", Schema: "v2", Metadata: util.PipelineTemplateMetadata{Name: "Deploy", Description: "new"}}
+	created := util.PipelineTemplate{ID: "canary", Schema: "v2", Tag: "v1", Metadata: util.PipelineTemplateMetadata{Name: "Canary"}}
+	failing := plank.Pipeline{Name: "Failing", Application: "testapp"}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(&plank.Application{Name: "testapp"}, nil).Times(2)
+	client.EXPECT().GetApplicationNotifications("testapp", "").Return(&plank.NotificationsType{}, nil).Times(1)
+	gomock.InOrder(
+		client.EXPECT().GetPipelineTemplate("deploy", "", "").Return(&stored, nil).Times(2),
+		client.EXPECT().UpsertPipelineTemplate(updated, "deploy", "").Return(nil).Times(1),
+		client.EXPECT().GetPipelineTemplate("deploy", "", "").Return(&updated, nil).Times(1),
+		client.EXPECT().UpsertPipelineTemplate(stored, "deploy", "").Return(nil).Times(1),
+	)
+	gomock.InOrder(
+		client.EXPECT().GetPipelineTemplate("canary", "v1", "").Return(nil, nil).Times(2),
+		client.EXPECT().UpsertPipelineTemplate(created, "", "").Return(nil).Times(1),
+		client.EXPECT().GetPipelineTemplate("canary", "v1", "").Return(&created, nil).Times(1),
+		client.EXPECT().DeletePipelineTemplate("canary", "v1", "").Return(nil).Times(1),
+	)
+	gomock.InOrder(
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{}, nil).Times(2),
+		client.EXPECT().UpsertPipeline(failing, "", "").Return(errors.New("boom")).Times(1),
+		client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{}, nil).Times(1),
+	)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.TransactionalUpdates = true
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec:   plank.Application{Name: "testapp"},
+		Pipelines:         []plank.Pipeline{failing},
+		PipelineTemplates: []util.PipelineTemplate{updated, created},
+	}
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	compensated, ok := err.(*CompensatedUpdateError)
+	assert.True(t, ok)
+	assert.Nil(t, compensated.CompensationErr)
+}
+
+func TestUpdatePipelinesTransactionalNewApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	failing := plank.Pipeline{Name: "Failing", Application: "testapp"}
+	notFound := &plank.FailedResponse{StatusCode: 404}
+
+	client := NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("testapp", "").Return(nil, notFound).Times(2)
+	client.EXPECT().CreateApplication(gomock.Any(), "").Return(nil).Times(1)
+	client.EXPECT().UpdateApplicationNotifications(gomock.Any(), "testapp", "").Return(nil).Times(1)
+	client.EXPECT().GetPipelines("testapp", "").Return([]plank.Pipeline{}, nil).Times(2)
+	client.EXPECT().UpsertPipeline(failing, "", "").Return(errors.New("boom")).Times(1)
+	client.EXPECT().DeleteApplication("testapp", "").Return(errors.New("forbidden")).Times(1)
+
+	b := testPipelineBuilder()
+	b.Client = client
+	b.TransactionalUpdates = true
+
+	dinghyfile := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "testapp"},
+		Pipelines:       []plank.Pipeline{failing},
+	}
+	_, err := b.updatePipelines(dinghyfile, "pusher", nil)
+	assert.EqualError(t, err, "boom (rolling back the changes to application testapp failed: deleting application: forbidden)")
+	assert.True(t, errors.Is(err, err.(*CompensatedUpdateError).Err))
+}
@@ -18,6 +18,10 @@ package dinghyfile
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/armory/dinghy/pkg/util"
 	"github.com/armory/plank/v4"
 	log "github.com/sirupsen/logrus"
@@ -31,6 +35,31 @@ var UserNameEmpty = errors.New("pusher is empty string")
 // whether given user has write permissions to application
 type WritePermissionsValidator interface {
 	Validate(pusherName string) error
+	// ValidateResources checks the user can use everything a dinghyfile references
+	ValidateResources(pusherName string, resources Resources) error
+}
+
+// Resources a dinghyfile creates or uses in Spinnaker on behalf of its pusher.
+type Resources struct {
+	Application string
+	// NewApplication is set when the dinghyfile creates the application
+	NewApplication bool
+	// ServiceAccounts of the triggers by pipeline
+	ServiceAccounts map[string][]string
+	// Accounts of the stages by pipeline
+	Accounts map[string][]string
+	// Roles of the pipelines by pipeline
+	Roles map[string][]string
+}
+
+// AuthorizationError lists everything a user isn't allowed to do.
+type AuthorizationError struct {
+	User       string
+	Violations []string
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("%s is not authorized: %s", e.User, strings.Join(e.Violations, "; "))
 }
 
 // A NoOpWritePermissionValidator interface implements WritePermissionsValidator
@@ -42,6 +71,10 @@ func (w NoOpWritePermissionValidator) Validate(string) error {
 	return nil
 }
 
+func (w NoOpWritePermissionValidator) ValidateResources(string, Resources) error {
+	return nil
+}
+
 // A FiatPermissionsValidator interface implements WritePermissionsValidator
 // It fetches user roles from Fiat and compares them with application permissions
 type FiatPermissionsValidator struct {
@@ -82,6 +115,78 @@ func (v FiatPermissionsValidator) Validate(pusher string) error {
 	return UserNotAuthorized
 }
 
+// ValidateResources checks with the Fiat permissions of the pusher that they
+// can write to the application, or create it with their roles, run the
+// triggers as their service accounts, deploy to the accounts of the stages
+// and have the roles of the pipelines.
+func (v FiatPermissionsValidator) ValidateResources(pusher string, resources Resources) error {
+	if pusher == "" {
+		log.Errorf("Got empty string as pusher. Either that attribute is mising in a webhook, or there's problem with mapping")
+		return UserNameEmpty
+	}
+	permissions, err := v.client.GetUserPermissions(pusher, "")
+	if err != nil {
+		if failedResponse, ok := err.(*plank.FailedResponse); ok && failedResponse.StatusCode == 404 {
+			log.Errorf("User %s was not found in Fiat", pusher)
+			return UserNotFoundError
+		}
+		log.Errorf("Failed to fetch %s's permissions from Fiat, because of: %s", pusher, err)
+		return err
+	}
+	if permissions.Admin {
+		return nil
+	}
+
+	violations := []string{}
+	if resources.NewApplication {
+		if !v.canCreate(permissions) {
+			violations = append(violations, fmt.Sprintf("can't create application %s, none of its write roles is theirs", resources.Application))
+		}
+	} else if !permissions.CanWriteApplication(resources.Application) {
+		violations = append(violations, fmt.Sprintf("can't write to application %s", resources.Application))
+	}
+	for _, pipeline := range sortedKeys(resources.ServiceAccounts) {
+		for _, serviceAccount := range resources.ServiceAccounts[pipeline] {
+			if !permissions.CanUseServiceAccount(serviceAccount) {
+				violations = append(violations, fmt.Sprintf("can't run the triggers of pipeline %s as service account %s", pipeline, serviceAccount))
+			}
+		}
+	}
+	for _, pipeline := range sortedKeys(resources.Accounts) {
+		for _, account := range resources.Accounts[pipeline] {
+			if !permissions.CanWriteAccount(account) {
+				violations = append(violations, fmt.Sprintf("can't deploy to account %s in pipeline %s", account, pipeline))
+			}
+		}
+	}
+	for _, pipeline := range sortedKeys(resources.Roles) {
+		for _, role := range resources.Roles[pipeline] {
+			if !permissions.HasRole(role) {
+				violations = append(violations, fmt.Sprintf("can't give role %s to pipeline %s, it isn't theirs", role, pipeline))
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return &AuthorizationError{User: pusher, Violations: violations}
+	}
+	return nil
+}
+
+// canCreate reports whether a user can create the application, which needs
+// one of its write roles so they aren't locked out. Applications without
+// write roles are open to everybody.
+func (v FiatPermissionsValidator) canCreate(permissions *util.UserPermissions) bool {
+	if v.application.Permissions == nil || len(v.application.Permissions.Write) == 0 {
+		return true
+	}
+	for _, role := range v.application.Permissions.Write {
+		if permissions.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
 // A GetWritePermissionsValidator is a factory method that produces
 // implementation of WritePermissionsValidator based on settings value
 func GetWritePermissionsValidator(userWritePermissionCheck bool, client util.PlankClient, application plank.Application) WritePermissionsValidator {
@@ -109,3 +214,12 @@ func (filter *WritePermissionUserFilter) ShouldIgnore(username string) bool {
 	}
 	return false
 }
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
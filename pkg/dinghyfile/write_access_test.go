@@ -18,6 +18,7 @@ package dinghyfile
 import (
 	"errors"
 	"fmt"
+	"github.com/armory/dinghy/pkg/util"
 	"github.com/armory/plank/v4"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -156,6 +157,122 @@ func TestFiatPermissionsValidator_UserNameEmpty(t *testing.T) {
 	assert.Equal(t, validationResult, UserNameEmpty)
 }
 
+func testUserPermissions() *util.UserPermissions {
+	return &util.UserPermissions{
+		Name:            pusher,
+		Roles:           []plank.FiatRole{{Name: "devops"}},
+		Accounts:        []plank.Authorization{{Name: "staging", Authorizations: []string{"READ", "WRITE"}}, {Name: "prod", Authorizations: []string{"READ"}}},
+		Applications:    []plank.Authorization{{Name: "ApplicationName", Authorizations: []string{"READ", "WRITE"}}},
+		ServiceAccounts: []util.ServiceAccount{{Name: "devops-svc", MemberOf: []string{"devops"}}},
+	}
+}
+
+func TestFiatPermissionsValidator_ValidateResources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockedPlankClient := NewMockPlankClient(ctrl)
+	mockedPlankClient.EXPECT().GetUserPermissions(gomock.Eq(pusher), "").Return(testUserPermissions(), nil).Times(2)
+	permissionsValidator := FiatPermissionsValidator{
+		client:      mockedPlankClient,
+		application: plank.Application{Name: "ApplicationName"},
+	}
+
+	assert.Nil(t, permissionsValidator.ValidateResources(pusher, Resources{
+		Application:     "ApplicationName",
+		ServiceAccounts: map[string][]string{"deploy": {"devops-svc"}},
+		Accounts:        map[string][]string{"deploy": {"staging"}},
+		Roles:           map[string][]string{"deploy": {"DevOps"}},
+	}))
+
+	err := permissionsValidator.ValidateResources(pusher, Resources{
+		Application:     "OtherApplication",
+		ServiceAccounts: map[string][]string{"deploy": {"admin-svc"}},
+		Accounts:        map[string][]string{"deploy": {"staging", "prod"}, "build": {"ci"}},
+		Roles:           map[string][]string{"deploy": {"admins"}},
+	})
+	authorizationError, ok := err.(*AuthorizationError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{
+		"can't write to application OtherApplication",
+		"can't run the triggers of pipeline deploy as service account admin-svc",
+		"can't deploy to account ci in pipeline build",
+		"can't deploy to account prod in pipeline deploy",
+		"can't give role admins to pipeline deploy, it isn't theirs",
+	}, authorizationError.Violations)
+}
+
+func TestFiatPermissionsValidator_ValidateResourcesNewApplication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockedPlankClient := NewMockPlankClient(ctrl)
+	mockedPlankClient.EXPECT().GetUserPermissions(gomock.Eq(pusher), "").Return(testUserPermissions(), nil).Times(3)
+	newApplication := Resources{Application: "NewApplication", NewApplication: true}
+
+	open := FiatPermissionsValidator{client: mockedPlankClient, application: plank.Application{Name: "NewApplication"}}
+	assert.Nil(t, open.ValidateResources(pusher, newApplication))
+
+	ownRoles := FiatPermissionsValidator{client: mockedPlankClient, application: plank.Application{
+		Name:        "NewApplication",
+		Permissions: &plank.PermissionsType{Write: []string{"devops"}},
+	}}
+	assert.Nil(t, ownRoles.ValidateResources(pusher, newApplication))
+
+	otherRoles := FiatPermissionsValidator{client: mockedPlankClient, application: plank.Application{
+		Name:        "NewApplication",
+		Permissions: &plank.PermissionsType{Write: []string{"qa"}},
+	}}
+	assert.EqualError(t, otherRoles.ValidateResources(pusher, newApplication),
+		"test_pusher is not authorized: can't create application NewApplication, none of its write roles is theirs")
+}
+
+func TestFiatPermissionsValidator_ValidateResourcesAdmin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockedPlankClient := NewMockPlankClient(ctrl)
+	mockedPlankClient.EXPECT().GetUserPermissions(gomock.Eq(pusher), "").Return(&util.UserPermissions{Name: pusher, Admin: true}, nil).Times(1)
+	mockedPlankClient.EXPECT().GetUserPermissions(gomock.Eq("unknown"), "").Return(nil, &plank.FailedResponse{StatusCode: 404}).Times(1)
+	permissionsValidator := FiatPermissionsValidator{client: mockedPlankClient}
+
+	assert.Nil(t, permissionsValidator.ValidateResources(pusher, Resources{Application: "Any", Accounts: map[string][]string{"deploy": {"prod"}}}))
+	assert.Equal(t, UserNotFoundError, permissionsValidator.ValidateResources("unknown", Resources{Application: "Any"}))
+	assert.Equal(t, UserNameEmpty, permissionsValidator.ValidateResources("", Resources{Application: "Any"}))
+}
+
+func TestUserWriteAccessValidation_ValidateResources(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	d := Dinghyfile{
+		ApplicationSpec: plank.Application{Name: "ApplicationName"},
+		Pipelines: []plank.Pipeline{{
+			Name:  "deploy",
+			Roles: []string{"devops"},
+			Triggers: []map[string]interface{}{
+				{"type": "git", "runAsUser": "devops-svc"},
+				{"type": "cron", "runAsUser": "${ trigger.user }"},
+			},
+			Stages: []map[string]interface{}{
+				{"type": "deployManifest", "account": "prod"},
+				{"type": "deploy", "clusters": []interface{}{map[string]interface{}{"account": "prod"}}},
+			},
+		}},
+	}
+
+	mockedPlankClient := NewMockPlankClient(ctrl)
+	validation := UserWriteAccessValidation{Client: mockedPlankClient, Ignore: []string{"automation"}, Logger: testPipelineBuilder().Logger}
+	assert.Nil(t, validation.ValidateResources(d, pusher))
+
+	validation.Resources = true
+	assert.Nil(t, validation.ValidateResources(d, "automation"))
+
+	mockedPlankClient.EXPECT().GetApplication("ApplicationName", "").Return(&d.ApplicationSpec, nil).Times(1)
+	mockedPlankClient.EXPECT().GetUserPermissions(gomock.Eq(pusher), "").Return(testUserPermissions(), nil).Times(1)
+	assert.EqualError(t, validation.ValidateResources(d, pusher), "test_pusher is not authorized: can't deploy to account prod in pipeline deploy")
+}
+
 func TestWritePermissionsUserFilter_ShouldProcess(t *testing.T) {
 
 	tests := []struct {
@@ -93,7 +93,9 @@ func (execution *RedisToSQLMigration) Execute() (map[string]interface{}, error)
 
 		for _, parentValue := range files {
 			childrens := execution.RedisCache.GetChildren(parentValue)
-			execution.SQLClient.SetDeps(parentValue, childrens)
+			if err := execution.SQLClient.SetDeps(parentValue, childrens); err != nil {
+				execution.Logger.Errorf("Failed to migrate the dependencies of %v: %v", parentValue, err)
+			}
 			for _, currChildren := range childrens {
 				if _, ok := visited[currChildren]; !ok {
 					visited[currChildren] = true
@@ -0,0 +1,135 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package freeze
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleField is a field of a cron schedule, the minutes, hours... it fires at
+type scheduleField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// schedule is a parsed cron schedule, the values each field fires at
+type schedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday are set for *, when both days are restricted
+	// either of them fires the schedule, like cron does
+	anyDay, anyWeekday bool
+}
+
+func parseSchedule(expression string) (*schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("has %d fields, %d expected", len(fields), len(scheduleFields))
+	}
+	values := make([]map[int]bool, len(fields))
+	for i, field := range fields {
+		v, err := scheduleFields[i].parse(field)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	// Sunday is 0 or 7
+	if values[4][7] {
+		values[4][0] = true
+	}
+	return &schedule{
+		minutes:    values[0],
+		hours:      values[1],
+		days:       values[2],
+		months:     values[3],
+		weekdays:   values[4],
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (s *schedule) matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+func (f scheduleField) parse(field string) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid %s step %s", f.name, part[i+1:])
+			}
+			part = part[:i]
+		}
+		low, high := f.min, f.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return nil, err
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = f.value(bounds[1]); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				// n/step runs from n to the end
+				high = f.max
+			}
+			if high < low {
+				return nil, fmt.Errorf("invalid %s range %s", f.name, part)
+			}
+		}
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (f scheduleField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %s, it must be between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
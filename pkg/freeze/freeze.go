@@ -0,0 +1,215 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package freeze
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// timeLayouts of the dates with a time, in the timezone of the window
+var timeLayouts = []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02T15:04:05", "2006-01-02 15:04:05"}
+
+// Window is when changes are blocked, either between two dates or for a
+// while every time a cron schedule fires. Without orgs, repos and
+// applications it blocks everything.
+type Window struct {
+	Name string
+	// Start and End of a date range, End is excluded unless it is a day
+	Start string
+	End   string
+	// Cron is a schedule of 5 fields (minute hour day-of-month month day-of-week)
+	// starting the window for Duration
+	Cron     string
+	Duration time.Duration
+	// Timezone of the dates and schedule, UTC by default
+	Timezone string
+	// Globs of the orgs, repos and applications frozen
+	Orgs         []string
+	Repos        []string
+	Applications []string
+	// Message explains the freeze in the commit statuses
+	Message string
+}
+
+// Scope is what a change is made to
+type Scope struct {
+	Org         string
+	Repo        string
+	Application string
+}
+
+// Freeze is an active window and when it ends
+type Freeze struct {
+	Window Window
+	Until  time.Time
+}
+
+// Description describes the freeze for commit statuses and logs
+func (f Freeze) Description() string {
+	description := fmt.Sprintf("change freeze %s until %s", f.Window.Name, f.Until.Format("2006-01-02 15:04 MST"))
+	if f.Window.Message != "" {
+		description = fmt.Sprintf("%s (%s)", description, f.Window.Message)
+	}
+	return description
+}
+
+// Calendar is a set of parsed windows
+type Calendar struct {
+	windows []window
+}
+
+type window struct {
+	Window
+	location *time.Location
+	start    time.Time
+	end      time.Time
+	schedule *schedule
+}
+
+// New parses the windows, every window needs a name and either a date range
+// or a cron schedule with a duration.
+func New(windows []Window) (*Calendar, error) {
+	c := &Calendar{}
+	for i, w := range windows {
+		parsed, err := parse(w)
+		if err != nil {
+			name := w.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("invalid freeze window %s: %w", name, err)
+		}
+		c.windows = append(c.windows, parsed)
+	}
+	return c, nil
+}
+
+func parse(w Window) (window, error) {
+	parsed := window{Window: w, location: time.UTC}
+	if w.Name == "" {
+		return parsed, fmt.Errorf("a name is required")
+	}
+	if w.Timezone != "" {
+		location, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return parsed, err
+		}
+		parsed.location = location
+	}
+	switch {
+	case w.Cron != "" && (w.Start != "" || w.End != ""):
+		return parsed, fmt.Errorf("either a cron schedule or a date range is expected, not both")
+	case w.Cron != "":
+		if w.Duration <= 0 {
+			return parsed, fmt.Errorf("a duration is required with a cron schedule")
+		}
+		s, err := parseSchedule(w.Cron)
+		if err != nil {
+			return parsed, fmt.Errorf("cron %q: %w", w.Cron, err)
+		}
+		parsed.schedule = s
+	case w.Start != "" && w.End != "":
+		var err error
+		if parsed.start, err = parseDate(w.Start, parsed.location, false); err != nil {
+			return parsed, err
+		}
+		if parsed.end, err = parseDate(w.End, parsed.location, true); err != nil {
+			return parsed, err
+		}
+		if !parsed.end.After(parsed.start) {
+			return parsed, fmt.Errorf("it ends before it starts")
+		}
+	default:
+		return parsed, fmt.Errorf("a cron schedule or a start and end date are required")
+	}
+	return parsed, nil
+}
+
+// parseDate parses a date in the location. A day without a time is its
+// beginning, or the beginning of the next day when it ends a window, so
+// the last day is frozen too.
+func parseDate(value string, location *time.Location, end bool) (time.Time, error) {
+	if t, err := time.ParseInLocation(dateLayout, value, location); err == nil {
+		if end {
+			return t.AddDate(0, 0, 1), nil
+		}
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q, YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339 expected", value)
+	}
+	return t, nil
+}
+
+// Active returns the window freezing changes to scope at a time, the one
+// ending last when there are many, nil when there is none.
+func (c *Calendar) Active(now time.Time, scope Scope) *Freeze {
+	if c == nil {
+		return nil
+	}
+	var active *Freeze
+	for _, w := range c.windows {
+		if !w.covers(scope) {
+			continue
+		}
+		until, ok := w.until(now)
+		if ok && (active == nil || until.After(active.Until)) {
+			active = &Freeze{Window: w.Window, Until: until}
+		}
+	}
+	return active
+}
+
+func (w window) covers(scope Scope) bool {
+	return matchesAny(w.Orgs, scope.Org) && matchesAny(w.Repos, scope.Repo) && matchesAny(w.Applications, scope.Application)
+}
+
+// matchesAny checks if a value matches one of the globs, no globs match everything
+func matchesAny(globs []string, value string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, value); matched || strings.EqualFold(glob, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// until returns when the window active at now ends, a scheduled window is
+// active when the schedule fired less than its duration ago.
+func (w window) until(now time.Time) (time.Time, bool) {
+	now = now.In(w.location)
+	if w.schedule == nil {
+		return w.end, !now.Before(w.start) && now.Before(w.end)
+	}
+	for t := now.Truncate(time.Minute); now.Sub(t) < w.Duration; t = t.Add(-time.Minute) {
+		if w.schedule.matches(t) {
+			return t.Add(w.Duration), true
+		}
+	}
+	return time.Time{}, false
+}
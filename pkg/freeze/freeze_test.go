@@ -0,0 +1,113 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package freeze
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func at(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestDateRange(t *testing.T) {
+	c, err := New([]Window{{Name: "holidays", Start: "2022-12-23", End: "2022-12-26", Timezone: "America/New_York"}})
+	assert.Nil(t, err)
+	scope := Scope{Org: "org", Repo: "repo", Application: "app"}
+
+	assert.Nil(t, c.Active(at("2022-12-23T04:59:00Z"), scope))
+	active := c.Active(at("2022-12-23T05:00:00Z"), scope)
+	assert.NotNil(t, active)
+	assert.Equal(t, "holidays", active.Window.Name)
+	// the last day is frozen too
+	assert.NotNil(t, c.Active(at("2022-12-27T04:59:00Z"), scope))
+	assert.Nil(t, c.Active(at("2022-12-27T05:00:00Z"), scope))
+	assert.Equal(t, "change freeze holidays until 2022-12-27 00:00 EST", active.Description())
+}
+
+func TestCronWindow(t *testing.T) {
+	// every friday from 17:00 for the weekend
+	c, err := New([]Window{{Name: "weekend", Cron: "0 17 * * FRI", Duration: 63 * time.Hour, Message: "no weekend deploys"}})
+	assert.Nil(t, err)
+	scope := Scope{}
+
+	assert.Nil(t, c.Active(at("2022-06-10T16:59:00Z"), scope))
+	active := c.Active(at("2022-06-10T17:00:30Z"), scope)
+	assert.NotNil(t, active)
+	assert.Equal(t, at("2022-06-13T08:00:00Z"), active.Until)
+	assert.NotNil(t, c.Active(at("2022-06-12T23:00:00Z"), scope))
+	assert.Nil(t, c.Active(at("2022-06-13T08:00:00Z"), scope))
+	assert.Equal(t, "change freeze weekend until 2022-06-13 08:00 UTC (no weekend deploys)", active.Description())
+}
+
+func TestScopes(t *testing.T) {
+	c, err := New([]Window{
+		{Name: "payments", Start: "2022-01-01", End: "2022-01-31", Applications: []string{"pay*"}},
+		{Name: "infra", Start: "2022-01-01", End: "2022-01-10", Orgs: []string{"infra"}, Repos: []string{"clusters"}},
+	})
+	assert.Nil(t, err)
+	now := at("2022-01-05T12:00:00Z")
+
+	assert.Equal(t, "payments", c.Active(now, Scope{Org: "infra", Repo: "clusters", Application: "payments"}).Window.Name)
+	assert.Equal(t, "infra", c.Active(now, Scope{Org: "infra", Repo: "clusters", Application: "dns"}).Window.Name)
+	assert.Nil(t, c.Active(now, Scope{Org: "infra", Repo: "other", Application: "dns"}))
+	assert.Nil(t, c.Active(now, Scope{Org: "other", Repo: "clusters", Application: "dns"}))
+
+	var disabled *Calendar
+	assert.Nil(t, disabled.Active(now, Scope{}))
+}
+
+func TestInvalidWindows(t *testing.T) {
+	for _, test := range []struct {
+		window   Window
+		expected string
+	}{
+		{Window{Start: "2022-01-01", End: "2022-01-02"}, "invalid freeze window #1: a name is required"},
+		{Window{Name: "w"}, "invalid freeze window w: a cron schedule or a start and end date are required"},
+		{Window{Name: "w", Cron: "0 17 * * 5"}, "invalid freeze window w: a duration is required with a cron schedule"},
+		{Window{Name: "w", Cron: "0 25 * * 5", Duration: time.Hour}, `invalid freeze window w: cron "0 25 * * 5": invalid hour 25, it must be between 0 and 23`},
+		{Window{Name: "w", Cron: "0 17 * *", Duration: time.Hour}, `invalid freeze window w: cron "0 17 * *": has 4 fields, 5 expected`},
+		{Window{Name: "w", Start: "2022-02-01", End: "2022-01-01"}, "invalid freeze window w: it ends before it starts"},
+		{Window{Name: "w", Start: "tomorrow", End: "2022-01-01"}, `invalid freeze window w: invalid date "tomorrow", YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339 expected`},
+		{Window{Name: "w", Start: "2022-01-01", End: "2022-01-02", Timezone: "Mars/Olympus"}, "invalid freeze window w: unknown time zone Mars/Olympus"},
+	} {
+		_, err := New([]Window{test.window})
+		if assert.NotNil(t, err) {
+			assert.Equal(t, test.expected, err.Error())
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, err := parseSchedule("*/15 9-17 1,15 * MON-FRI")
+	assert.Nil(t, err)
+	// either day restriction fires the schedule
+	assert.True(t, s.matches(at("2022-06-01T09:15:00Z")))
+	assert.True(t, s.matches(at("2022-06-15T09:30:00Z")))
+	assert.True(t, s.matches(at("2022-06-06T17:45:00Z")))
+	assert.False(t, s.matches(at("2022-06-04T09:15:00Z")))
+	assert.False(t, s.matches(at("2022-06-06T09:10:00Z")))
+	assert.False(t, s.matches(at("2022-06-06T18:00:00Z")))
+
+	s, err = parseSchedule("0 0 * * 7")
+	assert.Nil(t, err)
+	assert.True(t, s.matches(at("2022-06-05T00:00:00Z")))
+}
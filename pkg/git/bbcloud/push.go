@@ -58,6 +58,13 @@ type WebhookPush struct {
 type WebhookChange struct {
 	New WebhookChangeComparison `json:"new"`
 	Old WebhookChangeComparison `json:"old"`
+	// Commits are the newest pushed commits, Bitbucket sends up to 5
+	Commits []WebhookCommit `json:"commits"`
+}
+
+type WebhookCommit struct {
+	Hash    string `json:"hash"`
+	Message string `json:"message"`
 }
 
 type WebhookChangeComparison struct {
@@ -299,6 +306,18 @@ func (p *Push) GetCommits() []string {
 	return []string{}
 }
 
+// CommitMessages returns the messages of the pushed commits, only the newest
+// ones of pushes of more than 5 commits
+func (p *Push) CommitMessages() []string {
+	messages := []string{}
+	for _, change := range p.changes() {
+		for _, commit := range change.Commits {
+			messages = append(messages, commit.Message)
+		}
+	}
+	return messages
+}
+
 // Name returns the name of the provider to be used in configuration
 func (p *Push) Name() string {
 	return "bitbucket-cloud"
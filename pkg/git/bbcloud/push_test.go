@@ -367,3 +367,14 @@ func TestIsMaster(t *testing.T) {
 		})
 	}
 }
+
+func TestCommitMessages(t *testing.T) {
+	payload := `{"push":{"changes":[{"new":{"name":"master"},"commits":[{"hash":"b","message":"Second\n\nFreeze-Override: outage"},{"hash":"a","message":"First"}]}]}}`
+	webhookPayload := WebhookPayload{}
+	if err := json.NewDecoder(bytes.NewBufferString(payload)).Decode(&webhookPayload); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	p := &Push{Payload: webhookPayload}
+	assert.Equal(t, []string{"Second\n\nFreeze-Override: outage", "First"}, p.CommitMessages())
+}
@@ -0,0 +1,64 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package github
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/plank/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// ApprovalNotifier comments the pushed commits whose changes to protected
+// applications are held for approval with their change request, it only
+// notifies approvers.
+type ApprovalNotifier struct {
+	Config Config
+	Logger log.FieldLogger
+}
+
+func (n *ApprovalNotifier) SendSuccess(org, repo, path string, notifications plank.NotificationsType, content map[string]interface{}) {
+}
+
+func (n *ApprovalNotifier) SendFailure(org, repo, path string, err error, notifications plank.NotificationsType, content map[string]interface{}) {
+}
+
+func (n *ApprovalNotifier) SendOnValidation() bool {
+	return false
+}
+
+// SendApprovalRequest comments the commit of a change request, change
+// requests of other providers or without a commit are skipped.
+func (n *ApprovalNotifier) SendApprovalRequest(org, repo, path string, notifications plank.NotificationsType, content map[string]interface{}) {
+	request, ok := content["changeRequest"].(approvals.ChangeRequest)
+	if !ok || request.Provider != "github" || request.Commit == "" {
+		return
+	}
+	approvers, _ := content["approvers"].([]string)
+	if err := n.Config.CommentCommit(org, repo, request.Commit, approvalRequestComment(request, approvers)); err != nil {
+		n.Logger.Errorf("Failed to comment change request %d on commit %s: %s", request.ID, request.Commit, err.Error())
+	}
+}
+
+func approvalRequestComment(request approvals.ChangeRequest, approvers []string) string {
+	who := "anyone but " + request.Pusher
+	if len(approvers) > 0 {
+		who = strings.Join(approvers, ", ")
+	}
+	return fmt.Sprintf("The changes of `%s` to the protected application **%s** are held for approval as change request %d.\n\n"+
+		"It can be approved by %s with `POST /v1/changerequests/approve?id=%d`, or rejected with `POST /v1/changerequests/reject?id=%d`.",
+		request.Path, request.Application, request.ID, who, request.ID, request.ID)
+}
@@ -0,0 +1,58 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/plank/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovalNotifierCommentsCommit(t *testing.T) {
+	comments := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST /api/v3/repos/armory/dinghy/commits/abc/comments", r.Method+" "+r.URL.Path)
+		comment := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&comment)
+		comments = append(comments, comment["body"])
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	var n notifiers.Notifier = &ApprovalNotifier{Config: Config{Endpoint: ts.URL}, Logger: logrus.New()}
+	approvalNotifier, ok := n.(notifiers.ApprovalNotifier)
+	assert.True(t, ok)
+
+	request := approvals.ChangeRequest{ID: 7, Application: "billing", Provider: "github", Path: "dinghyfile", Commit: "abc", Pusher: "someone"}
+	approvalNotifier.SendApprovalRequest("armory", "dinghy", "dinghyfile", plank.NotificationsType{}, map[string]interface{}{
+		"changeRequest": request,
+		"approvers":     []string{"release-manager"},
+	})
+	// change requests without a commit can't be commented
+	request.Commit = ""
+	approvalNotifier.SendApprovalRequest("armory", "dinghy", "dinghyfile", plank.NotificationsType{}, map[string]interface{}{
+		"changeRequest": request,
+	})
+
+	assert.Equal(t, []string{"The changes of `dinghyfile` to the protected application **billing** are held for approval as change request 7.\n\n" +
+		"It can be approved by release-manager with `POST /v1/changerequests/approve?id=7`, or rejected with `POST /v1/changerequests/reject?id=7`."}, comments)
+}
@@ -145,6 +145,46 @@ func (g *Config) GetPullRequest(org, repo, ref, sha string) (*github.PullRequest
 	}
 }
 
+// CommentPullRequest comments the open pull request with head branch that
+// contains sha. It does nothing if there is no such pull request.
+func (g *Config) CommentPullRequest(org, repo, branch, sha, body string) error {
+	ctx := context.Background()
+	client, err := newGitHubClient(ctx, g.Endpoint, g.Token)
+	if err != nil {
+		return err
+	}
+
+	pullRequests, _, err := client.PullRequests.ListPullRequestsWithCommit(ctx, org, repo, sha, nil)
+	if err != nil {
+		if e, ok := err.(*github.RateLimitError); ok {
+			return &util.GithubRateLimitErr{RateLimit: e.Rate.Limit, RateReset: e.Rate.Reset.String()}
+		}
+		return err
+	}
+	for _, pr := range pullRequests {
+		if pr.GetState() == "open" && pr.GetHead().GetRef() == branch {
+			_, _, err = client.Issues.CreateComment(ctx, org, repo, pr.GetNumber(), &github.IssueComment{Body: &body})
+			return err
+		}
+	}
+	return nil
+}
+
+// CommentCommit comments a commit.
+func (g *Config) CommentCommit(org, repo, sha, body string) error {
+	ctx := context.Background()
+	client, err := newGitHubClient(ctx, g.Endpoint, g.Token)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = client.Repositories.CreateComment(ctx, org, repo, sha, &github.RepositoryComment{Body: &body})
+	if e, ok := err.(*github.RateLimitError); ok {
+		return &util.GithubRateLimitErr{RateLimit: e.Rate.Limit, RateReset: e.Rate.Reset.String()}
+	}
+	return err
+}
+
 func (g *Config) GetShaFromRawData(rawPushData []byte) string {
 
 	// deserialze push data to a map.  used in template logic later
@@ -0,0 +1,90 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package github
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/google/go-github/v33/github"
+)
+
+// pollPayload mimics the push webhook payload for the changes found polling a
+// repository, only the fields used by dinghy are included.
+type pollPayload struct {
+	Ref        string     `json:"ref"`
+	Before     string     `json:"before"`
+	After      string     `json:"after"`
+	Repository Repository `json:"repository"`
+	Commits    []Commit   `json:"commits"`
+	Pusher     Pusher     `json:"pusher"`
+}
+
+// HeadSha returns the sha of the last commit of a branch
+func (g *Config) HeadSha(org, repo, branch string) (string, error) {
+	ctx := context.Background()
+	client, err := newGitHubClient(ctx, g.Endpoint, g.Token)
+	if err != nil {
+		return "", err
+	}
+
+	b, _, err := client.Repositories.GetBranch(ctx, org, repo, branch)
+	if err != nil {
+		if e, ok := err.(*github.RateLimitError); ok {
+			return "", &util.GithubRateLimitErr{RateLimit: e.Rate.Limit, RateReset: e.Rate.Reset.String()}
+		}
+		return "", err
+	}
+	return b.GetCommit().GetSHA(), nil
+}
+
+// PushPayload returns a push webhook payload with the files changed between
+// two commits of a branch, pushed by pusher. The commit authors are not used
+// as anyone can set them.
+func (g *Config) PushPayload(org, repo, branch, base, head, pusher string) ([]byte, error) {
+	ctx := context.Background()
+	client, err := newGitHubClient(ctx, g.Endpoint, g.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison, _, err := client.Repositories.CompareCommits(ctx, org, repo, base, head)
+	if err != nil {
+		if e, ok := err.(*github.RateLimitError); ok {
+			return nil, &util.GithubRateLimitErr{RateLimit: e.Rate.Limit, RateReset: e.Rate.Reset.String()}
+		}
+		return nil, err
+	}
+
+	commit := Commit{ID: head, Added: []string{}, Modified: []string{}}
+	for _, file := range comparison.Files {
+		switch file.GetStatus() {
+		case "added", "renamed", "copied":
+			commit.Added = append(commit.Added, file.GetFilename())
+		case "removed":
+		default:
+			commit.Modified = append(commit.Modified, file.GetFilename())
+		}
+	}
+
+	return json.Marshal(pollPayload{
+		Ref:        branch,
+		Before:     base,
+		After:      head,
+		Repository: Repository{Name: repo, Organization: org},
+		Commits:    []Commit{commit},
+		Pusher:     Pusher{Name: pusher},
+	})
+}
@@ -0,0 +1,48 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package github
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushPayloadIgnoresCommitAuthors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/repos/my-org/my-repo/compare/abc...def", r.URL.Path)
+		fmt.Fprint(w, `{
+			"commits": [{"sha": "def", "author": {"login": "admin"}, "commit": {"author": {"name": "Admin"}}}],
+			"files": [
+				{"filename": "dinghyfile", "status": "modified"},
+				{"filename": "new/dinghyfile", "status": "added"},
+				{"filename": "old/dinghyfile", "status": "removed"}
+			]
+		}`)
+	}))
+	defer ts.Close()
+
+	g := &Config{Endpoint: ts.URL}
+	raw, err := g.PushPayload("my-org", "my-repo", "main", "abc", "def", "dinghy-poller")
+	assert.Nil(t, err)
+
+	p := Push{}
+	assert.Nil(t, json.Unmarshal(raw, &p))
+	assert.Equal(t, "dinghy-poller", p.PusherName())
+	assert.Equal(t, []string{"new/dinghyfile", "dinghyfile"}, p.Files())
+}
@@ -35,6 +35,7 @@ type Push struct {
 // Commit is a commit received from Github webhook
 type Commit struct {
 	ID       string   `json:"id"`
+	Message  string   `json:"message"`
 	Added    []string `json:"added"`
 	Modified []string `json:"modified"`
 }
@@ -131,3 +132,20 @@ func (p *Push) Name() string {
 func (p *Push) PusherName() string {
 	return p.Pusher.Name
 }
+
+// CommitMessages returns the messages of the pushed commits
+func (p *Push) CommitMessages() []string {
+	messages := []string{}
+	for _, c := range p.Commits {
+		messages = append(messages, c.Message)
+	}
+	return messages
+}
+
+// CommentPullRequest comments the open pull request of the pushed branch
+func (p *Push) CommentPullRequest(body string) error {
+	if len(p.Commits) == 0 {
+		return nil
+	}
+	return p.Config.CommentPullRequest(p.Org(), p.Repo(), strings.Replace(p.Branch(), "refs/heads/", "", 1), p.Commits[len(p.Commits)-1].ID, body)
+}
@@ -0,0 +1,103 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/armory/dinghy/pkg/settings/global"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// pollCommit is a commit of the push webhook payload
+type pollCommit struct {
+	ID       string   `json:"id"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+}
+
+// pollPayload mimics the push webhook payload for the changes found polling a
+// repository, only the fields used by dinghy are included.
+type pollPayload struct {
+	ObjectKind  string `json:"object_kind"`
+	Ref         string `json:"ref"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"`
+	UserName    string `json:"user_name"`
+	Project     struct {
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	Commits []pollCommit `json:"commits"`
+}
+
+// NewFileService returns a file service using the gitlab settings
+func NewFileService(cfg *global.Settings) (*FileService, error) {
+	fs := &FileService{
+		Client: gitlab.NewClient(nil, cfg.GitLabToken),
+	}
+	// Note:  SetBaseURL will ensure a trailing slash as needed.
+	err := fs.Client.SetBaseURL(cfg.GitLabEndpoint)
+	return fs, err
+}
+
+// HeadSha returns the sha of the last commit of a branch
+func (f *FileService) HeadSha(org, repo, branch string) (string, error) {
+	pid := fmt.Sprintf("%s/%s", org, repo)
+	b, _, err := f.Client.Branches.GetBranch(pid, branch)
+	if err != nil {
+		return "", err
+	}
+	if b.Commit == nil {
+		return "", fmt.Errorf("branch %s of %s has no commits", branch, pid)
+	}
+	return b.Commit.ID, nil
+}
+
+// PushPayload returns a push webhook payload with the files changed between
+// two commits of a branch, pushed by pusher. The commit authors are not used
+// as they are not verified by GitLab.
+func (f *FileService) PushPayload(org, repo, branch, base, head, pusher string) ([]byte, error) {
+	pid := fmt.Sprintf("%s/%s", org, repo)
+	comparison, _, err := f.Client.Repositories.Compare(pid, &gitlab.CompareOptions{From: &base, To: &head})
+	if err != nil {
+		return nil, err
+	}
+
+	commit := pollCommit{ID: head, Added: []string{}, Modified: []string{}}
+	for _, diff := range comparison.Diffs {
+		switch {
+		case diff.DeletedFile:
+		case diff.NewFile || diff.RenamedFile:
+			commit.Added = append(commit.Added, diff.NewPath)
+		default:
+			commit.Modified = append(commit.Modified, diff.NewPath)
+		}
+	}
+
+	payload := pollPayload{
+		ObjectKind:  "push",
+		Ref:         "refs/heads/" + branch,
+		Before:      base,
+		After:       head,
+		CheckoutSHA: head,
+		UserName:    pusher,
+		Commits:     []pollCommit{commit},
+	}
+	payload.Project.Name = repo
+	payload.Project.PathWithNamespace = pid
+	return json.Marshal(payload)
+}
@@ -104,6 +104,17 @@ func (p *Push) PusherName() string {
 	return p.Event.UserName
 }
 
+// CommitMessages returns the messages of the pushed commits
+func (p *Push) CommitMessages() []string {
+	messages := []string{}
+	for _, c := range p.Event.Commits {
+		if c != nil {
+			messages = append(messages, c.Message)
+		}
+	}
+	return messages
+}
+
 // ParseWebhook parses the webhook into the struct and returns a file service
 // instance (and error)
 func (p *Push) ParseWebhook(cfg *global.Settings, body []byte) (FileService, error) {
@@ -141,6 +141,81 @@ func (p *Push) getFilesChanged(fromCommitHash, toCommitHash string, start int) (
 	return
 }
 
+// CommitsResponse is a page of the commits of a Stash repository
+type CommitsResponse struct {
+	PagedAPIResponse
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"values"`
+}
+
+// CommitMessages returns the messages of the pushed commits, the webhooks
+// don't send them so they are requested to Stash.
+func (p *Push) CommitMessages() []string {
+	messages := []string{}
+	for _, change := range p.changes() {
+		for start := -1; start != 0; {
+			page, nextStart, err := p.getCommitMessages(change.FromHash, change.ToHash, start)
+			if err != nil {
+				p.Logger.Warnf("Could not get the commit messages of %s: %s", change.ToHash, err.Error())
+				break
+			}
+			messages = append(messages, page...)
+			start = nextStart
+		}
+	}
+	return messages
+}
+
+func (p *Push) getCommitMessages(fromCommitHash, toCommitHash string, start int) (messages []string, nextStart int, err error) {
+	url := fmt.Sprintf(
+		`%s/projects/%s/repos/%s/commits`,
+		p.StashEndpoint,
+		p.Payload.Repository.Project.Key,
+		p.Payload.Repository.Slug,
+	)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := req.URL.Query()
+	query.Add("until", toCommitHash)
+	// new branches are pushed from a zero hash
+	if strings.Trim(fromCommitHash, "0") != "" {
+		query.Add("since", fromCommitHash)
+	} else {
+		query.Add("limit", "1")
+	}
+	if start != -1 {
+		query.Add("start", strconv.Itoa(start))
+	}
+	req.URL.RawQuery = query.Encode()
+	req.SetBasicAuth(p.StashUsername, p.StashToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("got %d from retrieving commits", resp.StatusCode)
+	}
+	var body CommitsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, 0, err
+	}
+	for _, commit := range body.Commits {
+		messages = append(messages, commit.Message)
+	}
+	if !body.IsLastPage && strings.Trim(fromCommitHash, "0") != "" {
+		nextStart = body.NextPageStart
+	}
+	return messages, nextStart, nil
+}
+
 type Config struct {
 	Username string
 	Token    string
@@ -20,8 +20,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestContainsFile(t *testing.T) {
@@ -118,3 +122,26 @@ func TestIsMaster(t *testing.T) {
 		})
 	}
 }
+
+func TestCommitMessages(t *testing.T) {
+	pages := map[string]string{
+		"":  `{"isLastPage":false,"nextPageStart":1,"values":[{"id":"b","message":"Second\n\nFreeze-Override: outage"}]}`,
+		"1": `{"isLastPage":true,"values":[{"id":"a","message":"First"}]}`,
+	}
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/projects/PRJ/repos/repo/commits", req.URL.Path)
+		assert.Equal(t, "b", req.URL.Query().Get("until"))
+		assert.Equal(t, "0a1b", req.URL.Query().Get("since"))
+		res.Write([]byte(pages[req.URL.Query().Get("start")]))
+	}))
+	defer testServer.Close()
+
+	payload := `{"repository":{"slug":"repo","project":{"key":"PRJ"}},"changes":[{"refId":"refs/heads/master","fromHash":"0a1b","toHash":"b"}]}`
+	webhookPayload := WebhookPayload{}
+	if err := json.NewDecoder(bytes.NewBufferString(payload)).Decode(&webhookPayload); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	p := &Push{Payload: webhookPayload, StashEndpoint: testServer.URL, Logger: dinghyfile.NewDinghylog()}
+	assert.Equal(t, []string{"Second\n\nFreeze-Override: outage", "First"}, p.CommitMessages())
+}
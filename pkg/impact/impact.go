@@ -0,0 +1,111 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package impact
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+)
+
+// ReportsClient stores the impact reports of template repo branches
+type ReportsClient interface {
+	// GetReport returns the report, or nil if there is none or its TTL expired.
+	GetReport(id string) (*Report, error)
+	SaveReport(report Report) error
+}
+
+// Report describes how the modules changed in a template repo branch affect
+// the dinghyfiles rendering them.
+type Report struct {
+	ID          string                    `json:"id" yaml:"id"`
+	Provider    string                    `json:"provider" yaml:"provider"`
+	Org         string                    `json:"org" yaml:"org"`
+	Repo        string                    `json:"repo" yaml:"repo"`
+	Branch      string                    `json:"branch" yaml:"branch"`
+	PullRequest string                    `json:"pullRequest,omitempty" yaml:"pullRequest,omitempty"`
+	Modules     []dinghyfile.ModuleImpact `json:"modules" yaml:"modules"`
+	Date        int64                     `json:"date" yaml:"date"`
+}
+
+// ReportID identifies the latest report of a branch
+func ReportID(provider, org, repo, branch string) string {
+	return fmt.Sprintf("%s:%s/%s@%s", provider, org, repo, branch)
+}
+
+// Failed reports if any dinghyfile didn't render or validate
+func (r Report) Failed() bool {
+	for _, m := range r.Modules {
+		if m.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown renders the report for pull request comments
+func (r Report) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString("### Dinghy module impact report\n")
+	for _, m := range r.Modules {
+		fmt.Fprintf(&sb, "\n#### Module `%s`\n\n", m.Path)
+		if len(m.Dinghyfiles) == 0 {
+			sb.WriteString("No dinghyfiles depend on this module.\n")
+			continue
+		}
+		sb.WriteString("| Dinghyfile | Application | Rendered | Validated | Pipelines |\n")
+		sb.WriteString("|---|---|---|---|---|\n")
+		for _, d := range m.Dinghyfiles {
+			fmt.Fprintf(&sb, "| `%s/%s/%s` | %s | %s | %s | %s |\n",
+				d.Org, d.Repo, d.Path, d.Application, check(d.Rendered), check(d.Validated), pipelineSummary(d.Pipelines))
+		}
+		for _, d := range m.Dinghyfiles {
+			if d.Error != "" {
+				fmt.Fprintf(&sb, "\n`%s/%s/%s` failed: %s\n", d.Org, d.Repo, d.Path, d.Error)
+			}
+			for _, p := range d.Pipelines {
+				if p.Diff == "" {
+					continue
+				}
+				fmt.Fprintf(&sb, "\n<details><summary>%s / %s (%s)</summary>\n\n```diff\n%s```\n</details>\n", d.Application, p.Name, p.Change, p.Diff)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func check(ok bool) string {
+	if ok {
+		return "yes"
+	}
+	return "**no**"
+}
+
+func pipelineSummary(pipelines []dinghyfile.PipelineImpact) string {
+	counts := map[string]int{}
+	for _, p := range pipelines {
+		counts[p.Change]++
+	}
+	summary := []string{}
+	for _, change := range []string{dinghyfile.PipelineCreated, dinghyfile.PipelineUpdated, dinghyfile.PipelineDeleted, dinghyfile.PipelineUnchanged} {
+		if counts[change] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[change], change))
+		}
+	}
+	if len(summary) == 0 {
+		return "-"
+	}
+	return strings.Join(summary, ", ")
+}
@@ -0,0 +1,58 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package impact
+
+import (
+	"sync"
+	"time"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// ReportMemoryClient serves the reports built by this replica, the report
+// of a branch replaces the previous one.
+type ReportMemoryClient struct {
+	MinutesTTL time.Duration
+	mutex      sync.Mutex
+	reports    map[string]Report
+}
+
+func NewReportMemoryClient(minutesTTL time.Duration) *ReportMemoryClient {
+	return &ReportMemoryClient{
+		MinutesTTL: minutesTTL,
+		reports:    map[string]Report{},
+	}
+}
+
+func (c *ReportMemoryClient) GetReport(id string) (*Report, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	report, found := c.reports[id]
+	if !found {
+		return nil, nil
+	}
+	if store.Expired(report.Date, c.MinutesTTL) {
+		delete(c.reports, id)
+		return nil, nil
+	}
+	return &report, nil
+}
+
+func (c *ReportMemoryClient) SaveReport(report Report) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	report.Date = store.Now()
+	c.reports[report.ID] = report
+	return nil
+}
@@ -0,0 +1,41 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package impact
+
+import (
+	"time"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// ReportRedisClient keeps the latest report of every branch in its own key,
+// expiring with it.
+type ReportRedisClient struct {
+	MinutesTTL  time.Duration
+	RedisClient *cache.RedisCache
+}
+
+func (c ReportRedisClient) GetReport(id string) (*Report, error) {
+	var report Report
+	if found, err := store.GetJSON(c.RedisClient.Client, cache.CompileKey("impact", id), &report); !found {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (c ReportRedisClient) SaveReport(report Report) error {
+	report.Date = store.Now()
+	return store.SetJSON(c.RedisClient.Client, cache.CompileKey("impact", report.ID), report, c.MinutesTTL)
+}
@@ -0,0 +1,70 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package impact
+
+import (
+	"time"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// ReportSQLClient keeps the reports encoded in the impactreports table, the
+// expired ones are deleted as new ones are saved.
+type ReportSQLClient struct {
+	MinutesTTL time.Duration
+	SQLClient  *database.SQLClient
+}
+
+func (ReportSQL) TableName() string {
+	return "impactreports"
+}
+
+type ReportSQL struct {
+	Id     string `gorm:"primaryKey;column:id"`
+	Report string `gorm:"column:report"`
+	Date   int64  `gorm:"column:reportdate"`
+}
+
+func (c ReportSQLClient) GetReport(id string) (*Report, error) {
+	found := []ReportSQL{}
+	result := c.SQLClient.Client.Where("id = ? AND reportdate >= ?", id, store.Cutoff(c.MinutesTTL)).Limit(1).Find(&found)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	var report Report
+	if err := store.FromJSONColumn(found[0].Report, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (c ReportSQLClient) SaveReport(report Report) error {
+	if err := store.DeleteExpired(c.SQLClient.Client, &ReportSQL{}, "reportdate", c.MinutesTTL); err != nil {
+		return err
+	}
+	report.Date = store.Now()
+	column, err := store.JSONColumn(report)
+	if err != nil {
+		return err
+	}
+	return c.SQLClient.Client.Save(&ReportSQL{
+		Id:     report.ID,
+		Report: column,
+		Date:   report.Date,
+	}).Error
+}
@@ -0,0 +1,142 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package impact
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func testReport() Report {
+	return Report{
+		ID:       ReportID("github", "org", "templates", "feature"),
+		Provider: "github",
+		Org:      "org",
+		Repo:     "templates",
+		Branch:   "feature",
+		Modules: []dinghyfile.ModuleImpact{
+			{
+				Path: "mod1",
+				Dinghyfiles: []dinghyfile.DinghyfileImpact{
+					{
+						Org: "org", Repo: "app", Path: "dinghyfile", Application: "app",
+						Rendered: true, Validated: true,
+						Pipelines: []dinghyfile.PipelineImpact{
+							{Name: "deploy", Change: dinghyfile.PipelineUpdated, Diff: "-  \"a\": 1\n+  \"a\": 2\n"},
+							{Name: "build", Change: dinghyfile.PipelineUnchanged},
+						},
+					},
+					{Org: "org", Repo: "other", Path: "dinghyfile", Error: "rendering failed"},
+				},
+			},
+			{Path: "mod2", Dinghyfiles: []dinghyfile.DinghyfileImpact{}},
+		},
+	}
+}
+
+func TestReportMarkdown(t *testing.T) {
+	report := testReport()
+	assert.True(t, report.Failed())
+
+	md := report.Markdown()
+	assert.Contains(t, md, "#### Module `mod1`")
+	assert.Contains(t, md, "| `org/app/dinghyfile` | app | yes | yes | 1 updated, 1 unchanged |")
+	assert.Contains(t, md, "| `org/other/dinghyfile` |  | **no** | **no** | - |")
+	assert.Contains(t, md, "`org/other/dinghyfile` failed: rendering failed")
+	assert.Contains(t, md, "<details><summary>app / deploy (updated)</summary>\n\n```diff\n-  \"a\": 1\n+  \"a\": 2\n```")
+	assert.NotContains(t, md, "app / build")
+	assert.Contains(t, md, "#### Module `mod2`\n\nNo dinghyfiles depend on this module.")
+}
+
+func TestReportMemoryClient(t *testing.T) {
+	c := NewReportMemoryClient(60)
+
+	found, err := c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	assert.Nil(t, c.SaveReport(testReport()))
+	found, err = c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Equal(t, "feature", found.Branch)
+	assert.NotZero(t, found.Date)
+
+	// a new report of the branch replaces the previous one
+	report := testReport()
+	report.PullRequest = "https://github.com/org/templates/pull/2"
+	report.Modules = report.Modules[1:]
+	assert.Nil(t, c.SaveReport(report))
+	found, err = c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Equal(t, report.PullRequest, found.PullRequest)
+	assert.False(t, found.Failed())
+
+	c.reports["github:org/templates@feature"] = Report{Date: 1}
+	found, err = c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+	assert.Empty(t, c.reports)
+
+	// reports are kept forever without a TTL
+	forever := NewReportMemoryClient(0)
+	forever.reports["github:org/templates@feature"] = Report{Date: 1}
+	found, err = forever.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.NotNil(t, found)
+}
+
+func TestReportSQLClient(t *testing.T) {
+	config := &database.SQLConfig{Driver: database.SQLiteDriver, DbName: filepath.Join(t.TempDir(), "dinghy.db")}
+	sqlClient, err := database.NewSQLClient(config, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	c := ReportSQLClient{MinutesTTL: 60, SQLClient: sqlClient}
+
+	assert.Nil(t, c.SaveReport(testReport()))
+	found, err := c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Equal(t, testReport().Modules, found.Modules)
+	assert.True(t, found.Failed())
+
+	// expired reports aren't served, and go away when another one is saved
+	old := time.Now().Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)
+	assert.Nil(t, sqlClient.Client.Model(&ReportSQL{}).Where("id = ?", found.ID).Update("reportdate", old).Error)
+	found, err = c.GetReport("github:org/templates@feature")
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	other := testReport()
+	other.ID = ReportID("github", "org", "templates", "other")
+	assert.Nil(t, c.SaveReport(other))
+	var rows int64
+	assert.Nil(t, sqlClient.Client.Model(&ReportSQL{}).Count(&rows).Error)
+	assert.Equal(t, int64(1), rows)
+
+	// reports are kept forever without a TTL
+	c.MinutesTTL = 0
+	assert.Nil(t, sqlClient.Client.Model(&ReportSQL{}).Where("id = ?", other.ID).Update("reportdate", old).Error)
+	found, err = c.GetReport(other.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, found)
+	assert.Nil(t, c.SaveReport(testReport()))
+	assert.Nil(t, sqlClient.Client.Model(&ReportSQL{}).Count(&rows).Error)
+	assert.Equal(t, int64(2), rows)
+}
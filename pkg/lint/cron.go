@@ -0,0 +1,142 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cronField is a field of a Quartz cron expression, the format of Spinnaker cron triggers
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var (
+	cronFields = []cronField{
+		{name: "seconds", min: 0, max: 59},
+		{name: "minutes", min: 0, max: 59},
+		{name: "hours", min: 0, max: 23},
+		{name: "day of month", min: 1, max: 31},
+		{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+		{name: "day of week", min: 1, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+		{name: "year", min: 1970, max: 2099},
+	}
+
+	dayOfMonthSpecial = regexp.MustCompile(`^(L(-\d+)?|LW|\d+W)$`)
+	dayOfWeekSpecial  = regexp.MustCompile(`^(\w+L|\w+#\d+|L)$`)
+)
+
+const (
+	dayOfMonth = 3
+	dayOfWeek  = 5
+)
+
+// ValidateCron checks a Quartz cron expression: seconds, minutes, hours,
+// day of month, month, day of week and an optional year.
+func ValidateCron(expression string) error {
+	fields := strings.Fields(expression)
+	if len(fields) != 6 && len(fields) != 7 {
+		return fmt.Errorf("has %d fields, 6 or 7 expected", len(fields))
+	}
+	for i, field := range fields {
+		if err := cronFields[i].validate(field, i); err != nil {
+			return err
+		}
+	}
+	// Quartz requires one of the days to be ? and doesn't support both
+	if (fields[dayOfMonth] == "?") == (fields[dayOfWeek] == "?") {
+		return fmt.Errorf("one, and only one, of day of month and day of week must be ?")
+	}
+	return nil
+}
+
+func (f cronField) validate(field string, position int) error {
+	if field == "?" {
+		if position != dayOfMonth && position != dayOfWeek {
+			return fmt.Errorf("? is only allowed for day of month and day of week")
+		}
+		return nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		if position == dayOfMonth && dayOfMonthSpecial.MatchString(part) {
+			continue
+		}
+		if position == dayOfWeek && dayOfWeekSpecial.MatchString(part) {
+			if err := f.validateSpecialDay(part); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := f.validatePart(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateSpecialDay checks the day of nL (last day n of the month) and n#m (the m day n)
+func (f cronField) validateSpecialDay(part string) error {
+	if part == "L" {
+		return nil
+	}
+	day := strings.TrimSuffix(part, "L")
+	if i := strings.Index(part, "#"); i >= 0 {
+		day = part[:i]
+		if nth, _ := strconv.Atoi(part[i+1:]); nth < 1 || nth > 5 {
+			return fmt.Errorf("%s: the week of the month must be between 1 and 5", part)
+		}
+	}
+	_, err := f.value(day)
+	return err
+}
+
+func (f cronField) validatePart(part string) error {
+	rangePart := part
+	if i := strings.Index(part, "/"); i >= 0 {
+		rangePart = part[:i]
+		step, err := strconv.Atoi(part[i+1:])
+		if err != nil || step < 1 {
+			return fmt.Errorf("%s: invalid %s increment %s", part, f.name, part[i+1:])
+		}
+	}
+	if rangePart == "*" {
+		return nil
+	}
+	bounds := strings.SplitN(rangePart, "-", 2)
+	for _, bound := range bounds {
+		if _, err := f.value(bound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %s, it must be between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,198 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/armory/plank/v4"
+)
+
+// Severity of the findings of a rule
+type Severity string
+
+const (
+	// SeverityOff disables a rule
+	SeverityOff Severity = "off"
+	// SeverityWarn findings are reported
+	SeverityWarn Severity = "warn"
+	// SeverityError findings fail the dinghyfile
+	SeverityError Severity = "error"
+)
+
+// Context is what the rules check, the pipelines of a rendered dinghyfile.
+type Context struct {
+	Application string
+	Pipelines   []plank.Pipeline
+	// Lookup returns the pipelines of an application in Spinnaker, rules
+	// needing them are skipped when it is nil
+	Lookup func(application string) ([]plank.Pipeline, error)
+
+	lookups map[string][]plank.Pipeline
+}
+
+// existing returns the pipelines of an application in Spinnaker, false
+// when they can't be looked up.
+func (c *Context) existing(application string) ([]plank.Pipeline, bool) {
+	if c.Lookup == nil {
+		return nil, false
+	}
+	if c.lookups == nil {
+		c.lookups = map[string][]plank.Pipeline{}
+	}
+	if pipelines, found := c.lookups[application]; found {
+		return pipelines, true
+	}
+	pipelines, err := c.Lookup(application)
+	if err != nil {
+		return nil, false
+	}
+	c.lookups[application] = pipelines
+	return pipelines, true
+}
+
+// Problem is something wrong a rule found, Pipeline is empty when it isn't about a single pipeline.
+type Problem struct {
+	Pipeline string
+	Message  string
+}
+
+// Rule checks the pipelines of a dinghyfile.
+type Rule struct {
+	ID          string
+	Description string
+	// Default severity when the rule isn't configured
+	Default Severity
+	Check   func(c *Context) []Problem
+}
+
+// Finding is a problem found by a rule with the severity of the rule.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Pipeline string   `json:"pipeline,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	if f.Pipeline != "" {
+		return fmt.Sprintf("[%s] pipeline %s: %s", f.Rule, f.Pipeline, f.Message)
+	}
+	return fmt.Sprintf("[%s] %s", f.Rule, f.Message)
+}
+
+// Error fails a dinghyfile with error findings.
+type Error struct {
+	Findings []Finding
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Findings))
+	for i, f := range e.Findings {
+		messages[i] = f.String()
+	}
+	return fmt.Sprintf("dinghyfile failed lint: %s", strings.Join(messages, "; "))
+}
+
+// Report adds up the warnings of the dinghyfiles of a push.
+type Report struct {
+	mutex    sync.Mutex
+	Warnings []Finding
+}
+
+func (r *Report) Add(findings ...Finding) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Warnings = append(r.Warnings, findings...)
+}
+
+// Count returns the number of warnings.
+func (r *Report) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.Warnings)
+}
+
+var (
+	rulesMutex sync.RWMutex
+	rules      = map[string]Rule{}
+)
+
+// Register adds a rule, it replaces the rule with the same ID.
+func Register(rule Rule) {
+	rulesMutex.Lock()
+	defer rulesMutex.Unlock()
+	rules[rule.ID] = rule
+}
+
+// Rules returns the registered rules sorted by ID.
+func Rules() []Rule {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	sorted := make([]Rule, 0, len(rules))
+	for _, r := range rules {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+// Run checks the pipelines with every rule not turned off, severities
+// overrides the default severity of the rules by ID.
+func Run(c *Context, severities map[string]string) ([]Finding, error) {
+	all := Rules()
+	known := map[string]bool{}
+	for _, r := range all {
+		known[r.ID] = true
+	}
+	for id, severity := range severities {
+		if !known[id] {
+			return nil, fmt.Errorf("unknown lint rule %s", id)
+		}
+		switch Severity(severity) {
+		case SeverityOff, SeverityWarn, SeverityError:
+		default:
+			return nil, fmt.Errorf("lint rule %s: severity must be off, warn or error", id)
+		}
+	}
+
+	findings := []Finding{}
+	for _, r := range all {
+		severity := r.Default
+		if configured, ok := severities[r.ID]; ok {
+			severity = Severity(configured)
+		}
+		if severity == SeverityOff {
+			continue
+		}
+		for _, p := range r.Check(c) {
+			findings = append(findings, Finding{Rule: r.ID, Severity: severity, Pipeline: p.Pipeline, Message: p.Message})
+		}
+	}
+	return findings, nil
+}
+
+// WithSeverity returns the findings of a severity.
+func WithSeverity(findings []Finding, severity Severity) []Finding {
+	found := []Finding{}
+	for _, f := range findings {
+		if f.Severity == severity {
+			found = append(found, f)
+		}
+	}
+	return found
+}
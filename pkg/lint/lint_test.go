@@ -0,0 +1,139 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lint
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/armory/plank/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPipelines() []plank.Pipeline {
+	return []plank.Pipeline{
+		{
+			Name: "deploy",
+			Stages: []map[string]interface{}{
+				{"refId": "1", "name": "bake", "requisiteStageRefIds": []interface{}{}},
+				{"refId": "2", "name": "deploy", "requisiteStageRefIds": []interface{}{"1", "3"}},
+			},
+			Triggers: []map[string]interface{}{
+				{"type": "cron", "cronExpression": "0 0 12 * * ?"},
+				{"type": "cron", "cronExpression": "0 61 * * * ?"},
+				{"type": "pipeline", "application": "other", "pipeline": "missing", "enabled": false},
+				{"type": "pipeline", "application": "other", "pipeline": "build-id"},
+			},
+			Parameters: []map[string]interface{}{
+				{"name": "env", "hasOptions": true, "default": "qa", "options": []interface{}{
+					map[string]interface{}{"value": "dev"}, map[string]interface{}{"value": "prod"},
+				}},
+				{"name": "region", "hasOptions": true, "default": "us", "options": []interface{}{
+					map[string]interface{}{"value": "us"},
+				}},
+			},
+		},
+		{
+			Name: "deploy",
+			Stages: []map[string]interface{}{
+				{"refId": "1", "name": "wait", "waitTime": "${ trigger['parameters']['wait' }"},
+			},
+		},
+	}
+}
+
+func testLookup(application string) ([]plank.Pipeline, error) {
+	if application == "other" {
+		return []plank.Pipeline{{ID: "build-id", Name: "build"}}, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestRun(t *testing.T) {
+	findings, err := Run(&Context{Application: "app", Pipelines: testPipelines(), Lookup: testLookup}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []Finding{
+		{Rule: DuplicatePipelineName, Severity: SeverityError, Pipeline: "deploy", Message: "more than one pipeline has this name"},
+		{Rule: InvalidCron, Severity: SeverityError, Pipeline: "deploy", Message: `cron expression "0 61 * * * ?": invalid minutes 61, it must be between 0 and 59`},
+		{Rule: MalformedSpEL, Severity: SeverityError, Pipeline: "deploy", Message: "expression ${ trigger['parameters']['wait' }: expected ] but found }"},
+		{Rule: ParameterDefaultOptions, Severity: SeverityWarn, Pipeline: "deploy", Message: "parameter env defaults to qa, not one of its options (dev, prod)"},
+		{Rule: TriggerMissingPipeline, Severity: SeverityWarn, Pipeline: "deploy", Message: "disabled trigger of pipeline missing of application other, it doesn't exist"},
+		{Rule: UnknownStageRef, Severity: SeverityError, Pipeline: "deploy", Message: "stage deploy depends on unknown refId 3"},
+	}, findings)
+}
+
+func TestRunSeverities(t *testing.T) {
+	findings, err := Run(&Context{Application: "app", Pipelines: testPipelines()}, map[string]string{
+		DuplicatePipelineName:   "warn",
+		InvalidCron:             "off",
+		MalformedSpEL:           "off",
+		ParameterDefaultOptions: "error",
+		UnknownStageRef:         "off",
+	})
+	assert.Nil(t, err)
+	// the trigger rule is skipped without a lookup
+	assert.Equal(t, []Finding{
+		{Rule: DuplicatePipelineName, Severity: SeverityWarn, Pipeline: "deploy", Message: "more than one pipeline has this name"},
+		{Rule: ParameterDefaultOptions, Severity: SeverityError, Pipeline: "deploy", Message: "parameter env defaults to qa, not one of its options (dev, prod)"},
+	}, findings)
+	assert.Len(t, WithSeverity(findings, SeverityError), 1)
+
+	_, err = Run(&Context{}, map[string]string{"no-such-rule": "warn"})
+	assert.EqualError(t, err, "unknown lint rule no-such-rule")
+	_, err = Run(&Context{}, map[string]string{InvalidCron: "fatal"})
+	assert.EqualError(t, err, "lint rule invalid-cron: severity must be off, warn or error")
+}
+
+func TestValidateCron(t *testing.T) {
+	valid := []string{
+		"0 0 12 * * ?",
+		"0 15 10 ? * MON-FRI",
+		"0 0/5 14,18 * * ?",
+		"0 15 10 L * ?",
+		"0 15 10 ? * 6L 2002-2005",
+		"0 15 10 ? * 6#3",
+		"0 0 12 1/5 * ?",
+		"0 11 11 11 NOV ?",
+	}
+	for _, expression := range valid {
+		assert.Nil(t, ValidateCron(expression), expression)
+	}
+
+	invalid := map[string]string{
+		"* * * * *":         "has 5 fields, 6 or 7 expected",
+		"0 0 24 * * ?":      "invalid hours 24, it must be between 0 and 23",
+		"0 0 12 * * *":      "one, and only one, of day of month and day of week must be ?",
+		"0 0 12 ? * ?":      "one, and only one, of day of month and day of week must be ?",
+		"? 0 12 * * ?":      "? is only allowed for day of month and day of week",
+		"0 0/0 12 * * ?":    "0/0: invalid minutes increment 0",
+		"0 0 12 ? FOO MON":  "invalid month FOO, it must be between 1 and 12",
+		"0 15 10 ? * 6#6":   "6#6: the week of the month must be between 1 and 5",
+		"0 0 12 * * ? 1900": "invalid year 1900, it must be between 1970 and 2099",
+	}
+	for expression, message := range invalid {
+		assert.EqualError(t, ValidateCron(expression), message, expression)
+	}
+}
+
+func TestValidateSpEL(t *testing.T) {
+	assert.Nil(t, ValidateSpEL("plain text"))
+	assert.Nil(t, ValidateSpEL("${ trigger['tag'] } and ${ {'a': 1}['a'] } and ${ 'a}b' }"))
+	assert.Nil(t, ValidateSpEL("${ #stage('bake')['context'] }"))
+
+	assert.EqualError(t, ValidateSpEL("${ trigger['tag'] "), "expression ${ trigger['tag'] : isn't closed")
+	assert.EqualError(t, ValidateSpEL("ok ${ 'unclosed }"), "expression ${ 'unclosed }: unclosed ' string")
+	assert.EqualError(t, ValidateSpEL("${ fn(1] }"), "expression ${ fn(1] }: expected ) but found ]")
+	assert.EqualError(t, ValidateSpEL("${ a) }"), "expression ${ a) }: unexpected )")
+	assert.EqualError(t, ValidateSpEL("${ }"), "expression ${ } is empty")
+}
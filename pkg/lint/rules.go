@@ -0,0 +1,300 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DuplicatePipelineName   = "duplicate-pipeline-name"
+	UnknownStageRef         = "unknown-stage-ref"
+	TriggerMissingPipeline  = "trigger-missing-pipeline"
+	InvalidCron             = "invalid-cron"
+	MalformedSpEL           = "malformed-spel"
+	ParameterDefaultOptions = "parameter-default-options"
+)
+
+func init() {
+	Register(Rule{
+		ID:          DuplicatePipelineName,
+		Description: "Pipelines of a dinghyfile with the same name, only the last one is kept",
+		Default:     SeverityError,
+		Check:       duplicatePipelineNames,
+	})
+	Register(Rule{
+		ID:          UnknownStageRef,
+		Description: "Stages depending on a refId no stage of the pipeline has",
+		Default:     SeverityError,
+		Check:       unknownStageRefs,
+	})
+	Register(Rule{
+		ID:          TriggerMissingPipeline,
+		Description: "Pipeline triggers, enabled or disabled, of pipelines that don't exist",
+		Default:     SeverityWarn,
+		Check:       triggersMissingPipelines,
+	})
+	Register(Rule{
+		ID:          InvalidCron,
+		Description: "Cron triggers with an expression Spinnaker can't schedule",
+		Default:     SeverityError,
+		Check:       invalidCronTriggers,
+	})
+	Register(Rule{
+		ID:          MalformedSpEL,
+		Description: "SpEL expressions ${...} that aren't closed or balanced",
+		Default:     SeverityError,
+		Check:       malformedSpEL,
+	})
+	Register(Rule{
+		ID:          ParameterDefaultOptions,
+		Description: "Parameters with options whose default isn't one of them",
+		Default:     SeverityWarn,
+		Check:       parameterDefaultsOutsideOptions,
+	})
+}
+
+func duplicatePipelineNames(c *Context) []Problem {
+	problems := []Problem{}
+	count := map[string]int{}
+	for _, p := range c.Pipelines {
+		count[p.Name]++
+		if count[p.Name] == 2 {
+			problems = append(problems, Problem{Pipeline: p.Name, Message: "more than one pipeline has this name"})
+		}
+	}
+	return problems
+}
+
+func unknownStageRefs(c *Context) []Problem {
+	problems := []Problem{}
+	for _, p := range c.Pipelines {
+		refIds := map[string]bool{}
+		for _, stage := range p.Stages {
+			if refId, ok := stage["refId"]; ok {
+				refIds[fmt.Sprint(refId)] = true
+			}
+		}
+		for _, stage := range p.Stages {
+			requisites, _ := stage["requisiteStageRefIds"].([]interface{})
+			for _, requisite := range requisites {
+				if !refIds[fmt.Sprint(requisite)] {
+					problems = append(problems, Problem{
+						Pipeline: p.Name,
+						Message:  fmt.Sprintf("stage %v depends on unknown refId %v", stageName(stage), requisite),
+					})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func triggersMissingPipelines(c *Context) []Problem {
+	problems := []Problem{}
+	for _, p := range c.Pipelines {
+		for _, trigger := range p.Triggers {
+			if trigger["type"] != "pipeline" {
+				continue
+			}
+			id, _ := trigger["pipeline"].(string)
+			application, _ := trigger["application"].(string)
+			if id == "" {
+				problems = append(problems, Problem{Pipeline: p.Name, Message: "pipeline trigger without a pipeline"})
+				continue
+			}
+			if application == "" {
+				application = c.Application
+			}
+			existing, ok := c.existing(application)
+			if !ok {
+				continue
+			}
+			found := false
+			for _, e := range existing {
+				found = found || e.ID == id
+			}
+			if !found {
+				problems = append(problems, Problem{
+					Pipeline: p.Name,
+					Message:  fmt.Sprintf("%s trigger of pipeline %s of application %s, it doesn't exist", enabledWord(trigger), id, application),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+func enabledWord(trigger map[string]interface{}) string {
+	if enabled, ok := trigger["enabled"].(bool); ok && !enabled {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+func invalidCronTriggers(c *Context) []Problem {
+	problems := []Problem{}
+	for _, p := range c.Pipelines {
+		for _, trigger := range p.Triggers {
+			if trigger["type"] != "cron" {
+				continue
+			}
+			expression, _ := trigger["cronExpression"].(string)
+			if err := ValidateCron(expression); err != nil {
+				problems = append(problems, Problem{Pipeline: p.Name, Message: fmt.Sprintf("cron expression %q: %s", expression, err.Error())})
+			}
+		}
+	}
+	return problems
+}
+
+func malformedSpEL(c *Context) []Problem {
+	problems := []Problem{}
+	for _, p := range c.Pipelines {
+		var object interface{}
+		raw, err := json.Marshal(p)
+		if err != nil || json.Unmarshal(raw, &object) != nil {
+			continue
+		}
+		messages := []string{}
+		walkStrings(object, func(s string) {
+			if err := ValidateSpEL(s); err != nil {
+				messages = append(messages, err.Error())
+			}
+		})
+		sort.Strings(messages)
+		for _, message := range messages {
+			problems = append(problems, Problem{Pipeline: p.Name, Message: message})
+		}
+	}
+	return problems
+}
+
+func walkStrings(v interface{}, visit func(string)) {
+	switch value := v.(type) {
+	case string:
+		visit(value)
+	case []interface{}:
+		for _, item := range value {
+			walkStrings(item, visit)
+		}
+	case map[string]interface{}:
+		for _, item := range value {
+			walkStrings(item, visit)
+		}
+	}
+}
+
+// ValidateSpEL checks that the ${...} expressions of a string are closed and
+// their brackets and quotes balanced, it doesn't parse the expressions.
+func ValidateSpEL(s string) error {
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			return nil
+		}
+		end, err := expressionEnd(s[start+2:])
+		if err != nil {
+			return fmt.Errorf("expression %s: %s", abbreviate(s[start:]), err.Error())
+		}
+		if strings.TrimSpace(s[start+2:start+2+end]) == "" {
+			return fmt.Errorf("expression %s is empty", s[start:start+3+end])
+		}
+		s = s[start+3+end:]
+	}
+}
+
+// expressionEnd returns the index of the } closing an expression.
+func expressionEnd(s string) (int, error) {
+	closing := map[rune]rune{'(': ')', '[': ']', '{': '}'}
+	stack := []rune{}
+	var quote rune
+	for i, r := range s {
+		if quote != 0 {
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '\'', '"':
+			quote = r
+		case '(', '[', '{':
+			stack = append(stack, closing[r])
+		case ')', ']', '}':
+			if len(stack) == 0 {
+				if r == '}' {
+					return i, nil
+				}
+				return 0, fmt.Errorf("unexpected %c", r)
+			}
+			if stack[len(stack)-1] != r {
+				return 0, fmt.Errorf("expected %c but found %c", stack[len(stack)-1], r)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		return 0, fmt.Errorf("unclosed %c string", quote)
+	}
+	return 0, fmt.Errorf("isn't closed")
+}
+
+func abbreviate(s string) string {
+	if len(s) > 40 {
+		return s[:40] + "..."
+	}
+	return s
+}
+
+func parameterDefaultsOutsideOptions(c *Context) []Problem {
+	problems := []Problem{}
+	for _, p := range c.Pipelines {
+		for _, parameter := range p.Parameters {
+			if hasOptions, _ := parameter["hasOptions"].(bool); !hasOptions {
+				continue
+			}
+			options, _ := parameter["options"].([]interface{})
+			def, hasDefault := parameter["default"]
+			if !hasDefault || def == nil || fmt.Sprint(def) == "" || len(options) == 0 {
+				continue
+			}
+			values := []string{}
+			found := false
+			for _, option := range options {
+				o, _ := option.(map[string]interface{})
+				value := fmt.Sprint(o["value"])
+				values = append(values, value)
+				found = found || value == fmt.Sprint(def)
+			}
+			if !found {
+				problems = append(problems, Problem{
+					Pipeline: p.Name,
+					Message:  fmt.Sprintf("parameter %v defaults to %v, not one of its options (%s)", parameter["name"], def, strings.Join(values, ", ")),
+				})
+			}
+		}
+	}
+	return problems
+}
+
+func stageName(stage map[string]interface{}) interface{} {
+	if name, ok := stage["name"]; ok {
+		return name
+	}
+	return stage["refId"]
+}
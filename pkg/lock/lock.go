@@ -0,0 +1,135 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when a lock could not be acquired within the wait timeout
+	ErrTimeout = errors.New("timed out waiting for lock")
+	// ErrLost is returned when a lock expired or was taken over by another holder
+	ErrLost = errors.New("lock is no longer held")
+)
+
+// Locker hands out locks shared by every dinghy replica using the same backend.
+type Locker interface {
+	// Acquire blocks until the lock for key is obtained or the wait timeout expires
+	Acquire(key string) (Lock, error)
+}
+
+// Lock is a held lock.
+type Lock interface {
+	Key() string
+	// Token is a fencing token, it increases every time the key is acquired
+	Token() int64
+	// Check returns ErrLost when the lease expired and someone else may hold the key
+	Check() error
+	// Extend renews the lease, it returns ErrLost when the lock was already lost
+	Extend() error
+	Release() error
+}
+
+// Options are shared by all the Locker implementations.
+type Options struct {
+	// WaitTimeout is the maximum time Acquire waits for a held lock
+	WaitTimeout time.Duration
+	// Lease is the time after which an unreleased lock expires
+	Lease time.Duration
+	// RenewInterval is the time between the lease renewals of a held lock, so
+	// it only expires when its holder is gone. Zero disables the renewals.
+	RenewInterval time.Duration
+}
+
+// RepositoryKey is the lock key used when processing a push to a repository branch.
+func RepositoryKey(provider, org, repo, branch string) string {
+	return fmt.Sprintf("repository:%s:%s/%s:%s", provider, org, repo, branch)
+}
+
+// ApplicationKey is the lock key used when updating the pipelines of an application.
+func ApplicationKey(app string) string {
+	return fmt.Sprintf("application:%s", app)
+}
+
+// PollKey is the lock key held by the replica polling a repository branch.
+func PollKey(provider, org, repo, branch string) string {
+	return fmt.Sprintf("poll:%s:%s/%s:%s", provider, org, repo, branch)
+}
+
+// ReconciliationKey is the lock key held by the replica reconciling the dinghyfiles.
+const ReconciliationKey = "reconciliation"
+
+// retryDelay is the time to wait between attempts for backends without blocking primitives.
+func retryDelay(attempt int) time.Duration {
+	delay := 25 * time.Millisecond << uint(attempt)
+	if delay > 500*time.Millisecond || delay <= 0 {
+		return 500 * time.Millisecond
+	}
+	return delay
+}
+
+// keepAlive extends the lease of l on every interval until stop is closed or
+// the lock is lost.
+func keepAlive(l Lock, interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if errors.Is(l.Extend(), ErrLost) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// acquire tries to take a lock until the wait timeout expires, recording the
+// result in the contention metrics.
+func acquire(key string, waitTimeout time.Duration, try func() (Lock, error)) (Lock, error) {
+	start := time.Now()
+	deadline := start.Add(waitTimeout)
+	for attempt := 0; ; attempt++ {
+		l, err := try()
+		if err != nil {
+			observe(key, resultError, start)
+			return nil, err
+		}
+		if l != nil {
+			if attempt > 0 {
+				contended.WithLabelValues(scope(key)).Inc()
+			}
+			observe(key, resultAcquired, start)
+			return l, nil
+		}
+		delay := retryDelay(attempt)
+		if remaining := time.Until(deadline); remaining <= 0 {
+			contended.WithLabelValues(scope(key)).Inc()
+			observe(key, resultTimeout, start)
+			return nil, fmt.Errorf("%w: %s", ErrTimeout, key)
+		} else if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryLocker keeps locks in memory, it only serializes a single replica.
+// Held locks renew their lease on the renew interval until released.
+type MemoryLocker struct {
+	Options
+	mutex  sync.Mutex
+	held   map[string]memoryEntry
+	fences map[string]int64
+}
+
+type memoryEntry struct {
+	token   int64
+	expires time.Time
+}
+
+type memoryLock struct {
+	locker *MemoryLocker
+	key    string
+	token  int64
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewMemoryLocker(options Options) *MemoryLocker {
+	return &MemoryLocker{
+		Options: options,
+		held:    map[string]memoryEntry{},
+		fences:  map[string]int64{},
+	}
+}
+
+func (m *MemoryLocker) Acquire(key string) (Lock, error) {
+	return acquire(key, m.WaitTimeout, func() (Lock, error) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if entry, found := m.held[key]; found && time.Now().Before(entry.expires) {
+			return nil, nil
+		}
+		m.fences[key]++
+		m.held[key] = memoryEntry{token: m.fences[key], expires: time.Now().Add(m.Lease)}
+		l := &memoryLock{locker: m, key: key, token: m.fences[key], stop: make(chan struct{})}
+		keepAlive(l, m.RenewInterval, l.stop)
+		return l, nil
+	})
+}
+
+func (l *memoryLock) Key() string {
+	return l.key
+}
+
+func (l *memoryLock) Token() int64 {
+	return l.token
+}
+
+func (l *memoryLock) Check() error {
+	l.locker.mutex.Lock()
+	defer l.locker.mutex.Unlock()
+	entry, found := l.locker.held[l.key]
+	if !found || entry.token != l.token || time.Now().After(entry.expires) {
+		return ErrLost
+	}
+	return nil
+}
+
+func (l *memoryLock) Extend() error {
+	l.locker.mutex.Lock()
+	defer l.locker.mutex.Unlock()
+	entry, found := l.locker.held[l.key]
+	if !found || entry.token != l.token || time.Now().After(entry.expires) {
+		return ErrLost
+	}
+	entry.expires = time.Now().Add(l.locker.Lease)
+	l.locker.held[l.key] = entry
+	return nil
+}
+
+func (l *memoryLock) Release() error {
+	l.once.Do(func() { close(l.stop) })
+	l.locker.mutex.Lock()
+	defer l.locker.mutex.Unlock()
+	if entry, found := l.locker.held[l.key]; found && entry.token == l.token {
+		delete(l.locker.held, l.key)
+	}
+	return nil
+}
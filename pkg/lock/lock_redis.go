@@ -0,0 +1,113 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/go-redis/redis"
+)
+
+// releaseScript deletes the lock only if it is still owned by the token,
+// otherwise an expired holder could release a lock taken by someone else.
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// extendScript renews the lease of the lock only if it is still owned by the
+// token, it returns 0 when the lock expired or was taken over.
+var extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RedisLocker implements locks with SET NX PX, every acquisition increments a
+// fence counter whose value is stored in the lock and used as fencing token.
+// Held locks renew their lease on the renew interval until released.
+type RedisLocker struct {
+	Options
+	RedisClient *cache.RedisCache
+}
+
+type redisLock struct {
+	client *redis.Client
+	key    string
+	token  int64
+	lease  time.Duration
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func (r RedisLocker) Acquire(key string) (Lock, error) {
+	lockKey := cache.CompileKey("lock", key)
+	client := r.RedisClient.Client
+	return acquire(key, r.WaitTimeout, func() (Lock, error) {
+		// Checking before incrementing the fence avoids burning tokens while waiting
+		if held, err := client.Exists(lockKey).Result(); err != nil {
+			return nil, err
+		} else if held > 0 {
+			return nil, nil
+		}
+		token, err := client.Incr(cache.CompileKey("lockfence", key)).Result()
+		if err != nil {
+			return nil, err
+		}
+		ok, err := client.SetNX(lockKey, strconv.FormatInt(token, 10), r.Lease).Result()
+		if err != nil || !ok {
+			return nil, err
+		}
+		l := &redisLock{client: client, key: key, token: token, lease: r.Lease, stop: make(chan struct{})}
+		keepAlive(l, r.RenewInterval, l.stop)
+		return l, nil
+	})
+}
+
+func (l *redisLock) Key() string {
+	return l.key
+}
+
+func (l *redisLock) Token() int64 {
+	return l.token
+}
+
+func (l *redisLock) Check() error {
+	value, err := l.client.Get(cache.CompileKey("lock", l.key)).Result()
+	if err == redis.Nil || (err == nil && value != strconv.FormatInt(l.token, 10)) {
+		return ErrLost
+	}
+	return err
+}
+
+func (l *redisLock) Extend() error {
+	extended, err := extendScript.Run(l.client, []string{cache.CompileKey("lock", l.key)},
+		strconv.FormatInt(l.token, 10), l.lease.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if extended != 1 {
+		return ErrLost
+	}
+	return nil
+}
+
+func (l *redisLock) Release() error {
+	l.once.Do(func() { close(l.stop) })
+	return releaseScript.Run(l.client, []string{cache.CompileKey("lock", l.key)}, strconv.FormatInt(l.token, 10)).Err()
+}
@@ -0,0 +1,168 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/armory/dinghy/pkg/database"
+)
+
+// mysqlLockNameLimit is the maximum length of a MySQL user-level lock name,
+// PostgreSQL names are hashed so they are shortened the same way
+const mysqlLockNameLimit = 64
+
+// SQLLocker implements locks with the advisory locks of MySQL (GET_LOCK) or
+// PostgreSQL (pg_try_advisory_lock). Advisory locks belong to the session that
+// took them, so every lock holds a dedicated connection until released and the
+// lease is not used: the lock is released by the database as soon as the
+// connection is lost. The fencing tokens are counted by lock name in the
+// lockfences table.
+type SQLLocker struct {
+	Options
+	SQLClient *database.SQLClient
+}
+
+type sqlLock struct {
+	conn       *sql.Conn
+	statements lockStatements
+	key        string
+	name       string
+	token      int64
+}
+
+// lockStatements are the advisory lock queries of a database, every query
+// takes the lock name and returns 1 on success. fence, when set, increments the
+// fencing token of the lock name and token returns it, otherwise token does both.
+type lockStatements struct {
+	acquire string
+	check   string
+	release string
+	fence   string
+	token   string
+}
+
+var sqlLockStatements = map[string]lockStatements{
+	database.MySQLDriver: {
+		acquire: "SELECT GET_LOCK(?, 0)",
+		check:   "SELECT IS_USED_LOCK(?) = CONNECTION_ID()",
+		release: "SELECT RELEASE_LOCK(?)",
+		fence:   "INSERT INTO lockfences (lockname, token) VALUES (?, LAST_INSERT_ID(1)) ON DUPLICATE KEY UPDATE token = LAST_INSERT_ID(token + 1)",
+		token:   "SELECT LAST_INSERT_ID()",
+	},
+	// PostgreSQL locks are identified by a number, different names with the
+	// same hash share a lock. pg_locks splits the number in two halves.
+	database.PostgreSQLDriver: {
+		acquire: "SELECT pg_try_advisory_lock(hashtext($1))::int",
+		check: "SELECT count(*)::int FROM pg_locks WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid() AND objsubid = 1" +
+			" AND classid::bigint = (hashtext($1)::bigint >> 32) & 4294967295 AND objid::bigint = hashtext($1)::bigint & 4294967295",
+		release: "SELECT pg_advisory_unlock(hashtext($1))::int",
+		token: "INSERT INTO lockfences (lockname, token) VALUES ($1, 1)" +
+			" ON CONFLICT (lockname) DO UPDATE SET token = lockfences.token + 1 RETURNING token",
+	},
+}
+
+// lockName shortens long keys to fit MySQL lock names.
+func lockName(key string) string {
+	name := "dinghy:" + key
+	if len(name) <= mysqlLockNameLimit {
+		return name
+	}
+	sum := sha1.Sum([]byte(key))
+	return "dinghy:" + hex.EncodeToString(sum[:])
+}
+
+func (s SQLLocker) Acquire(key string) (Lock, error) {
+	statements, supported := sqlLockStatements[s.SQLClient.Client.Dialector.Name()]
+	if !supported {
+		return nil, fmt.Errorf("locks are not supported for %s databases", s.SQLClient.Client.Dialector.Name())
+	}
+	db, err := s.SQLClient.Client.DB()
+	if err != nil {
+		return nil, err
+	}
+	name := lockName(key)
+	return acquire(key, s.WaitTimeout, func() (Lock, error) {
+		ctx := context.Background()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, statements.acquire, name).Scan(&acquired); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if !acquired.Valid || acquired.Int64 != 1 {
+			conn.Close()
+			return nil, nil
+		}
+		token, err := fence(ctx, conn, statements, name)
+		if err != nil {
+			conn.ExecContext(ctx, statements.release, name)
+			conn.Close()
+			return nil, err
+		}
+		return &sqlLock{conn: conn, statements: statements, key: key, name: name, token: token}, nil
+	})
+}
+
+// fence increments the fencing token of a lock name on the connection holding
+// it, holders of the same name are serialized so the token keeps increasing.
+func fence(ctx context.Context, conn *sql.Conn, statements lockStatements, name string) (int64, error) {
+	var token int64
+	if statements.fence == "" {
+		err := conn.QueryRowContext(ctx, statements.token, name).Scan(&token)
+		return token, err
+	}
+	if _, err := conn.ExecContext(ctx, statements.fence, name); err != nil {
+		return 0, err
+	}
+	err := conn.QueryRowContext(ctx, statements.token).Scan(&token)
+	return token, err
+}
+
+func (l *sqlLock) Key() string {
+	return l.key
+}
+
+func (l *sqlLock) Token() int64 {
+	return l.token
+}
+
+func (l *sqlLock) Check() error {
+	var owned sql.NullInt64
+	if err := l.conn.QueryRowContext(context.Background(), l.statements.check, l.name).Scan(&owned); err != nil {
+		return err
+	}
+	if !owned.Valid || owned.Int64 != 1 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Extend only checks the lock, advisory locks have no lease.
+func (l *sqlLock) Extend() error {
+	return l.Check()
+}
+
+func (l *sqlLock) Release() error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(context.Background(), l.statements.release, l.name)
+	return err
+}
@@ -0,0 +1,133 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLockerSerializes(t *testing.T) {
+	locker := NewMemoryLocker(Options{WaitTimeout: 5 * time.Second, Lease: time.Minute})
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	running, maxRunning := 0, 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := locker.Acquire(ApplicationKey("app"))
+			if !assert.Nil(t, err) {
+				return
+			}
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			assert.Nil(t, l.Release())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, maxRunning)
+}
+
+func TestMemoryLockerTimeout(t *testing.T) {
+	locker := NewMemoryLocker(Options{WaitTimeout: 50 * time.Millisecond, Lease: time.Minute})
+
+	held, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+
+	_, err = locker.Acquire(ApplicationKey("app"))
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	other, err := locker.Acquire(ApplicationKey("other"))
+	assert.Nil(t, err)
+	assert.Nil(t, other.Release())
+	assert.Nil(t, held.Release())
+}
+
+func TestMemoryLockerFencing(t *testing.T) {
+	locker := NewMemoryLocker(Options{WaitTimeout: time.Second, Lease: 20 * time.Millisecond})
+
+	first, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+	assert.Nil(t, first.Check())
+
+	// The lease expires and the lock is taken over
+	second, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+	assert.True(t, second.Token() > first.Token())
+	assert.Equal(t, ErrLost, first.Check())
+	assert.Nil(t, second.Check())
+
+	// Releasing a lost lock must not release the new holder
+	assert.Nil(t, first.Release())
+	assert.Nil(t, second.Check())
+	assert.Nil(t, second.Release())
+}
+
+func TestMemoryLockerRenewal(t *testing.T) {
+	locker := NewMemoryLocker(Options{WaitTimeout: 50 * time.Millisecond, Lease: 30 * time.Millisecond, RenewInterval: 10 * time.Millisecond})
+
+	held, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+
+	// The lease is renewed while the lock is held
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, held.Check())
+	_, err = locker.Acquire(ApplicationKey("app"))
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	// Releasing stops the renewals
+	assert.Nil(t, held.Release())
+	assert.Equal(t, ErrLost, held.Extend())
+	next, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+	assert.True(t, next.Token() > held.Token())
+	assert.Nil(t, next.Release())
+}
+
+func TestMemoryLockerExtendLost(t *testing.T) {
+	locker := NewMemoryLocker(Options{WaitTimeout: time.Second, Lease: 20 * time.Millisecond})
+
+	first, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+	assert.Nil(t, first.Extend())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, ErrLost, first.Extend())
+	second, err := locker.Acquire(ApplicationKey("app"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrLost, first.Extend())
+	assert.Nil(t, second.Extend())
+	assert.Nil(t, second.Release())
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, "repository:github:org/repo:refs/heads/master", RepositoryKey("github", "org", "repo", "refs/heads/master"))
+	assert.Equal(t, "application:app", ApplicationKey("app"))
+	assert.Equal(t, "repository", scope(RepositoryKey("github", "org", "repo", "master")))
+	assert.True(t, len(lockName(RepositoryKey("github", "some-long-organization", "some-long-repository-name", "refs/heads/feature"))) <= mysqlLockNameLimit)
+}
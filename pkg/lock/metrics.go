@@ -0,0 +1,61 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package lock
+
+import (
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	resultAcquired = "acquired"
+	resultTimeout  = "timeout"
+	resultError    = "error"
+)
+
+var (
+	acquisitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dinghy",
+		Subsystem: "lock",
+		Name:      "acquisitions_total",
+		Help:      "Lock acquisition attempts by scope and result.",
+	}, []string{"scope", "result"})
+	contended = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dinghy",
+		Subsystem: "lock",
+		Name:      "contended_total",
+		Help:      "Lock acquisitions that had to wait for another holder.",
+	}, []string{"scope"})
+	waitSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "dinghy",
+		Subsystem: "lock",
+		Name:      "wait_seconds",
+		Help:      "Time spent waiting to acquire a lock.",
+		Buckets:   []float64{.005, .025, .1, .5, 1, 5, 15, 30, 60, 120},
+	}, []string{"scope"})
+)
+
+// scope is the kind of lock (repository, application), used as metric label
+// so the cardinality doesn't grow with the number of applications.
+func scope(key string) string {
+	return strings.SplitN(key, ":", 2)[0]
+}
+
+func observe(key, result string, start time.Time) {
+	acquisitions.WithLabelValues(scope(key), result).Inc()
+	waitSeconds.WithLabelValues(scope(key)).Observe(time.Since(start).Seconds())
+}
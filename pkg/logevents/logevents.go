@@ -34,4 +34,22 @@ type LogEvent struct {
 	RawData            string   `json:"rawdata" yaml:"rawdata"`
 	RenderedDinghyfile string   `json:"rendereddinghyfile" yaml:"rendereddinghyfile"`
 	PullRequest        string   `json:"pullrequest" yaml:"pullrequest"`
+	// Pipelines counts the pipelines changed while processing the push, nil when nothing was applied
+	Pipelines *PipelineChanges `json:"pipelines,omitempty" yaml:"pipelines,omitempty"`
+}
+
+// PipelineChanges counts how the pipelines of the processed dinghyfiles were applied.
+type PipelineChanges struct {
+	Created   int `json:"created" yaml:"created"`
+	Updated   int `json:"updated" yaml:"updated"`
+	Unchanged int `json:"unchanged" yaml:"unchanged"`
+	Deleted   int `json:"deleted" yaml:"deleted"`
+}
+
+// Add adds the counts of other.
+func (c *PipelineChanges) Add(other PipelineChanges) {
+	c.Created += other.Created
+	c.Updated += other.Updated
+	c.Unchanged += other.Unchanged
+	c.Deleted += other.Deleted
 }
@@ -14,6 +14,7 @@
 package logevents
 
 import (
+	"encoding/json"
 	"github.com/armory/dinghy/pkg/database"
 	"strings"
 	"time"
@@ -41,6 +42,8 @@ type LogEventSQL struct {
 	Author             string `gorm:"column:author"`
 	RenderedDinghyfile string `gorm:"column:rendereddinghyfile"`
 	PullRequest        string `gorm:"column:pullrequest"`
+	// Pipelines is the JSON of the pipeline changes, empty when there are none
+	Pipelines string `gorm:"column:pipelines"`
 }
 
 func (log LogEventSQL) ToLogEvent() LogEvent {
@@ -55,10 +58,28 @@ func (log LogEventSQL) ToLogEvent() LogEvent {
 		RawData:            log.RawData,
 		RenderedDinghyfile: log.RenderedDinghyfile,
 		PullRequest:        log.PullRequest,
+		Pipelines:          pipelineChanges(log.Pipelines),
 	}
 }
 
+func pipelineChanges(value string) *PipelineChanges {
+	if value == "" {
+		return nil
+	}
+	changes := &PipelineChanges{}
+	if err := json.Unmarshal([]byte(value), changes); err != nil {
+		return nil
+	}
+	return changes
+}
+
 func (log LogEvent) ToLogEventSQL() LogEventSQL {
+	pipelines := ""
+	if log.Pipelines != nil {
+		if out, err := json.Marshal(log.Pipelines); err == nil {
+			pipelines = string(out)
+		}
+	}
 	return LogEventSQL{
 		Org:                log.Org,
 		Repo:               log.Repo,
@@ -70,6 +91,7 @@ func (log LogEvent) ToLogEventSQL() LogEventSQL {
 		RawData:            log.RawData,
 		RenderedDinghyfile: log.RenderedDinghyfile,
 		PullRequest:        log.PullRequest,
+		Pipelines:          pipelines,
 	}
 }
 
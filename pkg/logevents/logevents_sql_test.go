@@ -0,0 +1,54 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package logevents
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogEventSQLClientTTL(t *testing.T) {
+	sqlClient, err := database.NewSQLClient(&database.SQLConfig{
+		Driver: database.SQLiteDriver,
+		DbName: filepath.Join(t.TempDir(), "dinghy.db"),
+	}, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	client := LogEventSQLClient{SQLClient: sqlClient, MinutesTTL: 60}
+
+	assert.Nil(t, client.SaveLogEvent(LogEvent{
+		Org:       "org",
+		Repo:      "repo",
+		Files:     []string{"dinghyfile"},
+		Commits:   []string{"abc"},
+		Status:    "success",
+		Pipelines: &PipelineChanges{Created: 1, Unchanged: 2},
+	}))
+	expired := LogEvent{Org: "org", Repo: "old", Files: []string{"dinghyfile"}}.ToLogEventSQL()
+	expired.Date = time.Now().Add(-2*time.Hour).UnixNano() / 1000000
+	assert.Nil(t, sqlClient.Client.Create(&expired).Error)
+
+	events, err := client.GetLogEvents()
+	assert.Nil(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "repo", events[0].Repo)
+	assert.Equal(t, []string{"abc"}, events[0].Commits)
+	assert.Equal(t, &PipelineChanges{Created: 1, Unchanged: 2}, events[0].Pipelines)
+}
@@ -7,3 +7,9 @@ type Notifier interface {
 	SendFailure(org, repo, path string, err error, notifications plank.NotificationsType, content map[string]interface{})
 	SendOnValidation() bool
 }
+
+// ApprovalNotifier is implemented by the notifiers that tell approvers about
+// the changes to protected applications held for their approval.
+type ApprovalNotifier interface {
+	SendApprovalRequest(org, repo, path string, notifications plank.NotificationsType, content map[string]interface{})
+}
@@ -0,0 +1,288 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SeverityDeny fails the dinghyfile
+	SeverityDeny = "deny"
+	// SeverityWarn is only reported
+	SeverityWarn = "warn"
+
+	// ScopeDinghyfile rules are evaluated once with the dinghyfile variable
+	ScopeDinghyfile = "dinghyfile"
+	// ScopePipeline rules are evaluated for every pipeline with the dinghyfile and pipeline variables
+	ScopePipeline = "pipeline"
+)
+
+// Source is where the policies are loaded from, a directory or a file in a repository.
+type Source struct {
+	Directory string
+	Org       string
+	Repo      string
+	Path      string
+	Branch    string
+}
+
+// Download reads a file of a repository.
+type Download func(org, repo, path, branch string) (string, error)
+
+// Rule is a CEL condition on the rendered dinghyfile, it is broken when the condition is true.
+type Rule struct {
+	ID        string `json:"id" yaml:"id"`
+	Severity  string `json:"severity" yaml:"severity"`
+	Scope     string `json:"scope" yaml:"scope"`
+	Condition string `json:"condition" yaml:"condition"`
+	Message   string `json:"message" yaml:"message"`
+}
+
+// File is the format of the policy files.
+type File struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// Violation is a rule a dinghyfile broke, Pipeline is set for pipeline rules.
+type Violation struct {
+	RuleID   string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+func (v Violation) String() string {
+	if v.Pipeline != "" {
+		return fmt.Sprintf("[%s] pipeline %s: %s", v.RuleID, v.Pipeline, v.Message)
+	}
+	return fmt.Sprintf("[%s] %s", v.RuleID, v.Message)
+}
+
+// ViolationError fails a dinghyfile that broke deny rules.
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.String()
+	}
+	return fmt.Sprintf("dinghyfile denied by policy: %s", strings.Join(messages, "; "))
+}
+
+type compiledRule struct {
+	Rule
+	program cel.Program
+}
+
+// Engine evaluates compiled rules.
+type Engine struct {
+	rules []compiledRule
+}
+
+var env, envErr = cel.NewEnv(cel.Declarations(
+	decls.NewVar(ScopeDinghyfile, decls.NewMapType(decls.String, decls.Dyn)),
+	decls.NewVar(ScopePipeline, decls.NewMapType(decls.String, decls.Dyn)),
+))
+
+// NewEngine compiles the rules, every condition must be a boolean.
+func NewEngine(rules []Rule) (*Engine, error) {
+	if envErr != nil {
+		return nil, envErr
+	}
+	engine := &Engine{}
+	ids := map[string]bool{}
+	for _, r := range rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("policy rule without id: %s", r.Condition)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("policy rule %s is defined twice", r.ID)
+		}
+		ids[r.ID] = true
+		if r.Severity == "" {
+			r.Severity = SeverityDeny
+		}
+		if r.Severity != SeverityDeny && r.Severity != SeverityWarn {
+			return nil, fmt.Errorf("policy rule %s: severity must be deny or warn", r.ID)
+		}
+		if r.Scope == "" {
+			r.Scope = ScopeDinghyfile
+		}
+		if r.Scope != ScopeDinghyfile && r.Scope != ScopePipeline {
+			return nil, fmt.Errorf("policy rule %s: scope must be dinghyfile or pipeline", r.ID)
+		}
+		ast, issues := env.Compile(r.Condition)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("policy rule %s: %w", r.ID, issues.Err())
+		}
+		if ast.ResultType() != decls.Bool && ast.ResultType() != decls.Dyn {
+			return nil, fmt.Errorf("policy rule %s: condition must be a boolean", r.ID)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %s: %w", r.ID, err)
+		}
+		engine.rules = append(engine.rules, compiledRule{Rule: r, program: program})
+	}
+	return engine, nil
+}
+
+// Evaluate returns the rules broken by a dinghyfile, given as its JSON object.
+func (e *Engine) Evaluate(dinghyfile map[string]interface{}) ([]Violation, error) {
+	violations := []Violation{}
+	pipelines, _ := dinghyfile["pipelines"].([]interface{})
+	for _, r := range e.rules {
+		if r.Scope == ScopeDinghyfile {
+			broken, err := r.eval(map[string]interface{}{ScopeDinghyfile: dinghyfile, ScopePipeline: map[string]interface{}{}})
+			if err != nil {
+				return nil, err
+			}
+			if broken {
+				violations = append(violations, Violation{RuleID: r.ID, Severity: r.Severity, Message: r.Message})
+			}
+			continue
+		}
+		for _, p := range pipelines {
+			pipeline, ok := p.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			broken, err := r.eval(map[string]interface{}{ScopeDinghyfile: dinghyfile, ScopePipeline: pipeline})
+			if err != nil {
+				return nil, err
+			}
+			if broken {
+				name, _ := pipeline["name"].(string)
+				violations = append(violations, Violation{RuleID: r.ID, Severity: r.Severity, Message: r.Message, Pipeline: name})
+			}
+		}
+	}
+	return violations, nil
+}
+
+func (r compiledRule) eval(vars map[string]interface{}) (bool, error) {
+	out, _, err := r.program.Eval(vars)
+	if err != nil {
+		return false, fmt.Errorf("policy rule %s: %w", r.ID, err)
+	}
+	broken, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("policy rule %s: condition must be a boolean, got %v", r.ID, out.Value())
+	}
+	return broken, nil
+}
+
+// Denied returns the violations of deny rules.
+func Denied(violations []Violation) []Violation {
+	return withSeverity(violations, SeverityDeny)
+}
+
+// Warnings returns the violations of warn rules.
+func Warnings(violations []Violation) []Violation {
+	return withSeverity(violations, SeverityWarn)
+}
+
+func withSeverity(violations []Violation, severity string) []Violation {
+	found := []Violation{}
+	for _, v := range violations {
+		if v.Severity == severity {
+			found = append(found, v)
+		}
+	}
+	return found
+}
+
+// Parse reads the rules of a policy file, YAML or JSON.
+func Parse(data []byte) ([]Rule, error) {
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return f.Rules, nil
+}
+
+// engines caches the engines by the content of their policy files
+var engines sync.Map
+
+// Load returns the engine of the policies of a source, they are compiled
+// again only when the policy files change.
+func Load(source Source, download Download) (*Engine, error) {
+	files := map[string][]byte{}
+	if source.Directory != "" {
+		names, err := filepath.Glob(filepath.Join(source.Directory, "*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			switch strings.ToLower(filepath.Ext(name)) {
+			case ".yml", ".yaml", ".json":
+				data, err := ioutil.ReadFile(name)
+				if err != nil {
+					return nil, err
+				}
+				files[name] = data
+			}
+		}
+	} else {
+		data, err := download(source.Org, source.Repo, source.Path, source.Branch)
+		if err != nil {
+			return nil, fmt.Errorf("could not download the policies: %w", err)
+		}
+		files[source.Path] = []byte(data)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write(files[name])
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+	if cached, ok := engines.Load(key); ok {
+		return cached.(*Engine), nil
+	}
+
+	rules := []Rule{}
+	for _, name := range names {
+		parsed, err := Parse(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the policies of %s: %w", name, err)
+		}
+		rules = append(rules, parsed...)
+	}
+	engine, err := NewEngine(rules)
+	if err != nil {
+		return nil, err
+	}
+	engines.Store(key, engine)
+	return engine, nil
+}
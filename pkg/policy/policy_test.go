@@ -0,0 +1,106 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package policy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPolicies = `
+rules:
+  - id: no-prod-in-name
+    condition: 'dinghyfile.application.contains("prod")'
+    message: application names can't contain prod
+  - id: pipeline-description
+    severity: warn
+    scope: pipeline
+    condition: '!has(pipeline.description) || pipeline.description == ""'
+    message: pipelines should have a description
+  - id: wait-limit
+    scope: pipeline
+    condition: 'has(pipeline.stages) && pipeline.stages.exists(s, s.type == "wait" && s.waitTime > 3600.0)'
+    message: wait stages can't wait for more than an hour
+`
+
+func testDinghyfile() map[string]interface{} {
+	return map[string]interface{}{
+		"application": "myapp",
+		"pipelines": []interface{}{
+			map[string]interface{}{"name": "deploy", "description": "deploys", "stages": []interface{}{
+				map[string]interface{}{"type": "wait", "waitTime": float64(7200)},
+			}},
+			map[string]interface{}{"name": "build", "stages": []interface{}{}},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules, err := Parse([]byte(testPolicies))
+	assert.Nil(t, err)
+	engine, err := NewEngine(rules)
+	assert.Nil(t, err)
+
+	violations, err := engine.Evaluate(testDinghyfile())
+	assert.Nil(t, err)
+	assert.Equal(t, []Violation{
+		{RuleID: "pipeline-description", Severity: SeverityWarn, Message: "pipelines should have a description", Pipeline: "build"},
+		{RuleID: "wait-limit", Severity: SeverityDeny, Message: "wait stages can't wait for more than an hour", Pipeline: "deploy"},
+	}, violations)
+	assert.Len(t, Denied(violations), 1)
+	assert.Len(t, Warnings(violations), 1)
+	assert.Equal(t, "dinghyfile denied by policy: [wait-limit] pipeline deploy: wait stages can't wait for more than an hour",
+		(&ViolationError{Violations: Denied(violations)}).Error())
+
+	d := testDinghyfile()
+	d["application"] = "myprodapp"
+	violations, err = engine.Evaluate(d)
+	assert.Nil(t, err)
+	assert.Equal(t, "no-prod-in-name", violations[0].RuleID)
+}
+
+func TestNewEngineErrors(t *testing.T) {
+	_, err := NewEngine([]Rule{{ID: "broken", Condition: "dinghyfile.application =="}})
+	assert.NotNil(t, err)
+	_, err = NewEngine([]Rule{{ID: "number", Condition: "1 + 1"}})
+	assert.EqualError(t, err, "policy rule number: condition must be a boolean")
+	_, err = NewEngine([]Rule{{ID: "severity", Severity: "info", Condition: "true"}})
+	assert.EqualError(t, err, "policy rule severity: severity must be deny or warn")
+	_, err = NewEngine([]Rule{{ID: "twice", Condition: "true"}, {ID: "twice", Condition: "false"}})
+	assert.EqualError(t, err, "policy rule twice is defined twice")
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "policies.yml"), []byte(testPolicies), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("not a policy"), 0644))
+
+	engine, err := Load(Source{Directory: dir}, nil)
+	assert.Nil(t, err)
+	assert.Len(t, engine.rules, 3)
+	cached, err := Load(Source{Directory: dir}, nil)
+	assert.Nil(t, err)
+	assert.True(t, engine == cached)
+
+	engine, err = Load(Source{Org: "org", Repo: "policies", Path: "dinghy.yml", Branch: "master"}, func(org, repo, path, branch string) (string, error) {
+		assert.Equal(t, "policies", repo)
+		return `{"rules": [{"id": "always", "condition": "true", "message": "denied"}]}`, nil
+	})
+	assert.Nil(t, err)
+	violations, _ := engine.Evaluate(testDinghyfile())
+	assert.Equal(t, "always", violations[0].RuleID)
+}
@@ -0,0 +1,89 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package revisions
+
+import (
+	"sort"
+
+	"github.com/armory/plank/v4"
+)
+
+// Client stores the revisions of the applications updated by dinghy
+type Client interface {
+	// SaveRevision stores a revision, it returns the revision with its ID and date.
+	SaveRevision(revision Revision) (Revision, error)
+	// GetRevisions returns the revisions of an application, newest first.
+	GetRevisions(application string) ([]Revision, error)
+	// GetRevisionsByCommit returns the revisions applied by a commit, newest first.
+	// There are none for an empty commit.
+	GetRevisionsByCommit(commit string) ([]Revision, error)
+}
+
+// Revision is what a dinghyfile applied to an application: the rendered
+// dinghyfile and its pipelines the way they were saved.
+type Revision struct {
+	ID          int64  `json:"id" yaml:"id"`
+	Application string `json:"application" yaml:"application"`
+	Provider    string `json:"provider" yaml:"provider"`
+	Org         string `json:"org" yaml:"org"`
+	Repo        string `json:"repo" yaml:"repo"`
+	Path        string `json:"path" yaml:"path"`
+	Branch      string `json:"branch" yaml:"branch"`
+	// Commit is only known when the dinghyfile was processed for a push to its repository
+	Commit     string           `json:"commit,omitempty" yaml:"commit,omitempty"`
+	Pusher     string           `json:"pusher" yaml:"pusher"`
+	Dinghyfile string           `json:"dinghyfile" yaml:"dinghyfile"`
+	Pipelines  []plank.Pipeline `json:"pipelines" yaml:"pipelines"`
+	// RollbackOf is the revision a rollback restored
+	RollbackOf int64 `json:"rollbackOf,omitempty" yaml:"rollbackOf,omitempty"`
+	Date       int64 `json:"date" yaml:"date"`
+}
+
+// SameDinghyfile checks if both revisions were applied to the same
+// application by the same dinghyfile.
+func (r Revision) SameDinghyfile(other Revision) bool {
+	return r.Application == other.Application && r.Provider == other.Provider &&
+		r.Org == other.Org && r.Repo == other.Repo && r.Path == other.Path && r.Branch == other.Branch
+}
+
+// Previous returns the revision applied by the same dinghyfile before r, nil
+// if there is none. revisions are the revisions of the application.
+func Previous(revisions []Revision, r Revision) *Revision {
+	var previous *Revision
+	for i := range revisions {
+		candidate := revisions[i]
+		if candidate.ID < r.ID && candidate.SameDinghyfile(r) && (previous == nil || candidate.ID > previous.ID) {
+			previous = &revisions[i]
+		}
+	}
+	return previous
+}
+
+// Latest returns the last revision applied by the same dinghyfile as r.
+func Latest(revisions []Revision, r Revision) Revision {
+	latest := r
+	for _, candidate := range revisions {
+		if candidate.ID > latest.ID && candidate.SameDinghyfile(r) {
+			latest = candidate
+		}
+	}
+	return latest
+}
+
+func newestFirst(revisions []Revision) []Revision {
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].ID > revisions[j].ID
+	})
+	return revisions
+}
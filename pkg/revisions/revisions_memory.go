@@ -0,0 +1,76 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package revisions
+
+import (
+	"sync"
+
+	"github.com/armory/dinghy/pkg/store"
+)
+
+// RevisionMemoryClient keeps the revisions applied by this replica until it
+// restarts, numbered from 1.
+type RevisionMemoryClient struct {
+	MaxPerApplication int
+	mutex             sync.Mutex
+	nextID            int64
+	revisions         []Revision
+}
+
+func NewRevisionMemoryClient(maxPerApplication int) *RevisionMemoryClient {
+	return &RevisionMemoryClient{MaxPerApplication: maxPerApplication, revisions: []Revision{}}
+}
+
+func (c *RevisionMemoryClient) SaveRevision(revision Revision) (Revision, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nextID++
+	revision.ID = c.nextID
+	revision.Date = store.Now()
+	c.revisions = append(c.revisions, revision)
+
+	kept := []Revision{}
+	count := 0
+	for i := len(c.revisions) - 1; i >= 0; i-- {
+		if c.revisions[i].Application == revision.Application {
+			count++
+			if c.MaxPerApplication > 0 && count > c.MaxPerApplication {
+				continue
+			}
+		}
+		kept = append([]Revision{c.revisions[i]}, kept...)
+	}
+	c.revisions = kept
+	return revision, nil
+}
+
+func (c *RevisionMemoryClient) GetRevisions(application string) ([]Revision, error) {
+	return c.find(func(r Revision) bool { return r.Application == application }), nil
+}
+
+func (c *RevisionMemoryClient) GetRevisionsByCommit(commit string) ([]Revision, error) {
+	return c.find(func(r Revision) bool { return commit != "" && r.Commit == commit }), nil
+}
+
+func (c *RevisionMemoryClient) find(match func(Revision) bool) []Revision {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	found := []Revision{}
+	for _, r := range c.revisions {
+		if match(r) {
+			found = append(found, r)
+		}
+	}
+	return newestFirst(found)
+}
@@ -0,0 +1,119 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package revisions
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/store"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// RevisionRedisClient keeps every revision in its own key, with a list of
+// the revisions of each application and a set of the revisions of each commit.
+type RevisionRedisClient struct {
+	MaxPerApplication int
+	RedisClient       *cache.RedisCache
+}
+
+func revisionKey(id int64) string {
+	return cache.CompileKey("revision", strconv.FormatInt(id, 10))
+}
+
+func (c RevisionRedisClient) SaveRevision(revision Revision) (Revision, error) {
+	loge := log.WithFields(log.Fields{"func": "SaveRevision"})
+	id, err := store.NextID(c.RedisClient.Client, cache.CompileKey("revisions", "nextid"))
+	if err != nil {
+		return revision, err
+	}
+	revision.ID = id
+	revision.Date = store.Now()
+	revisionBytes, err := json.Marshal(revision)
+	if err != nil {
+		loge.WithFields(log.Fields{"operation": "marshall revision", "id": id}).Error(err)
+		return revision, err
+	}
+
+	appKey := cache.CompileKey("revisions", "application", revision.Application)
+	pipe := c.RedisClient.Client.TxPipeline()
+	pipe.Set(revisionKey(id), revisionBytes, 0)
+	pipe.LPush(appKey, id)
+	if revision.Commit != "" {
+		pipe.SAdd(cache.CompileKey("revisions", "commit", revision.Commit), id)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		loge.WithFields(log.Fields{"operation": "save revision", "id": id}).Error(err)
+		return revision, err
+	}
+
+	if c.MaxPerApplication > 0 {
+		expired, err := c.RedisClient.Client.LRange(appKey, int64(c.MaxPerApplication), -1).Result()
+		if err != nil {
+			loge.WithFields(log.Fields{"operation": "expired revisions", "key": appKey}).Error(err)
+			return revision, nil
+		}
+		if len(expired) > 0 {
+			pipe := c.RedisClient.Client.TxPipeline()
+			pipe.LTrim(appKey, 0, int64(c.MaxPerApplication)-1)
+			for _, expiredID := range expired {
+				pipe.Del(cache.CompileKey("revision", expiredID))
+			}
+			if _, err := pipe.Exec(); err != nil {
+				loge.WithFields(log.Fields{"operation": "trim revisions", "key": appKey}).Error(err)
+			}
+		}
+	}
+	return revision, nil
+}
+
+func (c RevisionRedisClient) GetRevisions(application string) ([]Revision, error) {
+	ids, err := c.RedisClient.Client.LRange(cache.CompileKey("revisions", "application", application), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return c.load(ids)
+}
+
+func (c RevisionRedisClient) GetRevisionsByCommit(commit string) ([]Revision, error) {
+	ids, err := c.RedisClient.Client.SMembers(cache.CompileKey("revisions", "commit", commit)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	return c.load(ids)
+}
+
+// load returns the revisions with the ids, the commit sets keep the ids of
+// revisions removed from the application lists and those are skipped.
+func (c RevisionRedisClient) load(ids []string) ([]Revision, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = cache.CompileKey("revision", id)
+	}
+	revisions := []Revision{}
+	err := store.LoadJSON(c.RedisClient.Client, keys, func(value []byte) error {
+		var revision Revision
+		if err := json.Unmarshal(value, &revision); err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newestFirst(revisions), nil
+}
@@ -0,0 +1,132 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package revisions
+
+import (
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/dinghy/pkg/store"
+	"github.com/armory/plank/v4"
+)
+
+// RevisionSQLClient numbers revisions with the ids of the revisions table,
+// their pipelines are encoded in a column.
+type RevisionSQLClient struct {
+	MaxPerApplication int
+	SQLClient         *database.SQLClient
+}
+
+func (RevisionSQL) TableName() string {
+	return "revisions"
+}
+
+type RevisionSQL struct {
+	Id          int64  `gorm:"primaryKey;column:id"`
+	Application string `gorm:"column:application"`
+	Provider    string `gorm:"column:provider"`
+	Org         string `gorm:"column:org"`
+	Repo        string `gorm:"column:repo"`
+	Path        string `gorm:"column:path"`
+	Branch      string `gorm:"column:branch"`
+	Commit      string `gorm:"column:commitsha"`
+	Pusher      string `gorm:"column:pusher"`
+	Dinghyfile  string `gorm:"column:dinghyfile"`
+	Pipelines   string `gorm:"column:pipelines"`
+	RollbackOf  int64  `gorm:"column:rollbackof"`
+	Date        int64  `gorm:"column:revisiondate"`
+}
+
+func (c RevisionSQLClient) SaveRevision(revision Revision) (Revision, error) {
+	revision.Date = store.Now()
+	pipelines, err := store.JSONColumn(revision.Pipelines)
+	if err != nil {
+		return revision, err
+	}
+	row := RevisionSQL{
+		Application: revision.Application,
+		Provider:    revision.Provider,
+		Org:         revision.Org,
+		Repo:        revision.Repo,
+		Path:        revision.Path,
+		Branch:      revision.Branch,
+		Commit:      revision.Commit,
+		Pusher:      revision.Pusher,
+		Dinghyfile:  revision.Dinghyfile,
+		Pipelines:   pipelines,
+		RollbackOf:  revision.RollbackOf,
+		Date:        revision.Date,
+	}
+	if err := c.SQLClient.Client.Create(&row).Error; err != nil {
+		return revision, err
+	}
+	revision.ID = row.Id
+
+	if c.MaxPerApplication > 0 {
+		// Everything older than the oldest revision kept goes away
+		kept := []RevisionSQL{}
+		result := c.SQLClient.Client.Select("id").Where("application = ?", revision.Application).
+			Order("id desc").Offset(c.MaxPerApplication - 1).Limit(1).Find(&kept)
+		if result.Error != nil {
+			return revision, result.Error
+		}
+		if len(kept) > 0 {
+			err := c.SQLClient.Client.Where("application = ? AND id < ?", revision.Application, kept[0].Id).Delete(&RevisionSQL{}).Error
+			if err != nil {
+				return revision, err
+			}
+		}
+	}
+	return revision, nil
+}
+
+func (c RevisionSQLClient) GetRevisions(application string) ([]Revision, error) {
+	return c.find("application = ?", application)
+}
+
+func (c RevisionSQLClient) GetRevisionsByCommit(commit string) ([]Revision, error) {
+	if commit == "" {
+		// revisions without a commit weren't applied by one
+		return []Revision{}, nil
+	}
+	return c.find("commitsha = ?", commit)
+}
+
+func (c RevisionSQLClient) find(query string, arg string) ([]Revision, error) {
+	found := []RevisionSQL{}
+	if err := c.SQLClient.Client.Where(query, arg).Order("id desc").Find(&found).Error; err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(found))
+	for _, row := range found {
+		pipelines := []plank.Pipeline{}
+		if err := store.FromJSONColumn(row.Pipelines, &pipelines); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, Revision{
+			ID:          row.Id,
+			Application: row.Application,
+			Provider:    row.Provider,
+			Org:         row.Org,
+			Repo:        row.Repo,
+			Path:        row.Path,
+			Branch:      row.Branch,
+			Commit:      row.Commit,
+			Pusher:      row.Pusher,
+			Dinghyfile:  row.Dinghyfile,
+			Pipelines:   pipelines,
+			RollbackOf:  row.RollbackOf,
+			Date:        row.Date,
+		})
+	}
+	return revisions, nil
+}
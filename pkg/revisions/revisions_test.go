@@ -0,0 +1,140 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package revisions
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/database"
+	"github.com/armory/plank/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func revision(application, path, commit string) Revision {
+	return Revision{
+		Application: application,
+		Provider:    "github",
+		Org:         "org",
+		Repo:        "repo",
+		Path:        path,
+		Branch:      "master",
+		Commit:      commit,
+		Pusher:      "someone",
+		Dinghyfile:  `{"application":"` + application + `"}`,
+		Pipelines:   []plank.Pipeline{{ID: "p1", Name: "deploy", Application: application}},
+	}
+}
+
+func ids(revisions []Revision) []int64 {
+	found := []int64{}
+	for _, r := range revisions {
+		found = append(found, r.ID)
+	}
+	return found
+}
+
+func TestPreviousAndLatest(t *testing.T) {
+	revisions := []Revision{
+		{ID: 5, Application: "app", Path: "dinghyfile"},
+		{ID: 4, Application: "app", Path: "other/dinghyfile"},
+		{ID: 3, Application: "app", Path: "dinghyfile"},
+		{ID: 1, Application: "app", Path: "dinghyfile"},
+	}
+
+	// the previous revision is the closest one of the same dinghyfile
+	assert.Equal(t, int64(3), Previous(revisions, revisions[0]).ID)
+	assert.Equal(t, int64(1), Previous(revisions, revisions[2]).ID)
+	assert.Nil(t, Previous(revisions, revisions[3]))
+	assert.Nil(t, Previous(revisions, revisions[1]))
+
+	assert.Equal(t, int64(5), Latest(revisions, revisions[3]).ID)
+	assert.Equal(t, int64(4), Latest(revisions, revisions[1]).ID)
+}
+
+func TestRevisionMemoryClientPrunesEachApplication(t *testing.T) {
+	c := NewRevisionMemoryClient(2)
+	first, err := c.SaveRevision(revision("app", "dinghyfile", "c1"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), first.ID)
+	assert.NotZero(t, first.Date)
+	other, _ := c.SaveRevision(revision("other", "dinghyfile", "c1"))
+	second, _ := c.SaveRevision(revision("app", "dinghyfile", "c2"))
+	third, _ := c.SaveRevision(revision("app", "dinghyfile", "c3"))
+
+	found, err := c.GetRevisions("app")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{third.ID, second.ID}, ids(found))
+
+	// other applications keep theirs, and pruned revisions are gone by commit too
+	found, _ = c.GetRevisions("other")
+	assert.Equal(t, []int64{other.ID}, ids(found))
+	found, _ = c.GetRevisionsByCommit("c1")
+	assert.Equal(t, []int64{other.ID}, ids(found))
+}
+
+func TestRevisionMemoryClientUnlimited(t *testing.T) {
+	c := NewRevisionMemoryClient(0)
+	for i := 0; i < 5; i++ {
+		_, err := c.SaveRevision(revision("app", "dinghyfile", ""))
+		assert.Nil(t, err)
+	}
+	found, err := c.GetRevisions("app")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{5, 4, 3, 2, 1}, ids(found))
+
+	// revisions without a commit aren't found by an empty one
+	found, err = c.GetRevisionsByCommit("")
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+}
+
+func TestRevisionSQLClient(t *testing.T) {
+	config := &database.SQLConfig{Driver: database.SQLiteDriver, DbName: filepath.Join(t.TempDir(), "dinghy.db")}
+	sqlClient, err := database.NewSQLClient(config, logrus.New(), context.Background(), make(chan os.Signal, 1))
+	assert.Nil(t, err)
+	c := RevisionSQLClient{MaxPerApplication: 2, SQLClient: sqlClient}
+
+	first, err := c.SaveRevision(revision("app", "dinghyfile", "c1"))
+	assert.Nil(t, err)
+	assert.NotZero(t, first.ID)
+	assert.NotZero(t, first.Date)
+	other, _ := c.SaveRevision(revision("other", "dinghyfile", "c2"))
+	second, _ := c.SaveRevision(revision("app", "other/dinghyfile", "c2"))
+	third, _ := c.SaveRevision(revision("app", "dinghyfile", ""))
+
+	// the oldest revisions of app are deleted, whatever their dinghyfile
+	found, err := c.GetRevisions("app")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{third.ID, second.ID}, ids(found))
+	assert.Equal(t, "deploy", found[0].Pipelines[0].Name)
+	assert.Equal(t, `{"application":"app"}`, found[0].Dinghyfile)
+
+	found, err = c.GetRevisionsByCommit("c2")
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{second.ID, other.ID}, ids(found))
+	found, _ = c.GetRevisionsByCommit("c1")
+	assert.Empty(t, found)
+	found, _ = c.GetRevisionsByCommit("")
+	assert.Empty(t, found)
+
+	// rows saved without pipelines are read back with none
+	assert.Nil(t, sqlClient.Client.Model(&RevisionSQL{}).Where("id = ?", third.ID).Update("pipelines", "").Error)
+	found, err = c.GetRevisions("app")
+	assert.Nil(t, err)
+	assert.Equal(t, []plank.Pipeline{}, found[0].Pipelines)
+}
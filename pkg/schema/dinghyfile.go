@@ -0,0 +1,145 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package schema
+
+// Dinghyfile is the JSON Schema of a rendered dinghyfile, it checks the
+// document and the basics of its pipelines and stages, what Spinnaker
+// accepts for every stage type is left to the extensions.
+const Dinghyfile = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Dinghyfile",
+  "type": "object",
+  "properties": {
+    "application": {"type": "string", "minLength": 1},
+    "spec": {"$ref": "#/definitions/application"},
+    "globals": {"type": "object"},
+    "deleteStalePipelines": {"type": "boolean"},
+    "takeoverPipelines": {"type": "boolean"},
+    "pipelines": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/pipeline"}
+    },
+    "pipelineTemplates": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/pipelineTemplate"}
+    },
+    "templatedPipelines": {
+      "type": "array",
+      "items": {"$ref": "#/definitions/templatedPipeline"}
+    }
+  },
+  "definitions": {
+    "application": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "email": {"type": "string"},
+        "description": {"type": "string"},
+        "dataSources": {"type": "object"},
+        "permissions": {"type": "object"},
+        "notifications": {"type": "object"}
+      }
+    },
+    "refId": {"type": ["string", "integer"]},
+    "pipeline": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "id": {"type": "string"},
+        "name": {"type": "string", "minLength": 1},
+        "application": {"type": "string"},
+        "description": {"type": "string"},
+        "parallel": {"type": "boolean"},
+        "limitConcurrent": {"type": "boolean"},
+        "keepWaitingPipelines": {"type": "boolean"},
+        "roles": {"type": "array", "items": {"type": "string"}},
+        "stages": {"type": "array", "items": {"$ref": "#/definitions/stage"}},
+        "triggers": {"type": "array", "items": {"$ref": "#/definitions/trigger"}},
+        "parameterConfig": {"type": "array", "items": {"$ref": "#/definitions/parameter"}},
+        "notifications": {"type": "array", "items": {"type": "object"}},
+        "expectedArtifacts": {"type": "array", "items": {"type": "object"}},
+        "locked": {"type": "object"}
+      }
+    },
+    "pipelineTemplate": {
+      "type": "object",
+      "required": ["id"],
+      "properties": {
+        "id": {"type": "string", "minLength": 1},
+        "schema": {"const": "v2"},
+        "tag": {"type": "string"},
+        "protect": {"type": "boolean"},
+        "metadata": {"type": "object"},
+        "variables": {"type": "array", "items": {"type": "object", "required": ["name"]}},
+        "pipeline": {"type": "object"}
+      }
+    },
+    "templatedPipeline": {
+      "type": "object",
+      "required": ["name", "template"],
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "schema": {"const": "v2"},
+        "application": {"type": "string"},
+        "template": {
+          "type": "object",
+          "required": ["reference"],
+          "properties": {
+            "artifactAccount": {"type": "string"},
+            "reference": {"type": "string", "minLength": 1},
+            "type": {"type": "string"}
+          }
+        },
+        "variables": {"type": "object"},
+        "exclude": {"type": "array", "items": {"type": "string"}},
+        "stages": {"type": "array", "items": {"type": "object"}},
+        "triggers": {"type": "array", "items": {"$ref": "#/definitions/trigger"}},
+        "parameters": {"type": "array", "items": {"$ref": "#/definitions/parameter"}},
+        "notifications": {"type": "array", "items": {"type": "object"}}
+      }
+    },
+    "stage": {
+      "type": "object",
+      "required": ["type", "refId"],
+      "properties": {
+        "type": {"type": "string", "minLength": 1},
+        "name": {"type": "string"},
+        "refId": {"$ref": "#/definitions/refId"},
+        "requisiteStageRefIds": {"type": "array", "items": {"$ref": "#/definitions/refId"}},
+        "stageEnabled": {"type": "object"},
+        "failPipeline": {"type": "boolean"},
+        "continuePipeline": {"type": "boolean"},
+        "completeOtherBranchesThenFail": {"type": "boolean"}
+      }
+    },
+    "trigger": {
+      "type": "object",
+      "required": ["type"],
+      "properties": {
+        "type": {"type": "string", "minLength": 1},
+        "enabled": {"type": "boolean"}
+      }
+    },
+    "parameter": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string", "minLength": 1},
+        "required": {"type": "boolean"},
+        "hasOptions": {"type": "boolean"},
+        "options": {"type": "array", "items": {"type": "object"}}
+      }
+    }
+  }
+}`
@@ -0,0 +1,144 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ModuleSchema validates the rendered modules whose path matches Pattern.
+type ModuleSchema struct {
+	Pattern string
+	Schema  string
+}
+
+// Extensions are the schemas an organization keeps in its template repo, the
+// dinghyfiles must match them besides the Dinghyfile schema.
+type Extensions struct {
+	// Dinghyfile schemas, paths in the template repo
+	Dinghyfile []string
+	// Modules schemas by module path
+	Modules []ModuleSchema
+}
+
+// SchemaFor returns the schema of a module, empty when it has none.
+func (e Extensions) SchemaFor(module string) string {
+	for _, m := range e.Modules {
+		if matched, _ := path.Match(m.Pattern, module); matched {
+			return m.Schema
+		}
+	}
+	return ""
+}
+
+// Error lists where a document doesn't match its schemas.
+type Error struct {
+	// Subject is what was validated, e.g. dinghyfile or module stages/deploy.module
+	Subject  string
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s doesn't match the schema: %s", e.Subject, strings.Join(e.Problems, "; "))
+}
+
+// Validator validates documents against one or more schemas.
+type Validator struct {
+	schemas []*gojsonschema.Schema
+}
+
+// validators caches the compiled schemas by their content
+var validators sync.Map
+
+// Compile returns the validator of the schemas, a document must match every one of them.
+func Compile(schemas ...string) (*Validator, error) {
+	hash := sha256.New()
+	for _, s := range schemas {
+		hash.Write([]byte(s))
+		hash.Write([]byte{0})
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+	if cached, ok := validators.Load(key); ok {
+		return cached.(*Validator), nil
+	}
+
+	v := &Validator{}
+	for i, s := range schemas {
+		compiled, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema %d: %w", i+1, err)
+		}
+		v.schemas = append(v.schemas, compiled)
+	}
+	validators.Store(key, v)
+	return v, nil
+}
+
+// Validate returns where the JSON document doesn't match the schemas, e.g.
+// "pipelines[2].stages[4].type is required".
+func (v *Validator) Validate(document []byte) ([]string, error) {
+	problems := []string{}
+	seen := map[string]bool{}
+	for _, s := range v.schemas {
+		result, err := s.Validate(gojsonschema.NewBytesLoader(document))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range result.Errors() {
+			problem := describe(e)
+			if !seen[problem] {
+				seen[problem] = true
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems, nil
+}
+
+// describe returns the problem of a result error with its path in the document.
+func describe(e gojsonschema.ResultError) string {
+	segments := strings.Split(e.Context().String("\x00"), "\x00")[1:]
+	if e.Type() == "required" {
+		segments = append(segments, fmt.Sprint(e.Details()["property"]))
+		return fmt.Sprintf("%s is required", documentPath(segments))
+	}
+	if len(segments) == 0 {
+		return e.Description()
+	}
+	return fmt.Sprintf("%s: %s", documentPath(segments), e.Description())
+}
+
+// documentPath joins the segments of a path, array indexes between brackets.
+func documentPath(segments []string) string {
+	var b strings.Builder
+	for _, s := range segments {
+		if _, err := strconv.Atoi(s); err == nil {
+			b.WriteString("[" + s + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(s)
+	}
+	return b.String()
+}
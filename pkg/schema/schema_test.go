@@ -0,0 +1,89 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDinghyfile = `{
+  "application": "myapp",
+  "globals": {"team": "core"},
+  "pipelines": [
+    {"name": "build", "stages": []},
+    {
+      "name": "deploy",
+      "parallel": "yes",
+      "stages": [
+        {"refId": "1", "type": "wait", "waitTime": 30},
+        {"refId": "2", "name": "missing type", "requisiteStageRefIds": ["1"]}
+      ],
+      "triggers": [{"enabled": true}]
+    }
+  ]
+}`
+
+func TestValidateDinghyfile(t *testing.T) {
+	v, err := Compile(Dinghyfile)
+	assert.Nil(t, err)
+	problems, err := v.Validate([]byte(testDinghyfile))
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{
+		"pipelines[1].parallel: Invalid type. Expected: boolean, given: string",
+		"pipelines[1].stages[1].type is required",
+		"pipelines[1].triggers[0].type is required",
+	}, problems)
+
+	problems, err = v.Validate([]byte(`{"application": "myapp", "pipelines": [{"name": "build"}]}`))
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = v.Validate([]byte(`{
+  "application": "myapp",
+  "pipelineTemplates": [{"id": "deploy", "schema": "v1"}],
+  "templatedPipelines": [{"name": "deploy-prod", "template": {}}]
+}`))
+	assert.Nil(t, err)
+	assert.Len(t, problems, 2)
+	assert.Contains(t, problems, "templatedPipelines[0].template.reference is required")
+}
+
+func TestValidateExtensions(t *testing.T) {
+	extension := `{
+  "properties": {
+    "globals": {"required": ["team"]},
+    "pipelines": {"items": {"required": ["description"]}}
+  },
+  "required": ["globals"]
+}`
+	v, err := Compile(Dinghyfile, extension)
+	assert.Nil(t, err)
+	problems, err := v.Validate([]byte(`{"application": "myapp", "pipelines": [{"name": "build"}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"globals is required", "pipelines[0].description is required"}, problems)
+
+	_, err = Compile(Dinghyfile, `{"type": 3}`)
+	assert.NotNil(t, err)
+}
+
+func TestSchemaFor(t *testing.T) {
+	e := Extensions{Modules: []ModuleSchema{{Pattern: "stages/*", Schema: "schemas/stage.json"}}}
+	assert.Equal(t, "schemas/stage.json", e.SchemaFor("stages/deploy.module"))
+	assert.Equal(t, "", e.SchemaFor("pipelines/deploy.module"))
+
+	err := &Error{Subject: "module stages/deploy.module", Problems: []string{"type is required"}}
+	assert.EqualError(t, err, "module stages/deploy.module doesn't match the schema: type is required")
+}
@@ -0,0 +1,88 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package global
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// repositorySettingsSchema lists the settings a repository can override in
+// the settings block of its root .dinghy.yml, with their type.
+var repositorySettingsSchema = map[string]string{
+	"autoLockPipelines":                  "boolean",
+	"deleteStalePipelines":               "boolean",
+	"jsonValidationDisabled":             "boolean",
+	"upsertPipelineUsingOrcaTaskEnabled": "boolean",
+	"repositoryRawdataProcessing":        "boolean",
+}
+
+// RepositorySettings are the settings overridden by a repository, unset
+// fields keep the global value.
+type RepositorySettings struct {
+	AutoLockPipelines                  *bool `json:"autoLockPipelines,omitempty" yaml:"autoLockPipelines"`
+	DeleteStalePipelines               *bool `json:"deleteStalePipelines,omitempty" yaml:"deleteStalePipelines"`
+	JsonValidationDisabled             *bool `json:"jsonValidationDisabled,omitempty" yaml:"jsonValidationDisabled"`
+	UpsertPipelineUsingOrcaTaskEnabled *bool `json:"upsertPipelineUsingOrcaTaskEnabled,omitempty" yaml:"upsertPipelineUsingOrcaTaskEnabled"`
+	RepositoryRawdataProcessing        *bool `json:"repositoryRawdataProcessing,omitempty" yaml:"repositoryRawdataProcessing"`
+}
+
+// ValidateRepositorySettings checks the settings overridden by a repository
+// against the schema, only the allow-listed settings can be overridden.
+func ValidateRepositorySettings(raw map[interface{}]interface{}) error {
+	for k, v := range raw {
+		key := fmt.Sprintf("%v", k)
+		kind, allowed := repositorySettingsSchema[key]
+		if !allowed {
+			return fmt.Errorf("setting %q can't be overridden by a repository, allowed settings are: %s", key, strings.Join(allowedRepositorySettings(), ", "))
+		}
+		if _, ok := v.(bool); kind == "boolean" && !ok {
+			return fmt.Errorf("setting %q must be a %s, got %v", key, kind, v)
+		}
+	}
+	return nil
+}
+
+func allowedRepositorySettings() []string {
+	keys := make([]string, 0, len(repositorySettingsSchema))
+	for key := range repositorySettingsSchema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithRepositorySettings returns a copy of the settings with the repository
+// overrides applied, the receiver is not modified.
+func (s *Settings) WithRepositorySettings(r RepositorySettings) *Settings {
+	merged := *s
+	if r.AutoLockPipelines != nil {
+		merged.AutoLockPipelines = strconv.FormatBool(*r.AutoLockPipelines)
+	}
+	if r.DeleteStalePipelines != nil {
+		merged.DeleteStalePipelines = *r.DeleteStalePipelines
+	}
+	if r.JsonValidationDisabled != nil {
+		merged.JsonValidationDisabled = *r.JsonValidationDisabled
+	}
+	if r.UpsertPipelineUsingOrcaTaskEnabled != nil {
+		merged.UpsertPipelineUsingOrcaTaskEnabled = *r.UpsertPipelineUsingOrcaTaskEnabled
+	}
+	if r.RepositoryRawdataProcessing != nil {
+		merged.RepositoryRawdataProcessing = *r.RepositoryRawdataProcessing
+	}
+	return &merged
+}
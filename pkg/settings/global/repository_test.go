@@ -0,0 +1,65 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package global
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRepositorySettings(t *testing.T) {
+	cases := map[string]struct {
+		raw   map[interface{}]interface{}
+		valid bool
+	}{
+		"empty": {
+			raw:   nil,
+			valid: true,
+		},
+		"allowed": {
+			raw:   map[interface{}]interface{}{"autoLockPipelines": false, "deleteStalePipelines": true},
+			valid: true,
+		},
+		"not allowed": {
+			raw:   map[interface{}]interface{}{"githubToken": "token"},
+			valid: false,
+		},
+		"wrong type": {
+			raw:   map[interface{}]interface{}{"jsonValidationDisabled": "yes"},
+			valid: false,
+		},
+	}
+
+	for desc, c := range cases {
+		t.Run(desc, func(t *testing.T) {
+			err := ValidateRepositorySettings(c.raw)
+			assert.Equal(t, c.valid, err == nil)
+		})
+	}
+}
+
+func TestWithRepositorySettings(t *testing.T) {
+	autolock, upsert := false, true
+	s := &Settings{AutoLockPipelines: "true", RepositoryRawdataProcessing: true}
+
+	merged := s.WithRepositorySettings(RepositorySettings{AutoLockPipelines: &autolock, UpsertPipelineUsingOrcaTaskEnabled: &upsert})
+	assert.Equal(t, "false", merged.AutoLockPipelines)
+	assert.True(t, merged.UpsertPipelineUsingOrcaTaskEnabled)
+	assert.True(t, merged.RepositoryRawdataProcessing)
+
+	// The global settings are left untouched
+	assert.Equal(t, "true", s.AutoLockPipelines)
+	assert.False(t, s.UpsertPipelineUsingOrcaTaskEnabled)
+}
@@ -64,6 +64,9 @@ func NewDefaultSettings() Settings {
 				Enabled: "true",
 				BaseURL: util.GetenvOrDefault("FRONT50_BASE_URL", "http://front50:8080"),
 			},
+			Clouddriver: SpinnakerService{
+				BaseURL: util.GetenvOrDefault("CLOUDDRIVER_BASE_URL", "http://clouddriver:7002"),
+			},
 			Echo: SpinnakerService{
 				BaseURL: util.GetenvOrDefault("ECHO_BASE_URL", "http://echo:8089"),
 			},
@@ -87,11 +90,50 @@ func NewDefaultSettings() Settings {
 		LogEventTTLMinutes: 1440,
 		SQL: Sqlconfig{
 			Enabled:       false,
+			Driver:        "mysql",
 			EventLogsOnly: false,
 		},
 		UserWritePermissionsCheckEnabled: false,
 		MultipleBranchesEnabled:          "true",
 		DinghyIgnoreRegexp2Enabled:       "true",
+		WebhookDeduplication: WebhookDeduplication{
+			Enabled:    false,
+			TTLMinutes: 1440,
+		},
+		Locking: Locking{
+			Enabled:            false,
+			WaitTimeoutSeconds: 60,
+			LeaseSeconds:       300,
+		},
+		ImpactReports: ImpactReports{
+			Enabled:    false,
+			TTLMinutes: 10080,
+		},
+		Reconciliation: Reconciliation{
+			Enabled:         false,
+			IntervalMinutes: 360,
+			Concurrency:     4,
+		},
+		DriftDetection: DriftDetection{
+			Enabled:         false,
+			IntervalMinutes: 60,
+		},
+		Revisions: Revisions{
+			Enabled:           false,
+			MaxPerApplication: 50,
+		},
+		ReferenceValidation: ReferenceValidation{
+			Enabled:         false,
+			CacheTTLSeconds: 300,
+		},
+		Approvals: Approvals{
+			Enabled:           false,
+			ExpirationMinutes: 1440,
+		},
+		Freezes: Freezes{
+			Enabled:         false,
+			OverrideTrailer: "Freeze-Override",
+		},
 	}
 }
 
@@ -105,8 +147,21 @@ type Settings struct {
 	TemplateRepo string `json:"templateRepo,omitempty" yaml:"templateRepo"`
 	// Names of the file that will be processed by dinghy, by default is dinghyfile
 	DinghyFilename string `json:"dinghyFilename,omitempty" yaml:"dinghyFilename"`
+	// Globs of additional file names processed by dinghy, eg: *.dinghyfile
+	DinghyFilenamePatterns []string `json:"dinghyFilenamePatterns,omitempty" yaml:"dinghyFilenamePatterns"`
+	// Look for .dinghy.yml files setting the defaults of the dinghyfiles in their directory and below,
+	// the one at the repository root can also override some settings for the pushes to the repository
+	DirectoryConfigEnabled bool `json:"directoryConfigEnabled,omitempty" yaml:"directoryConfigEnabled"`
 	// Lock Dinghy pipelines
 	AutoLockPipelines string `json:"autoLockPipelines,omitempty" yaml:"autoLockPipelines"`
+	// Delete the pipelines of an application not present in its dinghyfile, unless the dinghyfile sets deleteStalePipelines
+	DeleteStalePipelines bool `json:"deleteStalePipelines,omitempty" yaml:"deleteStalePipelines"`
+	// Stamp the pipelines with the dinghyfile that manages them, the pipelines of other dinghyfiles are neither
+	// updated nor deleted unless the dinghyfile sets takeoverPipelines
+	PipelineOwnershipEnabled bool `json:"pipelineOwnershipEnabled,omitempty" yaml:"pipelineOwnershipEnabled"`
+	// Update applications all or nothing, when an update fails the application, its notifications and
+	// pipelines are restored the way they were before it
+	TransactionalUpdatesEnabled bool `json:"transactionalUpdatesEnabled,omitempty" yaml:"transactionalUpdatesEnabled"`
 	// Overwrite deck baseUrl
 	SpinnakerUIURL string `json:"spinUIUrl,omitempty" yaml:"spinUIUrl"`
 	// Github credentials path
@@ -161,24 +216,196 @@ type Settings struct {
 	DinghyIgnoreRegexp2Enabled string `json:"dinghyIgnoreRegexp2Enabled" yaml:"dinghyIgnoreRegexp2Enabled"`
 	// Check user's write permissions by calling Fiat /authorize/${user}/roles before updating application
 	UserWritePermissionsCheckEnabled bool `json:"userWritePermissionsCheckEnabled" yaml:"userWritePermissionsCheckEnabled"`
+	// Check with Fiat /authorize/${user} that the pusher can create or write to the application, run the triggers
+	// as their service accounts, deploy to the accounts of the stages and give the pipelines their roles
+	UserResourcePermissionsCheckEnabled bool `json:"userResourcePermissionsCheckEnabled" yaml:"userResourcePermissionsCheckEnabled"`
 	// Users for whom we should ignore and skip write permissions validations
 	IgnoreUsersPermissions []string `json:"ignoreUsersWritePermissions" yaml:"ignoreUsersWritePermissions"`
 	// Enable processing of multiple branches in single repository
 	MultipleBranchesEnabled string `json:"multipleBranchesEnabled" yaml:"multipleBranchesEnabled"`
 	// Enable using savePipeline and updatePipeline tasks from Orca
 	UpsertPipelineUsingOrcaTaskEnabled bool `json:"upsertPipelineUsingOrcaTaskEnabled" yaml:"upsertPipelineUsingOrcaTaskEnabled"`
+	// Suppress duplicated webhook deliveries (provider retries, redeliveries)
+	WebhookDeduplication WebhookDeduplication `json:"webhookDeduplication" yaml:"webhookDeduplication"`
+	// Serialize the processing of repositories and applications between dinghy replicas
+	Locking Locking `json:"locking" yaml:"locking"`
+	// Poll the template repository for changes, other repositories are polled from repoConfig
+	TemplateRepoPolling TemplateRepoPolling `json:"templateRepoPolling" yaml:"templateRepoPolling"`
+	// Report the impact of template repo branches on the dinghyfiles using their modules
+	ImpactReports ImpactReports `json:"impactReports" yaml:"impactReports"`
+	// Process again every dinghyfile of the dependency graph on a schedule, fixing pipelines that drifted
+	Reconciliation Reconciliation `json:"reconciliation" yaml:"reconciliation"`
+	// Compare the dinghyfiles with the pipelines and applications in Spinnaker on a schedule, nothing is updated
+	DriftDetection DriftDetection `json:"driftDetection" yaml:"driftDetection"`
+	// Keep the dinghyfiles and pipelines applied to every application, they can be rolled back
+	Revisions Revisions `json:"revisions" yaml:"revisions"`
+	// Evaluate the rendered dinghyfiles against CEL policies before updating Spinnaker
+	Policies Policies `json:"policies" yaml:"policies"`
+	// Check the rendered pipelines with the built-in lint rules
+	Lint Lint `json:"lint" yaml:"lint"`
+	// Check the accounts, stage types and pipelines referenced by the pipelines exist in Spinnaker
+	ReferenceValidation ReferenceValidation `json:"referenceValidation" yaml:"referenceValidation"`
+	// Validate the rendered dinghyfiles and modules against JSON Schemas
+	SchemaValidation SchemaValidation `json:"schemaValidation" yaml:"schemaValidation"`
+	// Hold the changes to protected applications until they are approved
+	Approvals Approvals `json:"approvals" yaml:"approvals"`
+	// Only validate the dinghyfiles of the applications in a change freeze
+	Freezes Freezes `json:"freezes" yaml:"freezes"`
+}
+
+type ImpactReports struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Time in minutes a report is kept, by default is one week
+	TTLMinutes time.Duration `json:"ttlMinutes" yaml:"ttlMinutes"`
+	// Comment the report in the pull request of the branch, only supported for GitHub
+	CommentPullRequests bool `json:"commentPullRequests,omitempty" yaml:"commentPullRequests"`
+}
+
+type Reconciliation struct {
+	// Enabled flag, requires locking. When disabled a reconciliation can still be started with POST /v1/reconcile
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Minutes between reconciliations, shared by all the replicas
+	IntervalMinutes int `json:"intervalMinutes" yaml:"intervalMinutes"`
+	// Number of dinghyfiles processed at the same time
+	Concurrency int `json:"concurrency" yaml:"concurrency"`
+}
+
+type DriftDetection struct {
+	// Enabled flag, when disabled drift can still be detected with POST /v1/drift
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Minutes between drift detections
+	IntervalMinutes int `json:"intervalMinutes" yaml:"intervalMinutes"`
+}
+
+type Revisions struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Number of revisions kept by application, older ones are deleted
+	MaxPerApplication int `json:"maxPerApplication" yaml:"maxPerApplication"`
+}
+
+type Policies struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Directory with the policy files (.yml, .yaml or .json), takes precedence over the repository file
+	Directory string `json:"directory,omitempty" yaml:"directory"`
+	// Policy file in a repository of the git provider of the dinghyfiles
+	Org    string `json:"org,omitempty" yaml:"org"`
+	Repo   string `json:"repo,omitempty" yaml:"repo"`
+	Path   string `json:"path,omitempty" yaml:"path"`
+	Branch string `json:"branch,omitempty" yaml:"branch"`
+}
+
+type Lint struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Severity (off, warn or error) by rule ID, rules not listed keep their default severity
+	Rules map[string]string `json:"rules,omitempty" yaml:"rules"`
+}
+
+type ReferenceValidation struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Seconds the accounts, stage types, applications and pipelines of Spinnaker are cached
+	CacheTTLSeconds int `json:"cacheTTLSeconds" yaml:"cacheTTLSeconds"`
+	// Stage types accepted besides the ones of Orca, e.g. the stages of plugins
+	StageTypes []string `json:"stageTypes,omitempty" yaml:"stageTypes"`
+}
+
+type SchemaValidation struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Schemas in the template repo the dinghyfiles must match besides the built-in one
+	Extensions []string `json:"extensions,omitempty" yaml:"extensions"`
+	// Schemas in the template repo of the modules, by glob of the module path
+	Modules []ModuleSchema `json:"modules,omitempty" yaml:"modules"`
+}
+
+type Approvals struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Globs of the protected application names, applications with protected: true in their spec are protected too
+	Applications []string `json:"applications,omitempty" yaml:"applications"`
+	// Minutes a change request can be approved before it expires
+	ExpirationMinutes int `json:"expirationMinutes" yaml:"expirationMinutes"`
+	// Users allowed to approve, anyone but the pusher when empty
+	Approvers []string `json:"approvers,omitempty" yaml:"approvers"`
+	// Addresses or CIDRs of the Gate replicas in front of dinghy. Approvals and rejections are made by the user
+	// of the X-Spinnaker-User header Gate sets once it authenticated them, the header of requests sent by anything
+	// else is not trusted and they are rejected. Only local requests are trusted when empty
+	TrustedProxies []string `json:"trustedProxies,omitempty" yaml:"trustedProxies"`
+	// Comment the pushed commits held for approval with their change request (github only)
+	CommentCommits bool `json:"commentCommits,omitempty" yaml:"commentCommits"`
+}
+
+type Freezes struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// When the changes are frozen and to what
+	Windows []FreezeWindow `json:"windows,omitempty" yaml:"windows"`
+	// Pushers whose changes are applied during freezes
+	OverrideUsers []string `json:"overrideUsers,omitempty" yaml:"overrideUsers"`
+	// Commit message trailer applying the changes of a push during freezes, its value is the reason. Bitbucket Cloud
+	// webhooks only send the 5 newest commits of a push, the trailer must be in one of them
+	OverrideTrailer string `json:"overrideTrailer" yaml:"overrideTrailer"`
+}
+
+type FreezeWindow struct {
+	// Name of the freeze, shown in the commit statuses
+	Name string `json:"name" yaml:"name"`
+	// Start and end of a date range (YYYY-MM-DD, YYYY-MM-DDTHH:MM or RFC 3339), a day ends at midnight
+	Start string `json:"start,omitempty" yaml:"start"`
+	End   string `json:"end,omitempty" yaml:"end"`
+	// Cron schedule (minute hour day-of-month month day-of-week) starting the freeze for durationMinutes
+	Cron            string `json:"cron,omitempty" yaml:"cron"`
+	DurationMinutes int    `json:"durationMinutes,omitempty" yaml:"durationMinutes"`
+	// Timezone of the dates and cron schedule (eg: America/New_York), UTC by default
+	Timezone string `json:"timezone,omitempty" yaml:"timezone"`
+	// Globs of the orgs, repos and applications frozen, everything when none is set
+	Orgs         []string `json:"orgs,omitempty" yaml:"orgs"`
+	Repos        []string `json:"repos,omitempty" yaml:"repos"`
+	Applications []string `json:"applications,omitempty" yaml:"applications"`
+	// Message explaining the freeze
+	Message string `json:"message,omitempty" yaml:"message"`
+}
+
+type ModuleSchema struct {
+	// Glob of the module paths (eg: stages/*)
+	Pattern string `json:"pattern" yaml:"pattern"`
+	// Schema in the template repo
+	Schema string `json:"schema" yaml:"schema"`
+}
+
+type Locking struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Maximum time in seconds to wait for a lock held by another push
+	WaitTimeoutSeconds int `json:"waitTimeoutSeconds" yaml:"waitTimeoutSeconds"`
+	// Time in seconds after which a lock not released (e.g. a crashed replica) expires, not used by SQL.
+	// Held locks renew it every third of the lease
+	LeaseSeconds int `json:"leaseSeconds" yaml:"leaseSeconds"`
+}
+
+type WebhookDeduplication struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Time in minutes a processed delivery is remembered, by default is one day
+	TTLMinutes time.Duration `json:"ttlMinutes" yaml:"ttlMinutes"`
 }
 
 type Sqlconfig struct {
 	// Enabled flag
 	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Database driver, mysql, postgres or sqlite
+	Driver string `json:"driver" yaml:"driver"`
 	// Database url
 	BaseUrl string `json:"baseUrl" yaml:"baseUrl"`
 	// User
 	User string `json:"user" yaml:"user"`
 	// Password
 	Password string `json:"password" yaml:"password"`
-	// DB name
+	// DB name, or the path of the database file with sqlite
 	DatabaseName string `json:"databaseName" yaml:"databaseName"`
 	// If this flag is enabled only events will be saved in database, redis will continue to be used for relationships
 	EventLogsOnly bool `json:"eventlogsOnly" yaml:"eventlogsOnly"`
@@ -202,6 +429,8 @@ type SpinnakerSupplied struct {
 	Orca SpinnakerService `json:"orca,omitempty" yaml:"orca"`
 	// Front50 service information
 	Front50 SpinnakerService `json:"front50,omitempty" yaml:"front50"`
+	// Clouddriver service information, used to validate the accounts of the pipelines
+	Clouddriver SpinnakerService `json:"clouddriver,omitempty" yaml:"clouddriver"`
 	// Deck service information
 	Deck SpinnakerService `json:"deck,omitempty" yaml:"deck"`
 	// Echo service information
@@ -255,6 +484,55 @@ type RepoConfig struct {
 	Repo string `json:"repo,omitempty" yaml:"repo"`
 	// Branch
 	Branch string `json:"branch,omitempty" yaml:"branch"`
+	// Organization, only needed when polling
+	Org string `json:"org,omitempty" yaml:"org"`
+	// Globs of the directories where dinghyfiles are processed, by default all of them (eg: services/*)
+	IncludeDirs []string `json:"includeDirs,omitempty" yaml:"includeDirs"`
+	// Globs of the directories where dinghyfiles are ignored, they take precedence over includeDirs
+	ExcludeDirs []string `json:"excludeDirs,omitempty" yaml:"excludeDirs"`
+	// Poll the repository for changes, for repositories that can't send webhooks
+	Polling Polling `json:"polling" yaml:"polling"`
+	// Severity of the lint rules by rule ID, overrides lint.rules for the repository
+	Lint map[string]string `json:"lint,omitempty" yaml:"lint"`
+}
+
+type Polling struct {
+	// Enabled flag, only github and gitlab repositories can be polled
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Seconds between polls, by default is 60
+	IntervalSeconds int `json:"intervalSeconds,omitempty" yaml:"intervalSeconds"`
+	// Maximum random seconds added to every interval so replicas and repositories don't poll at once
+	JitterSeconds int `json:"jitterSeconds,omitempty" yaml:"jitterSeconds"`
+}
+
+type TemplateRepoPolling struct {
+	// Enabled flag
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled"`
+	// Version control provider of the template repository, only github and gitlab supported
+	Provider string `json:"provider,omitempty" yaml:"provider"`
+	// Branch, by default is master
+	Branch string `json:"branch,omitempty" yaml:"branch"`
+	// Seconds between polls, by default is 60
+	IntervalSeconds int `json:"intervalSeconds,omitempty" yaml:"intervalSeconds"`
+	// Maximum random seconds added to every interval
+	JitterSeconds int `json:"jitterSeconds,omitempty" yaml:"jitterSeconds"`
+}
+
+// pollingProviders are the version control providers that can be polled
+var pollingProviders = map[string]bool{"github": true, "gitlab": true}
+
+// ValidatePolling checks that polling is only enabled for repositories of a
+// provider that can be polled.
+func (s *Settings) ValidatePolling() error {
+	for _, rc := range s.RepoConfig {
+		if rc.Polling.Enabled && !pollingProviders[rc.Provider] {
+			return fmt.Errorf("repoConfig %s/%s: polling is not supported for provider %q, only github and gitlab repositories can be polled", rc.Org, rc.Repo, rc.Provider)
+		}
+	}
+	if tp := s.TemplateRepoPolling; tp.Enabled && !pollingProviders[tp.Provider] {
+		return fmt.Errorf("templateRepoPolling: polling is not supported for provider %q, only github and gitlab repositories can be polled", tp.Provider)
+	}
+	return nil
 }
 
 func (s *Settings) GetRepoConfig(provider, repo, branch string) *RepoConfig {
@@ -273,6 +551,21 @@ func (s *Settings) GetRepoConfig(provider, repo, branch string) *RepoConfig {
 	return nil
 }
 
+// LintRules returns the severity of the lint rules for a repository, the
+// global severities overridden by the ones of the repository.
+func (s *Settings) LintRules(provider, repo, branch string) map[string]string {
+	rules := map[string]string{}
+	for id, severity := range s.Lint.Rules {
+		rules[id] = severity
+	}
+	if rc := s.GetRepoConfig(provider, repo, branch); rc != nil {
+		for id, severity := range rc.Lint {
+			rules[id] = severity
+		}
+	}
+	return rules
+}
+
 // Redacted returns a copy of the Settings object with all the sensitive
 // fields **REDACTED**.
 func (s *Settings) Redacted() *Settings {
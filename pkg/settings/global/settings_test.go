@@ -138,3 +138,34 @@ func TestSettings_GetRepoConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestSettings_LintRules(t *testing.T) {
+	s := Settings{
+		Lint: Lint{Enabled: true, Rules: map[string]string{"invalid-cron": "warn", "malformed-spel": "error"}},
+		RepoConfig: []RepoConfig{
+			{Provider: "github", Repo: "legacy", Lint: map[string]string{"malformed-spel": "off"}},
+		},
+	}
+	assert.Equal(t, map[string]string{"invalid-cron": "warn", "malformed-spel": "off"}, s.LintRules("github", "legacy", "master"))
+	assert.Equal(t, map[string]string{"invalid-cron": "warn", "malformed-spel": "error"}, s.LintRules("github", "other", "master"))
+	// the repository overrides don't change the global severities
+	assert.Equal(t, "error", s.Lint.Rules["malformed-spel"])
+}
+
+func TestSettings_ValidatePolling(t *testing.T) {
+	s := Settings{
+		RepoConfig: []RepoConfig{
+			{Provider: "github", Org: "my-org", Repo: "polled", Polling: Polling{Enabled: true}},
+			{Provider: "bitbucket-server", Org: "PRJ", Repo: "webhooks"},
+		},
+		TemplateRepoPolling: TemplateRepoPolling{Enabled: true, Provider: "gitlab"},
+	}
+	assert.Nil(t, s.ValidatePolling())
+
+	s.RepoConfig[1].Polling.Enabled = true
+	assert.EqualError(t, s.ValidatePolling(), `repoConfig PRJ/webhooks: polling is not supported for provider "bitbucket-server", only github and gitlab repositories can be polled`)
+
+	s.RepoConfig[1].Polling.Enabled = false
+	s.TemplateRepoPolling.Provider = "bitbucket-cloud"
+	assert.EqualError(t, s.ValidatePolling(), `templateRepoPolling: polling is not supported for provider "bitbucket-cloud", only github and gitlab repositories can be polled`)
+}
@@ -94,6 +94,15 @@ func (i *Initialize) configureSettings(settings global.Settings) (*global.Settin
 		log.Warn("Overriding UserWritePermissionCheck to false")
 		settings.UserWritePermissionsCheckEnabled = false
 	}
+	if settings.UserResourcePermissionsCheckEnabled && settings.SpinnakerSupplied.Fiat.Enabled != "true" {
+		log.Warn("Cannot enable UserResourcePermissionsCheck when Fiat is disabled")
+		log.Warn("Overriding UserResourcePermissionsCheck to false")
+		settings.UserResourcePermissionsCheckEnabled = false
+	}
+
+	if err := settings.ValidatePolling(); err != nil {
+		return nil, err
+	}
 
 	if settings.ParserFormat == "" {
 		settings.ParserFormat = "json"
@@ -54,7 +54,7 @@ func (lSource *LocalSource) GetSettings(r *http.Request, logr *logr.Logger) (*gl
 
 func (*LocalSource) BustCacheHandler(w http.ResponseWriter, r *http.Request) {}
 
-func setupPlankClient(settings *global.Settings, log *logr.Logger) *plank.Client {
+func setupPlankClient(settings *global.Settings, log *logr.Logger) *util.SpinnakerClient {
 	var httpClient *http.Client
 	if log.Level == logr.DebugLevel {
 		httpClient = debug.NewInterceptorHttpClient(log, &settings.Http, true)
@@ -67,8 +67,9 @@ func setupPlankClient(settings *global.Settings, log *logr.Logger) *plank.Client
 	// Update the base URLs based on config
 	client.URLs["orca"] = settings.SpinnakerSupplied.Orca.BaseURL
 	client.URLs["front50"] = settings.SpinnakerSupplied.Front50.BaseURL
+	client.URLs["clouddriver"] = settings.SpinnakerSupplied.Clouddriver.BaseURL
 	client.URLs["gate"] = settings.SpinnakerSupplied.Gate.BaseURL
-	return client
+	return util.NewSpinnakerClient(client)
 }
 
 func (*LocalSource) IsMultiTenant() bool {
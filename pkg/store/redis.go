@@ -0,0 +1,87 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetJSON decodes the value of key into v, it returns false when there is no key.
+func GetJSON(client *redis.Client, key string, v interface{}) (bool, error) {
+	value, err := client.Get(key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"operation": "get key", "key": key}).Error(err)
+		return false, err
+	}
+	if err := json.Unmarshal(value, v); err != nil {
+		log.WithFields(log.Fields{"operation": "unmarshall key " + key}).Error(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// SetJSON saves v encoded in key for minutesTTL minutes, forever when it is 0.
+func SetJSON(client *redis.Client, key string, v interface{}, minutesTTL time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		log.WithFields(log.Fields{"operation": "marshall key " + key}).Error(err)
+		return err
+	}
+	if err := client.Set(key, value, minutesTTL*time.Minute).Err(); err != nil {
+		log.WithFields(log.Fields{"operation": "set key", "key": key}).Error(err)
+		return err
+	}
+	return nil
+}
+
+// NextID increments the counter in key, the ids of the records without a
+// database to number them.
+func NextID(client *redis.Client, key string) (int64, error) {
+	id, err := client.Incr(key).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"operation": "next id", "key": key}).Error(err)
+	}
+	return id, err
+}
+
+// LoadJSON gets the values of keys in one round trip and calls add with every
+// one found. Missing keys are skipped, as are the values add fails to decode
+// so one bad record doesn't hide the others.
+func LoadJSON(client *redis.Client, keys []string, add func(value []byte) error) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	values, err := client.MGet(keys...).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"operation": "get keys"}).Error(err)
+		return err
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if err := add([]byte(raw)); err != nil {
+			log.WithFields(log.Fields{"operation": "unmarshall key " + keys[i]}).Error(err)
+		}
+	}
+	return nil
+}
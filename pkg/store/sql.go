@@ -0,0 +1,46 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JSONColumn encodes v for a text column.
+func JSONColumn(v interface{}) (string, error) {
+	value, err := json.Marshal(v)
+	return string(value), err
+}
+
+// FromJSONColumn decodes a text column into v, an empty column leaves v as it is.
+func FromJSONColumn(column string, v interface{}) error {
+	if column == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(column), v)
+}
+
+// DeleteExpired deletes the rows of model kept for minutesTTL minutes whose
+// dateColumn is before the cutoff. Expired rows are never read again, the
+// clients clean them up as they save new ones. Nothing expires when
+// minutesTTL is 0.
+func DeleteExpired(db *gorm.DB, model interface{}, dateColumn string, minutesTTL time.Duration) error {
+	if minutesTTL <= 0 {
+		return nil
+	}
+	return db.Where(dateColumn+" < ?", Cutoff(minutesTTL)).Delete(model).Error
+}
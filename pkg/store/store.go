@@ -0,0 +1,41 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+// Package store has the date, expiration and encoding helpers shared by the
+// memory, Redis and SQL clients of the records dinghy keeps between requests:
+// webhook deliveries, impact reports, revisions and change requests.
+package store
+
+import (
+	"time"
+)
+
+// Now is the current time in milliseconds, the unit of the record dates.
+func Now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Cutoff is the date before which records kept for minutesTTL minutes expired,
+// records without a TTL never expire so their cutoff is 0.
+func Cutoff(minutesTTL time.Duration) int64 {
+	if minutesTTL <= 0 {
+		return 0
+	}
+	return Now() - int64(minutesTTL*time.Minute/time.Millisecond)
+}
+
+// Expired tells if a record of date kept for minutesTTL minutes expired, it
+// never did when minutesTTL is 0.
+func Expired(date int64, minutesTTL time.Duration) bool {
+	return date < Cutoff(minutesTTL)
+}
@@ -0,0 +1,98 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpired(t *testing.T) {
+	now := Now()
+	assert.False(t, Expired(now, 10))
+	assert.False(t, Expired(now-int64(9*time.Minute/time.Millisecond), 10))
+	assert.True(t, Expired(now-int64(11*time.Minute/time.Millisecond), 10))
+	// nothing expires without a TTL
+	assert.False(t, Expired(1, 0))
+	assert.Equal(t, int64(0), Cutoff(0))
+}
+
+func TestJSONColumn(t *testing.T) {
+	column, err := JSONColumn([]string{"a", "b"})
+	assert.Nil(t, err)
+	assert.Equal(t, `["a","b"]`, column)
+
+	decoded := []string{}
+	assert.Nil(t, FromJSONColumn(column, &decoded))
+	assert.Equal(t, []string{"a", "b"}, decoded)
+
+	// an empty column keeps the default
+	empty := []string{}
+	assert.Nil(t, FromJSONColumn("", &empty))
+	assert.Equal(t, []string{}, empty)
+
+	assert.NotNil(t, FromJSONColumn("[", &decoded))
+}
+
+func TestRedisJSON(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", util.GetenvOrDefault("REDIS_HOST", "redis"), util.GetenvOrDefault("REDIS_PORT", "6379")),
+		Password: util.GetenvOrDefault("REDIS_PASSWORD", ""),
+	})
+	if _, err := client.Ping().Result(); err != nil {
+		t.Skip("Could not connect to Redis; skipping test")
+	}
+	client.Del("store:test:1", "store:test:2", "store:test:bad", "store:test:nextid")
+
+	var value map[string]int
+	found, err := GetJSON(client, "store:test:1", &value)
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	assert.Nil(t, SetJSON(client, "store:test:1", map[string]int{"a": 1}, 1))
+	found, err = GetJSON(client, "store:test:1", &value)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, value["a"])
+	assert.True(t, client.PTTL("store:test:1").Val() > 0)
+	// values without a TTL are kept forever
+	assert.Nil(t, SetJSON(client, "store:test:2", map[string]int{"a": 2}, 0))
+	assert.Equal(t, time.Duration(-1), client.PTTL("store:test:2").Val())
+
+	first, err := NextID(client, "store:test:nextid")
+	assert.Nil(t, err)
+	second, _ := NextID(client, "store:test:nextid")
+	assert.Equal(t, first+1, second)
+
+	// missing and undecodable values are skipped
+	client.Set("store:test:bad", "{", 0)
+	loaded := []map[string]int{}
+	err = LoadJSON(client, []string{"store:test:1", "store:test:2", "store:test:bad"}, func(raw []byte) error {
+		var v map[string]int
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		loaded = append(loaded, v)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []map[string]int{{"a": 1}, {"a": 2}}, loaded)
+	client.Del("store:test:1", "store:test:2", "store:test:bad", "store:test:nextid")
+}
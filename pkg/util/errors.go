@@ -17,8 +17,13 @@
 package util
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"regexp"
+	"strings"
+
+	gitlab "github.com/xanzy/go-gitlab"
 )
 
 type GithubRateLimitErr struct {
@@ -57,3 +62,22 @@ func IsGitHubFileNotFoundErr(errString string) bool {
 func (e *GitHubFileNotFoundErr) Error() string {
 	return fmt.Sprintf("File %s not found for org %s in repository %s", e.Path, e.Org, e.Repo)
 }
+
+// IsFileNotFoundErr tells whether a Downloader failed because the file doesn't
+// exist, the providers report it differently.
+func IsFileNotFoundErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	var notFound *GitHubFileNotFoundErr
+	if errors.As(err, &notFound) {
+		return true
+	}
+	var gitlabErr *gitlab.ErrorResponse
+	if errors.As(err, &gitlabErr) {
+		return gitlabErr.Response != nil && gitlabErr.Response.StatusCode == http.StatusNotFound
+	}
+	// bitbucket downloaders only report the status code
+	return strings.HasSuffix(err.Error(), fmt.Sprintf("Status: %d", http.StatusNotFound)) ||
+		err.Error() == "File not found"
+}
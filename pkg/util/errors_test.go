@@ -17,12 +17,29 @@
 package util
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	gitlab "github.com/xanzy/go-gitlab"
 )
 
 func TestIsGitHubFileNotFoundErr(t *testing.T) {
 	assert.True(t, IsGitHubFileNotFoundErr("No file named stuff found in other stuff"))
 	assert.False(t, IsGitHubFileNotFoundErr("meh"))
 }
+
+func TestIsFileNotFoundErr(t *testing.T) {
+	assert.True(t, IsFileNotFoundErr(&GitHubFileNotFoundErr{Org: "org", Repo: "repo", Path: "dinghyfile"}))
+	assert.True(t, IsFileNotFoundErr(fmt.Errorf("wrapped: %w", &GitHubFileNotFoundErr{})))
+	assert.True(t, IsFileNotFoundErr(&gitlab.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}}))
+	assert.True(t, IsFileNotFoundErr(errors.New("Error downloading file from http://stash: Status: 404")))
+	assert.True(t, IsFileNotFoundErr(errors.New("File not found")))
+
+	assert.False(t, IsFileNotFoundErr(nil))
+	assert.False(t, IsFileNotFoundErr(&gitlab.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnauthorized}}))
+	assert.False(t, IsFileNotFoundErr(errors.New("Error downloading file from http://stash: Status: 500")))
+	assert.False(t, IsFileNotFoundErr(&GithubRateLimitErr{RateLimit: 5000}))
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"strings"
+
 	"github.com/armory/plank/v4"
 )
 
@@ -10,6 +12,8 @@ type PlankClient interface {
 	GetApplicationNotifications(string, string) (*plank.NotificationsType, error)
 	CreateApplication(*plank.Application, string) error
 	UpdateApplication(plank.Application, string) error
+	DeleteApplication(string, string) error
+	GetApplications(string) (*[]plank.Application, error)
 	GetPipelines(string, string) ([]plank.Pipeline, error)
 	DeletePipeline(plank.Pipeline, string) error
 	UpsertPipeline(plank.Pipeline, string, string) error
@@ -20,4 +24,128 @@ type PlankClient interface {
 	UseGateEndpoints()
 	UseServiceEndpoints()
 	UserRoles(string, string) ([]string, error)
+	GetAccounts(string) ([]Account, error)
+	GetStageTypes(string) ([]string, error)
+	GetUserPermissions(string, string) (*UserPermissions, error)
+	GetPipelineTemplate(string, string, string) (*PipelineTemplate, error)
+	UpsertPipelineTemplate(PipelineTemplate, string, string) error
+	DeletePipelineTemplate(string, string, string) error
+	GetTemplatedPipelines(string, string) ([]TemplatedPipeline, error)
+	UpsertTemplatedPipeline(TemplatedPipeline, string, string) error
+}
+
+// Account is a cloud account of Clouddriver.
+type Account struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ServiceAccount is a Fiat service account, usable by the users with all its roles.
+type ServiceAccount struct {
+	Name     string   `json:"name"`
+	MemberOf []string `json:"memberOf"`
+}
+
+// UserPermissions is what Fiat's /authorize/{user} returns, the roles of a
+// user and what they can do with them.
+type UserPermissions struct {
+	Name            string                `json:"name"`
+	Admin           bool                  `json:"admin"`
+	Roles           []plank.FiatRole      `json:"roles"`
+	Accounts        []plank.Authorization `json:"accounts"`
+	Applications    []plank.Authorization `json:"applications"`
+	ServiceAccounts []ServiceAccount      `json:"serviceAccounts"`
+}
+
+// HasRole reports whether the user has a role, roles are case insensitive in Fiat.
+func (u *UserPermissions) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if strings.EqualFold(r.Name, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanWriteApplication reports whether the user can write to an application.
+func (u *UserPermissions) CanWriteApplication(application string) bool {
+	return u.Admin || authorized(u.Applications, application, "WRITE")
+}
+
+// CanWriteAccount reports whether the user can deploy to an account.
+func (u *UserPermissions) CanWriteAccount(account string) bool {
+	return u.Admin || authorized(u.Accounts, account, "WRITE")
+}
+
+// CanUseServiceAccount reports whether the user can run pipelines as a service account.
+func (u *UserPermissions) CanUseServiceAccount(serviceAccount string) bool {
+	if u.Admin {
+		return true
+	}
+	for _, s := range u.ServiceAccounts {
+		if strings.EqualFold(s.Name, serviceAccount) {
+			return true
+		}
+	}
+	return false
+}
+
+func authorized(authorizations []plank.Authorization, name, authorization string) bool {
+	for _, a := range authorizations {
+		if !strings.EqualFold(a.Name, name) {
+			continue
+		}
+		for _, granted := range a.Authorizations {
+			if strings.EqualFold(granted, authorization) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SpinnakerClient is a plank client that also looks up the accounts of
+// Clouddriver and the stage types of Orca, which plank doesn't.
+type SpinnakerClient struct {
+	*plank.Client
+}
+
+// NewSpinnakerClient wraps a plank client, the Clouddriver URL is read from its "clouddriver" URL.
+func NewSpinnakerClient(c *plank.Client) *SpinnakerClient {
+	return &SpinnakerClient{Client: c}
+}
+
+// GetAccounts returns the accounts of Clouddriver.
+func (c *SpinnakerClient) GetAccounts(traceparent string) ([]Account, error) {
+	url := c.URLs["clouddriver"] + "/credentials"
+	if c.UseGate {
+		url = c.URLs["gate"] + "/credentials"
+	}
+	var accounts []Account
+	if err := c.Get(url, traceparent, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetStageTypes returns the types of the stages Orca can run.
+func (c *SpinnakerClient) GetStageTypes(traceparent string) ([]string, error) {
+	url := c.URLs["orca"] + "/stageTypes"
+	if c.UseGate {
+		url = c.URLs["gate"] + "/plank/stageTypes"
+	}
+	var stageTypes []string
+	if err := c.Get(url, traceparent, &stageTypes); err != nil {
+		return nil, err
+	}
+	return stageTypes, nil
+}
+
+// GetUserPermissions returns the permissions of a user from Fiat.
+func (c *SpinnakerClient) GetUserPermissions(username, traceparent string) (*UserPermissions, error) {
+	var permissions UserPermissions
+	if err := c.Get(c.URLs["fiat"]+"/authorize/"+username, traceparent, &permissions); err != nil {
+		return nil, err
+	}
+	return &permissions, nil
 }
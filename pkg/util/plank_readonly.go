@@ -23,7 +23,7 @@ import (
 )
 
 type PlankReadOnly struct {
-	Plank     *plank.Client
+	Plank     *SpinnakerClient
 	tempPipes *[]plank.Pipeline
 }
 
@@ -47,6 +47,14 @@ func (p *PlankReadOnly) UpdateApplication(plank.Application, string) error {
 	return nil
 }
 
+func (p *PlankReadOnly) DeleteApplication(string, string) error {
+	return nil
+}
+
+func (p *PlankReadOnly) GetApplications(traceparent string) (*[]plank.Application, error) {
+	return p.Plank.GetApplications(traceparent)
+}
+
 func (p *PlankReadOnly) GetPipelines(appName, traceparent string) ([]plank.Pipeline, error) {
 	pipes, err := p.Plank.GetPipelines(appName, traceparent)
 	if err != nil {
@@ -112,3 +120,36 @@ func (p *PlankReadOnly) UseGateEndpoints() {
 
 func (p *PlankReadOnly) UseServiceEndpoints() {
 }
+
+func (p *PlankReadOnly) GetAccounts(traceparent string) ([]Account, error) {
+	return p.Plank.GetAccounts(traceparent)
+}
+
+func (p *PlankReadOnly) GetStageTypes(traceparent string) ([]string, error) {
+	return p.Plank.GetStageTypes(traceparent)
+}
+
+func (p *PlankReadOnly) GetUserPermissions(username, traceparent string) (*UserPermissions, error) {
+	return p.Plank.GetUserPermissions(username, traceparent)
+}
+
+func (p *PlankReadOnly) GetPipelineTemplate(id, tag, traceparent string) (*PipelineTemplate, error) {
+	return p.Plank.GetPipelineTemplate(id, tag, traceparent)
+}
+
+func (p *PlankReadOnly) UpsertPipelineTemplate(PipelineTemplate, string, string) error {
+	return nil
+}
+
+func (p *PlankReadOnly) DeletePipelineTemplate(string, string, string) error {
+	return nil
+}
+
+func (p *PlankReadOnly) GetTemplatedPipelines(appName, traceparent string) ([]TemplatedPipeline, error) {
+	return p.Plank.GetTemplatedPipelines(appName, traceparent)
+}
+
+func (p *PlankReadOnly) UpsertTemplatedPipeline(pipe TemplatedPipeline, appName string, traceparent string) error {
+	// Like the other pipelines, so that pipelineID finds it
+	return p.UpsertPipeline(plank.Pipeline{Name: pipe.Name, Application: pipe.Application, Type: pipe.Type}, appName, traceparent)
+}
@@ -0,0 +1,197 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/armory/plank/v4"
+)
+
+const (
+	// TemplateSchema is the schema of the pipeline templates and templated pipelines dinghy manages (MPTv2)
+	TemplateSchema = "v2"
+	// TemplatedPipelineType is the type of the pipelines built from a pipeline template
+	TemplatedPipelineType = "templatedPipeline"
+	// Front50TemplateScheme is the scheme of the references to the pipeline templates saved in Front50
+	Front50TemplateScheme = "spinnaker://"
+	// Front50TemplateType is the artifact type of the pipeline templates saved in Front50
+	Front50TemplateType = "front50/pipelineTemplate"
+	// Front50TemplateAccount is the artifact account of the pipeline templates saved in Front50
+	Front50TemplateAccount = "front50ArtifactCredentials"
+)
+
+// PipelineTemplate is a v2 pipeline template, saved in Front50 with a tag.
+type PipelineTemplate struct {
+	ID        string                   `json:"id" yaml:"id" hcl:"id"`
+	Schema    string                   `json:"schema" yaml:"schema" hcl:"schema"`
+	Tag       string                   `json:"tag,omitempty" yaml:"tag,omitempty" hcl:"tag,omitempty"`
+	Protect   bool                     `json:"protect" yaml:"protect" hcl:"protect"`
+	Metadata  PipelineTemplateMetadata `json:"metadata" yaml:"metadata" hcl:"metadata"`
+	Variables []map[string]interface{} `json:"variables,omitempty" yaml:"variables,omitempty" hcl:"variables,omitempty"`
+	Pipeline  map[string]interface{}   `json:"pipeline" yaml:"pipeline" hcl:"pipeline"`
+}
+
+// PipelineTemplateMetadata describes a pipeline template.
+type PipelineTemplateMetadata struct {
+	Name        string   `json:"name" yaml:"name" hcl:"name"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty" hcl:"description,omitempty"`
+	Owner       string   `json:"owner,omitempty" yaml:"owner,omitempty" hcl:"owner,omitempty"`
+	Scopes      []string `json:"scopes,omitempty" yaml:"scopes,omitempty" hcl:"scopes,omitempty"`
+}
+
+// TemplateReference is the pipeline template a templated pipeline is built from.
+type TemplateReference struct {
+	ArtifactAccount string `json:"artifactAccount" yaml:"artifactAccount" hcl:"artifactAccount"`
+	Reference       string `json:"reference" yaml:"reference" hcl:"reference"`
+	Type            string `json:"type" yaml:"type" hcl:"type"`
+}
+
+// Front50Template returns the ID and tag of a pipeline template saved in
+// Front50 from a reference like spinnaker://id:tag, ok is false for the
+// templates of other artifacts.
+func (r TemplateReference) Front50Template() (id, tag string, ok bool) {
+	if !strings.HasPrefix(r.Reference, Front50TemplateScheme) {
+		return "", "", false
+	}
+	id = strings.TrimPrefix(r.Reference, Front50TemplateScheme)
+	// a digest pins the template whatever its tag
+	if i := strings.Index(id, "@"); i >= 0 {
+		id = id[:i]
+	}
+	if i := strings.Index(id, ":"); i >= 0 {
+		id, tag = id[:i], id[i+1:]
+	}
+	return id, tag, id != ""
+}
+
+// TemplatedPipeline is a pipeline built from a v2 pipeline template, plank
+// doesn't know its template and variables.
+type TemplatedPipeline struct {
+	ID                string                   `json:"id,omitempty" yaml:"id,omitempty" hcl:"id,omitempty"`
+	Schema            string                   `json:"schema" yaml:"schema" hcl:"schema"`
+	Type              string                   `json:"type" yaml:"type" hcl:"type"`
+	Name              string                   `json:"name" yaml:"name" hcl:"name"`
+	Application       string                   `json:"application" yaml:"application" hcl:"application"`
+	Description       string                   `json:"description,omitempty" yaml:"description,omitempty" hcl:"description,omitempty"`
+	Template          TemplateReference        `json:"template" yaml:"template" hcl:"template"`
+	Variables         map[string]interface{}   `json:"variables,omitempty" yaml:"variables,omitempty" hcl:"variables,omitempty"`
+	Exclude           []string                 `json:"exclude,omitempty" yaml:"exclude,omitempty" hcl:"exclude,omitempty"`
+	Stages            []map[string]interface{} `json:"stages,omitempty" yaml:"stages,omitempty" hcl:"stages,omitempty"`
+	Triggers          []map[string]interface{} `json:"triggers,omitempty" yaml:"triggers,omitempty" hcl:"triggers,omitempty"`
+	Parameters        []map[string]interface{} `json:"parameters,omitempty" yaml:"parameters,omitempty" hcl:"parameters,omitempty"`
+	Notifications     []map[string]interface{} `json:"notifications,omitempty" yaml:"notifications,omitempty" hcl:"notifications,omitempty"`
+	ExpectedArtifacts []map[string]interface{} `json:"expectedArtifacts,omitempty" yaml:"expectedArtifacts,omitempty" hcl:"expectedArtifacts,omitempty"`
+	Locked            *plank.PipelineLockType  `json:"locked,omitempty" yaml:"locked,omitempty" hcl:"locked,omitempty"`
+	Config            interface{}              `json:"config,omitempty" yaml:"config,omitempty" hcl:"config,omitempty"`
+}
+
+func (c *SpinnakerClient) pipelineTemplatesURL() string {
+	if c.UseGate {
+		return c.URLs["gate"] + "/v2/pipelineTemplates"
+	}
+	return c.URLs["front50"] + "/v2/pipelineTemplates"
+}
+
+func (c *SpinnakerClient) templatedPipelinesURL() string {
+	if c.UseGate {
+		return c.URLs["gate"] + "/plank/pipelines"
+	}
+	return c.URLs["front50"] + "/pipelines"
+}
+
+// GetPipelineTemplate returns a version of a pipeline template, the latest
+// without a tag. It returns nil when there is none.
+func (c *SpinnakerClient) GetPipelineTemplate(id, tag, traceparent string) (*PipelineTemplate, error) {
+	var template PipelineTemplate
+	err := c.Get(fmt.Sprintf("%s/%s%s", c.pipelineTemplatesURL(), url.PathEscape(id), tagQuery(tag)), traceparent, &template)
+	if failed, ok := err.(*plank.FailedResponse); ok && failed.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get pipeline template '%s': %w", id, err)
+	}
+	return &template, nil
+}
+
+// UpsertPipelineTemplate saves a pipeline template with its tag, it is
+// created when id is empty and updated otherwise, like plank's UpsertPipeline.
+func (c *SpinnakerClient) UpsertPipelineTemplate(t PipelineTemplate, id, traceparent string) error {
+	var unused interface{}
+	var err error
+	switch {
+	case id == "" && c.UseGate:
+		err = c.PostWithRetry(c.pipelineTemplatesURL()+"/create"+tagQuery(t.Tag), traceparent, plank.ApplicationJson, t, &unused)
+	case id == "":
+		err = c.PostWithRetry(c.pipelineTemplatesURL()+tagQuery(t.Tag), traceparent, plank.ApplicationJson, t, &unused)
+	case c.UseGate:
+		err = c.PostWithRetry(fmt.Sprintf("%s/update/%s%s", c.pipelineTemplatesURL(), url.PathEscape(id), tagQuery(t.Tag)), traceparent, plank.ApplicationJson, t, &unused)
+	default:
+		err = c.PutWithRetry(fmt.Sprintf("%s/%s%s", c.pipelineTemplatesURL(), url.PathEscape(id), tagQuery(t.Tag)), traceparent, plank.ApplicationJson, t, &unused)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save pipeline template '%s': %w", t.ID, err)
+	}
+	return nil
+}
+
+// DeletePipelineTemplate deletes a version of a pipeline template, the
+// latest without a tag.
+func (c *SpinnakerClient) DeletePipelineTemplate(id, tag, traceparent string) error {
+	if err := c.DeleteWithRetry(fmt.Sprintf("%s/%s%s", c.pipelineTemplatesURL(), url.PathEscape(id), tagQuery(tag)), traceparent); err != nil {
+		return fmt.Errorf("could not delete pipeline template '%s': %w", id, err)
+	}
+	return nil
+}
+
+// GetTemplatedPipelines returns the templated pipelines of an application.
+func (c *SpinnakerClient) GetTemplatedPipelines(app, traceparent string) ([]TemplatedPipeline, error) {
+	var pipelines []TemplatedPipeline
+	if err := c.GetWithRetry(c.templatedPipelinesURL()+"/"+app, traceparent, &pipelines); err != nil {
+		return nil, fmt.Errorf("could not get templated pipelines for %s - %v", app, err)
+	}
+	templated := []TemplatedPipeline{}
+	for _, p := range pipelines {
+		if p.Type == TemplatedPipelineType {
+			templated = append(templated, p)
+		}
+	}
+	return templated, nil
+}
+
+// UpsertTemplatedPipeline saves a templated pipeline, it is created when id
+// is empty and updated otherwise.
+func (c *SpinnakerClient) UpsertTemplatedPipeline(p TemplatedPipeline, id, traceparent string) error {
+	var unused interface{}
+	if id == "" {
+		if err := c.PostWithRetry(c.templatedPipelinesURL(), traceparent, plank.ApplicationJson, p, &unused); err != nil {
+			return fmt.Errorf("could not create templated pipeline '%s' in app '%s': %w", p.Name, p.Application, err)
+		}
+		return nil
+	}
+	if err := c.PutWithRetry(fmt.Sprintf("%s/%s", c.templatedPipelinesURL(), id), traceparent, plank.ApplicationJson, p, &unused); err != nil {
+		return fmt.Errorf("could not update templated pipeline '%s' in app '%s': %w", p.Name, p.Application, err)
+	}
+	return nil
+}
+
+func tagQuery(tag string) string {
+	if tag == "" {
+		return ""
+	}
+	return "?tag=" + url.QueryEscape(tag)
+}
@@ -0,0 +1,125 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/plank/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpinnakerClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/credentials":
+			w.Write([]byte(`[{"name": "k8s", "type": "kubernetes", "environment": "prod"}]`))
+		case "/stageTypes":
+			w.Write([]byte(`["deployManifest", "wait"]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := NewSpinnakerClient(plank.New(plank.WithMaxRetries(1)))
+	c.URLs["clouddriver"] = server.URL
+	c.URLs["orca"] = server.URL
+
+	accounts, err := c.GetAccounts("")
+	assert.Nil(t, err)
+	assert.Equal(t, []Account{{Name: "k8s", Type: "kubernetes"}}, accounts)
+
+	stageTypes, err := c.GetStageTypes("")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deployManifest", "wait"}, stageTypes)
+
+	c.URLs["gate"] = server.URL
+	c.UseGateEndpoints()
+	_, err = c.GetStageTypes("")
+	assert.NotNil(t, err)
+}
+
+func TestSpinnakerClientPipelineTemplates(t *testing.T) {
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2/pipelineTemplates/deploy":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"id": "deploy", "schema": "v2", "metadata": {"name": "Deploy"}, "pipeline": {"stages": []}}`))
+			}
+		case "/v2/pipelineTemplates":
+		case "/pipelines/app":
+			w.Write([]byte(`[{"id": "1", "name": "plain"}, {"id": "2", "name": "templated", "type": "templatedPipeline", "schema": "v2", "template": {"reference": "spinnaker://deploy"}, "variables": {"replicas": 2}}]`))
+		case "/pipelines":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := NewSpinnakerClient(plank.New(plank.WithMaxRetries(1)))
+	c.URLs["front50"] = server.URL
+
+	template, err := c.GetPipelineTemplate("deploy", "v1", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Deploy", template.Metadata.Name)
+	template, err = c.GetPipelineTemplate("missing", "", "")
+	assert.Nil(t, err)
+	assert.Nil(t, template)
+
+	assert.Nil(t, c.UpsertPipelineTemplate(PipelineTemplate{ID: "deploy", Tag: "v2"}, "", ""))
+	assert.Nil(t, c.UpsertPipelineTemplate(PipelineTemplate{ID: "deploy", Tag: "v2"}, "deploy", ""))
+	assert.Nil(t, c.DeletePipelineTemplate("deploy", "v2", ""))
+
+	templated, err := c.GetTemplatedPipelines("app", "")
+	assert.Nil(t, err)
+	assert.Len(t, templated, 1)
+	assert.Equal(t, "templated", templated[0].Name)
+	assert.Equal(t, map[string]interface{}{"replicas": float64(2)}, templated[0].Variables)
+	assert.Nil(t, c.UpsertTemplatedPipeline(templated[0], "", ""))
+
+	assert.Equal(t, []string{
+		"GET /v2/pipelineTemplates/deploy?tag=v1",
+		"GET /v2/pipelineTemplates/missing",
+		"POST /v2/pipelineTemplates?tag=v2",
+		"PUT /v2/pipelineTemplates/deploy?tag=v2",
+		"DELETE /v2/pipelineTemplates/deploy?tag=v2",
+		"GET /pipelines/app",
+		"POST /pipelines",
+	}, requests)
+}
+
+func TestFront50Template(t *testing.T) {
+	for _, test := range []struct {
+		reference string
+		id, tag   string
+		ok        bool
+	}{
+		{"spinnaker://deploy", "deploy", "", true},
+		{"spinnaker://deploy:v1", "deploy", "v1", true},
+		{"spinnaker://deploy@sha256:abc", "deploy", "", true},
+		{"https://example.org/deploy.json", "", "", false},
+	} {
+		id, tag, ok := TemplateReference{Reference: test.reference}.Front50Template()
+		assert.Equal(t, test.id, id)
+		assert.Equal(t, test.tag, tag)
+		assert.Equal(t, test.ok, ok)
+	}
+}
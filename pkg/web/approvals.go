@@ -0,0 +1,310 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/git/dummy"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+)
+
+// ChangeRequestStatus is the status of the log events of approved change requests
+const ChangeRequestStatus = "changerequest"
+
+// expirationUser is who expired change requests are recorded as expired by
+const expirationUser = "dinghy"
+
+var errApprovalsDisabled = errors.New("approvals are not enabled")
+
+// ChangeRequestOutcome is a change request after it was approved or rejected.
+type ChangeRequestOutcome struct {
+	approvals.ChangeRequest
+	Pipelines *logevents.PipelineChanges `json:"pipelines,omitempty"`
+	Error     string                     `json:"error,omitempty"`
+}
+
+// changeRequestsHandler returns a change request by id, or lists the change
+// requests filtered by status and application, newest first.
+func (wa *WebAPI) changeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if wa.ApprovalsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errApprovalsDisabled)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("id") != "" {
+		request, status, err := wa.changeRequest(q.Get("id"))
+		if err != nil {
+			util.WriteHTTPError(w, status, err)
+			return
+		}
+		writeJSON(w, request)
+		return
+	}
+	found, err := wa.ApprovalsClient.GetChangeRequests(q.Get("status"), q.Get("application"))
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	listed := []approvals.ChangeRequest{}
+	for _, request := range found {
+		request = wa.expireChangeRequest(request)
+		// expiring changed the status the change requests were filtered by
+		if q.Get("status") == "" || request.Status == q.Get("status") {
+			listed = append(listed, request)
+		}
+	}
+	writeJSON(w, listed)
+}
+
+// approveHandler applies a pending change request, it must be approved by
+// someone other than its pusher and, when approvers are set, by one of them.
+// The approver is the user Gate authenticated, the endpoint must be reached
+// through Gate and requests from anything but approvals.trustedProxies are
+// rejected.
+func (wa *WebAPI) approveHandler(w http.ResponseWriter, r *http.Request) {
+	if wa.ApprovalsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errApprovalsDisabled)
+		return
+	}
+	settings, plankClient, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	request, approver, ok := wa.pendingChangeRequest(w, r, settings)
+	if !ok {
+		return
+	}
+	if approver == request.Pusher {
+		util.WriteHTTPError(w, http.StatusForbidden, fmt.Errorf("%s pushed change request %d and can't approve it", approver, request.ID))
+		return
+	}
+	if len(settings.Approvals.Approvers) > 0 && !contains(settings.Approvals.Approvers, approver) {
+		util.WriteHTTPError(w, http.StatusForbidden, fmt.Errorf("%s is not an approver", approver))
+		return
+	}
+	// the changes of a frozen application wait for the end of the freeze,
+	// unless an approver allowed to override freezes approves them
+	eventStatus, overridden := ChangeRequestStatus, ""
+	active, err := freezes(settings).Active(request.Org, request.Repo, request.Application)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if active != nil {
+		if !contains(settings.Freezes.OverrideUsers, approver) {
+			util.WriteHTTPError(w, http.StatusConflict, fmt.Errorf("change request %d can't be applied during the %s", request.ID, active.Description()))
+			return
+		}
+		eventStatus = FreezeOverrideStatus
+		overridden = fmt.Sprintf(" despite the %s", active.Description())
+	}
+
+	request.Record(approver, approvals.ActionApproved, r.URL.Query().Get("comment"))
+	request.Status = approvals.StatusApplied
+	if !wa.transitionChangeRequest(w, request) {
+		return
+	}
+
+	builder := wa.newPipelineBuilder(dummy.FileService{}, dinghylog.NewDinghyLogs(wa.Logger), plankClient, settings, nil, nil)
+	changes, err := builder.ApplyChangeRequest(request)
+	outcome := ChangeRequestOutcome{Pipelines: &changes}
+	message := fmt.Sprintf("Applied change request %d to %s%s, approved by %s", request.ID, request.Application, overridden, approver)
+	if err != nil {
+		request.Status = approvals.StatusFailed
+		request.Record(approver, approvals.ActionFailed, err.Error())
+		outcome.Error = err.Error()
+		message = fmt.Sprintf("Failed to apply change request %d to %s: %s", request.ID, request.Application, err.Error())
+	} else {
+		request.Record(approver, approvals.ActionApplied, "")
+	}
+	if saved, errSave := wa.ApprovalsClient.SaveChangeRequest(request); errSave != nil {
+		wa.Logger.Errorf("Unable to save change request %d: %s", request.ID, errSave.Error())
+	} else {
+		request = saved
+	}
+	outcome.ChangeRequest = request
+	wa.saveChangeRequestEvent(request, eventStatus, message, changes)
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, outcome)
+}
+
+// rejectHandler discards a pending change request.
+func (wa *WebAPI) rejectHandler(w http.ResponseWriter, r *http.Request) {
+	if wa.ApprovalsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errApprovalsDisabled)
+		return
+	}
+	settings, _, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	request, user, ok := wa.pendingChangeRequest(w, r, settings)
+	if !ok {
+		return
+	}
+	request.Status = approvals.StatusRejected
+	request.Record(user, approvals.ActionRejected, r.URL.Query().Get("comment"))
+	if !wa.transitionChangeRequest(w, request) {
+		return
+	}
+	wa.Logger.Infof("Change request %d of %s rejected by %s", request.ID, request.Application, user)
+	writeJSON(w, ChangeRequestOutcome{ChangeRequest: request})
+}
+
+// pendingChangeRequest returns the change request of the id parameter and the
+// user of the request, writing the error when the change request can't be
+// approved or rejected.
+func (wa *WebAPI) pendingChangeRequest(w http.ResponseWriter, r *http.Request, s *global.Settings) (approvals.ChangeRequest, string, bool) {
+	if !trustedProxy(r, s.Approvals.TrustedProxies) {
+		wa.Logger.Warnf("Rejected a change request approval from %s, it isn't a trusted proxy", r.RemoteAddr)
+		util.WriteHTTPError(w, http.StatusForbidden, errors.New("change requests can only be approved or rejected through Gate"))
+		return approvals.ChangeRequest{}, "", false
+	}
+	user := r.Header.Get("X-Spinnaker-User")
+	if user == "" {
+		util.WriteHTTPError(w, http.StatusUnauthorized, errors.New("the X-Spinnaker-User header is required"))
+		return approvals.ChangeRequest{}, "", false
+	}
+	request, status, err := wa.changeRequest(r.URL.Query().Get("id"))
+	if err != nil {
+		util.WriteHTTPError(w, status, err)
+		return request, user, false
+	}
+	if request.Status != approvals.StatusPending {
+		util.WriteHTTPError(w, http.StatusConflict, fmt.Errorf("change request %d is %s", request.ID, request.Status))
+		return request, user, false
+	}
+	return request, user, true
+}
+
+// trustedProxy checks if a request was sent by one of the trusted proxies, by
+// address or CIDR. The X-Spinnaker-User header is only set by Gate after it
+// authenticated the user, anything else reaching dinghy directly could set it
+// to anyone. Only local requests are trusted when there are no proxies.
+func trustedProxy(r *http.Request, proxies []string) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if len(proxies) == 0 {
+		return ip.IsLoopback()
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
+		if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionChangeRequest saves a pending change request that was approved or
+// rejected, writing the error when it was approved or rejected meanwhile.
+func (wa *WebAPI) transitionChangeRequest(w http.ResponseWriter, request approvals.ChangeRequest) bool {
+	transitioned, err := wa.ApprovalsClient.TransitionChangeRequest(request, approvals.StatusPending)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !transitioned {
+		util.WriteHTTPError(w, http.StatusConflict, fmt.Errorf("change request %d is not pending anymore", request.ID))
+		return false
+	}
+	return true
+}
+
+// changeRequest returns the change request with an id, and the HTTP status of
+// the error when there is one.
+func (wa *WebAPI) changeRequest(rawID string) (approvals.ChangeRequest, int, error) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return approvals.ChangeRequest{}, http.StatusBadRequest, errors.New("a numeric id parameter is required")
+	}
+	request, err := wa.ApprovalsClient.GetChangeRequest(id)
+	if err != nil {
+		return approvals.ChangeRequest{}, http.StatusInternalServerError, err
+	}
+	if request == nil {
+		return approvals.ChangeRequest{}, http.StatusNotFound, fmt.Errorf("change request %d not found", id)
+	}
+	return wa.expireChangeRequest(*request), http.StatusOK, nil
+}
+
+// expireChangeRequest records that a pending change request expired, once it did.
+func (wa *WebAPI) expireChangeRequest(request approvals.ChangeRequest) approvals.ChangeRequest {
+	if !request.Expired() {
+		return request
+	}
+	expired := request
+	expired.Status = approvals.StatusExpired
+	expired.Record(expirationUser, approvals.ActionExpired, "")
+	transitioned, err := wa.ApprovalsClient.TransitionChangeRequest(expired, approvals.StatusPending)
+	if err != nil {
+		wa.Logger.Errorf("Unable to expire change request %d: %s", request.ID, err.Error())
+		return request
+	}
+	if !transitioned {
+		// it was approved or rejected just before expiring
+		if current, err := wa.ApprovalsClient.GetChangeRequest(request.ID); err == nil && current != nil {
+			return *current
+		}
+		return request
+	}
+	return expired
+}
+
+func (wa *WebAPI) saveChangeRequestEvent(request approvals.ChangeRequest, status, message string, changes logevents.PipelineChanges) {
+	if wa.LogEventsClient == nil {
+		return
+	}
+	commits := []string{}
+	if request.Commit != "" {
+		commits = append(commits, request.Commit)
+	}
+	if err := wa.LogEventsClient.SaveLogEvent(logevents.LogEvent{
+		Org:                request.Org,
+		Repo:               request.Repo,
+		Files:              []string{request.Path},
+		Message:            message,
+		Commits:            commits,
+		Status:             status,
+		RenderedDinghyfile: request.Dinghyfile,
+		Pipelines:          &changes,
+	}); err != nil {
+		wa.Logger.Errorf("Unable to save the log event of change request %d: %s", request.ID, err.Error())
+	}
+}
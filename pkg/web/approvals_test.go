@@ -0,0 +1,196 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func pendingChangeRequest(store approvals.Client, application string) approvals.ChangeRequest {
+	request, _ := store.SaveChangeRequest(approvals.ChangeRequest{
+		Application: application,
+		Org:         "org",
+		Repo:        "repo",
+		Path:        "dinghyfile",
+		Branch:      "master",
+		Commit:      "c1",
+		Pusher:      "pusher",
+		Dinghyfile:  `{"application":"` + application + `","pipelines":[{"name":"deploy","application":"` + application + `"}]}`,
+		Status:      approvals.StatusPending,
+	})
+	return request
+}
+
+func postChangeRequest(handler http.HandlerFunc, url, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, url, nil)
+	r.RemoteAddr = "127.0.0.1:41234"
+	if user != "" {
+		r.Header.Set("X-Spinnaker-User", user)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, r)
+	return rr
+}
+
+func TestApproveChangeRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := dinghyfile.NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("app", "").Return(&plank.Application{Name: "app"}, nil).Times(1)
+	client.EXPECT().GetPipelines("app", "").Return([]plank.Pipeline{}, nil).Times(1)
+	client.EXPECT().UpsertPipeline(gomock.Any(), "", "").Return(nil).Times(1)
+
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(r *http.Request, logger2 *logrus.Logger) (*global.Settings, util.PlankClient, error) {
+		return &global.Settings{DinghyFilename: "dinghyfile", Approvals: global.Approvals{Approvers: []string{"pusher", "approver"}}}, client, nil
+	})
+
+	logEvents := logevents.NewMockLogEventsClient(ctrl)
+	logEvents.EXPECT().SaveLogEvent(gomock.Any()).DoAndReturn(func(e logevents.LogEvent) error {
+		assert.Equal(t, ChangeRequestStatus, e.Status)
+		assert.Equal(t, []string{"c1"}, e.Commits)
+		assert.Equal(t, "Applied change request 1 to app, approved by approver", e.Message)
+		return nil
+	}).Times(1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(sc, cache.NewMemoryCache(), nil, logger, nil, nil, logEvents, nil)
+	wa.AddDinghyfileUnmarshaller(&dinghyfile.DinghyJsonUnmarshaller{})
+	store := approvals.NewChangeRequestMemoryClient()
+	wa.ApprovalsClient = store
+	request := pendingChangeRequest(store, "app")
+
+	rr := postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1", "")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	// the header is only trusted from Gate
+	r := httptest.NewRequest(http.MethodPost, "/v1/changerequests/approve?id=1", nil)
+	r.Header.Set("X-Spinnaker-User", "approver")
+	rr = httptest.NewRecorder()
+	wa.approveHandler(rr, r)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), "through Gate")
+	rr = postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1", "pusher")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1", "someone")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=2", "approver")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1&comment=ship+it", "approver")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"pipelines":{"created":1,"updated":0,"unchanged":0,"deleted":0}`)
+
+	applied, _ := store.GetChangeRequest(request.ID)
+	assert.Equal(t, approvals.StatusApplied, applied.Status)
+	assert.Len(t, applied.Audit, 2)
+	assert.Equal(t, approvals.AuditEntry{Date: applied.Audit[0].Date, User: "approver", Action: approvals.ActionApproved, Comment: "ship it"}, applied.Audit[0])
+	assert.Equal(t, approvals.ActionApplied, applied.Audit[1].Action)
+
+	// it was applied already
+	rr = postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1", "approver")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+}
+
+func TestRejectChangeRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).AnyTimes().Return(&global.Settings{}, nil, nil)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(sc, cache.NewMemoryCache(), nil, logger, nil, nil, nil, nil)
+	rr := postChangeRequest(wa.rejectHandler, "/v1/changerequests/reject?id=1", "approver")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	store := approvals.NewChangeRequestMemoryClient()
+	wa.ApprovalsClient = store
+	request := pendingChangeRequest(store, "app")
+	rr = postChangeRequest(wa.rejectHandler, "/v1/changerequests/reject?id=1&comment=no", "approver")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rejected, _ := store.GetChangeRequest(request.ID)
+	assert.Equal(t, approvals.StatusRejected, rejected.Status)
+	assert.Equal(t, "no", rejected.Audit[0].Comment)
+
+	expired := pendingChangeRequest(store, "app")
+	expired.ExpiresAt = 1
+	store.SaveChangeRequest(expired)
+	rr = postChangeRequest(wa.rejectHandler, "/v1/changerequests/reject?id=2", "approver")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "change request 2 is expired")
+}
+
+func TestChangeRequestsHandler(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, logger, nil, nil, nil, nil)
+	store := approvals.NewChangeRequestMemoryClient()
+	wa.ApprovalsClient = store
+	pendingChangeRequest(store, "app")
+	expired := pendingChangeRequest(store, "other")
+	expired.ExpiresAt = 1
+	store.SaveChangeRequest(expired)
+
+	rr := httptest.NewRecorder()
+	wa.changeRequestsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/changerequests?status=pending", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"application":"app"`)
+	assert.NotContains(t, rr.Body.String(), `"application":"other"`)
+
+	rr = httptest.NewRecorder()
+	wa.changeRequestsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/changerequests?id=2", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"expired"`)
+}
+
+func TestTrustedProxy(t *testing.T) {
+	cases := map[string]struct {
+		remoteAddr string
+		proxies    []string
+		expected   bool
+	}{
+		"local without proxies":      {remoteAddr: "127.0.0.1:1234", expected: true},
+		"local ipv6 without proxies": {remoteAddr: "[::1]:1234", expected: true},
+		"remote without proxies":     {remoteAddr: "10.0.0.5:1234", expected: false},
+		"proxy address":              {remoteAddr: "10.0.0.5:1234", proxies: []string{"10.0.0.5"}, expected: true},
+		"proxy network":              {remoteAddr: "10.0.0.5:1234", proxies: []string{"10.0.0.0/24"}, expected: true},
+		"local with proxies":         {remoteAddr: "127.0.0.1:1234", proxies: []string{"10.0.0.0/24"}, expected: false},
+		"outside of the network":     {remoteAddr: "10.0.1.5:1234", proxies: []string{"10.0.0.0/24", "bad"}, expected: false},
+	}
+	for desc, c := range cases {
+		t.Run(desc, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/changerequests/approve", nil)
+			r.RemoteAddr = c.remoteAddr
+			assert.Equal(t, c.expected, trustedProxy(r, c.proxies))
+		})
+	}
+}
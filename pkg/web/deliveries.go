@@ -0,0 +1,149 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/armory/dinghy/pkg/deliveries"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+)
+
+const (
+	// DuplicateDeliveryHeader is set on responses replayed for an already processed delivery
+	DuplicateDeliveryHeader = "X-Dinghy-Duplicate-Delivery"
+	// ForceReprocessHeader makes dinghy process a delivery even if it was already processed,
+	// the same can be achieved with the force=true query parameter
+	ForceReprocessHeader = "X-Dinghy-Force-Reprocess"
+)
+
+// staleDeliveryClaim is how long a delivery can be in progress, after it the
+// replica processing it is assumed dead and redeliveries are processed
+const staleDeliveryClaim = 15 * time.Minute
+
+// deliveryResponseWriter records the response sent for a webhook delivery so
+// it can be replayed when the same delivery is received again.
+type deliveryResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *deliveryResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deliveryResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// buildPipelinesOnce wraps buildPipelines suppressing duplicated deliveries of
+// the same webhook. When no DeliveriesClient is configured every delivery is
+// processed.
+func (wa *WebAPI) buildPipelinesOnce(
+	r *http.Request,
+	p Push,
+	rawPushBytes []byte,
+	d dinghyfile.Downloader,
+	w http.ResponseWriter,
+	l dinghylog.DinghyLog,
+	pullRequest string,
+	pc util.PlankClient,
+	s *global.Settings,
+) {
+	if wa.DeliveriesClient == nil {
+		wa.buildPipelines(p, rawPushBytes, d, w, l, pullRequest, pc, s)
+		return
+	}
+
+	id := deliveryID(r, p, rawPushBytes)
+	// Redeliveries caused by timeouts usually arrive while the original
+	// delivery is still being processed, it is claimed as in progress right
+	// away and atomically so replicas receiving both don't process it twice.
+	if forceReprocess(r) {
+		l.Infof("Forcing reprocess of webhook delivery %s", id)
+		if err := wa.DeliveriesClient.SaveDelivery(deliveries.Delivery{
+			ID:         id,
+			Provider:   p.Name(),
+			StatusCode: http.StatusAccepted,
+			Response:   deliveries.ProcessingResponse,
+		}); err != nil {
+			l.Warnf("Unable to record webhook delivery %s: %s", id, err.Error())
+		}
+	} else {
+		delivery, err := wa.DeliveriesClient.ClaimDelivery(id, p.Name(), staleDeliveryClaim)
+		if err != nil {
+			l.Warnf("Unable to claim webhook delivery %s, processing it anyway: %s", id, err.Error())
+		} else if delivery != nil {
+			l.Infof("Webhook delivery %s was already received, replaying its original outcome", id)
+			w.Header().Set(DuplicateDeliveryHeader, "true")
+			w.WriteHeader(delivery.StatusCode)
+			w.Write([]byte(delivery.Response))
+			return
+		}
+	}
+
+	recorder := &deliveryResponseWriter{ResponseWriter: w, status: http.StatusOK}
+	wa.buildPipelines(p, rawPushBytes, d, recorder, l, pullRequest, pc, s)
+
+	if err := wa.DeliveriesClient.SaveDelivery(deliveries.Delivery{
+		ID:         id,
+		Provider:   p.Name(),
+		StatusCode: recorder.status,
+		Response:   recorder.body.String(),
+	}); err != nil {
+		l.Warnf("Unable to record outcome of webhook delivery %s: %s", id, err.Error())
+	}
+}
+
+// deliveryID identifies a delivery by the headers set by the provider, falling
+// back to a hash of the provider, repository, ref and pushed sha.
+func deliveryID(r *http.Request, p Push, rawPushBytes []byte) string {
+	if id := deliveries.IDFromHeaders(p.Name(), r.Header); id != "" {
+		return id
+	}
+	return deliveries.IDFromPush(p.Name(), p.Org(), p.Repo(), p.Branch(), afterSha(p, rawPushBytes))
+}
+
+// afterSha returns the sha the ref was moved to by the push. Providers that
+// don't send it are identified by their whole payload.
+func afterSha(p Push, rawPushBytes []byte) string {
+	content := make(map[string]interface{})
+	if err := json.Unmarshal(rawPushBytes, &content); err == nil {
+		for _, key := range []string{"after", "checkout_sha"} {
+			if sha, ok := content[key].(string); ok && sha != "" {
+				return sha
+			}
+		}
+	}
+	if commits := p.GetCommits(); len(commits) > 0 {
+		return commits[len(commits)-1]
+	}
+	sum := sha256.Sum256(rawPushBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+func forceReprocess(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "true" || r.Header.Get(ForceReprocessHeader) == "true"
+}
@@ -0,0 +1,113 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/deliveries"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/mock"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGithubWebhookHandlerDuplicateDelivery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := mock.NewMockFieldLogger(ctrl)
+	logger.EXPECT().WithFields(gomock.Any()).AnyTimes()
+	logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Info(gomock.Any()).AnyTimes()
+
+	s := source.NewMockSourceConfiguration(ctrl)
+	s.EXPECT().GetSettings(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(r *http.Request, logger2 *logrus.Logger) (*global.Settings, util.PlankClient, error) {
+		return &global.Settings{
+			TemplateRepo:   "my-repo",
+			TemplateOrg:    "my-org",
+			DinghyFilename: "dinghyfile",
+			RepoConfig:     []global.RepoConfig{},
+		}, dinghyfile.NewMockPlankClient(ctrl), nil
+	})
+
+	dc := deliveries.NewDeliveryMemoryClient(60)
+	dc.SaveDelivery(deliveries.Delivery{
+		ID:         "github:72d3162e",
+		Provider:   "github",
+		StatusCode: http.StatusOK,
+		Response:   `{"status":"accepted"}`,
+	})
+
+	wa := NewWebAPI(s, nil, nil, logger, nil, nil, nil, nil)
+	wa.DeliveriesClient = dc
+	wa.Parser = dinghyfile.NewDinghyfileParser(&dinghyfile.PipelineBuilder{})
+	payload := bytes.NewBufferString(`{"ref":"refs/heads/some_branch","repository":{"name":"my-repo","organization":"my-org"}}`)
+	req := httptest.NewRequest("POST", "/v1/webhooks/github", payload)
+	req.Header.Set("X-GitHub-Delivery", "72d3162e")
+	rr := httptest.NewRecorder()
+	wa.githubWebhookHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "true", rr.Header().Get(DuplicateDeliveryHeader))
+	assert.Equal(t, `{"status":"accepted"}`, rr.Body.String())
+}
+
+func TestAfterSha(t *testing.T) {
+	cases := map[string]struct {
+		push     Push
+		body     string
+		expected string
+	}{
+		"github after": {
+			push:     &github.Push{},
+			body:     `{"after":"abc123"}`,
+			expected: "abc123",
+		},
+		"gitlab checkout_sha": {
+			push:     &github.Push{},
+			body:     `{"checkout_sha":"def456"}`,
+			expected: "def456",
+		},
+		"last commit": {
+			push:     &github.Push{Commits: []github.Commit{{ID: "first"}, {ID: "last"}}},
+			body:     `{}`,
+			expected: "last",
+		},
+	}
+
+	for desc, c := range cases {
+		t.Run(desc, func(t *testing.T) {
+			assert.Equal(t, c.expected, afterSha(c.push, []byte(c.body)))
+		})
+	}
+}
+
+func TestForceReprocess(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/webhooks/github?force=true", nil)
+	assert.True(t, forceReprocess(req))
+
+	req = httptest.NewRequest("POST", "/v1/webhooks/github", nil)
+	assert.False(t, forceReprocess(req))
+
+	req.Header.Set(ForceReprocessHeader, "true")
+	assert.True(t, forceReprocess(req))
+}
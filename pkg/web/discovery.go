@@ -0,0 +1,113 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"path"
+	"strings"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/settings/global"
+)
+
+// repositorySettings merges the settings overridden in the root .dinghy.yml
+// of the pushed repository over the global settings.
+func repositorySettings(org, repo, branch string, dc *dinghyfile.DirectoryConfigs, s *global.Settings, l dinghylog.DinghyLog) (*global.Settings, error) {
+	config, err := dc.ForRepository(org, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	if config.Settings == nil {
+		return s, nil
+	}
+	l.Infof("Using settings overridden in %s of %s/%s", dinghyfile.DirectoryConfigFilename, org, repo)
+	return s.WithRepositorySettings(*config.Settings), nil
+}
+
+// dinghyfilesInPush returns the dinghyfiles added or modified by a push that
+// are in the directories included by the repository configuration.
+func dinghyfilesInPush(p Push, b *dinghyfile.PipelineBuilder, rc *global.RepoConfig) []string {
+	var dinghyfiles []string
+	for _, file := range p.Files() {
+		if b.IsDinghyfile(file) && directoryIncluded(path.Dir(file), rc) {
+			dinghyfiles = append(dinghyfiles, file)
+		}
+	}
+	return dinghyfiles
+}
+
+// directoryIncluded checks a directory against the include and exclude
+// globs of a repository, excludes win over includes.
+func directoryIncluded(dir string, rc *global.RepoConfig) bool {
+	if rc == nil {
+		return true
+	}
+	for _, pattern := range rc.ExcludeDirs {
+		if matchDirGlob(pattern, dir) {
+			return false
+		}
+	}
+	if len(rc.IncludeDirs) == 0 {
+		return true
+	}
+	for _, pattern := range rc.IncludeDirs {
+		if matchDirGlob(pattern, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDirGlob matches a directory or any of its parents against a glob,
+// "**" matches any number of directories. The repository root is ".".
+func matchDirGlob(pattern, dir string) bool {
+	patternParts := splitPath(pattern)
+	dirParts := splitPath(dir)
+	for i := len(dirParts); i >= 0; i-- {
+		if matchParts(patternParts, dirParts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "." || p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
+
+func matchParts(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchParts(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+		return false
+	}
+	return matchParts(pattern[1:], parts[1:])
+}
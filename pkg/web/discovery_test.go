@@ -0,0 +1,95 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"testing"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/git/dummy"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/mock"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDirGlob(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		dir      string
+		expected bool
+	}{
+		{"services/*", "services/app", true},
+		{"services/*", "services/app/nested", true},
+		{"services/*", "services", false},
+		{"services/*", "tools/app", false},
+		{"**/legacy", "services/app/legacy", true},
+		{"**/legacy", "legacy", true},
+		{"**/legacy", "services/app", false},
+		{"services/**/deploy", "services/app/deploy", true},
+		{"services/**/deploy", "services/deploy", true},
+		{".", ".", true},
+		{"**", "services/app", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, matchDirGlob(c.pattern, c.dir), "%s %s", c.pattern, c.dir)
+	}
+}
+
+func TestDinghyfilesInPush(t *testing.T) {
+	p := &github.Push{
+		Commits: []github.Commit{{
+			Added:    []string{"services/app/dinghyfile", "services/legacy/dinghyfile", "services/app/module.json"},
+			Modified: []string{"tools/cli.dinghyfile", "dinghyfile"},
+		}},
+	}
+	b := &dinghyfile.PipelineBuilder{DinghyfileName: "dinghyfile", DinghyfilePatterns: []string{"*.dinghyfile"}}
+
+	assert.Equal(t, []string{"services/app/dinghyfile", "services/legacy/dinghyfile", "tools/cli.dinghyfile", "dinghyfile"}, dinghyfilesInPush(p, b, nil))
+
+	rc := &global.RepoConfig{IncludeDirs: []string{"services/*"}, ExcludeDirs: []string{"services/legacy"}}
+	assert.Equal(t, []string{"services/app/dinghyfile"}, dinghyfilesInPush(p, b, rc))
+
+	// The repository root includes every directory
+	rc = &global.RepoConfig{IncludeDirs: []string{"."}, ExcludeDirs: []string{"tools"}}
+	assert.Equal(t, []string{"services/app/dinghyfile", "services/legacy/dinghyfile", "dinghyfile"}, dinghyfilesInPush(p, b, rc))
+}
+
+func TestRepositorySettings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := mock.NewMockDinghyLog(ctrl)
+	logger.EXPECT().Infof(gomock.Any(), gomock.Any()).Times(1)
+
+	p := &github.Push{Ref: "master", Repository: github.Repository{Name: "repo", Organization: "org"}}
+	s := &global.Settings{AutoLockPipelines: "true"}
+
+	fs := dummy.FileService{"master": {".dinghy.yml": "settings:\n  autoLockPipelines: false\n"}}
+	merged, err := repositorySettings(p.Org(), p.Repo(), p.Branch(), dinghyfile.NewDirectoryConfigs(fs), s, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, "false", merged.AutoLockPipelines)
+	assert.Equal(t, "true", s.AutoLockPipelines)
+
+	// Repositories without .dinghy.yml use the global settings
+	merged, err = repositorySettings(p.Org(), p.Repo(), p.Branch(), dinghyfile.NewDirectoryConfigs(dummy.FileService{}), s, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, s, merged)
+
+	fs = dummy.FileService{"master": {".dinghy.yml": "settings:\n  instanceId: other\n"}}
+	_, err = repositorySettings(p.Org(), p.Repo(), p.Branch(), dinghyfile.NewDirectoryConfigs(fs), s, logger)
+	assert.NotNil(t, err)
+}
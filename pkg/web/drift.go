@@ -0,0 +1,272 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// driftRunKey keeps the time of the last drift detection in the poll state
+	driftRunKey          = "drift"
+	defaultDriftInterval = time.Hour
+	// DriftStatus is the status of the log events of the applications that drifted
+	DriftStatus = "drift"
+)
+
+var (
+	errDetectingDrift = errors.New("drift detection is already running")
+
+	driftPipelines = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dinghy",
+		Subsystem: "drift",
+		Name:      "pipelines",
+		Help:      "Pipelines that drifted from the dinghyfiles by application and drift (modified, unmanaged, missing).",
+	}, []string{"application", "drift"})
+	driftSettings = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "dinghy",
+		Subsystem: "drift",
+		Name:      "application_settings",
+		Help:      "Application settings that differ from the dinghyfiles by application.",
+	}, []string{"application"})
+	driftFailed = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "dinghy",
+		Subsystem: "drift",
+		Name:      "failed",
+		Help:      "Dinghyfiles and applications that couldn't be compared in the last drift detection.",
+	})
+	driftTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "dinghy",
+		Subsystem: "drift",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Time of the last drift detection of this replica.",
+	})
+)
+
+// DriftReport compares the known dinghyfiles with Spinnaker.
+type DriftReport struct {
+	Date         int64                         `json:"date"`
+	Applications []dinghyfile.ApplicationDrift `json:"applications"`
+	// Failed are the dinghyfiles and applications that couldn't be compared with their error
+	Failed  map[string]string `json:"failed"`
+	Skipped []string          `json:"skipped"`
+}
+
+func driftInterval(s *global.Settings) time.Duration {
+	if s.DriftDetection.IntervalMinutes <= 0 {
+		return defaultDriftInterval
+	}
+	return time.Duration(s.DriftDetection.IntervalMinutes) * time.Minute
+}
+
+// StartDriftDetection detects drift on the interval of the settings until the
+// context is done.
+func (wa *WebAPI) StartDriftDetection(ctx context.Context) {
+	settings, _, ok := wa.scheduledSettings(ctx, "drift detection")
+	if !ok || !settings.DriftDetection.Enabled {
+		return
+	}
+
+	interval := driftInterval(settings)
+	wa.Logger.Infof("Detecting drift every %s", interval)
+	every(ctx, interval, func() {
+		if _, err := wa.runDriftDetection(settings, true); err != nil {
+			wa.Logger.Warnf("Drift detection skipped: %s", err.Error())
+		}
+	})
+}
+
+// runDriftDetection detects drift unless it is already running in this
+// replica. Scheduled runs are also skipped when any replica detected drift
+// within the interval, the report is nil when the run was skipped.
+func (wa *WebAPI) runDriftDetection(s *global.Settings, scheduled bool) (*DriftReport, error) {
+	if !atomic.CompareAndSwapInt32(&wa.detectingDrift, 0, 1) {
+		return nil, errDetectingDrift
+	}
+	defer atomic.StoreInt32(&wa.detectingDrift, 0)
+
+	now := time.Now()
+	if store, ok := wa.Cache.(PollStateStore); ok {
+		if scheduled && !runDue(store, driftRunKey, driftInterval(s), now) {
+			return nil, nil
+		}
+		saveRunTime(store, driftRunKey, now, wa.Logger)
+	}
+
+	report := wa.detectDrift(s, reconcileDownloaders)
+	updateDriftMetrics(report)
+	drifted := 0
+	for _, app := range report.Applications {
+		if app.Drifted() {
+			drifted++
+			wa.saveDriftLogEvent(app)
+		}
+	}
+	wa.Logger.Infof("Drift detected in %d of %d applications, %d failed", drifted, len(report.Applications), len(report.Failed))
+
+	wa.driftMutex.Lock()
+	wa.driftReport = &report
+	wa.driftMutex.Unlock()
+	return &report, nil
+}
+
+// detectDrift renders every dinghyfile of the dependency graph in read-only
+// mode and compares them, grouped by application, with Spinnaker.
+func (wa *WebAPI) detectDrift(s *global.Settings, downloaders func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader) DriftReport {
+	report := DriftReport{
+		Date:         time.Now().UnixNano() / int64(time.Millisecond),
+		Applications: []dinghyfile.ApplicationDrift{},
+		Failed:       map[string]string{},
+		Skipped:      []string{},
+	}
+
+	type application struct {
+		builder     *dinghyfile.PipelineBuilder
+		dinghyfiles []dinghyfile.Dinghyfile
+	}
+	apps := map[string]*application{}
+	urls := wa.CacheReadOnly.GetAllDinghyfiles()
+	sort.Strings(urls)
+	for _, url := range urls {
+		l := dinghylog.NewDinghyLogs(wa.Logger.WithFields(log.Fields{"drift": url}))
+		builder, err := wa.dinghyfileBuilder(url, s, wa.ClientReadOnly, wa.CacheReadOnly, downloaders(s, l), l)
+		if errors.Is(err, errDinghyfileSkipped) {
+			report.Skipped = append(report.Skipped, url)
+			continue
+		} else if err != nil {
+			report.Failed[url] = err.Error()
+			continue
+		}
+		builder.Action = pipebuilder.Validate
+
+		org, repo, path, branch := builder.Downloader.DecodeURL(url)
+		d, err := builder.RenderDinghyfile(org, repo, path, branch)
+		if err != nil {
+			report.Failed[url] = err.Error()
+			continue
+		}
+		name := d.ApplicationSpec.Name
+		if apps[name] == nil {
+			apps[name] = &application{builder: builder}
+		}
+		apps[name].dinghyfiles = append(apps[name].dinghyfiles, d)
+	}
+
+	names := make([]string, 0, len(apps))
+	for name := range apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		drift, err := apps[name].builder.ApplicationDrift(name, apps[name].dinghyfiles)
+		if err != nil {
+			report.Failed[name] = err.Error()
+			continue
+		}
+		report.Applications = append(report.Applications, drift)
+	}
+	return report
+}
+
+func updateDriftMetrics(report DriftReport) {
+	driftPipelines.Reset()
+	driftSettings.Reset()
+	for _, app := range report.Applications {
+		for _, drift := range []string{dinghyfile.DriftModified, dinghyfile.DriftUnmanaged, dinghyfile.DriftMissing} {
+			driftPipelines.WithLabelValues(app.Application, drift).Set(float64(app.Count(drift)))
+		}
+		driftSettings.WithLabelValues(app.Application).Set(float64(len(app.Settings)))
+	}
+	driftFailed.Set(float64(len(report.Failed)))
+	driftTimestamp.Set(float64(report.Date) / 1000)
+}
+
+func (wa *WebAPI) saveDriftLogEvent(app dinghyfile.ApplicationDrift) {
+	if wa.LogEventsClient == nil {
+		return
+	}
+	if err := wa.LogEventsClient.SaveLogEvent(logevents.LogEvent{
+		Repo:    app.Application,
+		Message: driftMessage(app),
+		Status:  DriftStatus,
+	}); err != nil {
+		wa.Logger.Errorf("Unable to save the drift of %s: %s", app.Application, err.Error())
+	}
+}
+
+// driftMessage describes the drift of an application, one change per line.
+func driftMessage(app dinghyfile.ApplicationDrift) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Application %s drifted from its dinghyfiles\n", app.Application)
+	if app.Missing {
+		sb.WriteString("application is missing from Spinnaker\n")
+	}
+	for _, f := range app.Settings {
+		fmt.Fprintf(&sb, "setting %s: expected %v, found %v\n", f.Path, f.Expected, f.Actual)
+	}
+	for _, p := range app.Pipelines {
+		fmt.Fprintf(&sb, "pipeline %s is %s\n", p.Name, p.Drift)
+		for _, f := range p.Fields {
+			fmt.Fprintf(&sb, "  %s: expected %v, found %v\n", f.Path, f.Expected, f.Actual)
+		}
+	}
+	return sb.String()
+}
+
+// driftReportHandler returns the last drift report of this replica.
+func (wa *WebAPI) driftReportHandler(w http.ResponseWriter, r *http.Request) {
+	wa.driftMutex.Lock()
+	report := wa.driftReport
+	wa.driftMutex.Unlock()
+	if report == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errors.New("drift has not been detected yet, start it with POST /v1/drift"))
+		return
+	}
+	writeJSON(w, report)
+}
+
+// driftHandler detects drift and returns the report.
+func (wa *WebAPI) driftHandler(w http.ResponseWriter, r *http.Request) {
+	settings, _, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	report, err := wa.runDriftDetection(settings, false)
+	if err == errDetectingDrift {
+		util.WriteHTTPError(w, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, report)
+}
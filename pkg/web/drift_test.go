@@ -0,0 +1,93 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/events"
+	"github.com/armory/dinghy/pkg/git/dummy"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectDrift(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer echo.Close()
+	s := &global.Settings{DinghyFilename: "dinghyfile"}
+	s.SpinnakerSupplied.Echo.BaseURL = echo.URL
+	fs := dummy.FileService{"master": {
+		"dinghyfile":        `{"application": "app", "pipelines": [{"name": "deploy", "application": "app", "stages": [{"name": "wait", "type": "wait", "waitTime": 10}]}]}`,
+		"broken/dinghyfile": `{"application": "app", "pipelines": [}`,
+		"module":            `{}`,
+	}}
+	c := cache.NewMemoryCache()
+	c.SetDeps(fs.EncodeURL("org", "repo", "dinghyfile", "master"), []string{fs.EncodeURL("org", "templates", "module", "master")})
+	c.SetDeps(fs.EncodeURL("org", "repo", "broken/dinghyfile", "master"), []string{fs.EncodeURL("org", "templates", "module", "master")})
+	c.SetDeps("https://gitlab.example.com/org/repo/dinghyfile", []string{fs.EncodeURL("org", "templates", "other", "master")})
+
+	client := dinghyfile.NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("app", "").Return(&plank.Application{Name: "app"}, nil).Times(1)
+	client.EXPECT().GetPipelines("app", "").Return([]plank.Pipeline{
+		{Name: "deploy", Application: "app", Stages: []map[string]interface{}{{"name": "wait", "type": "wait", "waitTime": 20}}},
+		{Name: "manual", Application: "app"},
+	}, nil).Times(1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, logger, c, client, nil, nil)
+	wa.EventClient = events.NewEventClient(context.Background(), s, false)
+	wa.AddDinghyfileUnmarshaller(&dinghyfile.DinghyJsonUnmarshaller{})
+	wa.Parser = dinghyfile.NewDinghyfileParser(&dinghyfile.PipelineBuilder{})
+
+	report := wa.detectDrift(s, func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader {
+		return []dinghyfile.Downloader{fs}
+	})
+
+	assert.Equal(t, []string{"https://gitlab.example.com/org/repo/dinghyfile"}, report.Skipped)
+	assert.Contains(t, report.Failed, fs.EncodeURL("org", "repo", "broken/dinghyfile", "master"))
+	assert.Len(t, report.Applications, 1)
+	app := report.Applications[0]
+	assert.True(t, app.Drifted())
+	assert.Equal(t, 1, app.Count(dinghyfile.DriftModified))
+	assert.Equal(t, 1, app.Count(dinghyfile.DriftUnmanaged))
+	assert.Contains(t, driftMessage(app), "pipeline manual is unmanaged")
+}
+
+func TestDriftReportHandler(t *testing.T) {
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, nil, nil, nil, nil, nil)
+
+	rr := httptest.NewRecorder()
+	wa.driftReportHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/drift", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	wa.driftReport = &DriftReport{Date: 1, Applications: []dinghyfile.ApplicationDrift{{Application: "app"}}}
+	rr = httptest.NewRecorder()
+	wa.driftReportHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/drift", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"application":"app"`)
+}
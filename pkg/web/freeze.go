@@ -0,0 +1,107 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/freeze"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+)
+
+// FreezeOverrideStatus is the status of the log events of dinghyfiles applied
+// despite a change freeze
+const FreezeOverrideStatus = "freeze-override"
+
+// CommitMessenger is implemented by the pushes of providers sending the
+// messages of the pushed commits.
+type CommitMessenger interface {
+	CommitMessages() []string
+}
+
+// freezes returns the change freezes, nil when they are disabled.
+func freezes(s *global.Settings) *dinghyfile.Freezes {
+	if !s.Freezes.Enabled {
+		return nil
+	}
+	windows := make([]freeze.Window, 0, len(s.Freezes.Windows))
+	for _, w := range s.Freezes.Windows {
+		windows = append(windows, freeze.Window{
+			Name:         w.Name,
+			Start:        w.Start,
+			End:          w.End,
+			Cron:         w.Cron,
+			Duration:     time.Duration(w.DurationMinutes) * time.Minute,
+			Timezone:     w.Timezone,
+			Orgs:         w.Orgs,
+			Repos:        w.Repos,
+			Applications: w.Applications,
+			Message:      w.Message,
+		})
+	}
+	calendar, err := freeze.New(windows)
+	return &dinghyfile.Freezes{Calendar: calendar, Error: err}
+}
+
+// freezeOverride describes who or what overrides the freezes for a push,
+// an allow-listed pusher or the override trailer of a commit message. It is
+// empty when the freezes apply.
+func freezeOverride(p Push, s *global.Settings) string {
+	for _, user := range s.Freezes.OverrideUsers {
+		if user != "" && user == p.PusherName() {
+			return fmt.Sprintf("pusher %s", user)
+		}
+	}
+	messenger, ok := p.(CommitMessenger)
+	if !ok || s.Freezes.OverrideTrailer == "" {
+		return ""
+	}
+	prefix := strings.ToLower(s.Freezes.OverrideTrailer) + ":"
+	for _, message := range messenger.CommitMessages() {
+		for _, line := range strings.Split(message, "\n") {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(strings.ToLower(line), prefix) {
+				continue
+			}
+			if reason := strings.TrimSpace(line[len(prefix):]); reason != "" {
+				return fmt.Sprintf("%s of %s: %s", s.Freezes.OverrideTrailer, p.PusherName(), reason)
+			}
+		}
+	}
+	return ""
+}
+
+// saveFreezeOverrides records every dinghyfile applied despite a freeze as its own log event.
+func (wa *WebAPI) saveFreezeOverrides(p Push, f *dinghyfile.Freezes, l dinghylog.DinghyLog) {
+	if wa.LogEventsClient == nil {
+		return
+	}
+	for _, overridden := range f.Overridden {
+		if err := wa.LogEventsClient.SaveLogEvent(logevents.LogEvent{
+			Org:     overridden.Org,
+			Repo:    overridden.Repo,
+			Files:   []string{overridden.Path},
+			Message: fmt.Sprintf("Applied %s to %s despite the %s, overridden by %s", overridden.Path, overridden.Application, overridden.Freeze.Description(), f.Override),
+			Commits: p.GetCommits(),
+			Status:  FreezeOverrideStatus,
+		}); err != nil {
+			l.Errorf("Unable to save the freeze override of %s: %s", overridden.Path, err.Error())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/approvals"
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/freeze"
+	"github.com/armory/dinghy/pkg/git/github"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func freezeSettings() *global.Settings {
+	return &global.Settings{
+		DinghyFilename: "dinghyfile",
+		Freezes: global.Freezes{
+			Enabled:         true,
+			Windows:         []global.FreezeWindow{{Name: "forever", Start: "2000-01-01", End: "2999-12-31", Applications: []string{"app"}}},
+			OverrideUsers:   []string{"release-manager"},
+			OverrideTrailer: "Freeze-Override",
+		},
+	}
+}
+
+func TestFreezes(t *testing.T) {
+	assert.Nil(t, freezes(&global.Settings{}))
+
+	f := freezes(freezeSettings())
+	active, err := f.Active("org", "repo", "app")
+	assert.Nil(t, err)
+	assert.Equal(t, "forever", active.Window.Name)
+
+	s := freezeSettings()
+	s.Freezes.Windows = append(s.Freezes.Windows, global.FreezeWindow{Name: "broken", Cron: "0 17 * * 5"})
+	_, err = freezes(s).Active("org", "repo", "app")
+	assert.EqualError(t, err, "invalid freeze window broken: a duration is required with a cron schedule")
+}
+
+func TestFreezeOverride(t *testing.T) {
+	s := freezeSettings()
+	for _, test := range []struct {
+		push     *github.Push
+		expected string
+	}{
+		{&github.Push{Pusher: github.Pusher{Name: "dev"}}, ""},
+		{&github.Push{Pusher: github.Pusher{Name: "release-manager"}}, "pusher release-manager"},
+		{&github.Push{Pusher: github.Pusher{Name: "dev"}, Commits: []github.Commit{{Message: "Fix the deploy\n\nfreeze-override: outage INC-42"}}}, "Freeze-Override of dev: outage INC-42"},
+		// a trailer needs a reason
+		{&github.Push{Pusher: github.Pusher{Name: "dev"}, Commits: []github.Commit{{Message: "Fix the deploy\n\nFreeze-Override:"}}}, ""},
+	} {
+		assert.Equal(t, test.expected, freezeOverride(test.push, s))
+	}
+}
+
+func TestSaveFreezeOverrides(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logEvents := logevents.NewMockLogEventsClient(ctrl)
+	logEvents.EXPECT().SaveLogEvent(gomock.Any()).DoAndReturn(func(e logevents.LogEvent) error {
+		assert.Equal(t, FreezeOverrideStatus, e.Status)
+		assert.Equal(t, []string{"c1"}, e.Commits)
+		assert.Equal(t, []string{"dinghyfile"}, e.Files)
+		assert.Equal(t, "Applied dinghyfile to app despite the change freeze forever until 0001-01-01 00:00 UTC, overridden by pusher release-manager", e.Message)
+		return nil
+	}).Times(1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, logger, nil, nil, logEvents, nil)
+	f := &dinghyfile.Freezes{
+		Override:   "pusher release-manager",
+		Overridden: []dinghyfile.FrozenDinghyfile{{Org: "org", Repo: "repo", Path: "dinghyfile", Application: "app", Freeze: freeze.Freeze{Window: freeze.Window{Name: "forever"}}}},
+	}
+	wa.saveFreezeOverrides(&github.Push{Commits: []github.Commit{{ID: "c1"}}}, f, dinghylog.NewDinghyLogs(logger))
+}
+
+func TestApproveChangeRequestDuringFreeze(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	client := dinghyfile.NewMockPlankClient(ctrl)
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(r *http.Request, logger2 *logrus.Logger) (*global.Settings, util.PlankClient, error) {
+		return freezeSettings(), client, nil
+	})
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(sc, cache.NewMemoryCache(), nil, logger, nil, nil, nil, nil)
+	store := approvals.NewChangeRequestMemoryClient()
+	wa.ApprovalsClient = store
+	request := pendingChangeRequest(store, "app")
+
+	rr := postChangeRequest(wa.approveHandler, "/v1/changerequests/approve?id=1", "approver")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), "change request 1 can't be applied during the change freeze forever")
+	pending, _ := store.GetChangeRequest(request.ID)
+	assert.Equal(t, approvals.StatusPending, pending.Status)
+}
@@ -0,0 +1,116 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/util"
+)
+
+// graph returns the dependency manager used to answer graph queries,
+// preferring the read only one.
+func (wa *WebAPI) graph() dinghyfile.DependencyManager {
+	if wa.CacheReadOnly != nil {
+		return wa.CacheReadOnly
+	}
+	return wa.Cache
+}
+
+// GraphChecker is implemented by the dependency managers able to verify the
+// consistency of the graph they store.
+type GraphChecker interface {
+	CheckGraph() ([]cache.GraphInconsistency, error)
+}
+
+// graphCheck reports the edges of the graph that are missing their mirror.
+func (wa *WebAPI) graphCheck(w http.ResponseWriter, r *http.Request) {
+	checker, ok := wa.graph().(GraphChecker)
+	if !ok {
+		util.WriteHTTPError(w, http.StatusNotImplemented, errors.New("the dependency graph backend doesn't support consistency checks"))
+		return
+	}
+	inconsistencies, err := checker.CheckGraph()
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"consistent":      len(inconsistencies) == 0,
+		"inconsistencies": inconsistencies,
+	})
+}
+
+// graphExport returns the whole dependency graph, as JSON or as Graphviz DOT
+// when format=dot is requested.
+func (wa *WebAPI) graphExport(w http.ResponseWriter, r *http.Request) {
+	graph := dinghyfile.BuildDependencyGraph(wa.graph())
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, graph)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.Write([]byte(graph.DOT()))
+	default:
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("format must be json or dot"))
+	}
+}
+
+// graphDinghyfiles lists every known dinghyfile.
+func (wa *WebAPI) graphDinghyfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, sortedURLs(wa.graph().GetAllDinghyfiles()))
+}
+
+// graphChildren lists the modules directly rendered by the url parameter.
+func (wa *WebAPI) graphChildren(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("url parameter is required"))
+		return
+	}
+	writeJSON(w, sortedURLs(wa.graph().GetChildren(url)))
+}
+
+// graphRoots lists the dinghyfiles depending, directly or not, on the url parameter.
+func (wa *WebAPI) graphRoots(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("url parameter is required"))
+		return
+	}
+	writeJSON(w, sortedURLs(wa.graph().GetRoots(url)))
+}
+
+func sortedURLs(urls []string) []string {
+	if urls == nil {
+		return []string{}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	bytesResult, err := json.Marshal(v)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytesResult)
+}
@@ -0,0 +1,141 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGraphWebAPI() *WebAPI {
+	c := cache.NewMemoryCache()
+	c.SetDeps("df1", []string{"mod1", "mod2"})
+	c.SetDeps("df2", []string{"mod2"})
+	c.SetDeps("mod2", []string{"mod3"})
+	return NewWebAPI(nil, c, nil, nil, nil, nil, nil, nil)
+}
+
+func TestGraphQueries(t *testing.T) {
+	wa := newGraphWebAPI()
+
+	cases := map[string]struct {
+		handler  http.HandlerFunc
+		target   string
+		status   int
+		expected string
+	}{
+		"dinghyfiles": {
+			handler:  wa.graphDinghyfiles,
+			target:   "/v1/graph/dinghyfiles",
+			status:   http.StatusOK,
+			expected: `["df1","df2"]`,
+		},
+		"children": {
+			handler:  wa.graphChildren,
+			target:   "/v1/graph/children?url=df1",
+			status:   http.StatusOK,
+			expected: `["mod1","mod2"]`,
+		},
+		"children of unknown url": {
+			handler:  wa.graphChildren,
+			target:   "/v1/graph/children?url=missing",
+			status:   http.StatusOK,
+			expected: `[]`,
+		},
+		"roots": {
+			handler:  wa.graphRoots,
+			target:   "/v1/graph/roots?url=mod3",
+			status:   http.StatusOK,
+			expected: `["df1","df2"]`,
+		},
+		"roots without url": {
+			handler: wa.graphRoots,
+			target:  "/v1/graph/roots",
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for desc, c := range cases {
+		t.Run(desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			c.handler(rr, httptest.NewRequest(http.MethodGet, c.target, nil))
+			assert.Equal(t, c.status, rr.Code)
+			if c.expected != "" {
+				assert.JSONEq(t, c.expected, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGraphExport(t *testing.T) {
+	wa := newGraphWebAPI()
+
+	rr := httptest.NewRecorder()
+	wa.graphExport(rr, httptest.NewRequest(http.MethodGet, "/v1/graph", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{
+		"nodes": [
+			{"url": "df1", "dinghyfile": true},
+			{"url": "df2", "dinghyfile": true},
+			{"url": "mod1", "dinghyfile": false},
+			{"url": "mod2", "dinghyfile": false},
+			{"url": "mod3", "dinghyfile": false}
+		],
+		"edges": [
+			{"parent": "df1", "child": "mod1"},
+			{"parent": "df1", "child": "mod2"},
+			{"parent": "df2", "child": "mod2"},
+			{"parent": "mod2", "child": "mod3"}
+		]
+	}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	wa.graphExport(rr, httptest.NewRequest(http.MethodGet, "/v1/graph?format=dot", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"mod2" -> "mod3";`)
+
+	rr = httptest.NewRecorder()
+	wa.graphExport(rr, httptest.NewRequest(http.MethodGet, "/v1/graph?format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+type checkedGraph struct {
+	cache.MemoryCache
+	inconsistencies []cache.GraphInconsistency
+}
+
+func (c checkedGraph) CheckGraph() ([]cache.GraphInconsistency, error) {
+	return c.inconsistencies, nil
+}
+
+func TestGraphCheck(t *testing.T) {
+	wa := newGraphWebAPI()
+
+	rr := httptest.NewRecorder()
+	wa.graphCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/graph/check", nil))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+
+	wa.CacheReadOnly = checkedGraph{
+		MemoryCache:     cache.NewMemoryCache(),
+		inconsistencies: []cache.GraphInconsistency{{Parent: "df1", Child: "mod1", Missing: "parents"}},
+	}
+	rr = httptest.NewRecorder()
+	wa.graphCheck(rr, httptest.NewRequest(http.MethodGet, "/v1/graph/check", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"consistent": false, "inconsistencies": [{"parent": "df1", "child": "mod1", "missing": "parents"}]}`, rr.Body.String())
+}
@@ -0,0 +1,102 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/impact"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+)
+
+// maxCommentLength keeps impact reports under the size limit of pull request comments
+const maxCommentLength = 60000
+
+// PullRequestCommenter is implemented by the pushes of providers able to
+// comment the pull request of the pushed branch.
+type PullRequestCommenter interface {
+	CommentPullRequest(body string) error
+}
+
+// saveImpactReport reports how the modules in the push affect the dinghyfiles
+// rendering them, and comments it in the pull request when enabled.
+func (wa *WebAPI) saveImpactReport(p Push, b *dinghyfile.PipelineBuilder, ignoreFile IgnoreFile, pullRequest string, s *global.Settings, l dinghylog.DinghyLog) {
+	report := impact.Report{
+		ID:          impact.ReportID(p.Name(), p.Org(), p.Repo(), p.Branch()),
+		Provider:    p.Name(),
+		Org:         p.Org(),
+		Repo:        p.Repo(),
+		Branch:      p.Branch(),
+		PullRequest: pullRequest,
+		Modules:     []dinghyfile.ModuleImpact{},
+	}
+	for _, file := range p.Files() {
+		if !ignoreFile.ShouldIgnore(file) {
+			report.Modules = append(report.Modules, b.ModuleImpact(p.Org(), p.Repo(), file, p.Branch()))
+		}
+	}
+
+	if err := wa.ImpactReportsClient.SaveReport(report); err != nil {
+		l.Warnf("Unable to save impact report %s: %s", report.ID, err.Error())
+	}
+
+	if !s.ImpactReports.CommentPullRequests {
+		return
+	}
+	commenter, ok := p.(PullRequestCommenter)
+	if !ok {
+		l.Warnf("Commenting impact reports is not supported for %s", p.Name())
+		return
+	}
+	body := report.Markdown()
+	if len(body) > maxCommentLength {
+		body = body[:maxCommentLength] + "\n\nThe report was truncated, the full report is available at /v1/impact\n"
+	}
+	if err := commenter.CommentPullRequest(body); err != nil {
+		l.Warnf("Unable to comment impact report %s: %s", report.ID, err.Error())
+	}
+}
+
+// impactReport returns the latest impact report of a template repo branch,
+// rendered as markdown when format=markdown is requested.
+func (wa *WebAPI) impactReport(w http.ResponseWriter, r *http.Request) {
+	if wa.ImpactReportsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errors.New("impact reports are not enabled"))
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("provider") == "" || q.Get("org") == "" || q.Get("repo") == "" || q.Get("branch") == "" {
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("provider, org, repo and branch parameters are required"))
+		return
+	}
+	report, err := wa.ImpactReportsClient.GetReport(impact.ReportID(q.Get("provider"), q.Get("org"), q.Get("repo"), q.Get("branch")))
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if report == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errors.New("no impact report found"))
+		return
+	}
+	if q.Get("format") == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown")
+		w.Write([]byte(report.Markdown()))
+		return
+	}
+	writeJSON(w, report)
+}
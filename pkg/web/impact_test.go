@@ -0,0 +1,60 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/impact"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpactReport(t *testing.T) {
+	wa := NewWebAPI(nil, nil, nil, nil, nil, nil, nil, nil)
+	target := "/v1/impact?provider=github&org=org&repo=templates&branch=feature"
+
+	rr := httptest.NewRecorder()
+	wa.impactReport(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	reports := impact.NewReportMemoryClient(60)
+	wa.ImpactReportsClient = reports
+
+	rr = httptest.NewRecorder()
+	wa.impactReport(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	wa.impactReport(rr, httptest.NewRequest(http.MethodGet, "/v1/impact?org=org", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	reports.SaveReport(impact.Report{
+		ID:      impact.ReportID("github", "org", "templates", "feature"),
+		Branch:  "feature",
+		Modules: []dinghyfile.ModuleImpact{{Path: "mod1", Dinghyfiles: []dinghyfile.DinghyfileImpact{}}},
+	})
+
+	rr = httptest.NewRecorder()
+	wa.impactReport(rr, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"path":"mod1"`)
+
+	rr = httptest.NewRecorder()
+	wa.impactReport(rr, httptest.NewRequest(http.MethodGet, target+"&format=markdown", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "#### Module `mod1`")
+}
@@ -0,0 +1,295 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/git/gitlab"
+	"github.com/armory/dinghy/pkg/lock"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PollPusher is the pusher name of the pushes found polling repositories,
+	// commit authors can be set to anyone by whoever pushes so they are never
+	// used for permission checks
+	PollPusher          = "dinghy-poller"
+	defaultPollInterval = 60 * time.Second
+	defaultPollBranch   = "master"
+)
+
+// PollStateStore keeps the last processed sha of the polled branches, it is
+// implemented by the redis and SQL DependencyManager backends.
+type PollStateStore interface {
+	GetPollState(key string) (string, error)
+	SetPollState(key string, sha string) error
+}
+
+// pollSource is the provider API used to find the changes of a branch.
+type pollSource interface {
+	HeadSha(org, repo, branch string) (string, error)
+	// PushPayload returns the payload of a push of the changes between base
+	// and head by pusher.
+	PushPayload(org, repo, branch, base, head, pusher string) ([]byte, error)
+}
+
+type pollTarget struct {
+	provider string
+	org      string
+	repo     string
+	branch   string
+	interval time.Duration
+	jitter   time.Duration
+}
+
+func (t pollTarget) key() string {
+	return fmt.Sprintf("%s:%s/%s:%s", t.provider, t.org, t.repo, t.branch)
+}
+
+func (t pollTarget) wait() time.Duration {
+	if t.jitter <= 0 {
+		return t.interval
+	}
+	return t.interval + time.Duration(rand.Int63n(int64(t.jitter)))
+}
+
+func newPollTarget(provider, org, repo, branch string, intervalSeconds, jitterSeconds int) pollTarget {
+	t := pollTarget{
+		provider: provider,
+		org:      org,
+		repo:     repo,
+		branch:   branch,
+		interval: time.Duration(intervalSeconds) * time.Second,
+		jitter:   time.Duration(jitterSeconds) * time.Second,
+	}
+	if t.branch == "" {
+		t.branch = defaultPollBranch
+	}
+	if t.interval <= 0 {
+		t.interval = defaultPollInterval
+	}
+	return t
+}
+
+// pollTargets returns the repositories with polling enabled in the settings.
+func pollTargets(s *global.Settings) []pollTarget {
+	var targets []pollTarget
+	for _, rc := range s.RepoConfig {
+		if rc.Polling.Enabled {
+			targets = append(targets, newPollTarget(rc.Provider, rc.Org, rc.Repo, rc.Branch, rc.Polling.IntervalSeconds, rc.Polling.JitterSeconds))
+		}
+	}
+	if tp := s.TemplateRepoPolling; tp.Enabled {
+		targets = append(targets, newPollTarget(tp.Provider, s.TemplateOrg, s.TemplateRepo, tp.Branch, tp.IntervalSeconds, tp.JitterSeconds))
+	}
+	return targets
+}
+
+// memoryPollState is used when the DependencyManager can't keep the poll
+// state, branches are processed again from their head after a restart.
+type memoryPollState struct {
+	mutex sync.Mutex
+	shas  map[string]string
+}
+
+func (m *memoryPollState) GetPollState(key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.shas[key], nil
+}
+
+func (m *memoryPollState) SetPollState(key string, sha string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.shas[key] = sha
+	return nil
+}
+
+// pollResponseWriter collects the outcome of processing a polled push.
+type pollResponseWriter struct {
+	header http.Header
+	status int
+	body   []byte
+}
+
+func (w *pollResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pollResponseWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *pollResponseWriter) Write(data []byte) (int, error) {
+	w.body = append(w.body, data...)
+	return len(data), nil
+}
+
+// StartPolling polls the repositories that have polling enabled until the
+// context is done. Changes found are processed like a webhook push. It fails
+// when a repository can't be polled, rather than silently not polling it.
+func (wa *WebAPI) StartPolling(ctx context.Context) error {
+	if wa.SourceConfig.IsMultiTenant() {
+		wa.Logger.Warn("Repository polling is not supported with multi tenant configuration sources")
+		return nil
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/v1/poll", nil)
+	if err != nil {
+		return err
+	}
+	settings, plankClient, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		return fmt.Errorf("failed to get the settings: %w", err)
+	}
+
+	targets := pollTargets(settings)
+	if len(targets) == 0 {
+		return nil
+	}
+	sources := make([]pollSource, len(targets))
+	for i, t := range targets {
+		if t.org == "" || t.repo == "" {
+			return fmt.Errorf("can't poll %s repository %q, org and repo are required", t.provider, t.repo)
+		}
+		if sources[i], err = newPollSource(t.provider, settings); err != nil {
+			return fmt.Errorf("can't poll %s: %w", t.key(), err)
+		}
+	}
+	store, ok := wa.Cache.(PollStateStore)
+	if !ok {
+		wa.Logger.Warn("The dependency manager can't keep the poll state, it will be kept in memory")
+		store = &memoryPollState{shas: map[string]string{}}
+	}
+
+	for i, t := range targets {
+		wa.Logger.Infof("Polling %s every %s", t.key(), t.interval)
+		go wa.poll(ctx, t, sources[i], store, settings, plankClient)
+	}
+	return nil
+}
+
+func (wa *WebAPI) poll(ctx context.Context, t pollTarget, source pollSource, store PollStateStore, s *global.Settings, pc util.PlankClient) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.wait()):
+			if err := wa.pollLocked(t, source, store, s, pc); err != nil {
+				wa.Logger.Errorf("Polling %s failed: %s", t.key(), err.Error())
+			}
+		}
+	}
+}
+
+// pollLocked polls a branch holding its lock, every replica polls the same
+// branches and only one of them must process each change.
+func (wa *WebAPI) pollLocked(t pollTarget, source pollSource, store PollStateStore, s *global.Settings, pc util.PlankClient) error {
+	if wa.Locker != nil {
+		pollLock, err := wa.Locker.Acquire(lock.PollKey(t.provider, t.org, t.repo, t.branch))
+		if errors.Is(err, lock.ErrTimeout) {
+			// another replica is polling it
+			return nil
+		} else if err != nil {
+			return err
+		}
+		defer pollLock.Release()
+	}
+	return wa.pollOnce(t, source, store, s, pc)
+}
+
+// pollOnce processes the changes pushed to a branch since the last processed
+// sha. The first poll of a branch only records its head, changes made before
+// polling was enabled are not processed.
+func (wa *WebAPI) pollOnce(t pollTarget, source pollSource, store PollStateStore, s *global.Settings, pc util.PlankClient) error {
+	head, err := source.HeadSha(t.org, t.repo, t.branch)
+	if err != nil {
+		return err
+	}
+	last, err := store.GetPollState(t.key())
+	if err != nil {
+		return err
+	}
+	if last == head {
+		return nil
+	}
+	if last == "" {
+		wa.Logger.Infof("Started polling %s at %s", t.key(), head)
+		return store.SetPollState(t.key(), head)
+	}
+
+	payload, err := source.PushPayload(t.org, t.repo, t.branch, last, head, PollPusher)
+	if err != nil {
+		return err
+	}
+	l := dinghylog.NewDinghyLogs(wa.Logger.WithFields(log.Fields{"poll": t.key()}))
+	p, d, err := newPolledPush(t.provider, payload, s, l)
+	if err != nil {
+		return err
+	}
+
+	w := &pollResponseWriter{header: http.Header{}, status: http.StatusOK}
+	wa.buildPipelines(p, payload, d, w, l, "", pc, s)
+	if w.status >= http.StatusBadRequest {
+		// The sha is not saved so the changes are processed again on the next poll
+		return fmt.Errorf("processing %s..%s failed (%d): %s", last, head, w.status, string(w.body))
+	}
+	return store.SetPollState(t.key(), head)
+}
+
+func newPollSource(provider string, s *global.Settings) (pollSource, error) {
+	switch provider {
+	case "github":
+		return &github.Config{Endpoint: s.GithubEndpoint, Token: s.GitHubToken}, nil
+	case "gitlab":
+		return gitlab.NewFileService(s)
+	default:
+		return nil, fmt.Errorf("polling is not supported for provider %q", provider)
+	}
+}
+
+// newPolledPush parses a payload built by a pollSource the same way the
+// webhook handlers do.
+func newPolledPush(provider string, payload []byte, s *global.Settings, l dinghylog.DinghyLog) (Push, dinghyfile.Downloader, error) {
+	switch provider {
+	case "github":
+		gh := github.Config{Endpoint: s.GithubEndpoint, Token: s.GitHubToken}
+		p := github.Push{Logger: l, Config: gh, DeckBaseURL: s.Deck.BaseURL}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, nil, err
+		}
+		return &p, &github.FileService{GitHub: &gh, Logger: l}, nil
+	case "gitlab":
+		p := gitlab.Push{Logger: l}
+		fs, err := p.ParseWebhook(s, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &p, &fs, nil
+	default:
+		return nil, nil, fmt.Errorf("polling is not supported for provider %q", provider)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/lock"
+	"github.com/armory/dinghy/pkg/mock"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePollSource struct {
+	head     string
+	payloads int
+}
+
+func (f *fakePollSource) HeadSha(org, repo, branch string) (string, error) {
+	return f.head, nil
+}
+
+func (f *fakePollSource) PushPayload(org, repo, branch, base, head, pusher string) ([]byte, error) {
+	f.payloads++
+	return []byte(`{}`), nil
+}
+
+func TestPollTargets(t *testing.T) {
+	s := &global.Settings{
+		TemplateOrg:  "my-org",
+		TemplateRepo: "templates",
+		RepoConfig: []global.RepoConfig{
+			{Provider: "github", Org: "my-org", Repo: "webhooks", Branch: "main"},
+			{Provider: "github", Org: "my-org", Repo: "polled", Branch: "main", Polling: global.Polling{Enabled: true, IntervalSeconds: 30, JitterSeconds: 5}},
+		},
+		TemplateRepoPolling: global.TemplateRepoPolling{Enabled: true, Provider: "gitlab"},
+	}
+
+	targets := pollTargets(s)
+	assert.Equal(t, []pollTarget{
+		{provider: "github", org: "my-org", repo: "polled", branch: "main", interval: 30 * time.Second, jitter: 5 * time.Second},
+		{provider: "gitlab", org: "my-org", repo: "templates", branch: "master", interval: defaultPollInterval},
+	}, targets)
+	assert.Equal(t, "github:my-org/polled:main", targets[0].key())
+
+	wait := targets[0].wait()
+	assert.True(t, wait >= 30*time.Second && wait < 35*time.Second)
+}
+
+func TestPollOnceRecordsFirstHead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	logger := mock.NewMockFieldLogger(ctrl)
+	logger.EXPECT().Infof(gomock.Any(), gomock.Any()).Times(1)
+
+	wa := NewWebAPI(nil, nil, nil, logger, nil, nil, nil, nil)
+	target := newPollTarget("github", "my-org", "my-repo", "main", 0, 0)
+	source := &fakePollSource{head: "abc"}
+	store := &memoryPollState{shas: map[string]string{}}
+
+	assert.Nil(t, wa.pollOnce(target, source, store, &global.Settings{}, nil))
+	sha, _ := store.GetPollState(target.key())
+	assert.Equal(t, "abc", sha)
+	assert.Equal(t, 0, source.payloads)
+
+	// Nothing was pushed since the last poll
+	assert.Nil(t, wa.pollOnce(target, source, store, &global.Settings{}, nil))
+	assert.Equal(t, 0, source.payloads)
+}
+
+func TestNewPollSource(t *testing.T) {
+	_, err := newPollSource("github", &global.Settings{})
+	assert.Nil(t, err)
+
+	_, err = newPollSource("stash", &global.Settings{})
+	assert.NotNil(t, err)
+}
+
+func TestStartPollingUnsupportedProvider(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().IsMultiTenant().Return(false)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).Return(&global.Settings{
+		RepoConfig: []global.RepoConfig{
+			{Provider: "github", Org: "my-org", Repo: "polled", Polling: global.Polling{Enabled: true}},
+			{Provider: "bitbucket-server", Org: "PRJ", Repo: "polled", Polling: global.Polling{Enabled: true}},
+		},
+	}, nil, nil)
+
+	wa := NewWebAPI(sc, nil, nil, mock.NewMockFieldLogger(ctrl), nil, nil, nil, nil)
+	err := wa.StartPolling(context.Background())
+	assert.EqualError(t, err, `can't poll bitbucket-server:PRJ/polled:master: polling is not supported for provider "bitbucket-server"`)
+}
+
+func TestPollLockedSkipsTargetsPolledElsewhere(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	wa := NewWebAPI(nil, nil, nil, mock.NewMockFieldLogger(ctrl), nil, nil, nil, nil)
+	locker := lock.NewMemoryLocker(lock.Options{WaitTimeout: 10 * time.Millisecond, Lease: time.Minute})
+	wa.Locker = locker
+	target := newPollTarget("github", "my-org", "my-repo", "main", 0, 0)
+	source := &fakePollSource{head: "abc"}
+	store := &memoryPollState{shas: map[string]string{"github:my-org/my-repo:main": "abc"}}
+
+	held, err := locker.Acquire(lock.PollKey("github", "my-org", "my-repo", "main"))
+	assert.Nil(t, err)
+	source.head = "def"
+	assert.Nil(t, wa.pollLocked(target, source, store, &global.Settings{}, nil))
+	sha, _ := store.GetPollState(target.key())
+	assert.Equal(t, "abc", sha)
+	assert.Equal(t, 0, source.payloads)
+	held.Release()
+}
@@ -0,0 +1,443 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/git/bbcloud"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/git/gitlab"
+	"github.com/armory/dinghy/pkg/git/stash"
+	"github.com/armory/dinghy/pkg/lock"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ReconcilePusher is the pusher name used when reconciling a dinghyfile
+	// whose last push has no pusher
+	ReconcilePusher             = "dinghy-reconciler"
+	defaultReconcileInterval    = 6 * time.Hour
+	defaultReconcileConcurrency = 4
+)
+
+var (
+	errReconciling = errors.New("a reconciliation is already running")
+	// errDinghyfileSkipped is returned for the urls that are not dinghyfiles of
+	// a provider with credentials in the settings
+	errDinghyfileSkipped = errors.New("skipped")
+	// errLeaderLost is returned for the dinghyfiles left when another replica
+	// took over the reconciliation lock
+	errLeaderLost = errors.New("reconciliation lock lost")
+
+	reconciliations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dinghy",
+		Subsystem: "reconcile",
+		Name:      "runs_total",
+		Help:      "Reconciliations by result.",
+	}, []string{"result"})
+	reconciledDinghyfiles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "dinghy",
+		Subsystem: "reconcile",
+		Name:      "dinghyfiles_total",
+		Help:      "Dinghyfiles processed by reconciliations by result.",
+	}, []string{"result"})
+	reconcileSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "dinghy",
+		Subsystem: "reconcile",
+		Name:      "duration_seconds",
+		Help:      "Time spent reconciling all the dinghyfiles.",
+		Buckets:   []float64{1, 10, 30, 60, 300, 900, 1800, 3600},
+	})
+)
+
+// ReconcileSummary is the outcome of a reconciliation.
+type ReconcileSummary struct {
+	Dinghyfiles int      `json:"dinghyfiles"`
+	Succeeded   int      `json:"succeeded"`
+	Failed      []string `json:"failed"`
+	Skipped     []string `json:"skipped"`
+	Duration    string   `json:"duration"`
+}
+
+func (r ReconcileSummary) String() string {
+	return fmt.Sprintf("Reconciled %d dinghyfiles in %s: %d succeeded, %d failed, %d skipped",
+		r.Dinghyfiles, r.Duration, r.Succeeded, len(r.Failed), len(r.Skipped))
+}
+
+func reconcileInterval(s *global.Settings) time.Duration {
+	if s.Reconciliation.IntervalMinutes <= 0 {
+		return defaultReconcileInterval
+	}
+	return time.Duration(s.Reconciliation.IntervalMinutes) * time.Minute
+}
+
+// runDue checks the time of the last run of a scheduled job by any replica,
+// kept in the poll state under key.
+func runDue(store PollStateStore, key string, interval time.Duration, now time.Time) bool {
+	last, err := store.GetPollState(key)
+	if err != nil || last == "" {
+		return true
+	}
+	millis, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		return true
+	}
+	return now.Sub(time.Unix(0, millis*int64(time.Millisecond))) >= interval
+}
+
+func saveRunTime(store PollStateStore, key string, now time.Time, l log.FieldLogger) {
+	if err := store.SetPollState(key, strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)); err != nil {
+		l.Warnf("Unable to save the time of the %s: %s", key, err.Error())
+	}
+}
+
+// scheduledSettings returns the settings used by the jobs started with dinghy,
+// they are not supported with multi tenant configuration sources.
+func (wa *WebAPI) scheduledSettings(ctx context.Context, job string) (*global.Settings, util.PlankClient, bool) {
+	if wa.SourceConfig.IsMultiTenant() {
+		wa.Logger.Warnf("Scheduled %s is not supported with multi tenant configuration sources", job)
+		return nil, nil, false
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		wa.Logger.Errorf("Unable to schedule %s: %s", job, err.Error())
+		return nil, nil, false
+	}
+	settings, plankClient, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		wa.Logger.Errorf("Unable to schedule %s, failed to get the settings: %s", job, err.Error())
+		return nil, nil, false
+	}
+	return settings, plankClient, true
+}
+
+// every calls run on every interval until the context is done.
+func every(ctx context.Context, interval time.Duration, run func()) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+				run()
+			}
+		}
+	}()
+}
+
+// StartReconciliation processes every dinghyfile of the dependency graph again
+// on the interval of the settings until the context is done.
+func (wa *WebAPI) StartReconciliation(ctx context.Context) {
+	settings, plankClient, ok := wa.scheduledSettings(ctx, "reconciliation")
+	if !ok || !settings.Reconciliation.Enabled {
+		return
+	}
+	// Without a lock the replicas could all find a run due and reconcile at once
+	if wa.Locker == nil {
+		wa.Logger.Warn("Scheduled reconciliation requires locking to be enabled, dinghyfiles can still be reconciled with POST /v1/reconcile")
+		return
+	}
+
+	interval := reconcileInterval(settings)
+	wa.Logger.Infof("Reconciling dinghyfiles every %s", interval)
+	every(ctx, interval, func() {
+		if _, err := wa.runReconciliation(settings, plankClient, true); err == errReconciling {
+			wa.Logger.Info("Not reconciling, another reconciliation is running")
+		} else if err != nil {
+			wa.Logger.Errorf("Reconciliation failed: %s", err.Error())
+		}
+	})
+}
+
+// runReconciliation reconciles the dinghyfiles unless a reconciliation is
+// running in this replica or, with locking enabled, in another one. Scheduled
+// runs, which require locking, are also skipped when any replica reconciled
+// within the interval, the summary is nil when the run was skipped. The lock
+// renews its lease during the run, if it is lost anyway the dinghyfiles left
+// are skipped.
+func (wa *WebAPI) runReconciliation(s *global.Settings, pc util.PlankClient, scheduled bool) (*ReconcileSummary, error) {
+	if !atomic.CompareAndSwapInt32(&wa.reconciling, 0, 1) {
+		return nil, errReconciling
+	}
+	defer atomic.StoreInt32(&wa.reconciling, 0)
+
+	var leader lock.Lock
+	if wa.Locker != nil {
+		var err error
+		leader, err = wa.Locker.Acquire(lock.ReconciliationKey)
+		if errors.Is(err, lock.ErrTimeout) {
+			return nil, errReconciling
+		} else if err != nil {
+			return nil, err
+		}
+		defer leader.Release()
+	}
+
+	now := time.Now()
+	if store, ok := wa.Cache.(PollStateStore); ok {
+		if scheduled && !runDue(store, lock.ReconciliationKey, reconcileInterval(s), now) {
+			return nil, nil
+		}
+		saveRunTime(store, lock.ReconciliationKey, now, wa.Logger)
+	}
+
+	summary := wa.reconcile(s, pc, reconcileDownloaders, leader)
+	reconcileSeconds.Observe(time.Since(now).Seconds())
+
+	status := "success"
+	if len(summary.Failed) > 0 {
+		status = "error"
+		wa.Logger.Errorf("%s, failed dinghyfiles: %v", summary, summary.Failed)
+	} else {
+		wa.Logger.Info(summary.String())
+	}
+	reconciliations.WithLabelValues(status).Inc()
+	if wa.LogEventsClient != nil {
+		wa.LogEventsClient.SaveLogEvent(logevents.LogEvent{
+			Files:   summary.Failed,
+			Message: summary.String(),
+			Status:  status,
+		})
+	}
+	return &summary, nil
+}
+
+// reconcile processes every dinghyfile of the dependency graph with the raw
+// push data stored when it was last processed. The dinghyfiles are skipped
+// once leader, when not nil, is lost.
+func (wa *WebAPI) reconcile(s *global.Settings, pc util.PlankClient, downloaders func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader, leader lock.Lock) ReconcileSummary {
+	start := time.Now()
+	urls := wa.Cache.GetAllDinghyfiles()
+	sort.Strings(urls)
+
+	concurrency := s.Reconciliation.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultReconcileConcurrency
+	}
+	if !ownParser(wa.Parser) {
+		concurrency = 1
+	}
+
+	results := make([]error, len(urls))
+	workers := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, url string) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			if leader != nil && errors.Is(leader.Check(), lock.ErrLost) {
+				results[i] = errLeaderLost
+				return
+			}
+			results[i] = wa.reconcileDinghyfile(url, s, pc, downloaders)
+		}(i, url)
+	}
+	wg.Wait()
+
+	summary := ReconcileSummary{Dinghyfiles: len(urls), Failed: []string{}, Skipped: []string{}}
+	for i, err := range results {
+		switch {
+		case err == nil:
+			summary.Succeeded++
+			reconciledDinghyfiles.WithLabelValues("success").Inc()
+		case errors.Is(err, errDinghyfileSkipped), errors.Is(err, errLeaderLost):
+			summary.Skipped = append(summary.Skipped, urls[i])
+			reconciledDinghyfiles.WithLabelValues("skipped").Inc()
+		default:
+			summary.Failed = append(summary.Failed, urls[i])
+			reconciledDinghyfiles.WithLabelValues("error").Inc()
+		}
+	}
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+	if leader != nil && errors.Is(leader.Check(), lock.ErrLost) {
+		wa.Logger.Errorf("Lost the reconciliation lock, %d dinghyfiles were skipped", len(summary.Skipped))
+	}
+	return summary
+}
+
+// ownParser tells if every builder can have its own parser, other parsers
+// than the DinghyfileParser are shared and render one dinghyfile at a time.
+func ownParser(p dinghyfile.Parser) bool {
+	_, ok := p.(*dinghyfile.DinghyfileParser)
+	return ok || p == nil
+}
+
+func (wa *WebAPI) reconcileDinghyfile(url string, s *global.Settings, pc util.PlankClient, downloaders func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader) error {
+	l := dinghylog.NewDinghyLogs(wa.Logger.WithFields(log.Fields{"reconcile": url}))
+	builder, err := wa.dinghyfileBuilder(url, s, pc, wa.Cache, downloaders(s, l), l)
+	if err != nil {
+		return err
+	}
+	org, repo, path, branch := builder.Downloader.DecodeURL(url)
+	if _, err := builder.ProcessDinghyfile(org, repo, path, branch, lastPusher(builder.Downloader, s, builder.PushRaw)); err != nil {
+		l.Errorf("Failed to reconcile %s: %s", url, err.Error())
+		return err
+	}
+	return nil
+}
+
+// lastPusher returns the pusher of the push a dinghyfile was last processed
+// with, so its permissions and approvals are checked again when reconciling.
+// Stash pushes are processed as the configured user.
+func lastPusher(d dinghyfile.Downloader, s *global.Settings, rawPush map[string]interface{}) string {
+	if _, ok := d.(*stash.FileService); ok && s.StashUsername != "" {
+		return s.StashUsername
+	}
+	if pusher, ok := rawPush["pusher"].(map[string]interface{}); ok {
+		if name, ok := pusher["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	for _, key := range []string{"user_name", "actor"} {
+		if name, ok := rawPush[key].(string); ok && name != "" {
+			return name
+		}
+	}
+	return ReconcilePusher
+}
+
+// dinghyfileBuilder returns a builder for the dinghyfile at url using the raw
+// push data stored when it was last processed. errDinghyfileSkipped is returned
+// when url is not a dinghyfile or none of the downloaders encoded it.
+func (wa *WebAPI) dinghyfileBuilder(url string, s *global.Settings, pc util.PlankClient, depman dinghyfile.DependencyManager, downloaders []dinghyfile.Downloader, l dinghylog.DinghyLog) (*dinghyfile.PipelineBuilder, error) {
+	d, org, repo, path, branch := findDownloader(downloaders, url)
+	if d == nil {
+		l.Warnf("Skipping %s, it can't be downloaded with the providers configured", url)
+		return nil, errDinghyfileSkipped
+	}
+
+	var directoryConfigs *dinghyfile.DirectoryConfigs
+	if s.DirectoryConfigEnabled {
+		directoryConfigs = dinghyfile.NewDirectoryConfigs(d)
+		repoSettings, err := repositorySettings(org, repo, branch, directoryConfigs, s, l)
+		if err != nil {
+			l.Errorf("Invalid repository configuration: %s", err.Error())
+			return nil, err
+		}
+		s = repoSettings
+	}
+
+	rawPush := make(map[string]interface{})
+	if rawData, err := depman.GetRawData(url); err == nil && rawData != "" {
+		if err := json.Unmarshal([]byte(rawData), &rawPush); err != nil {
+			l.Warnf("Unable to deserialize the raw data of %s: %s", url, err.Error())
+		}
+	}
+
+	builder := wa.newPipelineBuilder(d, l, pc, s, rawPush, directoryConfigs)
+	builder.Depman = depman
+	if !builder.IsDinghyfile(path) {
+		return nil, errDinghyfileSkipped
+	}
+	if ownParser(wa.Parser) {
+		builder.Parser = dinghyfile.NewDinghyfileParser(builder)
+	} else {
+		builder.Parser = wa.Parser
+		builder.Parser.SetBuilder(builder)
+	}
+	return builder, nil
+}
+
+// reconcileDownloaders returns the downloaders of the providers with
+// credentials in the settings.
+func reconcileDownloaders(s *global.Settings, l dinghylog.DinghyLog) []dinghyfile.Downloader {
+	var downloaders []dinghyfile.Downloader
+	if s.GitHubToken != "" {
+		gh := github.Config{Endpoint: s.GithubEndpoint, Token: s.GitHubToken}
+		downloaders = append(downloaders, &github.FileService{GitHub: &gh, Logger: l})
+	}
+	if s.GitLabToken != "" {
+		if fs, err := gitlab.NewFileService(s); err == nil {
+			fs.Logger = l
+			downloaders = append(downloaders, fs)
+		} else {
+			l.Warnf("Unable to reconcile GitLab dinghyfiles: %s", err.Error())
+		}
+	}
+	if s.StashToken != "" {
+		downloaders = append(downloaders,
+			&stash.FileService{
+				Config: stash.Config{Endpoint: s.StashEndpoint, Username: s.StashUsername, Token: s.StashToken, Logger: l},
+				Logger: l,
+			},
+			&bbcloud.FileService{
+				Config: bbcloud.Config{Endpoint: s.StashEndpoint, Username: s.StashUsername, Token: s.StashToken, Logger: l},
+				Logger: l,
+			})
+	}
+	return downloaders
+}
+
+// findDownloader returns the downloader that encoded url.
+func findDownloader(downloaders []dinghyfile.Downloader, url string) (dinghyfile.Downloader, string, string, string, string) {
+	for _, d := range downloaders {
+		if org, repo, path, branch, ok := decodeURL(d, url); ok {
+			return d, org, repo, path, branch
+		}
+	}
+	return nil, "", "", "", ""
+}
+
+// decodeURL decodes url with d, the downloaders panic decoding the urls of
+// other providers and some decode them, so url must encode back to itself.
+func decodeURL(d dinghyfile.Downloader, url string) (org, repo, path, branch string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	org, repo, path, branch = d.DecodeURL(url)
+	return org, repo, path, branch, d.EncodeURL(org, repo, path, branch) == url
+}
+
+// reconcileHandler starts a reconciliation in the background, its summary is
+// logged and saved as a log event.
+func (wa *WebAPI) reconcileHandler(w http.ResponseWriter, r *http.Request) {
+	settings, plankClient, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if atomic.LoadInt32(&wa.reconciling) == 1 {
+		util.WriteHTTPError(w, http.StatusConflict, errReconciling)
+		return
+	}
+	go func() {
+		if _, err := wa.runReconciliation(settings, plankClient, false); err != nil {
+			wa.Logger.Errorf("Reconciliation failed: %s", err.Error())
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,182 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/events"
+	"github.com/armory/dinghy/pkg/git/dummy"
+	"github.com/armory/dinghy/pkg/git/github"
+	"github.com/armory/dinghy/pkg/git/gitlab"
+	"github.com/armory/dinghy/pkg/git/stash"
+	"github.com/armory/dinghy/pkg/lock"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/mock"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer echo.Close()
+	s := &global.Settings{DinghyFilename: "dinghyfile", Reconciliation: global.Reconciliation{Concurrency: 1}}
+	s.SpinnakerSupplied.Echo.BaseURL = echo.URL
+
+	fs := dummy.FileService{"master": {
+		"dinghyfile":        `{"application": "app", "pipelines": []}`,
+		"broken/dinghyfile": `{"application": "app", "pipelines": [}`,
+		"module":            `{}`,
+	}}
+	c := cache.NewMemoryCache()
+	c.SetDeps(fs.EncodeURL("org", "repo", "dinghyfile", "master"), []string{fs.EncodeURL("org", "templates", "module", "master")})
+	c.SetDeps(fs.EncodeURL("org", "repo", "broken/dinghyfile", "master"), []string{fs.EncodeURL("org", "templates", "module", "master")})
+	c.SetDeps(fs.EncodeURL("org", "templates", "wrapper", "master"), []string{fs.EncodeURL("org", "templates", "module", "master")})
+	c.SetDeps("https://gitlab.example.com/org/repo/dinghyfile", []string{fs.EncodeURL("org", "templates", "other", "master")})
+
+	client := dinghyfile.NewMockPlankClient(ctrl)
+	client.EXPECT().GetApplication("app", "").Return(&plank.Application{Name: "app"}, nil).Times(1)
+	client.EXPECT().GetPipelines("app", "").Return([]plank.Pipeline{}, nil).Times(1)
+	client.EXPECT().GetApplicationNotifications("app", "").Return(&plank.NotificationsType{}, nil).Times(1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(nil, c, nil, logger, nil, nil, nil, nil)
+	wa.EventClient = events.NewEventClient(context.Background(), s, false)
+	wa.AddDinghyfileUnmarshaller(&dinghyfile.DinghyJsonUnmarshaller{})
+	wa.Parser = dinghyfile.NewDinghyfileParser(&dinghyfile.PipelineBuilder{})
+
+	summary := wa.reconcile(s, client, func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader {
+		return []dinghyfile.Downloader{fs}
+	}, nil)
+
+	assert.Equal(t, 4, summary.Dinghyfiles)
+	assert.Equal(t, 1, summary.Succeeded)
+	assert.Equal(t, []string{fs.EncodeURL("org", "repo", "broken/dinghyfile", "master")}, summary.Failed)
+	assert.Equal(t, []string{
+		fs.EncodeURL("org", "templates", "wrapper", "master"),
+		"https://gitlab.example.com/org/repo/dinghyfile",
+	}, summary.Skipped)
+}
+
+func TestReconcileLeaderLost(t *testing.T) {
+	fs := dummy.FileService{"master": {"dinghyfile": `{"application": "app", "pipelines": []}`}}
+	c := cache.NewMemoryCache()
+	c.SetDeps(fs.EncodeURL("org", "repo", "dinghyfile", "master"), []string{})
+
+	locker := lock.NewMemoryLocker(lock.Options{WaitTimeout: time.Second, Lease: 10 * time.Millisecond})
+	leader, err := locker.Acquire(lock.ReconciliationKey)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+	other, err := locker.Acquire(lock.ReconciliationKey)
+	assert.Nil(t, err)
+	defer other.Release()
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(nil, c, nil, logger, nil, nil, nil, nil)
+	summary := wa.reconcile(&global.Settings{DinghyFilename: "dinghyfile"}, nil, func(*global.Settings, dinghylog.DinghyLog) []dinghyfile.Downloader {
+		return []dinghyfile.Downloader{fs}
+	}, leader)
+
+	assert.Equal(t, 0, summary.Succeeded)
+	assert.Equal(t, []string{fs.EncodeURL("org", "repo", "dinghyfile", "master")}, summary.Skipped)
+}
+
+func TestLastPusher(t *testing.T) {
+	s := &global.Settings{StashUsername: "stash-user"}
+	gh := &github.FileService{}
+	cases := []struct {
+		downloader dinghyfile.Downloader
+		rawPush    map[string]interface{}
+		expected   string
+	}{
+		{gh, map[string]interface{}{"pusher": map[string]interface{}{"name": "octocat"}}, "octocat"},
+		{gh, map[string]interface{}{"user_name": "gitlab-user"}, "gitlab-user"},
+		{gh, map[string]interface{}{"actor": "bitbucket-user"}, "bitbucket-user"},
+		{gh, map[string]interface{}{"pusher": map[string]interface{}{}}, ReconcilePusher},
+		{gh, map[string]interface{}{}, ReconcilePusher},
+		{&stash.FileService{}, map[string]interface{}{"actor": "bitbucket-user"}, "stash-user"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, lastPusher(c.downloader, s, c.rawPush))
+	}
+}
+
+func TestFindDownloader(t *testing.T) {
+	gh := &github.FileService{GitHub: &github.Config{Endpoint: "https://api.github.com"}}
+	gl, err := gitlab.NewFileService(&global.Settings{GitLabEndpoint: "https://gitlab.example.com"})
+	assert.Nil(t, err)
+	downloaders := []dinghyfile.Downloader{gh, gl}
+
+	d, org, repo, path, branch := findDownloader(downloaders, gl.EncodeURL("org", "repo", "dir/dinghyfile", "main"))
+	assert.Equal(t, gl, d)
+	assert.Equal(t, []string{"org", "repo", "dir/dinghyfile", "main"}, []string{org, repo, path, branch})
+
+	d, _, _, _, _ = findDownloader(downloaders, gh.EncodeURL("org", "repo", "dinghyfile", "master"))
+	assert.Equal(t, gh, d)
+
+	d, _, _, _, _ = findDownloader(downloaders, "https://bitbucket.example.com/projects/org/repos/repo/browse/dinghyfile?at=master&raw")
+	assert.Nil(t, d)
+}
+
+func TestRunDue(t *testing.T) {
+	store := &memoryPollState{shas: map[string]string{}}
+	now := time.Now()
+	assert.True(t, runDue(store, "reconciliation", time.Hour, now))
+
+	store.SetPollState("reconciliation", strconv.FormatInt(now.Add(-30*time.Minute).UnixNano()/int64(time.Millisecond), 10))
+	assert.False(t, runDue(store, "reconciliation", time.Hour, now))
+	assert.True(t, runDue(store, "reconciliation", 20*time.Minute, now))
+}
+
+func TestRunReconciliationWhileRunning(t *testing.T) {
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, nil, nil, nil, nil, nil)
+	wa.reconciling = 1
+
+	summary, err := wa.runReconciliation(&global.Settings{}, nil, false)
+	assert.Nil(t, summary)
+	assert.Equal(t, errReconciling, err)
+}
+
+func TestStartReconciliationRequiresLocking(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().IsMultiTenant().Return(false)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).Return(&global.Settings{
+		Reconciliation: global.Reconciliation{Enabled: true},
+	}, nil, nil)
+	logger := mock.NewMockFieldLogger(ctrl)
+	logger.EXPECT().Warn(gomock.Any()).Times(1)
+
+	wa := NewWebAPI(sc, cache.NewMemoryCache(), nil, logger, nil, nil, nil, nil)
+	wa.StartReconciliation(context.Background())
+}
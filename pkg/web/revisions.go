@@ -0,0 +1,220 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/armory/dinghy/pkg/git/dummy"
+	dinghylog "github.com/armory/dinghy/pkg/log"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/util"
+)
+
+// RollbackStatus is the status of the log events of rollbacks
+const RollbackStatus = "rollback"
+
+var errRevisionsDisabled = errors.New("revisions are not enabled")
+
+// Rollback is the outcome of rolling an application back to a revision.
+type Rollback struct {
+	Application string                    `json:"application"`
+	RollbackOf  int64                     `json:"rollbackOf"`
+	Revision    int64                     `json:"revision,omitempty"`
+	Pipelines   logevents.PipelineChanges `json:"pipelines"`
+	Error       string                    `json:"error,omitempty"`
+}
+
+// revisionsHandler lists the revisions of an application or of a commit, newest first.
+func (wa *WebAPI) revisionsHandler(w http.ResponseWriter, r *http.Request) {
+	if wa.RevisionsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errRevisionsDisabled)
+		return
+	}
+	q := r.URL.Query()
+	var found []revisions.Revision
+	var err error
+	switch {
+	case q.Get("application") != "":
+		found, err = wa.RevisionsClient.GetRevisions(q.Get("application"))
+	case q.Get("commit") != "":
+		found, err = wa.RevisionsClient.GetRevisionsByCommit(q.Get("commit"))
+	default:
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("application or commit parameter is required"))
+		return
+	}
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, found)
+}
+
+// rollbackHandler restores a revision of an application, or the revisions
+// before a commit of every application it updated.
+func (wa *WebAPI) rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if wa.RevisionsClient == nil {
+		util.WriteHTTPError(w, http.StatusNotFound, errRevisionsDisabled)
+		return
+	}
+	q := r.URL.Query()
+	var targets []rollbackTarget
+	var err error
+	switch {
+	case q.Get("application") != "":
+		id, errID := strconv.ParseInt(q.Get("revision"), 10, 64)
+		if errID != nil {
+			util.WriteHTTPError(w, http.StatusBadRequest, errors.New("a numeric revision parameter is required"))
+			return
+		}
+		targets, err = wa.revisionTarget(q.Get("application"), id)
+	case q.Get("commit") != "":
+		targets, err = wa.commitTargets(q.Get("commit"))
+	default:
+		util.WriteHTTPError(w, http.StatusBadRequest, errors.New("application or commit parameter is required"))
+		return
+	}
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(targets) == 0 {
+		util.WriteHTTPError(w, http.StatusNotFound, errors.New("no revision found to roll back to"))
+		return
+	}
+
+	settings, plankClient, err := wa.SourceConfig.GetSettings(r, wa.Logr)
+	if err != nil {
+		util.WriteHTTPError(w, http.StatusInternalServerError, err)
+		return
+	}
+	requester := r.Header.Get("X-Spinnaker-User")
+	status := http.StatusOK
+	rollbacks := make([]Rollback, 0, len(targets))
+	for _, target := range targets {
+		rollback := wa.rollback(settings, plankClient, target, requester)
+		if rollback.Error != "" {
+			status = http.StatusInternalServerError
+		}
+		rollbacks = append(rollbacks, rollback)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, rollbacks)
+}
+
+// rollbackTarget is a revision to restore and the revision of the same
+// dinghyfile in place, error is set when there is nothing to restore.
+type rollbackTarget struct {
+	target revisions.Revision
+	latest revisions.Revision
+	error  string
+}
+
+func (wa *WebAPI) revisionTarget(application string, id int64) ([]rollbackTarget, error) {
+	found, err := wa.RevisionsClient.GetRevisions(application)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range found {
+		if revision.ID == id {
+			return []rollbackTarget{{target: revision, latest: revisions.Latest(found, revision)}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// commitTargets returns the revisions applied before a commit by every
+// dinghyfile the commit updated.
+func (wa *WebAPI) commitTargets(commit string) ([]rollbackTarget, error) {
+	applied, err := wa.RevisionsClient.GetRevisionsByCommit(commit)
+	if err != nil {
+		return nil, err
+	}
+	// a dinghyfile may have been processed more than once for the commit,
+	// the first time is the one to go back from
+	first := []revisions.Revision{}
+	for i := len(applied) - 1; i >= 0; i-- {
+		seen := false
+		for _, f := range first {
+			seen = seen || f.SameDinghyfile(applied[i])
+		}
+		if !seen {
+			first = append(first, applied[i])
+		}
+	}
+
+	targets := []rollbackTarget{}
+	for _, revision := range first {
+		found, err := wa.RevisionsClient.GetRevisions(revision.Application)
+		if err != nil {
+			return nil, err
+		}
+		previous := revisions.Previous(found, revision)
+		if previous == nil {
+			targets = append(targets, rollbackTarget{
+				target: revision,
+				error:  fmt.Sprintf("no revision of %s before commit %s", revision.Application, commit),
+			})
+			continue
+		}
+		targets = append(targets, rollbackTarget{target: *previous, latest: revisions.Latest(found, revision)})
+	}
+	return targets, nil
+}
+
+// rollback restores a revision through the pipeline builder and records it as a log event.
+func (wa *WebAPI) rollback(s *global.Settings, pc util.PlankClient, t rollbackTarget, requester string) Rollback {
+	result := Rollback{Application: t.target.Application, RollbackOf: t.target.ID, Error: t.error}
+	if t.error != "" {
+		return result
+	}
+	builder := wa.newPipelineBuilder(dummy.FileService{}, dinghylog.NewDinghyLogs(wa.Logger), pc, s, nil, nil)
+	rollback, changes, err := builder.RestoreRevision(t.target, t.latest, requester)
+	result.Revision = rollback.ID
+	result.Pipelines = changes
+	message := fmt.Sprintf("Rolled back %s to revision %d", t.target.Application, t.target.ID)
+	if requester != "" {
+		message = fmt.Sprintf("%s, requested by %s", message, requester)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		message = fmt.Sprintf("Failed to roll back %s to revision %d: %s", t.target.Application, t.target.ID, err.Error())
+		wa.Logger.Error(message)
+	}
+	if wa.LogEventsClient != nil {
+		commits := []string{}
+		if t.target.Commit != "" {
+			commits = append(commits, t.target.Commit)
+		}
+		if errSave := wa.LogEventsClient.SaveLogEvent(logevents.LogEvent{
+			Org:                t.target.Org,
+			Repo:               t.target.Repo,
+			Files:              []string{t.target.Path},
+			Message:            message,
+			Commits:            commits,
+			Status:             RollbackStatus,
+			RenderedDinghyfile: t.target.Dinghyfile,
+			Pipelines:          &changes,
+		}); errSave != nil {
+			wa.Logger.Errorf("Unable to save the rollback of %s: %s", t.target.Application, errSave.Error())
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+/*
+* Copyright 2022 Armory, Inc.
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*    http://www.apache.org/licenses/LICENSE-2.0
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+ */
+
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/armory/dinghy/pkg/cache"
+	"github.com/armory/dinghy/pkg/dinghyfile"
+	"github.com/armory/dinghy/pkg/logevents"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/dinghy/pkg/settings/global"
+	"github.com/armory/dinghy/pkg/settings/source"
+	"github.com/armory/dinghy/pkg/util"
+	"github.com/armory/plank/v4"
+	"github.com/golang/mock/gomock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackCommit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := revisions.NewRevisionMemoryClient(0)
+	first, _ := store.SaveRevision(revisions.Revision{Application: "app", Org: "org", Repo: "repo", Path: "dinghyfile", Commit: "c1",
+		Pipelines: []plank.Pipeline{{Name: "deploy", Application: "app", Description: "one"}}})
+	store.SaveRevision(revisions.Revision{Application: "app", Org: "org", Repo: "repo", Path: "dinghyfile", Commit: "c2",
+		Pipelines: []plank.Pipeline{{Name: "deploy", Application: "app", Description: "two"}, {Name: "extra", Application: "app"}}})
+	store.SaveRevision(revisions.Revision{Application: "other", Org: "org", Repo: "repo", Path: "other/dinghyfile", Commit: "c2"})
+
+	extra := plank.Pipeline{Name: "extra", ID: "ExtraID", Application: "app"}
+	client := dinghyfile.NewMockPlankClient(ctrl)
+	client.EXPECT().GetPipelines("app", "").Return([]plank.Pipeline{
+		{Name: "deploy", ID: "DeployID", Application: "app", Description: "two"}, extra,
+	}, nil).Times(1)
+	client.EXPECT().UpsertPipeline(plank.Pipeline{Name: "deploy", ID: "DeployID", Application: "app", Description: "one"}, "DeployID", "").Return(nil).Times(1)
+	client.EXPECT().DeletePipeline(extra, "").Return(nil).Times(1)
+
+	sc := source.NewMockSourceConfiguration(ctrl)
+	sc.EXPECT().GetSettings(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(r *http.Request, logger2 *logrus.Logger) (*global.Settings, util.PlankClient, error) {
+		return &global.Settings{DinghyFilename: "dinghyfile"}, client, nil
+	})
+
+	logEvents := logevents.NewMockLogEventsClient(ctrl)
+	logEvents.EXPECT().SaveLogEvent(gomock.Any()).DoAndReturn(func(e logevents.LogEvent) error {
+		assert.Equal(t, RollbackStatus, e.Status)
+		assert.Equal(t, []string{"c1"}, e.Commits)
+		assert.Equal(t, "Rolled back app to revision 1, requested by someone", e.Message)
+		assert.Equal(t, &logevents.PipelineChanges{Updated: 1, Deleted: 1}, e.Pipelines)
+		return nil
+	}).Times(1)
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	wa := NewWebAPI(sc, cache.NewMemoryCache(), nil, logger, nil, nil, logEvents, nil)
+	wa.RevisionsClient = store
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/rollback?commit=c2", nil)
+	r.Header.Set("X-Spinnaker-User", "someone")
+	rr := httptest.NewRecorder()
+	wa.rollbackHandler(rr, r)
+
+	// other has no revision before the commit
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), `{"application":"app","rollbackOf":1,"revision":4,"pipelines":{"created":0,"updated":1,"unchanged":0,"deleted":1}}`)
+	assert.Contains(t, rr.Body.String(), `"error":"no revision of other before commit c2"`)
+
+	found, _ := store.GetRevisions("app")
+	assert.Equal(t, first.ID, found[0].RollbackOf)
+}
+
+func TestRevisionsHandler(t *testing.T) {
+	wa := NewWebAPI(nil, cache.NewMemoryCache(), nil, nil, nil, nil, nil, nil)
+	rr := httptest.NewRecorder()
+	wa.revisionsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/revisions?application=app", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	wa.RevisionsClient = revisions.NewRevisionMemoryClient(0)
+	wa.RevisionsClient.SaveRevision(revisions.Revision{Application: "app", Commit: "c1"})
+	rr = httptest.NewRecorder()
+	wa.revisionsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/revisions?application=app", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"commit":"c1"`)
+
+	rr = httptest.NewRecorder()
+	wa.revisionsHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/revisions", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
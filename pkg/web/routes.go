@@ -21,21 +21,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/armory/dinghy/pkg/deliveries"
 	"github.com/armory/dinghy/pkg/dinghyfile/pipebuilder"
+	"github.com/armory/dinghy/pkg/lint"
+	"github.com/armory/dinghy/pkg/lock"
 	dinghylog "github.com/armory/dinghy/pkg/log"
 	"github.com/armory/dinghy/pkg/logevents"
 	"github.com/armory/dinghy/pkg/settings/global"
 	"github.com/armory/dinghy/pkg/settings/source"
 	"io/ioutil"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/armory/dinghy/pkg/events"
 	"github.com/armory/dinghy/pkg/git/bbcloud"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/armory/dinghy/pkg/approvals"
 	"github.com/armory/dinghy/pkg/cache"
 	"github.com/armory/dinghy/pkg/dinghyfile"
 	"github.com/armory/dinghy/pkg/git"
@@ -43,7 +48,11 @@ import (
 	"github.com/armory/dinghy/pkg/git/github"
 	"github.com/armory/dinghy/pkg/git/gitlab"
 	"github.com/armory/dinghy/pkg/git/stash"
+	"github.com/armory/dinghy/pkg/impact"
 	"github.com/armory/dinghy/pkg/notifiers"
+	"github.com/armory/dinghy/pkg/policy"
+	"github.com/armory/dinghy/pkg/revisions"
+	"github.com/armory/dinghy/pkg/schema"
 	"github.com/armory/dinghy/pkg/util"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -75,19 +84,41 @@ func (nom *NoOpMetricsHandler) WrapHandleFunc(pattern string, handler func(http.
 }
 
 type WebAPI struct {
-	SourceConfig    source.SourceConfiguration
-	ClientReadOnly  util.PlankClient
-	Cache           dinghyfile.DependencyManager
-	CacheReadOnly   dinghyfile.DependencyManager
-	EventClient     *events.Client
-	Logger          log.FieldLogger
-	Ums             []dinghyfile.Unmarshaller
-	Notifiers       []notifiers.Notifier
-	Parser          dinghyfile.Parser
+	SourceConfig   source.SourceConfiguration
+	ClientReadOnly util.PlankClient
+	Cache          dinghyfile.DependencyManager
+	CacheReadOnly  dinghyfile.DependencyManager
+	EventClient    *events.Client
+	Logger         log.FieldLogger
+	Ums            []dinghyfile.Unmarshaller
+	Notifiers      []notifiers.Notifier
+	Parser         dinghyfile.Parser
+	// Parsers by format, available to the .dinghy.yml parserFormat
+	Parsers         map[string]dinghyfile.Parser
 	LogEventsClient logevents.LogEventsClient
-	MuxRouter       *mux.Router
-	Logr            *log.Logger
+	// The features below are disabled while their client is nil.
+	// DeliveriesClient is used to suppress duplicated webhook deliveries
+	DeliveriesClient deliveries.DeliveriesClient
+	// Locker serializes the processing of repositories and applications between replicas
+	Locker lock.Locker
+	// ImpactReportsClient stores the module impact reports of template repo branches
+	ImpactReportsClient impact.ReportsClient
+	// RevisionsClient stores what was applied to every application so it can be rolled back
+	RevisionsClient revisions.Client
+	// ApprovalsClient stores the changes to protected applications held for approval
+	ApprovalsClient approvals.Client
+	// References caches the accounts, stage types and pipelines of Spinnaker the dinghyfiles are validated against
+	References *dinghyfile.ReferenceCatalog
+	MuxRouter  *mux.Router
+	Logr       *log.Logger
 	MetricsHandler
+	// reconciling is set while this replica runs a reconciliation
+	reconciling int32
+	// detectingDrift is set while this replica detects drift
+	detectingDrift int32
+	driftMutex     sync.Mutex
+	// driftReport is the last drift report of this replica
+	driftReport *DriftReport
 }
 
 func NewWebAPI(s source.SourceConfiguration, r dinghyfile.DependencyManager, e *events.Client, l log.FieldLogger, depreadonly dinghyfile.DependencyManager, clientreadonly util.PlankClient, logeventsClient logevents.LogEventsClient, logr *log.Logger) *WebAPI {
@@ -113,6 +144,15 @@ func (wa *WebAPI) SetDinghyfileParser(p dinghyfile.Parser) {
 	wa.Parser = p
 }
 
+// AddDinghyfileParser registers a parser that can be selected by format in
+// the .dinghy.yml files.
+func (wa *WebAPI) AddDinghyfileParser(format string, p dinghyfile.Parser) {
+	if wa.Parsers == nil {
+		wa.Parsers = map[string]dinghyfile.Parser{}
+	}
+	wa.Parsers[format] = p
+}
+
 // AddNotifier adds a Notifier type instance that will be triggered when
 // a Dinghyfile processing phase completes (success/fail).  It only gets
 // triggered if there is work to do on a push (ie. a pipeline is intended
@@ -130,6 +170,20 @@ func (wa *WebAPI) Router(ts TraceSettings) *mux.Router {
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/health", wa.healthcheck))
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/healthcheck", wa.healthcheck))
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/logevents", wa.logevents)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/graph", wa.graphExport)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/graph/dinghyfiles", wa.graphDinghyfiles)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/graph/children", wa.graphChildren)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/graph/roots", wa.graphRoots)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/graph/check", wa.graphCheck)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/impact", wa.impactReport)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/reconcile", wa.reconcileHandler)).Methods("POST")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/drift", wa.driftReportHandler)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/drift", wa.driftHandler)).Methods("POST")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/revisions", wa.revisionsHandler)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/rollback", wa.rollbackHandler)).Methods("POST")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/changerequests", wa.changeRequestsHandler)).Methods("GET")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/changerequests/approve", wa.approveHandler)).Methods("POST")
+	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/changerequests/reject", wa.rejectHandler)).Methods("POST")
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/webhooks/github", wa.githubWebhookHandler)).Methods("POST")
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/webhooks/gitlab", wa.gitlabWebhookHandler)).Methods("POST")
 	r.HandleFunc(wa.MetricsHandler.WrapHandleFunc("/v1/webhooks/stash", wa.stashWebhookHandler)).Methods("POST")
@@ -258,7 +312,7 @@ func (wa *WebAPI) githubWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	wa.buildPipelines(&p, body, &fileService, w, dinghyLog, pullRequestUrl, plankClient, settings)
+	wa.buildPipelinesOnce(r, &p, body, &fileService, w, dinghyLog, pullRequestUrl, plankClient, settings)
 }
 
 func contains(whvalidations []string, provider string) bool {
@@ -373,7 +427,7 @@ func (wa *WebAPI) gitlabWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		saveLogEventError(wa.LogEventsClient, &p, dinghyLog, logevents.LogEvent{RawData: string(body)})
 		return
 	}
-	wa.buildPipelines(&p, body, &fileService, w, dinghyLog, "", plankClient, settings)
+	wa.buildPipelinesOnce(r, &p, body, &fileService, w, dinghyLog, "", plankClient, settings)
 }
 
 func (wa *WebAPI) stashWebhookHandler(w http.ResponseWriter, r *http.Request) {
@@ -426,7 +480,7 @@ func (wa *WebAPI) stashWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		Logger: dinghyLog,
 	}
 	dinghyLog.Infof("Building pipeslines from Stash webhook")
-	wa.buildPipelines(p, body, &fileService, w, dinghyLog, "", plankClient, settings)
+	wa.buildPipelinesOnce(r, p, body, &fileService, w, dinghyLog, "", plankClient, settings)
 }
 
 func (wa *WebAPI) bitbucketWebhookHandler(w http.ResponseWriter, r *http.Request) {
@@ -503,7 +557,7 @@ func (wa *WebAPI) bitbucketWebhookHandler(w http.ResponseWriter, r *http.Request
 			Logger: dinghyLog,
 		}
 
-		wa.buildPipelines(p, body, &fileService, w, dinghyLog, "", plankClient, settings)
+		wa.buildPipelinesOnce(r, p, body, &fileService, w, dinghyLog, "", plankClient, settings)
 
 	case "repo:refs_changed", "pr:merged":
 		dinghyLog.Info("Processing bitbucket-server webhook")
@@ -550,7 +604,7 @@ func (wa *WebAPI) bitbucketWebhookHandler(w http.ResponseWriter, r *http.Request
 			Logger: dinghyLog,
 		}
 
-		wa.buildPipelines(p, body, &fileService, w, dinghyLog, "", plankClient, settings)
+		wa.buildPipelinesOnce(r, p, body, &fileService, w, dinghyLog, "", plankClient, settings)
 
 	default:
 		util.WriteHTTPError(w, http.StatusInternalServerError, errors.New("Unknown bitbucket event type"))
@@ -564,8 +618,9 @@ func (wa *WebAPI) bitbucketWebhookHandler(w http.ResponseWriter, r *http.Request
 
 // ProcessPush processes a push using a pipeline builder
 func (wa *WebAPI) ProcessPush(p Push, b *dinghyfile.PipelineBuilder, settings *global.Settings) (string, error) {
+	dinghyfiles := dinghyfilesInPush(p, b, settings.GetRepoConfig(p.Name(), p.Repo(), p.Branch()))
 	// Ensure dinghyfile was changed.
-	if !p.ContainsFile(settings.DinghyFilename) {
+	if len(dinghyfiles) == 0 {
 		b.Logger.Infof("Push does not include %s, skipping.", settings.DinghyFilename)
 		errstat, status, _ := p.GetCommitStatus()
 		if errstat == nil && status == "" {
@@ -580,60 +635,71 @@ func (wa *WebAPI) ProcessPush(p Push, b *dinghyfile.PipelineBuilder, settings *g
 	p.SetCommitStatus(settings.InstanceId, git.StatusPending, git.DefaultMessagesByBuilderAction[b.Action][git.StatusPending])
 
 	var dinghyfilesRendered bytes.Buffer
-	for _, filePath := range p.Files() {
-		components := strings.Split(filePath, "/")
-		if components[len(components)-1] == settings.DinghyFilename {
-			// Process the dinghyfile.
-			dinghyRendered, err := b.ProcessDinghyfile(p.Org(), p.Repo(), filePath, p.Branch(), p.PusherName())
-			dinghyfilesRendered.WriteString(dinghyRendered)
-			// Set commit status based on result of processing.
-			if err != nil {
-				if err == dinghyfile.ErrMalformedJSON {
-					b.Logger.Errorf("Error processing Dinghyfile (malformed JSON): %s", err.Error())
-					p.SetCommitStatus(settings.InstanceId, git.StatusFailure, "Error processing Dinghyfile (malformed JSON)")
-				} else {
-					b.Logger.Errorf("Error processing Dinghyfile: %s", err.Error())
-					p.SetCommitStatus(settings.InstanceId, git.StatusError, fmt.Sprintf("%s", err.Error()))
-				}
-				return dinghyfilesRendered.String(), err
+	for _, filePath := range dinghyfiles {
+		// Process the dinghyfile.
+		dinghyRendered, err := b.ProcessDinghyfile(p.Org(), p.Repo(), filePath, p.Branch(), p.PusherName())
+		dinghyfilesRendered.WriteString(dinghyRendered)
+		// Set commit status based on result of processing.
+		if err != nil {
+			if err == dinghyfile.ErrMalformedJSON {
+				b.Logger.Errorf("Error processing Dinghyfile (malformed JSON): %s", err.Error())
+				p.SetCommitStatus(settings.InstanceId, git.StatusFailure, "Error processing Dinghyfile (malformed JSON)")
+			} else {
+				b.Logger.Errorf("Error processing Dinghyfile: %s", err.Error())
+				p.SetCommitStatus(settings.InstanceId, git.StatusError, fmt.Sprintf("%s", err.Error()))
 			}
-			p.SetCommitStatus(settings.InstanceId, git.StatusSuccess, git.DefaultMessagesByBuilderAction[b.Action][git.StatusSuccess])
+			return dinghyfilesRendered.String(), err
 		}
+		if pending := b.Approvals.PendingDescription(p.Org(), p.Repo(), filePath, p.Branch()); pending != "" {
+			p.SetCommitStatus(settings.InstanceId, git.StatusPending, pending)
+			continue
+		}
+		if frozen := b.Freezes.Description(p.Org(), p.Repo(), filePath); frozen != "" {
+			p.SetCommitStatus(settings.InstanceId, git.StatusSuccess, frozen)
+			continue
+		}
+		p.SetCommitStatus(settings.InstanceId, git.StatusSuccess, successDescription(b))
 	}
 	return dinghyfilesRendered.String(), nil
 }
 
+// successDescription returns the description of the success commit status,
+// with the number of lint warnings when there are any.
+func successDescription(b *dinghyfile.PipelineBuilder) string {
+	description := git.DefaultMessagesByBuilderAction[b.Action][git.StatusSuccess]
+	if b.LintReport == nil {
+		return description
+	}
+	switch warnings := b.LintReport.Count(); warnings {
+	case 0:
+		return description
+	case 1:
+		return description + " (1 lint warning)"
+	default:
+		return fmt.Sprintf("%s (%d lint warnings)", description, warnings)
+	}
+}
+
 type UserWriteAccessValidation struct {
 }
 
-// TODO: this func should return an error and allow the handlers to return the http response. Additionally,
-// it probably doesn't belong in this file once refactored.
-func (wa *WebAPI) buildPipelines(
-	p Push,
-	rawPushBytes []byte,
+// newPipelineBuilder returns a builder processing the dinghyfiles downloaded
+// with d using the settings s.
+func (wa *WebAPI) newPipelineBuilder(
 	d dinghyfile.Downloader,
-	w http.ResponseWriter,
 	l dinghylog.DinghyLog,
-	pullRequest string,
 	pc util.PlankClient,
 	s *global.Settings,
-) {
-	l.Infof("Processing request for branch: %s", p.Branch())
-
-	// deserialize push data to a map.  used in template logic later
-	rawPush := make(map[string]interface{})
-	if err := json.Unmarshal(rawPushBytes, &rawPush); err != nil {
-		l.Errorf("unable to deserialize raw data to map")
-	}
-
-	// Construct a pipeline builder using provided downloader
-	builder := &dinghyfile.PipelineBuilder{
+	rawPush map[string]interface{},
+	directoryConfigs *dinghyfile.DirectoryConfigs,
+) *dinghyfile.PipelineBuilder {
+	return &dinghyfile.PipelineBuilder{
 		Downloader:                  d,
 		Depman:                      wa.Cache,
 		TemplateRepo:                s.TemplateRepo,
 		TemplateOrg:                 s.TemplateOrg,
 		DinghyfileName:              s.DinghyFilename,
-		DeleteStalePipelines:        false,
+		DeleteStalePipelines:        s.DeleteStalePipelines,
 		AutolockPipelines:           s.AutoLockPipelines,
 		Client:                      pc,
 		EventClient:                 wa.EventClient,
@@ -645,12 +711,153 @@ func (wa *WebAPI) buildPipelines(
 		Action:                      pipebuilder.Process,
 		JsonValidationDisabled:      s.JsonValidationDisabled,
 		UserWriteAccessValidation: dinghyfile.UserWriteAccessValidation{
-			Enabled: s.UserWritePermissionsCheckEnabled,
-			Client:  pc,
-			Ignore:  s.IgnoreUsersPermissions,
-			Logger:  l,
+			Enabled:   s.UserWritePermissionsCheckEnabled,
+			Client:    pc,
+			Ignore:    s.IgnoreUsersPermissions,
+			Logger:    l,
+			Resources: s.UserResourcePermissionsCheckEnabled,
 		},
 		UpsertPipelineUsingOrcaTaskEnabled: s.UpsertPipelineUsingOrcaTaskEnabled,
+		Locker:                             wa.Locker,
+		DinghyfilePatterns:                 s.DinghyFilenamePatterns,
+		Parsers:                            wa.Parsers,
+		DirectoryConfigs:                   directoryConfigs,
+		PipelineOwnership:                  s.PipelineOwnershipEnabled,
+		Provider:                           providerName(d),
+		PipelineChanges:                    &logevents.PipelineChanges{},
+		Revisions:                          wa.RevisionsClient,
+		TransactionalUpdates:               s.TransactionalUpdatesEnabled,
+		PolicySource:                       policySource(s),
+		LintRules:                          lintRules(s, providerName(d)),
+		LintReport:                         &lint.Report{},
+		References:                         wa.References,
+		Schema:                             schemaExtensions(s),
+		Approvals:                          wa.approvals(s),
+		Freezes:                            freezes(s),
+	}
+}
+
+// approvals returns the protected applications, nil when approvals are disabled.
+func (wa *WebAPI) approvals(s *global.Settings) *dinghyfile.Approvals {
+	if wa.ApprovalsClient == nil || !s.Approvals.Enabled {
+		return nil
+	}
+	return &dinghyfile.Approvals{
+		Client:       wa.ApprovalsClient,
+		Applications: s.Approvals.Applications,
+		Expiration:   time.Duration(s.Approvals.ExpirationMinutes) * time.Minute,
+		Approvers:    s.Approvals.Approvers,
+	}
+}
+
+// schemaExtensions returns the schemas of the template repo, nil when schema validation is disabled.
+func schemaExtensions(s *global.Settings) *schema.Extensions {
+	if !s.SchemaValidation.Enabled {
+		return nil
+	}
+	extensions := &schema.Extensions{Dinghyfile: s.SchemaValidation.Extensions}
+	for _, m := range s.SchemaValidation.Modules {
+		extensions.Modules = append(extensions.Modules, schema.ModuleSchema{Pattern: m.Pattern, Schema: m.Schema})
+	}
+	return extensions
+}
+
+// lintRules returns the lint severities of the repositories of a provider, nil when lint is disabled.
+func lintRules(s *global.Settings, provider string) func(repo, branch string) map[string]string {
+	if !s.Lint.Enabled {
+		return nil
+	}
+	return func(repo, branch string) map[string]string {
+		return s.LintRules(provider, repo, branch)
+	}
+}
+
+// policySource returns where the policies are, nil when they are disabled.
+func policySource(s *global.Settings) *policy.Source {
+	if !s.Policies.Enabled {
+		return nil
+	}
+	return &policy.Source{
+		Directory: s.Policies.Directory,
+		Org:       s.Policies.Org,
+		Repo:      s.Policies.Repo,
+		Path:      s.Policies.Path,
+		Branch:    s.Policies.Branch,
+	}
+}
+
+// appliedChanges returns the pipelines changed by a builder, nil when it only validated.
+func appliedChanges(b *dinghyfile.PipelineBuilder) *logevents.PipelineChanges {
+	if b.Action == pipebuilder.Validate {
+		return nil
+	}
+	return b.PipelineChanges
+}
+
+// providerName returns the name of the git provider of a downloader, the
+// same as the name of its pushes.
+func providerName(d dinghyfile.Downloader) string {
+	switch d.(type) {
+	case *github.FileService:
+		return "github"
+	case *gitlab.FileService:
+		return "gitlab"
+	case *stash.FileService:
+		return "bitbucket-server"
+	case *bbcloud.FileService:
+		return "bitbucket-cloud"
+	case dummy.FileService, *dummy.FileService:
+		return "dummy"
+	}
+	return ""
+}
+
+// TODO: this func should return an error and allow the handlers to return the http response. Additionally,
+// it probably doesn't belong in this file once refactored.
+func (wa *WebAPI) buildPipelines(
+	p Push,
+	rawPushBytes []byte,
+	d dinghyfile.Downloader,
+	w http.ResponseWriter,
+	l dinghylog.DinghyLog,
+	pullRequest string,
+	pc util.PlankClient,
+	s *global.Settings,
+) {
+	l.Infof("Processing request for branch: %s", p.Branch())
+
+	// deserialize push data to a map.  used in template logic later
+	rawPush := make(map[string]interface{})
+	if err := json.Unmarshal(rawPushBytes, &rawPush); err != nil {
+		l.Errorf("unable to deserialize raw data to map")
+	}
+
+	var directoryConfigs *dinghyfile.DirectoryConfigs
+	if s.DirectoryConfigEnabled {
+		directoryConfigs = dinghyfile.NewDirectoryConfigs(d)
+		repositorySettings, err := repositorySettings(p.Org(), p.Repo(), p.Branch(), directoryConfigs, s, l)
+		if err != nil {
+			l.Errorf("Invalid repository configuration: %s", err.Error())
+			util.WriteHTTPError(w, http.StatusUnprocessableEntity, err)
+			setCommitStatus(p, s.InstanceId, git.StatusError, fmt.Sprintf("Invalid %s", dinghyfile.DirectoryConfigFilename))
+			saveLogEventError(wa.LogEventsClient, p, l, logevents.LogEvent{
+				RawData:     string(rawPushBytes),
+				PullRequest: pullRequest,
+			})
+			return
+		}
+		// only this request uses the repository settings
+		s = repositorySettings
+	}
+
+	// Construct a pipeline builder using provided downloader
+	builder := wa.newPipelineBuilder(d, l, pc, s, rawPush, directoryConfigs)
+	if commits := p.GetCommits(); len(commits) > 0 {
+		builder.PushCommit = dinghyfile.PushCommit{Org: p.Org(), Repo: p.Repo(), Branch: p.Branch(), SHA: commits[len(commits)-1]}
+	}
+	if builder.Freezes != nil {
+		builder.Freezes.Override = freezeOverride(p, s)
+		defer wa.saveFreezeOverrides(p, builder.Freezes, l)
 	}
 
 	if shouldRunValidation(p, s, l) {
@@ -659,6 +866,16 @@ func (wa *WebAPI) buildPipelines(
 		builder.Action = pipebuilder.Validate
 	}
 
+	if wa.Locker != nil && builder.Action == pipebuilder.Process {
+		repoLock, err := wa.Locker.Acquire(lock.RepositoryKey(p.Name(), p.Org(), p.Repo(), p.Branch()))
+		if err != nil {
+			l.Errorf("Unable to lock repository %s/%s: %s", p.Org(), p.Repo(), err.Error())
+			util.WriteHTTPError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		defer repoLock.Release()
+	}
+
 	builder.Parser = wa.Parser
 	builder.Parser.SetBuilder(builder)
 
@@ -674,6 +891,7 @@ func (wa *WebAPI) buildPipelines(
 			RawData:            string(rawPushBytes),
 			PullRequest:        pullRequest,
 			RenderedDinghyfile: renderedDinghyfile,
+			Pipelines:          appliedChanges(builder),
 		})
 		return
 	}
@@ -685,6 +903,7 @@ func (wa *WebAPI) buildPipelines(
 			RawData:            string(rawPushBytes),
 			PullRequest:        pullRequest,
 			RenderedDinghyfile: renderedDinghyfile,
+			Pipelines:          appliedChanges(builder),
 		})
 		return
 	}
@@ -706,6 +925,10 @@ func (wa *WebAPI) buildPipelines(
 			ignoreFile = NewRegexpIgnoreFile(ignoreFilePatterns, l)
 		}
 
+		if wa.ImpactReportsClient != nil && builder.Action == pipebuilder.Validate {
+			wa.saveImpactReport(p, builder, ignoreFile, pullRequest, s, l)
+		}
+
 		// For each module pushed, rebuild dependent dinghyfiles
 		for _, file := range p.Files() {
 			if !ignoreFile.ShouldIgnore(file) {
@@ -717,6 +940,7 @@ func (wa *WebAPI) buildPipelines(
 						RawData:            string(rawPushBytes),
 						PullRequest:        pullRequest,
 						RenderedDinghyfile: renderedDinghyfile,
+						Pipelines:          appliedChanges(builder),
 					})
 					return
 				}
@@ -733,6 +957,7 @@ func (wa *WebAPI) buildPipelines(
 						RawData:            string(rawPushBytes),
 						PullRequest:        pullRequest,
 						RenderedDinghyfile: renderedDinghyfile,
+						Pipelines:          appliedChanges(builder),
 					})
 					return
 				}
@@ -746,21 +971,18 @@ func (wa *WebAPI) buildPipelines(
 				RawData:            string(rawPushBytes),
 				PullRequest:        pullRequest,
 				RenderedDinghyfile: renderedDinghyfile,
+				Pipelines:          appliedChanges(builder),
 			})
 		}
 	} else {
-		var dinghyfiles []string
-		for _, file := range p.Files() {
-			if filepath.Base(file) == builder.DinghyfileName {
-				dinghyfiles = append(dinghyfiles, file)
-			}
-		}
+		dinghyfiles := dinghyfilesInPush(p, builder, s.GetRepoConfig(p.Name(), p.Repo(), p.Branch()))
 		if len(dinghyfiles) > 0 {
 			saveLogEventSuccess(wa.LogEventsClient, p, l, logevents.LogEvent{
 				RawData:            string(rawPushBytes),
 				Files:              dinghyfiles,
 				PullRequest:        pullRequest,
 				RenderedDinghyfile: renderedDinghyfile,
+				Pipelines:          appliedChanges(builder),
 			})
 		}
 	}